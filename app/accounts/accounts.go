@@ -0,0 +1,186 @@
+//package accounts provides users that log in with their account on an OAuth2
+//code host, their sessions, the projects they own and the credentials those
+//projects are downloaded with.
+package accounts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/zeebo/goci/app/entities"
+	"github.com/zeebo/goci/app/httputil"
+	"github.com/zeebo/goci/app/rpc"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//Conf is the configuration of the code host users log in with.
+type Conf struct {
+	ClientID     string //the id of goci on the code host
+	ClientSecret string //the secret of goci on the code host
+	AuthURL      string //where users are sent to let goci see their account
+	TokenURL     string //where codes are exchanged for access tokens
+	UserURL      string //returns the account of an access token as json
+	Scope        string //the scope of the access tokens asked for
+	Host         string //the host of import paths users can claim
+	SecretKey    []byte //the 32 byte key credentials of projects are encrypted with
+}
+
+//Config is the configuration for accounts. It defaults to GitHub and logging
+//in is disabled until a ClientID is set.
+var Config = &Conf{
+	AuthURL:  "https://github.com/login/oauth/authorize",
+	TokenURL: "https://github.com/login/oauth/access_token",
+	UserURL:  "https://api.github.com/user",
+	Host:     "github.com",
+}
+
+//Enabled returns if logging in is configured.
+func Enabled() bool {
+	return Config.ClientID != ""
+}
+
+//CallbackPath is the path the code host sends users back to after they log in.
+const CallbackPath = "/login/callback"
+
+//stateCookie holds the random state of a login in progress so that the code
+//host can only send back the user who started it.
+const stateCookie = "goci_login"
+
+//ErrBadState is returned when finishing a login that wasn't started by the
+//user finishing it.
+var ErrBadState = errors.New("login state doesn't match")
+
+//httpClient talks to the code host. It isn't the rpc client because the code
+//host doesn't trust our certificates and we shouldn't only trust theirs.
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+//StartLogin remembers a new login in a cookie and returns the url of the code
+//host to send the user to.
+func StartLogin(w http.ResponseWriter) (u string, err error) {
+	state, err := rpc.NewSecret()
+	if err != nil {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    state,
+		Path:     CallbackPath,
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   httputil.Config.TLS,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	v := url.Values{
+		"client_id":    {Config.ClientID},
+		"redirect_uri": {httputil.Absolute(CallbackPath)},
+		"state":        {state},
+	}
+	if Config.Scope != "" {
+		v.Set("scope", Config.Scope)
+	}
+	u = Config.AuthURL + "?" + v.Encode()
+	return
+}
+
+//FinishLogin handles the code host sending the user back. It stores the
+//account of the user and starts a session for them.
+func FinishLogin(ctx httputil.Context, w http.ResponseWriter, req *http.Request) (u *entities.User, err error) {
+	c, err := req.Cookie(stateCookie)
+	if err != nil || c.Value == "" || c.Value != req.FormValue("state") {
+		err = ErrBadState
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: CallbackPath, MaxAge: -1})
+
+	if msg := req.FormValue("error"); msg != "" {
+		err = fmt.Errorf("code host denied the login: %s", msg)
+		return
+	}
+	token, err := Exchange(req.FormValue("code"))
+	if err != nil {
+		return
+	}
+	hu, err := FetchUser(token)
+	if err != nil {
+		return
+	}
+	u, err = Login(ctx, w, hu)
+	return
+}
+
+//Exchange trades the code the code host sent the user back with for an access
+//token.
+func Exchange(code string) (token string, err error) {
+	v := url.Values{
+		"client_id":     {Config.ClientID},
+		"client_secret": {Config.ClientSecret},
+		"code":          {code},
+		"grant_type":    {"authorization_code"},
+		"redirect_uri":  {httputil.Absolute(CallbackPath)},
+	}
+	req, err := http.NewRequest("POST", Config.TokenURL, strings.NewReader(v.Encode()))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var res struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err = getJSON(req, &res); err != nil {
+		return
+	}
+	if res.Error != "" || res.AccessToken == "" {
+		err = fmt.Errorf("code host didn't give an access token: %q", res.Error)
+		return
+	}
+	token = res.AccessToken
+	return
+}
+
+//HostUser is an account on the code host.
+type HostUser struct {
+	ID    int64  `json:"id"`
+	Login string `json:"login"`
+	Name  string `json:"name"`
+}
+
+//FetchUser returns the account the access token is for.
+func FetchUser(token string) (u HostUser, err error) {
+	req, err := http.NewRequest("GET", Config.UserURL, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	if err = getJSON(req, &u); err != nil {
+		return
+	}
+	if u.Login == "" {
+		err = errors.New("code host returned an account without a login")
+	}
+	return
+}
+
+//getJSON does the request and decodes the json response into v.
+func getJSON(req *http.Request, v interface{}) (err error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("%s %s: %s", req.Method, req.URL, resp.Status)
+		return
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	return
+}
@@ -0,0 +1,209 @@
+package accounts
+
+import (
+	"encoding/json"
+	"github.com/zeebo/goci/app/entities"
+	"github.com/zeebo/goci/app/httputil"
+	"github.com/zeebo/goci/app/rpc"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//fakeHost is a code host that gives the access token "token" for the code
+//"code" and says the token is for the account zeebo.
+func fakeHost(t *testing.T) (s *httptest.Server, reset func()) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		if req.FormValue("client_secret") != "secret" {
+			t.Error("Invalid client secret:", req.FormValue("client_secret"))
+		}
+		res := map[string]string{"access_token": "token"}
+		if req.FormValue("code") != "code" {
+			res = map[string]string{"error": "bad_verification_code"}
+		}
+		json.NewEncoder(w).Encode(res)
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "bad credentials", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(HostUser{ID: 1, Login: "zeebo", Name: "Jeff"})
+	})
+	s = httptest.NewServer(mux)
+
+	old := *Config
+	Config.ClientID = "id"
+	Config.ClientSecret = "secret"
+	Config.AuthURL = s.URL + "/authorize"
+	Config.TokenURL = s.URL + "/token"
+	Config.UserURL = s.URL + "/user"
+	reset = func() {
+		*Config = old
+		s.Close()
+	}
+	return
+}
+
+func TestExchange(t *testing.T) {
+	_, reset := fakeHost(t)
+	defer reset()
+
+	token, err := Exchange("code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "token" {
+		t.Fatal("Invalid token:", token)
+	}
+	if _, err := Exchange("wrong"); err == nil {
+		t.Fatal("Expected an error for a bad code")
+	}
+}
+
+func TestFetchUser(t *testing.T) {
+	_, reset := fakeHost(t)
+	defer reset()
+
+	u, err := FetchUser("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != (HostUser{ID: 1, Login: "zeebo", Name: "Jeff"}) {
+		t.Fatal("Invalid user:", u)
+	}
+	if _, err := FetchUser("wrong"); err == nil {
+		t.Fatal("Expected an error for a bad token")
+	}
+}
+
+func TestStartLogin(t *testing.T) {
+	s, reset := fakeHost(t)
+	defer reset()
+
+	rec := httptest.NewRecorder()
+	u, err := StartLogin(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(u, s.URL+"/authorize?") {
+		t.Fatal("Invalid url:", u)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := parsed.Query()
+	if v.Get("client_id") != "id" {
+		t.Error("Invalid client id:", v.Get("client_id"))
+	}
+
+	//the state in the url is the one in the cookie
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != stateCookie {
+		t.Fatal("Invalid cookies:", cookies)
+	}
+	if v.Get("state") == "" || v.Get("state") != cookies[0].Value {
+		t.Fatal("State doesn't match:", v.Get("state"), cookies[0].Value)
+	}
+}
+
+func TestFinishLoginBadState(t *testing.T) {
+	req, _ := http.NewRequest("GET", CallbackPath+"?code=code&state=other", nil)
+	req.AddCookie(&http.Cookie{Name: stateCookie, Value: "state"})
+	if _, err := FinishLogin(httputil.Context{}, httptest.NewRecorder(), req); err != ErrBadState {
+		t.Fatal("Expected ErrBadState, got", err)
+	}
+}
+
+func TestPrefixes(t *testing.T) {
+	got := prefixes("github.com/zeebo/goci/app/")
+	exp := []string{
+		"github.com/zeebo/goci/app",
+		"github.com/zeebo/goci",
+		"github.com/zeebo",
+		"github.com",
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatal("Got", got)
+	}
+}
+
+func TestOwnership(t *testing.T) {
+	owner := &entities.User{ID: bson.NewObjectId(), Login: "zeebo"}
+	other := &entities.User{ID: bson.NewObjectId(), Login: "other"}
+	open := &entities.Project{ImportPath: "github.com/zeebo/open"}
+	private := &entities.Project{
+		ImportPath: "github.com/zeebo/private",
+		Owners:     []bson.ObjectId{owner.ID},
+		Private:    true,
+	}
+
+	if !IsOwner(nil, nil) || !IsOwner(open, other) {
+		t.Error("Anyone should own projects without owners")
+	}
+	if !IsOwner(private, owner) || IsOwner(private, other) || IsOwner(private, nil) {
+		t.Error("Only owners should own a project")
+	}
+	if !CanSee(open, nil) || !CanSee(private, owner) || CanSee(private, other) {
+		t.Error("Only owners should see private projects")
+	}
+}
+
+func TestCanClaim(t *testing.T) {
+	u := &entities.User{Login: "Zeebo"}
+	cases := []struct {
+		imp string
+		ok  bool
+	}{
+		{"github.com/zeebo/goci", true},
+		{"github.com/Zeebo/goci/app", true},
+		{"github.com/zeebon/goci", false},
+		{"github.com/zeebo", false},
+		{"bitbucket.org/zeebo/goci", false},
+	}
+	for _, c := range cases {
+		if CanClaim(u, c.imp) != c.ok {
+			t.Error(c.imp, "Expected", c.ok)
+		}
+	}
+	if CanClaim(nil, "github.com/zeebo/goci") {
+		t.Error("Logged out users can't claim")
+	}
+}
+
+func TestSeal(t *testing.T) {
+	defer func(k []byte) { Config.SecretKey = k }(Config.SecretKey)
+
+	a := rpc.Auth{Host: "github.com", Username: "zeebo", Token: "hunter2"}
+	Config.SecretKey = nil
+	if _, err := seal("github.com/zeebo/private", a); err != ErrNoSecretKey {
+		t.Fatal("Expected ErrNoSecretKey, got", err)
+	}
+
+	Config.SecretKey = []byte("0123456789abcdef0123456789abcdef")
+	sealed, err := seal("github.com/zeebo/private", a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(sealed), "hunter2") {
+		t.Fatal("Credentials weren't encrypted")
+	}
+	var got rpc.Auth
+	if err := open("github.com/zeebo/private", sealed, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != a {
+		t.Fatal("Got", got)
+	}
+
+	//the credentials only open for the project they were sealed for
+	if err := open("github.com/zeebo/other", sealed, &got); err == nil {
+		t.Fatal("Opened credentials for the wrong project")
+	}
+}
@@ -0,0 +1,161 @@
+package accounts
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"github.com/zeebo/goci/app/httputil"
+	"github.com/zeebo/goci/app/rpc"
+	"io"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"strings"
+	"time"
+)
+
+var (
+	//ErrNoSecretKey is returned when storing credentials without a SecretKey
+	//to encrypt them with.
+	ErrNoSecretKey = errors.New("credentials can't be stored without a secret key")
+
+	//ErrBadCredential is returned when storing credentials without a deploy key
+	//or a username and token.
+	ErrBadCredential = errors.New("credentials need a deploy key or a username and token")
+)
+
+//CredentialsEnabled returns if credentials can be stored.
+func CredentialsEnabled() bool {
+	return len(Config.SecretKey) > 0
+}
+
+//Credential is the encrypted credentials a project is downloaded with.
+type Credential struct {
+	ImportPath string    `bson:"_id"`
+	Kind       string    //"deploy key" or "token", so it can be shown without opening it
+	Updated    time.Time //when the credentials were set
+	Sealed     []byte    //the json of the rpc.Auth encrypted with the SecretKey
+}
+
+//host returns the host of the import path, like github.com.
+func host(importPath string) string {
+	return strings.SplitN(importPath, "/", 2)[0]
+}
+
+//aead returns the cipher credentials are encrypted with.
+func aead() (c cipher.AEAD, err error) {
+	if len(Config.SecretKey) == 0 {
+		err = ErrNoSecretKey
+		return
+	}
+	block, err := aes.NewCipher(Config.SecretKey)
+	if err != nil {
+		return
+	}
+	c, err = cipher.NewGCM(block)
+	return
+}
+
+//seal encrypts the json of v for the import path. The import path is
+//authenticated with it so it can't be moved to another project.
+func seal(importPath string, v interface{}) (sealed []byte, err error) {
+	c, err := aead()
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	nonce := make([]byte, c.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return
+	}
+	sealed = c.Seal(nonce, nonce, data, []byte(importPath))
+	return
+}
+
+//open decrypts the json sealed for the import path in to v.
+func open(importPath string, sealed []byte, v interface{}) (err error) {
+	c, err := aead()
+	if err != nil {
+		return
+	}
+	if len(sealed) < c.NonceSize() {
+		err = errors.New("sealed credentials are too short")
+		return
+	}
+	n := c.NonceSize()
+	data, err := c.Open(nil, sealed[:n], sealed[n:], []byte(importPath))
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, v)
+	return
+}
+
+//SetCredential encrypts and stores the credentials to download the project at
+//the import path with, replacing any it had. They are used for the host of the
+//import path.
+func SetCredential(ctx httputil.Context, importPath string, a rpc.Auth) (err error) {
+	a.Host = host(importPath)
+	cred := Credential{ImportPath: importPath, Updated: time.Now()}
+	switch {
+	case a.SSHKey != "":
+		cred.Kind, a.Username, a.Token = "deploy key", "", ""
+	case a.Username != "" && a.Token != "":
+		cred.Kind = "token"
+	default:
+		err = ErrBadCredential
+		return
+	}
+
+	if cred.Sealed, err = seal(importPath, a); err != nil {
+		return
+	}
+	if _, err = ctx.DB.C("ProjectCredential").UpsertId(importPath, cred); err != nil {
+		return
+	}
+	ctx.Infof("Set the %s of %s", cred.Kind, importPath)
+	return
+}
+
+//RemoveCredential removes the credentials of the project at the import path.
+func RemoveCredential(ctx httputil.Context, importPath string) (err error) {
+	err = ctx.DB.C("ProjectCredential").RemoveId(importPath)
+	if err == mgo.ErrNotFound {
+		err = nil
+	}
+	return
+}
+
+//StoredCredential returns the credentials stored for the project at the import
+//path without opening them, or nil if it doesn't have any.
+func StoredCredential(ctx httputil.Context, importPath string) (c *Credential, err error) {
+	err = ctx.DB.C("ProjectCredential").FindId(importPath).One(&c)
+	if err == mgo.ErrNotFound {
+		c, err = nil, nil
+	}
+	return
+}
+
+//Auth returns the opened credentials to download the import path with, from
+//the project with the longest import path it is under that has some. It
+//returns nil if there aren't any.
+func Auth(ctx httputil.Context, importPath string) (a *rpc.Auth, err error) {
+	var cs []Credential
+	sel := bson.M{"_id": bson.M{"$in": prefixes(importPath)}}
+	if err = ctx.DB.C("ProjectCredential").Find(sel).All(&cs); err != nil || len(cs) == 0 {
+		return
+	}
+
+	c := &cs[0]
+	for i := range cs {
+		if len(cs[i].ImportPath) > len(c.ImportPath) {
+			c = &cs[i]
+		}
+	}
+	err = open(c.ImportPath, c.Sealed, &a)
+	return
+}
@@ -0,0 +1,85 @@
+package accounts
+
+import (
+	"github.com/zeebo/goci/app/httputil"
+	"github.com/zeebo/goci/redact"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"strings"
+	"time"
+)
+
+//Masked is the encrypted secret values masked in the output of a project, like
+//the tokens its tests print from the environment.
+type Masked struct {
+	ImportPath string    `bson:"_id"`
+	Count      int       //how many values there are, so it can be shown without opening them
+	Updated    time.Time //when the values were set
+	Sealed     []byte    //the json of the values encrypted with the SecretKey
+}
+
+//SetMasked encrypts and stores the values masked in the output of the project
+//at the import path, replacing any it had. Blank values are ignored and no
+//values removes them.
+func SetMasked(ctx httputil.Context, importPath string, values []string) (err error) {
+	var vs []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			vs = append(vs, v)
+		}
+	}
+	if len(vs) == 0 {
+		err = ctx.DB.C("Masked").RemoveId(importPath)
+		if err == mgo.ErrNotFound {
+			err = nil
+		}
+		return
+	}
+
+	m := Masked{ImportPath: importPath, Count: len(vs), Updated: time.Now()}
+	if m.Sealed, err = seal(importPath, vs); err != nil {
+		return
+	}
+	if _, err = ctx.DB.C("Masked").UpsertId(importPath, m); err != nil {
+		return
+	}
+	ctx.Infof("Set %d masked values of %s", m.Count, importPath)
+	return
+}
+
+//StoredMasked returns the values masked in the output of the project at the
+//import path without opening them, or nil if it doesn't have any.
+func StoredMasked(ctx httputil.Context, importPath string) (m *Masked, err error) {
+	err = ctx.DB.C("Masked").FindId(importPath).One(&m)
+	if err == mgo.ErrNotFound {
+		m, err = nil, nil
+	}
+	return
+}
+
+//Masker returns what masks the secrets in the output of the import path: the
+//credentials it is downloaded with, the values masked in every project it is
+//under, and anything that looks like a secret.
+func Masker(ctx httputil.Context, importPath string) (m *redact.Masker, err error) {
+	a, err := Auth(ctx, importPath)
+	if err != nil {
+		return
+	}
+	values := a.Secrets()
+
+	var ms []Masked
+	sel := bson.M{"_id": bson.M{"$in": prefixes(importPath)}}
+	if err = ctx.DB.C("Masked").Find(sel).All(&ms); err != nil {
+		return
+	}
+	for _, masked := range ms {
+		var vs []string
+		if err = open(masked.ImportPath, masked.Sealed, &vs); err != nil {
+			return
+		}
+		values = append(values, vs...)
+	}
+
+	m = redact.New(values...)
+	return
+}
@@ -0,0 +1,164 @@
+package accounts
+
+import (
+	"errors"
+	"github.com/zeebo/goci/app/entities"
+	"github.com/zeebo/goci/app/httputil"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"strings"
+	"time"
+)
+
+var (
+	//ErrClaimed is returned when claiming a project that already has owners.
+	ErrClaimed = errors.New("project already has owners")
+
+	//ErrNotYours is returned when claiming a project that isn't under the
+	//account of the user on the code host.
+	ErrNotYours = errors.New("project isn't under your account")
+)
+
+//prefixes returns the import path and every import path it is under, longest
+//first.
+func prefixes(importPath string) (ps []string) {
+	for p := strings.Trim(importPath, "/"); p != ""; {
+		ps = append(ps, p)
+		i := strings.LastIndex(p, "/")
+		if i < 0 {
+			break
+		}
+		p = p[:i]
+	}
+	return
+}
+
+//Project returns the project the import path belongs to, which is the one
+//with the longest import path that it is under, or nil if there isn't one.
+func Project(ctx httputil.Context, importPath string) (p *entities.Project, err error) {
+	var ps []entities.Project
+	sel := bson.M{"_id": bson.M{"$in": prefixes(importPath)}}
+	if err = ctx.DB.C("Project").Find(sel).All(&ps); err != nil || len(ps) == 0 {
+		return
+	}
+
+	p = &ps[0]
+	for i := range ps {
+		if len(ps[i].ImportPath) > len(p.ImportPath) {
+			p = &ps[i]
+		}
+	}
+	return
+}
+
+//IsOwner returns if the user can change the project. Anyone can change a
+//project without owners.
+func IsOwner(p *entities.Project, u *entities.User) bool {
+	if p == nil || len(p.Owners) == 0 {
+		return true
+	}
+	if u == nil {
+		return false
+	}
+	for _, id := range p.Owners {
+		if id == u.ID {
+			return true
+		}
+	}
+	return false
+}
+
+//CanSee returns if the user can see the output of the tests in the project.
+func CanSee(p *entities.Project, u *entities.User) bool {
+	return p == nil || !p.Private || IsOwner(p, u)
+}
+
+//CanClaim returns if the import path is under the account of the user on the
+//code host, like github.com/user/project for the user named user.
+func CanClaim(u *entities.User, importPath string) bool {
+	if u == nil {
+		return false
+	}
+	ns := strings.ToLower(Config.Host + "/" + u.Login + "/")
+	return strings.HasPrefix(strings.ToLower(importPath), ns)
+}
+
+//Claim makes the user the owner of the project at the import path. The import
+//path has to be under their account and not already in an owned project.
+func Claim(ctx httputil.Context, u *entities.User, importPath string) (p *entities.Project, err error) {
+	if !CanClaim(u, importPath) {
+		err = ErrNotYours
+		return
+	}
+	if p, err = Project(ctx, importPath); err != nil {
+		return
+	}
+	if p != nil && len(p.Owners) > 0 {
+		err = ErrClaimed
+		return
+	}
+
+	p = &entities.Project{
+		ImportPath: importPath,
+		Owners:     []bson.ObjectId{u.ID},
+		Created:    time.Now(),
+	}
+	_, err = ctx.DB.C("Project").Upsert(bson.M{
+		"_id":    importPath,
+		"owners": bson.M{"$size": 0},
+	}, p)
+	if mgo.IsDup(err) {
+		err = ErrClaimed
+	}
+	if err != nil {
+		return
+	}
+
+	ctx.Infof("%s claimed %s", u.Login, importPath)
+	return
+}
+
+//Owned returns the projects the user owns.
+func Owned(ctx httputil.Context, u *entities.User) (ps []entities.Project, err error) {
+	err = ctx.DB.C("Project").Find(bson.M{"owners": u.ID}).Sort("_id").All(&ps)
+	return
+}
+
+//Owners returns the users that own the project.
+func Owners(ctx httputil.Context, p *entities.Project) (us []entities.User, err error) {
+	sel := bson.M{"_id": bson.M{"$in": p.Owners}}
+	err = ctx.DB.C("User").Find(sel).Sort("login").All(&us)
+	return
+}
+
+//SetPrivate sets if only the owners of the project can see the output of its
+//tests.
+func SetPrivate(ctx httputil.Context, importPath string, private bool) (err error) {
+	return ctx.DB.C("Project").UpdateId(importPath, bson.M{
+		"$set": bson.M{"private": private},
+	})
+}
+
+//AddOwner makes the user with the login an owner of the project. It returns
+//mgo.ErrNotFound if nobody with the login has logged in.
+func AddOwner(ctx httputil.Context, importPath, login string) (err error) {
+	var u entities.User
+	if err = ctx.DB.C("User").Find(bson.M{"login": login}).One(&u); err != nil {
+		return
+	}
+	return ctx.DB.C("Project").UpdateId(importPath, bson.M{
+		"$addToSet": bson.M{"owners": u.ID},
+	})
+}
+
+//RemoveOwner stops the user from owning the project. The last owner can't be
+//removed so the project isn't left open to anyone.
+func RemoveOwner(ctx httputil.Context, importPath string, id bson.ObjectId) (err error) {
+	sel := bson.M{
+		"_id":      importPath,
+		"owners.1": bson.M{"$exists": true},
+	}
+	return ctx.DB.C("Project").Update(sel, bson.M{
+		"$pull": bson.M{"owners": id},
+	})
+}
@@ -0,0 +1,152 @@
+package accounts
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/zeebo/goci/app/entities"
+	"github.com/zeebo/goci/app/httputil"
+	"github.com/zeebo/goci/app/rpc"
+	"io"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"time"
+)
+
+//CookieName is the cookie holding the session of a logged in user.
+const CookieName = "goci_session"
+
+//sessionTTL is how long a session lasts before the user has to log in again.
+const sessionTTL = 30 * 24 * time.Hour
+
+//session is a logged in user. Only a hash of the token in the cookie is stored
+//so the database can't be used to log in as anyone.
+type session struct {
+	ID      string        `bson:"_id"`
+	User    bson.ObjectId //the user that is logged in
+	Created time.Time     //when the user logged in
+}
+
+//hashToken returns the hex encoded sha256 of the session token.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+//EnsureSessions sets up the sessions collection so that sessions expire, and
+//users can be found by their account on the code host.
+func EnsureSessions(db *mgo.Database) (err error) {
+	err = db.C("Session").EnsureIndex(mgo.Index{
+		Key:         []string{"created"},
+		ExpireAfter: sessionTTL,
+	})
+	if err != nil {
+		return
+	}
+	err = db.C("User").EnsureIndex(mgo.Index{
+		Key:    []string{"hostid"},
+		Unique: true,
+	})
+	return
+}
+
+//Login stores the account from the code host as a user and starts a session for
+//them, setting the cookie on the response.
+func Login(ctx httputil.Context, w http.ResponseWriter, hu HostUser) (u *entities.User, err error) {
+	now := time.Now()
+	change := mgo.Change{
+		Update: bson.M{
+			"$set":         bson.M{"login": hu.Login, "name": hu.Name, "lastlogin": now},
+			"$setOnInsert": bson.M{"created": now},
+		},
+		Upsert:    true,
+		ReturnNew: true,
+	}
+	if _, err = ctx.DB.C("User").Find(bson.M{"hostid": hu.ID}).Apply(change, &u); err != nil {
+		return
+	}
+
+	token, err := rpc.NewSecret()
+	if err != nil {
+		return
+	}
+	s := session{
+		ID:      hashToken(token),
+		User:    u.ID,
+		Created: now,
+	}
+	if err = ctx.DB.C("Session").Insert(s); err != nil {
+		return
+	}
+
+	//lax cookies aren't sent with posts from other sites, and the forms that
+	//change things also carry the CSRFToken of the session
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  now.Add(sessionTTL),
+		HttpOnly: true,
+		Secure:   httputil.Config.TLS,
+		SameSite: http.SameSiteLaxMode,
+	})
+	ctx.Infof("Logged in %s", u.Login)
+	return
+}
+
+//CurrentUser returns the user logged in with the request, or nil if there isn't
+//one.
+func CurrentUser(ctx httputil.Context, req *http.Request) (u *entities.User, err error) {
+	c, err := req.Cookie(CookieName)
+	if err != nil || c.Value == "" {
+		err = nil
+		return
+	}
+
+	var s session
+	err = ctx.DB.C("Session").FindId(hashToken(c.Value)).One(&s)
+	if err == mgo.ErrNotFound {
+		err = nil
+		return
+	}
+	//expired sessions stick around until mongo gets around to removing them
+	if err != nil || time.Since(s.Created) > sessionTTL {
+		return
+	}
+
+	err = ctx.DB.C("User").FindId(s.User).One(&u)
+	if err == mgo.ErrNotFound {
+		u, err = nil, nil
+	}
+	return
+}
+
+//CSRFToken returns the token the forms posted by the logged in user carry. It
+//is derived from the session cookie, which other sites can't read, so every
+//session has its own. It is empty if the request has no session.
+func CSRFToken(req *http.Request) string {
+	c, err := req.Cookie(CookieName)
+	if err != nil || c.Value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(c.Value))
+	io.WriteString(mac, "goci session csrf")
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//Logout ends the session of the request and clears the cookie.
+func Logout(ctx httputil.Context, w http.ResponseWriter, req *http.Request) (err error) {
+	http.SetCookie(w, &http.Cookie{Name: CookieName, Path: "/", MaxAge: -1})
+
+	c, err := req.Cookie(CookieName)
+	if err != nil {
+		err = nil
+		return
+	}
+	err = ctx.DB.C("Session").RemoveId(hashToken(c.Value))
+	if err == mgo.ErrNotFound {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/pat"
+	"github.com/zeebo/goci/app/accounts"
+	"github.com/zeebo/goci/app/httputil"
+	"github.com/zeebo/goci/app/workqueue"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"net/url"
+)
+
+//Prefix is the path the api is served under.
+const Prefix = "/api/v1"
+
+//Mux is the handler for the api
+var Mux = pat.New()
+
+//register all the handlers with the serve mux. More specific paths must come
+//first because pat matches on prefixes, so /work has to come after /workers.
+func init() {
+	Mux.Add("GET", Prefix+"/workresults/{id}", handler(getWorkResult))
+	Mux.Add("GET", Prefix+"/workresults", handler(listWorkResults))
+	Mux.Add("GET", Prefix+"/workers", handler(listWorkers))
+	Mux.Add("POST", Prefix+"/work/{key}/rerun", handler(rerunWork))
+	Mux.Add("POST", Prefix+"/work/{key}/requeue", handler(requeueWork))
+	Mux.Add("POST", Prefix+"/work/{key}/cancel", handler(cancelWork))
+	Mux.Add("GET", Prefix+"/work/{key}", handler(getWork))
+	Mux.Add("GET", Prefix+"/work", handler(listWork))
+	Mux.Add("POST", Prefix+"/work", handler(queueWork))
+	Mux.Add("GET", Prefix+"/tests/{id}", handler(getTest))
+	Mux.Add("GET", Prefix+"/tests", handler(listTests))
+	Mux.Add("GET", Prefix+"/packages", handler(listPackages))
+	Mux.NotFoundHandler = handler(notFound)
+}
+
+//ErrorResponse is the body of every error response.
+type ErrorResponse struct {
+	Code    int
+	Message string
+}
+
+//Error implements the error interface so clients can return it.
+func (e ErrorResponse) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+//Page is the body of every list response. Next is the cursor to pass to get
+//the next page and is empty on the last page.
+type Page struct {
+	Items interface{}
+	Next  string `json:",omitempty"`
+}
+
+//handler is an api handler that returns the value to be sent as json with the
+//status code, or an error.
+type handler func(req *http.Request, ctx httputil.Context) (code int, v interface{}, e *httputil.Error)
+
+//ServeHTTP makes handler an http.Handler. It allocates and cleans up a context
+//and writes the value or the error as json.
+func (fn handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx := httputil.Config.ContextFunc(req)
+	defer ctx.Close()
+
+	code, v, e := fn(req, ctx)
+	if e != nil {
+		if e.Code >= 500 {
+			ctx.Errorf("[%d] %s (%v)", e.Code, e.Message, e.Error)
+		}
+		code, v = e.Code, ErrorResponse{e.Code, e.Message}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+//errorf returns an Error with the status code.
+func errorf(code int, err error, format string, v ...interface{}) *httputil.Error {
+	return &httputil.Error{Error: err, Message: fmt.Sprintf(format, v...), Code: code}
+}
+
+//lookupError returns a not found Error if the error is from a missing
+//document and an internal error otherwise.
+func lookupError(err error, format string, v ...interface{}) *httputil.Error {
+	if err == mgo.ErrNotFound {
+		return errorf(http.StatusNotFound, err, format, v...)
+	}
+	return httputil.Errorf(err, format, v...)
+}
+
+//changeError returns the Error for a failed change to the work item with the
+//key.
+func changeError(err error, key bson.ObjectId) *httputil.Error {
+	switch err {
+	case workqueue.ErrCompleted, workqueue.ErrChanged:
+		return errorf(http.StatusConflict, err, "work item %q: %s", key.Hex(), err)
+	}
+	return lookupError(err, "no work item with key %q", key.Hex())
+}
+
+//checkOwner returns a forbidden Error unless the user logged in with the request
+//owns the project of the import path.
+func checkOwner(req *http.Request, s store, importPath string) (e *httputil.Error) {
+	p, err := s.Project(importPath)
+	if err != nil {
+		e = httputil.Errorf(err, "error loading project")
+		return
+	}
+	if accounts.IsOwner(p, nil) {
+		return
+	}
+	u, err := s.User(req)
+	if err != nil {
+		e = httputil.Errorf(err, "error loading user")
+		return
+	}
+	if !accounts.IsOwner(p, u) {
+		e = errorf(http.StatusForbidden, nil, "only the owners of %s can change its work", p.ImportPath)
+	}
+	return
+}
+
+//canSee reports if the user logged in with the request can see the errors and
+//test results of the project of the import path.
+func canSee(req *http.Request, s store, importPath string) (ok bool, e *httputil.Error) {
+	p, err := s.Project(importPath)
+	if err != nil {
+		e = httputil.Errorf(err, "error loading project")
+		return
+	}
+	if ok = accounts.CanSee(p, nil); ok {
+		return
+	}
+	u, err := s.User(req)
+	if err != nil {
+		e = httputil.Errorf(err, "error loading user")
+		return
+	}
+	ok = accounts.CanSee(p, u)
+	return
+}
+
+//notFound responds to paths that aren't part of the api.
+func notFound(req *http.Request, ctx httputil.Context) (code int, v interface{}, e *httputil.Error) {
+	e = errorf(http.StatusNotFound, nil, "no api endpoint at %s %s", req.Method, req.URL.Path)
+	return
+}
+
+//grab loads a key from the form and returns the result. panics if the result
+//is not present or if there are multiple entries. it should only be used for
+//grabbing values out of parsed arguments from the pat package.
+func grab(parsed url.Values, key string) (val string) {
+	if vals := parsed[":"+key]; len(vals) == 1 {
+		val = vals[0]
+		return
+	}
+	panic("too many or few values for key")
+}
@@ -0,0 +1,511 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/zeebo/goci/app/entities"
+	"github.com/zeebo/goci/app/httputil"
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/app/tracker"
+	"github.com/zeebo/goci/app/workqueue"
+	"github.com/zeebo/goci/redact"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+//testStore is an in memory store.
+type testStore struct {
+	work    map[bson.ObjectId]*entities.Work
+	results map[bson.ObjectId]*entities.WorkResult //keyed by work item
+	tests   []entities.TestResult
+	project *entities.Project //the project of every import path
+	user    *entities.User    //the user logged in with every request
+	masked  []string          //the secrets masked in every output
+}
+
+func (t *testStore) Queue(d workqueue.Distiller) (*entities.Work, error) {
+	work, data := d.Distill()
+	w := &entities.Work{
+		ID:     bson.NewObjectId(),
+		Work:   work,
+		Data:   data,
+		Status: entities.WorkStatusWaiting,
+	}
+	t.work[w.ID] = w
+	return w, nil
+}
+
+func (t *testStore) setStatus(key bson.ObjectId, status string) error {
+	w, ok := t.work[key]
+	switch {
+	case !ok:
+		return mgo.ErrNotFound
+	case w.Status == entities.WorkStatusCompleted:
+		return workqueue.ErrCompleted
+	}
+	w.Status = status
+	return nil
+}
+
+func (t *testStore) Requeue(key bson.ObjectId) error {
+	return t.setStatus(key, entities.WorkStatusWaiting)
+}
+
+func (t *testStore) Cancel(key bson.ObjectId) error {
+	return t.setStatus(key, entities.WorkStatusCanceled)
+}
+
+//WorkList returns the work items newest first, like mongo does with the
+//object ids.
+func (t *testStore) WorkList(f WorkFilter, p page) (res []entities.Work, err error) {
+	var ids []string
+	for key := range t.work {
+		ids = append(ids, string(key))
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	for _, id := range ids {
+		w := t.work[bson.ObjectId(id)]
+		if p.after != "" && w.ID >= p.after || f.Status != "" && w.Status != f.Status {
+			continue
+		}
+		if len(res) <= p.limit {
+			res = append(res, *w)
+		}
+	}
+	return
+}
+
+func (t *testStore) Work(key bson.ObjectId) (*entities.Work, error) {
+	if w, ok := t.work[key]; ok {
+		return w, nil
+	}
+	return nil, mgo.ErrNotFound
+}
+
+func (t *testStore) WorkResult(key bson.ObjectId) (*entities.WorkResult, error) {
+	return t.results[key], nil
+}
+
+func (t *testStore) WorkResultByID(id bson.ObjectId) (*entities.WorkResult, error) {
+	for _, r := range t.results {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return nil, mgo.ErrNotFound
+}
+
+func (t *testStore) WorkResults(p page) (res []entities.WorkResult, err error) {
+	for _, r := range t.results {
+		res = append(res, *r)
+	}
+	return
+}
+
+func (t *testStore) Tests(result bson.ObjectId) (res []entities.TestResult, err error) {
+	for _, tr := range t.tests {
+		if tr.WorkResultID == result {
+			res = append(res, tr)
+		}
+	}
+	return
+}
+
+func (t *testStore) TestList(f TestFilter, p page) (res []entities.TestResult, err error) {
+	for _, tr := range t.tests {
+		if f.ImportPath != "" && tr.ImportPath != f.ImportPath || f.Revision != "" && tr.Revision != f.Revision {
+			continue
+		}
+		if len(res) <= p.limit {
+			tr.Output = ""
+			res = append(res, tr)
+		}
+	}
+	return
+}
+
+func (t *testStore) Test(id bson.ObjectId) (*entities.TestResult, error) {
+	for _, tr := range t.tests {
+		if tr.ID == id {
+			return &tr, nil
+		}
+	}
+	return nil, mgo.ErrNotFound
+}
+
+func (t *testStore) WorkKeys(results []bson.ObjectId) (map[bson.ObjectId]bson.ObjectId, error) {
+	keys := map[bson.ObjectId]bson.ObjectId{}
+	for key, r := range t.results {
+		keys[r.ID] = key
+	}
+	return keys, nil
+}
+
+func (t *testStore) Packages() ([]Package, error) {
+	return []Package{
+		{ImportPath: "a", Status: entities.TestStatusPass},
+		{ImportPath: "b", Status: entities.TestStatusFail},
+		{ImportPath: "c", Status: entities.TestStatusPass},
+	}, nil
+}
+
+func (t *testStore) Workers() ([]tracker.Builder, []tracker.Runner, error) {
+	return []tracker.Builder{{URL: "http://b"}}, []tracker.Runner{{URL: "http://r"}}, nil
+}
+
+func (t *testStore) User(req *http.Request) (*entities.User, error) {
+	return t.user, nil
+}
+
+func (t *testStore) Project(importPath string) (*entities.Project, error) {
+	return t.project, nil
+}
+
+func (t *testStore) Masker(importPath string) (*redact.Masker, error) {
+	return redact.New(t.masked...), nil
+}
+
+//newTestStore returns a store with a completed work item that has a passing
+//and failing test, and stubs out the store and contexts to use it.
+func newTestStore() (s *testStore, key bson.ObjectId) {
+	key = bson.NewObjectId()
+	rid := bson.NewObjectId()
+	s = &testStore{
+		work: map[bson.ObjectId]*entities.Work{
+			key: {
+				ID:     key,
+				Work:   rpc.Work{ImportPath: "github.com/zeebo/irc", Subpackages: true},
+				Data:   "raw",
+				Status: entities.WorkStatusCompleted,
+				AttemptLog: []entities.WorkAttempt{
+					{Builder: "b", Runner: "r", Secret: "secret"},
+				},
+			},
+		},
+		results: map[bson.ObjectId]*entities.WorkResult{
+			key: {ID: rid, WorkID: key, Success: true, Revision: "abc"},
+		},
+		tests: []entities.TestResult{
+			{ID: bson.NewObjectId(), WorkResultID: rid, ImportPath: "github.com/zeebo/irc", Revision: "abc", Status: entities.TestStatusPass, Output: "PASS\n"},
+			{ID: bson.NewObjectId(), WorkResultID: rid, ImportPath: "github.com/zeebo/irc/sub", Revision: "abc", Status: entities.TestStatusFail, Output: "FAIL\n"},
+		},
+	}
+
+	httputil.Config.ContextFunc = func(*http.Request) (c httputil.Context) { return }
+	newStore = func(httputil.Context) store { return s }
+	return
+}
+
+//do performs the request against the api and decodes the response into v.
+func do(t *testing.T, method, path, body string, v interface{}) (code int) {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	Mux.ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Fatalf("%s %s: Expected json. Got %q", method, path, ct)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("%s %s: %v: %s", method, path, err, rec.Body.String())
+	}
+	return rec.Code
+}
+
+func TestQueueWork(t *testing.T) {
+	s, _ := newTestStore()
+
+	var w Work
+	code := do(t, "POST", "/api/v1/work", `{"ImportPath":"github.com/zeebo/goci","Revision":"x","Subpackages":true}`, &w)
+	if code != http.StatusCreated {
+		t.Fatalf("Expected %d. Got %d", http.StatusCreated, code)
+	}
+	if w.Status != entities.WorkStatusWaiting || w.Work.ImportPath != "github.com/zeebo/goci" || !w.Work.Subpackages {
+		t.Fatalf("Got %+v", w)
+	}
+	if _, ok := s.work[bson.ObjectIdHex(w.Key)]; !ok {
+		t.Fatal("work item wasn't queued")
+	}
+
+	var e ErrorResponse
+	if code := do(t, "POST", "/api/v1/work", `{}`, &e); code != http.StatusBadRequest || e.Code != code {
+		t.Fatalf("Expected a bad request. Got %d %+v", code, e)
+	}
+}
+
+func TestGetWork(t *testing.T) {
+	_, key := newTestStore()
+
+	var w Work
+	if code := do(t, "GET", "/api/v1/work/"+key.Hex(), "", &w); code != http.StatusOK {
+		t.Fatalf("Expected %d. Got %d", http.StatusOK, code)
+	}
+	if w.Builder != "b" || w.Attempts != 1 || w.Result == nil || len(w.Result.Tests) != 2 {
+		t.Fatalf("Got %+v", w)
+	}
+	for _, tr := range w.Result.Tests {
+		if tr.Output != "" || tr.Work != key.Hex() {
+			t.Fatalf("Expected no output and the work key. Got %+v", tr)
+		}
+	}
+
+	var e ErrorResponse
+	if code := do(t, "GET", "/api/v1/work/"+bson.NewObjectId().Hex(), "", &e); code != http.StatusNotFound {
+		t.Fatalf("Expected %d. Got %d", http.StatusNotFound, code)
+	}
+	if code := do(t, "GET", "/api/v1/work/nope", "", &e); code != http.StatusBadRequest {
+		t.Fatalf("Expected %d. Got %d", http.StatusBadRequest, code)
+	}
+}
+
+func TestRerunWork(t *testing.T) {
+	s, key := newTestStore()
+
+	var w Work
+	if code := do(t, "POST", "/api/v1/work/"+key.Hex()+"/rerun", "", &w); code != http.StatusCreated {
+		t.Fatalf("Expected %d. Got %d", http.StatusCreated, code)
+	}
+	nw := s.work[bson.ObjectIdHex(w.Key)]
+	if w.Key == key.Hex() || nw == nil || nw.Data != "raw" || !nw.Work.Subpackages {
+		t.Fatalf("Expected a copy of the work item. Got %+v", nw)
+	}
+
+	//the new work item isn't completed so it can't be rerun
+	var e ErrorResponse
+	if code := do(t, "POST", "/api/v1/work/"+w.Key+"/rerun", "", &e); code != http.StatusConflict {
+		t.Fatalf("Expected %d. Got %d", http.StatusConflict, code)
+	}
+}
+
+func TestResults(t *testing.T) {
+	s, key := newTestStore()
+
+	var tests []Test
+	if code := do(t, "GET", "/api/v1/tests?import=github.com/zeebo/irc/sub&revision=abc", "", &Page{Items: &tests}); code != http.StatusOK {
+		t.Fatalf("Expected %d. Got %d", http.StatusOK, code)
+	}
+	if len(tests) != 1 || tests[0].Status != entities.TestStatusFail || tests[0].Work != key.Hex() || tests[0].Output != "" {
+		t.Fatalf("Got %+v", tests)
+	}
+
+	var test Test
+	if code := do(t, "GET", "/api/v1/tests/"+tests[0].ID, "", &test); code != http.StatusOK {
+		t.Fatalf("Expected %d. Got %d", http.StatusOK, code)
+	}
+	if test.Output != s.tests[1].Output {
+		t.Fatalf("Expected %q. Got %q", s.tests[1].Output, test.Output)
+	}
+
+	//secrets are masked in output stored before they were registered
+	s.tests[1].Output = "FAIL: token hunter22 is invalid\n"
+	s.masked = []string{"hunter22"}
+	if code := do(t, "GET", "/api/v1/tests/"+tests[0].ID, "", &test); code != http.StatusOK {
+		t.Fatalf("Expected %d. Got %d", http.StatusOK, code)
+	}
+	if test.Output != "FAIL: token ******** is invalid\n" {
+		t.Fatalf("Got %q", test.Output)
+	}
+
+	var e ErrorResponse
+	if code := do(t, "GET", "/api/v1/tests?import=github.com/zeebo/irc&limit=x", "", &e); code != http.StatusBadRequest {
+		t.Fatalf("Expected %d. Got %d", http.StatusBadRequest, code)
+	}
+	if code := do(t, "GET", "/api/v1/nothing", "", &e); code != http.StatusNotFound {
+		t.Fatalf("Expected %d. Got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestListWork(t *testing.T) {
+	s, key := newTestStore()
+	for i := 0; i < 3; i++ {
+		s.Queue(rpc.Work{ImportPath: "github.com/zeebo/goci"})
+	}
+
+	//page through the waiting work items two at a time
+	var keys []string
+	cursor := ""
+	for {
+		var items []Work
+		page := Page{Items: &items}
+		if code := do(t, "GET", "/api/v1/work?status=waiting&limit=2&cursor="+cursor, "", &page); code != http.StatusOK {
+			t.Fatalf("Expected %d. Got %d", http.StatusOK, code)
+		}
+		for _, w := range items {
+			if w.Status != entities.WorkStatusWaiting || w.Result != nil {
+				t.Fatalf("Got %+v", w)
+			}
+			keys = append(keys, w.Key)
+		}
+		if cursor = page.Next; cursor == "" {
+			break
+		}
+	}
+	if len(keys) != 3 || !sort.IsSorted(sort.Reverse(sort.StringSlice(keys))) {
+		t.Fatalf("Expected 3 keys newest first. Got %v", keys)
+	}
+	for _, k := range keys {
+		if k == key.Hex() {
+			t.Fatal("completed work item was listed")
+		}
+	}
+
+	var e ErrorResponse
+	if code := do(t, "GET", "/api/v1/work?status=nope", "", &e); code != http.StatusBadRequest {
+		t.Fatalf("Expected %d. Got %d", http.StatusBadRequest, code)
+	}
+	if code := do(t, "GET", "/api/v1/work?cursor=nope", "", &e); code != http.StatusBadRequest {
+		t.Fatalf("Expected %d. Got %d", http.StatusBadRequest, code)
+	}
+}
+
+func TestRequeueCancel(t *testing.T) {
+	s, key := newTestStore()
+	w, _ := s.Queue(rpc.Work{ImportPath: "github.com/zeebo/goci"})
+
+	var v Work
+	if code := do(t, "POST", "/api/v1/work/"+w.ID.Hex()+"/cancel", "", &v); code != http.StatusOK {
+		t.Fatalf("Expected %d. Got %d", http.StatusOK, code)
+	}
+	if v.Status != entities.WorkStatusCanceled {
+		t.Fatalf("Expected %q. Got %q", entities.WorkStatusCanceled, v.Status)
+	}
+	if code := do(t, "POST", "/api/v1/work/"+w.ID.Hex()+"/requeue", "", &v); code != http.StatusOK {
+		t.Fatalf("Expected %d. Got %d", http.StatusOK, code)
+	}
+	if v.Status != entities.WorkStatusWaiting {
+		t.Fatalf("Expected %q. Got %q", entities.WorkStatusWaiting, v.Status)
+	}
+
+	var e ErrorResponse
+	if code := do(t, "POST", "/api/v1/work/"+key.Hex()+"/cancel", "", &e); code != http.StatusConflict {
+		t.Fatalf("Expected %d. Got %d", http.StatusConflict, code)
+	}
+	if code := do(t, "POST", "/api/v1/work/"+bson.NewObjectId().Hex()+"/requeue", "", &e); code != http.StatusNotFound {
+		t.Fatalf("Expected %d. Got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestWorkResults(t *testing.T) {
+	s, key := newTestStore()
+	rid := s.results[key].ID
+
+	var results []Result
+	if code := do(t, "GET", "/api/v1/workresults", "", &Page{Items: &results}); code != http.StatusOK {
+		t.Fatalf("Expected %d. Got %d", http.StatusOK, code)
+	}
+	if len(results) != 1 || results[0].Work != key.Hex() || results[0].Tests != nil {
+		t.Fatalf("Got %+v", results)
+	}
+
+	var res Result
+	if code := do(t, "GET", "/api/v1/workresults/"+rid.Hex(), "", &res); code != http.StatusOK {
+		t.Fatalf("Expected %d. Got %d", http.StatusOK, code)
+	}
+	if res.ID != rid.Hex() || len(res.Tests) != 2 {
+		t.Fatalf("Got %+v", res)
+	}
+}
+
+func TestPackages(t *testing.T) {
+	newTestStore()
+
+	var pkgs []Package
+	page := Page{Items: &pkgs}
+	if code := do(t, "GET", "/api/v1/packages?limit=2", "", &page); code != http.StatusOK {
+		t.Fatalf("Expected %d. Got %d", http.StatusOK, code)
+	}
+	if len(pkgs) != 2 || page.Next != "2" {
+		t.Fatalf("Got %+v %q", pkgs, page.Next)
+	}
+
+	pkgs, page = nil, Page{Items: &pkgs}
+	if code := do(t, "GET", "/api/v1/packages?limit=2&cursor=2", "", &page); code != http.StatusOK {
+		t.Fatalf("Expected %d. Got %d", http.StatusOK, code)
+	}
+	if len(pkgs) != 1 || pkgs[0].ImportPath != "c" || page.Next != "" {
+		t.Fatalf("Got %+v %q", pkgs, page.Next)
+	}
+
+	var workers []Worker
+	if code := do(t, "GET", "/api/v1/workers", "", &Page{Items: &workers}); code != http.StatusOK {
+		t.Fatalf("Expected %d. Got %d", http.StatusOK, code)
+	}
+	if len(workers) != 2 || workers[0].Type != "Builder" || workers[1].URL != "http://r" {
+		t.Fatalf("Got %+v", workers)
+	}
+}
+
+func TestOwnedProject(t *testing.T) {
+	s, key := newTestStore()
+	owner := bson.NewObjectId()
+	s.project = &entities.Project{
+		ImportPath: "github.com/zeebo/irc",
+		Owners:     []bson.ObjectId{owner},
+		Private:    true,
+	}
+	test := s.tests[0].ID.Hex()
+
+	//anonymous users can't change the work or see the output
+	var e ErrorResponse
+	if code := do(t, "POST", "/api/v1/work/"+key.Hex()+"/rerun", "", &e); code != http.StatusForbidden {
+		t.Fatalf("Expected %d. Got %d", http.StatusForbidden, code)
+	}
+	if code := do(t, "POST", "/api/v1/work/"+key.Hex()+"/cancel", "", &e); code != http.StatusForbidden {
+		t.Fatalf("Expected %d. Got %d", http.StatusForbidden, code)
+	}
+	if code := do(t, "GET", "/api/v1/tests/"+test, "", &e); code != http.StatusNotFound {
+		t.Fatalf("Expected %d. Got %d", http.StatusNotFound, code)
+	}
+	if code := do(t, "POST", "/api/v1/work", `{"ImportPath":"github.com/zeebo/irc"}`, &e); code != http.StatusForbidden {
+		t.Fatalf("Expected %d. Got %d", http.StatusForbidden, code)
+	}
+
+	//or see the errors and tests of the results
+	s.results[key].Error = "clone failed"
+	rid := s.results[key].ID.Hex()
+	var w Work
+	if code := do(t, "GET", "/api/v1/work/"+key.Hex(), "", &w); code != http.StatusOK {
+		t.Fatalf("Expected %d. Got %d", http.StatusOK, code)
+	}
+	if w.Result == nil || w.Result.Error != "" || w.Result.Tests != nil {
+		t.Fatalf("Expected a hidden result. Got %+v", w.Result)
+	}
+	var r Result
+	if code := do(t, "GET", "/api/v1/workresults/"+rid, "", &r); code != http.StatusOK {
+		t.Fatalf("Expected %d. Got %d", http.StatusOK, code)
+	}
+	if r.Error != "" || r.Tests != nil {
+		t.Fatalf("Expected a hidden result. Got %+v", r)
+	}
+	var results []Result
+	if code := do(t, "GET", "/api/v1/workresults", "", &Page{Items: &results}); code != http.StatusOK {
+		t.Fatalf("Expected %d. Got %d", http.StatusOK, code)
+	}
+	if len(results) != 1 || results[0].Error != "" {
+		t.Fatalf("Expected hidden results. Got %+v", results)
+	}
+
+	//and neither can other users
+	s.user = &entities.User{ID: bson.NewObjectId()}
+	if code := do(t, "POST", "/api/v1/work/"+key.Hex()+"/rerun", "", &e); code != http.StatusForbidden {
+		t.Fatalf("Expected %d. Got %d", http.StatusForbidden, code)
+	}
+
+	//but the owner can
+	s.user = &entities.User{ID: owner}
+	if code := do(t, "GET", "/api/v1/workresults/"+rid, "", &r); code != http.StatusOK || r.Error == "" || len(r.Tests) != 2 {
+		t.Fatalf("Expected %d with the result. Got %d %+v", http.StatusOK, code, r)
+	}
+	if code := do(t, "POST", "/api/v1/work/"+key.Hex()+"/rerun", "", &w); code != http.StatusCreated {
+		t.Fatalf("Expected %d. Got %d", http.StatusCreated, code)
+	}
+	var res Test
+	if code := do(t, "GET", "/api/v1/tests/"+test, "", &res); code != http.StatusOK || res.Output == "" {
+		t.Fatalf("Expected %d with output. Got %d %+v", http.StatusOK, code, res)
+	}
+}
@@ -0,0 +1,49 @@
+//package api is the JSON api for goci
+/*
+The api is served under /api/v1/ and is what the goci command talks to. Every
+response is json, including errors which have the http status code and a
+message:
+
+	{"Code": 404, "Message": "no work item with key \"...\""}
+
+Lists respond with a Page of at most limit items (20 by default, at most 100)
+and the cursor of the next page, which is left out on the last page:
+
+	{"Items": [...], "Next": "..."}
+
+The endpoints are:
+
+	GET /api/v1/work?status=s&import=path&cursor=c&limit=n
+		returns a page of Work, newest first, without their results
+	POST /api/v1/work
+		queues the rpc.Work in the body and returns the Work it was stored as
+	GET /api/v1/work/{key}
+		returns the Work with its Result and every Test once it has completed
+	POST /api/v1/work/{key}/rerun
+		queues a completed work item again and returns the new Work
+	POST /api/v1/work/{key}/requeue
+		puts a work item that hasn't completed back in the queue
+	POST /api/v1/work/{key}/cancel
+		stops a work item that hasn't completed from being handed out
+	GET /api/v1/workresults?cursor=c&limit=n
+		returns a page of Results, newest first, without their tests
+	GET /api/v1/workresults/{id}
+		returns the Result with every Test
+	GET /api/v1/tests?import=path&revision=r&status=s&cursor=c&limit=n
+		returns a page of Tests, newest first, without their output
+	GET /api/v1/tests/{id}
+		returns the Test with its output
+	GET /api/v1/packages?cursor=c&limit=n
+		returns a page of the latest Package status of every import path
+	GET /api/v1/workers
+		returns every Worker in the tracker
+
+Requeueing or canceling a completed work item, or one that changes at the same
+time, responds with 409 Conflict.
+
+Rerunning, requeueing or canceling a work item in a project with owners needs the
+session cookie of one of them and responds with 403 Forbidden otherwise. The
+output of tests in a private project is only returned to its owners, and is
+404 Not Found for anyone else.
+*/
+package api
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"github.com/zeebo/goci/app/httputil"
+	"net/http"
+	"strconv"
+)
+
+//listPackages returns a page of the latest status of every import path, most
+//recently committed first. The cursor is how many packages to skip.
+func listPackages(req *http.Request, ctx httputil.Context) (code int, v interface{}, e *httputil.Error) {
+	if err := req.ParseForm(); err != nil {
+		e = errorf(http.StatusBadRequest, err, "error parsing form")
+		return
+	}
+	n, e := limit(req)
+	if e != nil {
+		return
+	}
+	var skip int
+	if c := req.Form.Get("cursor"); c != "" {
+		var err error
+		if skip, err = strconv.Atoi(c); err != nil || skip < 0 {
+			e = errorf(http.StatusBadRequest, err, "invalid cursor %q", c)
+			return
+		}
+	}
+
+	pkgs, err := newStore(ctx).Packages()
+	if err != nil {
+		e = httputil.Errorf(err, "error loading packages")
+		return
+	}
+
+	var page Page
+	if skip > len(pkgs) {
+		skip = len(pkgs)
+	}
+	pkgs = pkgs[skip:]
+	if len(pkgs) > n {
+		pkgs = pkgs[:n]
+		page.Next = strconv.Itoa(skip + n)
+	}
+	if pkgs == nil {
+		pkgs = []Package{}
+	}
+	page.Items = pkgs
+	code, v = http.StatusOK, page
+	return
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"github.com/zeebo/goci/app/entities"
+	"github.com/zeebo/goci/app/httputil"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"strconv"
+)
+
+//defaultLimit and maxLimit bound how many results are returned at once.
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+//limit returns the limit query parameter bounded by maxLimit.
+func limit(req *http.Request) (n int, e *httputil.Error) {
+	n = defaultLimit
+	if q := req.FormValue("limit"); q != "" {
+		var err error
+		if n, err = strconv.Atoi(q); err != nil || n <= 0 {
+			e = errorf(http.StatusBadRequest, err, "invalid limit %q", q)
+			return
+		}
+	}
+	if n > maxLimit {
+		n = maxLimit
+	}
+	return
+}
+
+//listPage returns the page asked for with the cursor and limit query
+//parameters. The cursor is the Next of the previous page.
+func listPage(req *http.Request) (p page, e *httputil.Error) {
+	if err := req.ParseForm(); err != nil {
+		e = errorf(http.StatusBadRequest, err, "error parsing form")
+		return
+	}
+	if p.limit, e = limit(req); e != nil {
+		return
+	}
+	if c := req.Form.Get("cursor"); c != "" {
+		if !bson.IsObjectIdHex(c) {
+			e = errorf(http.StatusBadRequest, nil, "invalid cursor %q", c)
+			return
+		}
+		p.after = bson.ObjectIdHex(c)
+	}
+	return
+}
+
+//more returns if a list of n items loaded for the page goes on to another
+//page.
+func (p page) more(n int) bool {
+	return n > p.limit
+}
+
+//listTests returns a page of test results, newest first, without their output.
+//They can be filtered by import path, revision and status.
+func listTests(req *http.Request, ctx httputil.Context) (code int, v interface{}, e *httputil.Error) {
+	p, e := listPage(req)
+	if e != nil {
+		return
+	}
+	f := TestFilter{
+		ImportPath: req.Form.Get("import"),
+		Revision:   req.Form.Get("revision"),
+		Status:     req.Form.Get("status"),
+	}
+	s := newStore(ctx)
+
+	res, err := s.TestList(f, p)
+	if err != nil {
+		e = httputil.Errorf(err, "error loading test results")
+		return
+	}
+	var page Page
+	if p.more(len(res)) {
+		res = res[:p.limit]
+		page.Next = res[p.limit-1].ID.Hex()
+	}
+
+	//find the work items the results came from
+	var ids []bson.ObjectId
+	for _, t := range res {
+		ids = append(ids, t.WorkResultID)
+	}
+	keys, err := s.WorkKeys(ids)
+	if err != nil {
+		e = httputil.Errorf(err, "error loading work results")
+		return
+	}
+
+	tests := []Test{}
+	for _, t := range res {
+		var work string
+		if key, ok := keys[t.WorkResultID]; ok {
+			work = key.Hex()
+		}
+		t.Output = ""
+		tests = append(tests, newTest(t, work))
+	}
+	page.Items = tests
+	code, v = http.StatusOK, page
+	return
+}
+
+//getTest returns a test result with its output.
+func getTest(req *http.Request, ctx httputil.Context) (code int, v interface{}, e *httputil.Error) {
+	id, e := objectId(req, "id")
+	if e != nil {
+		return
+	}
+	s := newStore(ctx)
+
+	t, err := s.Test(id)
+	if err != nil {
+		e = lookupError(err, "no test result with id %q", id.Hex())
+		return
+	}
+
+	//the output of private projects is only for their owners
+	ok, e := canSee(req, s, t.ImportPath)
+	if e != nil {
+		return
+	}
+	if !ok {
+		e = errorf(http.StatusNotFound, nil, "no test result with id %q", id.Hex())
+		return
+	}
+
+	//mask secrets that were registered after the output was stored
+	m, err := s.Masker(t.ImportPath)
+	if err != nil {
+		e = httputil.Errorf(err, "error loading secrets")
+		return
+	}
+	t.Output = m.Mask(t.Output)
+
+	var work string
+	keys, err := s.WorkKeys([]bson.ObjectId{t.WorkResultID})
+	if err != nil {
+		e = httputil.Errorf(err, "error loading work result")
+		return
+	}
+	if key, ok := keys[t.WorkResultID]; ok {
+		work = key.Hex()
+	}
+	code, v = http.StatusOK, newTest(*t, work)
+	return
+}
+
+//visibleResult reports if the user logged in with the request can see the
+//error and tests of the result, which are only for the owners of private
+//projects. Results without a work item are never shown.
+func visibleResult(req *http.Request, s store, res *entities.WorkResult) (ok bool, e *httputil.Error) {
+	w, err := s.Work(res.WorkID)
+	if err == mgo.ErrNotFound {
+		return
+	}
+	if err != nil {
+		e = httputil.Errorf(err, "error loading work item")
+		return
+	}
+	return canSee(req, s, w.Work.ImportPath)
+}
+
+//listWorkResults returns a page of work results, newest first, without their
+//tests.
+func listWorkResults(req *http.Request, ctx httputil.Context) (code int, v interface{}, e *httputil.Error) {
+	p, e := listPage(req)
+	if e != nil {
+		return
+	}
+	s := newStore(ctx)
+
+	res, err := s.WorkResults(p)
+	if err != nil {
+		e = httputil.Errorf(err, "error loading work results")
+		return
+	}
+
+	var page Page
+	if p.more(len(res)) {
+		res = res[:p.limit]
+		page.Next = res[p.limit-1].ID.Hex()
+	}
+	items := []*Result{}
+	for i := range res {
+		var ok bool
+		if ok, e = visibleResult(req, s, &res[i]); e != nil {
+			return
+		}
+		r := newResult(&res[i], nil)
+		if !ok {
+			r.hide()
+		}
+		items = append(items, r)
+	}
+	page.Items = items
+	code, v = http.StatusOK, page
+	return
+}
+
+//getWorkResult returns a work result with its tests.
+func getWorkResult(req *http.Request, ctx httputil.Context) (code int, v interface{}, e *httputil.Error) {
+	id, e := objectId(req, "id")
+	if e != nil {
+		return
+	}
+	s := newStore(ctx)
+
+	res, err := s.WorkResultByID(id)
+	if err != nil {
+		e = lookupError(err, "no work result with id %q", id.Hex())
+		return
+	}
+	tests, err := s.Tests(id)
+	if err != nil {
+		e = httputil.Errorf(err, "error loading test results")
+		return
+	}
+	r := newResult(res, tests)
+
+	ok, e := visibleResult(req, s, res)
+	if e != nil {
+		return
+	}
+	if !ok {
+		r.hide()
+	}
+	code, v = http.StatusOK, r
+	return
+}
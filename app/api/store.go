@@ -0,0 +1,201 @@
+package api
+
+import (
+	"github.com/zeebo/goci/app/accounts"
+	"github.com/zeebo/goci/app/entities"
+	"github.com/zeebo/goci/app/httputil"
+	"github.com/zeebo/goci/app/tracker"
+	"github.com/zeebo/goci/app/workqueue"
+	"github.com/zeebo/goci/redact"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"time"
+)
+
+var newStore = func(ctx httputil.Context) store {
+	return &mgoStore{ctx: ctx}
+}
+
+//page is a window into a list sorted newest first. Lists return one more item
+//than the limit so we know if there is another page.
+type page struct {
+	after bson.ObjectId //only items older than this one, if set
+	limit int
+}
+
+//WorkFilter picks the work items to list. Empty fields match everything.
+type WorkFilter struct {
+	Status     string
+	ImportPath string
+}
+
+//TestFilter picks the test results to list. Empty fields match everything.
+type TestFilter struct {
+	ImportPath string
+	Revision   string
+	Status     string
+}
+
+//Package is the latest status of an import path.
+type Package struct {
+	ImportPath string
+	Status     string
+	When       time.Time //when the revision with the status was committed
+}
+
+//store is how the api reads and changes work so tests can stub out mongo.
+type store interface {
+	Queue(d workqueue.Distiller) (*entities.Work, error)
+	Requeue(key bson.ObjectId) error
+	Cancel(key bson.ObjectId) error
+	Work(key bson.ObjectId) (*entities.Work, error)
+	WorkList(f WorkFilter, p page) ([]entities.Work, error)
+	WorkResult(key bson.ObjectId) (*entities.WorkResult, error)
+	WorkResultByID(id bson.ObjectId) (*entities.WorkResult, error)
+	WorkResults(p page) ([]entities.WorkResult, error)
+	Tests(result bson.ObjectId) ([]entities.TestResult, error)
+	TestList(f TestFilter, p page) ([]entities.TestResult, error)
+	Test(id bson.ObjectId) (*entities.TestResult, error)
+	WorkKeys(results []bson.ObjectId) (map[bson.ObjectId]bson.ObjectId, error)
+	Packages() ([]Package, error)
+	Workers() ([]tracker.Builder, []tracker.Runner, error)
+	User(req *http.Request) (*entities.User, error)
+	Project(importPath string) (*entities.Project, error)
+	Masker(importPath string) (*redact.Masker, error)
+}
+
+type mgoStore struct {
+	ctx httputil.Context
+}
+
+//find runs the query for the page of the collection, newest first, leaving
+//out the fields in omit.
+func (m *mgoStore) find(c string, sel bson.M, p page, omit bson.M, res interface{}) error {
+	if p.after != "" {
+		sel["_id"] = bson.M{"$lt": p.after}
+	}
+	return m.ctx.DB.C(c).Find(sel).Select(omit).Sort("-_id").Limit(p.limit + 1).All(res)
+}
+
+func (m *mgoStore) Queue(d workqueue.Distiller) (*entities.Work, error) {
+	return workqueue.Queue(m.ctx, d)
+}
+
+func (m *mgoStore) Requeue(key bson.ObjectId) error {
+	return workqueue.Requeue(m.ctx, key)
+}
+
+func (m *mgoStore) Cancel(key bson.ObjectId) error {
+	return workqueue.Cancel(m.ctx, key)
+}
+
+func (m *mgoStore) Work(key bson.ObjectId) (work *entities.Work, err error) {
+	err = m.ctx.DB.C("Work").FindId(key).One(&work)
+	return
+}
+
+func (m *mgoStore) WorkList(f WorkFilter, p page) (res []entities.Work, err error) {
+	sel := bson.M{}
+	if f.Status != "" {
+		sel["status"] = f.Status
+	}
+	if f.ImportPath != "" {
+		sel["work.importpath"] = f.ImportPath
+	}
+	err = m.find("Work", sel, p, bson.M{"data": 0, "built": 0}, &res)
+	return
+}
+
+//WorkResult returns the result of the work item, or nil if it doesn't have one
+//yet.
+func (m *mgoStore) WorkResult(key bson.ObjectId) (res *entities.WorkResult, err error) {
+	err = m.ctx.DB.C("WorkResult").Find(bson.M{"workid": key}).Sort("-when").One(&res)
+	if err == mgo.ErrNotFound {
+		res, err = nil, nil
+	}
+	return
+}
+
+func (m *mgoStore) WorkResultByID(id bson.ObjectId) (res *entities.WorkResult, err error) {
+	err = m.ctx.DB.C("WorkResult").FindId(id).One(&res)
+	return
+}
+
+func (m *mgoStore) WorkResults(p page) (res []entities.WorkResult, err error) {
+	err = m.find("WorkResult", bson.M{}, p, nil, &res)
+	return
+}
+
+func (m *mgoStore) Tests(result bson.ObjectId) (res []entities.TestResult, err error) {
+	err = m.ctx.DB.C("TestResult").Find(bson.M{"workresultid": result}).Sort("importpath").All(&res)
+	return
+}
+
+//TestList returns the test results matching the filter without their output.
+func (m *mgoStore) TestList(f TestFilter, p page) (res []entities.TestResult, err error) {
+	sel := bson.M{}
+	if f.ImportPath != "" {
+		sel["importpath"] = f.ImportPath
+	}
+	if f.Revision != "" {
+		sel["revision"] = f.Revision
+	}
+	if f.Status != "" {
+		sel["status"] = f.Status
+	}
+	err = m.find("TestResult", sel, p, bson.M{"output": 0}, &res)
+	return
+}
+
+func (m *mgoStore) Test(id bson.ObjectId) (res *entities.TestResult, err error) {
+	err = m.ctx.DB.C("TestResult").FindId(id).One(&res)
+	return
+}
+
+//WorkKeys returns the keys of the work items for the work results.
+func (m *mgoStore) WorkKeys(results []bson.ObjectId) (keys map[bson.ObjectId]bson.ObjectId, err error) {
+	var res []entities.WorkResult
+	err = m.ctx.DB.C("WorkResult").
+		Find(bson.M{"_id": bson.M{"$in": results}}).
+		Select(bson.M{"workid": 1}).
+		All(&res)
+	if err != nil {
+		return
+	}
+
+	keys = map[bson.ObjectId]bson.ObjectId{}
+	for _, r := range res {
+		keys[r.ID] = r.WorkID
+	}
+	return
+}
+
+//Packages returns the latest status of every import path, most recently
+//committed first.
+func (m *mgoStore) Packages() (pkgs []Package, err error) {
+	var res []entities.Package
+	if err = m.ctx.DB.C("Package").Find(nil).Sort("-revdate").All(&res); err != nil {
+		return
+	}
+	for _, r := range res {
+		pkgs = append(pkgs, Package{r.ImportPath, r.Status, r.RevDate})
+	}
+	return
+}
+
+func (m *mgoStore) Workers() ([]tracker.Builder, []tracker.Runner, error) {
+	return tracker.Services(m.ctx)
+}
+
+func (m *mgoStore) User(req *http.Request) (*entities.User, error) {
+	return accounts.CurrentUser(m.ctx, req)
+}
+
+func (m *mgoStore) Project(importPath string) (*entities.Project, error) {
+	return accounts.Project(m.ctx, importPath)
+}
+
+func (m *mgoStore) Masker(importPath string) (*redact.Masker, error) {
+	return accounts.Masker(m.ctx, importPath)
+}
@@ -0,0 +1,312 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/zeebo/goci/app/entities"
+	"github.com/zeebo/goci/app/httputil"
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/app/workqueue"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"time"
+)
+
+//Work is a work item with its result once it has completed. Secrets and the
+//tasks handed to workers are left out.
+type Work struct {
+	Key      string
+	Work     rpc.Work
+	Status   string    //waiting, processing, built, completed or canceled
+	Created  time.Time //when the work item was queued
+	Attempts int       //how many times the work item has been handed out
+	Builder  string    `json:",omitempty"` //the builder of the latest attempt
+	Runner   string    `json:",omitempty"` //the runner of the latest attempt
+	Worker   string    `json:",omitempty"` //the worker holding the lease on the work item
+	Result   *Result   `json:",omitempty"` //the result once the work item is completed
+}
+
+//Result is the result of a completed work item. Tests are only sent with a
+//single result.
+type Result struct {
+	ID       string
+	Work     string    `json:",omitempty"` //the key of the work item it is for
+	Success  bool      //false if there was an error before any tests ran
+	Revision string    //the revision that was tested
+	RevDate  time.Time //when the revision was committed
+	When     time.Time //when the result was recorded
+	Error    string    `json:",omitempty"` //the error if it wasn't a success
+	Tests    []Test    `json:",omitempty"` //the results of the tests of each package
+}
+
+//Test is the result of testing a package. Output is only sent when a single
+//test is requested.
+type Test struct {
+	ID         string
+	Work       string `json:",omitempty"` //the key of the work item that produced it
+	ImportPath string
+	Revision   string
+	RevDate    time.Time
+	When       time.Time
+	Status     string //Pass, Fail, WontBuild or Error
+	Output     string `json:",omitempty"`
+}
+
+//newWork returns the api view of the work item and its result, if any.
+func newWork(w *entities.Work, res *entities.WorkResult, tests []entities.TestResult) (v *Work) {
+	v = &Work{
+		Key:      w.ID.Hex(),
+		Work:     w.Work,
+		Status:   w.Status,
+		Created:  w.Created,
+		Attempts: len(w.AttemptLog),
+		Worker:   w.Lease.Worker,
+	}
+	if len(w.AttemptLog) > 0 {
+		v.Builder, v.Runner = w.AttemptLog[0].Builder, w.AttemptLog[0].Runner
+	}
+	if res != nil {
+		v.Result = newResult(res, tests)
+	}
+	return
+}
+
+//newResult returns the api view of the work result and its tests.
+func newResult(res *entities.WorkResult, tests []entities.TestResult) (v *Result) {
+	v = &Result{
+		ID:       res.ID.Hex(),
+		Work:     res.WorkID.Hex(),
+		Success:  res.Success,
+		Revision: res.Revision,
+		RevDate:  res.RevDate,
+		When:     res.When,
+		Error:    res.Error,
+	}
+	for _, t := range tests {
+		t.Output = ""
+		v.Tests = append(v.Tests, newTest(t, v.Work))
+	}
+	return
+}
+
+//hide removes the error and tests from the result for users that can't see
+//the project it is for.
+func (r *Result) hide() {
+	r.Error, r.Tests = "", nil
+}
+
+//newTest returns the api view of the test result.
+func newTest(t entities.TestResult, work string) Test {
+	return Test{
+		ID:         t.ID.Hex(),
+		Work:       work,
+		ImportPath: t.ImportPath,
+		Revision:   t.Revision,
+		RevDate:    t.RevDate,
+		When:       t.When,
+		Status:     t.Status,
+		Output:     t.Output,
+	}
+}
+
+//objectId parses the named path parameter as an object id.
+func objectId(req *http.Request, name string) (id bson.ObjectId, e *httputil.Error) {
+	if err := req.ParseForm(); err != nil {
+		e = errorf(http.StatusBadRequest, err, "error parsing form")
+		return
+	}
+	hex := grab(req.Form, name)
+	if !bson.IsObjectIdHex(hex) {
+		e = errorf(http.StatusBadRequest, nil, "invalid %s %q", name, hex)
+		return
+	}
+	id = bson.ObjectIdHex(hex)
+	return
+}
+
+//loadWork returns the api view of the work item with the key. The result is
+//hidden unless the user logged in with the request can see the project.
+func loadWork(req *http.Request, s store, key bson.ObjectId) (v *Work, e *httputil.Error) {
+	w, err := s.Work(key)
+	if err != nil {
+		e = lookupError(err, "no work item with key %q", key.Hex())
+		return
+	}
+	if w.Status != entities.WorkStatusCompleted {
+		v = newWork(w, nil, nil)
+		return
+	}
+
+	res, err := s.WorkResult(key)
+	if err != nil {
+		e = httputil.Errorf(err, "error loading work result")
+		return
+	}
+	var tests []entities.TestResult
+	if res != nil {
+		if tests, err = s.Tests(res.ID); err != nil {
+			e = httputil.Errorf(err, "error loading test results")
+			return
+		}
+	}
+	v = newWork(w, res, tests)
+	if v.Result == nil {
+		return
+	}
+
+	ok, e := canSee(req, s, w.Work.ImportPath)
+	if !ok {
+		v.Result.hide()
+	}
+	return
+}
+
+//workStatuses are the statuses work items can be listed by.
+var workStatuses = map[string]bool{
+	entities.WorkStatusWaiting:    true,
+	entities.WorkStatusProcessing: true,
+	entities.WorkStatusBuilt:      true,
+	entities.WorkStatusCompleted:  true,
+	entities.WorkStatusCanceled:   true,
+}
+
+//listWork returns a page of work items, newest first, without their results.
+//They can be filtered by status and import path.
+func listWork(req *http.Request, ctx httputil.Context) (code int, v interface{}, e *httputil.Error) {
+	p, e := listPage(req)
+	if e != nil {
+		return
+	}
+	f := WorkFilter{
+		Status:     req.Form.Get("status"),
+		ImportPath: req.Form.Get("import"),
+	}
+	if f.Status != "" && !workStatuses[f.Status] {
+		e = errorf(http.StatusBadRequest, nil, "invalid status %q", f.Status)
+		return
+	}
+
+	res, err := newStore(ctx).WorkList(f, p)
+	if err != nil {
+		e = httputil.Errorf(err, "error loading work items")
+		return
+	}
+
+	var page Page
+	if p.more(len(res)) {
+		res = res[:p.limit]
+		page.Next = res[p.limit-1].ID.Hex()
+	}
+	items := []*Work{}
+	for i := range res {
+		items = append(items, newWork(&res[i], nil, nil))
+	}
+	page.Items = items
+	code, v = http.StatusOK, page
+	return
+}
+
+//queueWork queues the rpc.Work in the body of the request.
+func queueWork(req *http.Request, ctx httputil.Context) (code int, v interface{}, e *httputil.Error) {
+	var work rpc.Work
+	if err := json.NewDecoder(req.Body).Decode(&work); err != nil {
+		e = errorf(http.StatusBadRequest, err, "invalid work: %s", err)
+		return
+	}
+	if work.ImportPath == "" {
+		e = errorf(http.StatusBadRequest, nil, "work needs an import path")
+		return
+	}
+	s := newStore(ctx)
+	if e = checkOwner(req, s, work.ImportPath); e != nil {
+		return
+	}
+
+	w, err := s.Queue(work)
+	if err != nil {
+		e = httputil.Errorf(err, "error queuing work")
+		return
+	}
+	code, v = http.StatusCreated, newWork(w, nil, nil)
+	return
+}
+
+//getWork returns the work item with its result.
+func getWork(req *http.Request, ctx httputil.Context) (code int, v interface{}, e *httputil.Error) {
+	key, e := objectId(req, "key")
+	if e != nil {
+		return
+	}
+	w, e := loadWork(req, newStore(ctx), key)
+	if e != nil {
+		return
+	}
+	code, v = http.StatusOK, w
+	return
+}
+
+//rerunWork queues a completed work item again.
+func rerunWork(req *http.Request, ctx httputil.Context) (code int, v interface{}, e *httputil.Error) {
+	key, e := objectId(req, "key")
+	if e != nil {
+		return
+	}
+	s := newStore(ctx)
+
+	w, err := s.Work(key)
+	if err != nil {
+		e = lookupError(err, "no work item with key %q", key.Hex())
+		return
+	}
+	if e = checkOwner(req, s, w.Work.ImportPath); e != nil {
+		return
+	}
+	if w.Status != entities.WorkStatusCompleted {
+		e = errorf(http.StatusConflict, nil, "work item %q is still %s", key.Hex(), w.Status)
+		return
+	}
+
+	nw, err := s.Queue(workqueue.Rerun{Work: w})
+	if err != nil {
+		e = httputil.Errorf(err, "error queuing work")
+		return
+	}
+	code, v = http.StatusCreated, newWork(nw, nil, nil)
+	return
+}
+
+//changeWork returns a handler that changes the work item with the key and
+//responds with it afterwards.
+func changeWork(change func(s store, key bson.ObjectId) error) handler {
+	return func(req *http.Request, ctx httputil.Context) (code int, v interface{}, e *httputil.Error) {
+		key, e := objectId(req, "key")
+		if e != nil {
+			return
+		}
+		s := newStore(ctx)
+
+		w, err := s.Work(key)
+		if err != nil {
+			e = lookupError(err, "no work item with key %q", key.Hex())
+			return
+		}
+		if e = checkOwner(req, s, w.Work.ImportPath); e != nil {
+			return
+		}
+		if err := change(s, key); err != nil {
+			e = changeError(err, key)
+			return
+		}
+		res, e := loadWork(req, s, key)
+		if e != nil {
+			return
+		}
+		code, v = http.StatusOK, res
+		return
+	}
+}
+
+//requeueWork puts a work item that hasn't completed back in the queue.
+var requeueWork = changeWork(store.Requeue)
+
+//cancelWork stops a work item that hasn't completed from being handed out.
+var cancelWork = changeWork(store.Cancel)
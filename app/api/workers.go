@@ -0,0 +1,59 @@
+package api
+
+import (
+	"github.com/zeebo/goci/app/httputil"
+	"net/http"
+	"time"
+)
+
+//Worker is a builder or runner in the tracker.
+type Worker struct {
+	Type         string //Builder or Runner
+	URL          string
+	GOOS, GOARCH string
+	Toolchains   []string
+	Labels       []string
+	Load         int       //how many tasks the worker has
+	Leased       time.Time //when the worker was last handed a task
+	LastSeen     time.Time //when the worker last announced or sent a heartbeat
+}
+
+//listWorkers returns every builder and runner in the tracker. There is only
+//ever one page.
+func listWorkers(req *http.Request, ctx httputil.Context) (code int, v interface{}, e *httputil.Error) {
+	bs, rs, err := newStore(ctx).Workers()
+	if err != nil {
+		e = httputil.Errorf(err, "error loading workers")
+		return
+	}
+
+	workers := []Worker{}
+	for _, b := range bs {
+		workers = append(workers, Worker{
+			Type:       "Builder",
+			URL:        b.URL,
+			GOOS:       b.GOOS,
+			GOARCH:     b.GOARCH,
+			Toolchains: b.Capabilities.Toolchains,
+			Labels:     b.Capabilities.Labels,
+			Load:       b.Load,
+			Leased:     b.Leased,
+			LastSeen:   b.LastSeen,
+		})
+	}
+	for _, r := range rs {
+		workers = append(workers, Worker{
+			Type:       "Runner",
+			URL:        r.URL,
+			GOOS:       r.GOOS,
+			GOARCH:     r.GOARCH,
+			Toolchains: r.Capabilities.Toolchains,
+			Labels:     r.Capabilities.Labels,
+			Load:       r.Load,
+			Leased:     r.Leased,
+			LastSeen:   r.LastSeen,
+		})
+	}
+	code, v = http.StatusOK, Page{Items: workers}
+	return
+}
@@ -2,8 +2,10 @@
 package entities
 
 import (
+	"fmt"
 	"github.com/zeebo/goci/app/rpc"
 	"labix.org/v2/mgo/bson"
+	"strings"
 	"time"
 )
 
@@ -37,12 +39,15 @@ type TestResult struct {
 	ID           bson.ObjectId `bson:"_id,omitempty" json:"-"`
 	WorkResultID bson.ObjectId `json:"-"` //key of the work result that this came from
 
-	ImportPath string    //import path of the test
-	Revision   string    //revision of the source code
-	RevDate    time.Time //when the revision was commit
-	When       time.Time //when the test result was recorded
-	Output     string    //the output of the test
-	Status     string    //the status of the build: (Pass/Fail/WontBuild/Error)
+	ImportPath string        //import path of the test
+	Revision   string        //revision of the source code
+	RevDate    time.Time     //when the revision was commit
+	When       time.Time     //when the test result was recorded
+	Output     string        //the output of the test
+	Status     string        //the status of the build: (Pass/Fail/WontBuild/Error)
+	Branch     string        //the branch or tag the work item asked for, if any
+	Toolchain  string        //the toolchain the work item asked for, if any
+	Duration   time.Duration //how long the test ran, if it ran
 }
 
 const (
@@ -52,6 +57,68 @@ const (
 	TestStatusError     = "Error"
 )
 
+//Package is an entity type that holds the status of the most recently committed
+//revision tested of an import path. It is kept up to date as test results come
+//in so listing packages doesn't have to look at every test result.
+type Package struct {
+	ImportPath string        `bson:"_id"`
+	Status     string        //the status of the test of the revision
+	Revision   string        //the most recently committed revision tested
+	RevDate    time.Time     //when the revision was commit
+	When       time.Time     //when the test result was recorded
+	Test       bson.ObjectId //the test result with the status
+}
+
+//User is an entity type that represents someone who logged in with their
+//account on the code host.
+type User struct {
+	ID        bson.ObjectId `bson:"_id,omitempty"`
+	HostID    int64         //the id of the account on the code host
+	Login     string        //the name of the account on the code host
+	Name      string        //the display name of the account, if any
+	Created   time.Time     //when the user first logged in
+	LastLogin time.Time     //when the user last logged in
+}
+
+//Project is an entity type that holds the settings of an import path and
+//every import path under it. A project without owners can be changed by
+//anyone.
+type Project struct {
+	ImportPath string          `bson:"_id"`
+	Owners     []bson.ObjectId //the users that can change the project
+	Private    bool            //only owners can see the output of its tests
+	Created    time.Time       //when the project was claimed
+}
+
+//Branch returns the branch or tag the work asked for, which is the revision it
+//asked for unless that is the revision that was tested, or a prefix of it.
+func Branch(work rpc.Work, revision string) string {
+	if strings.HasPrefix(revision, work.Revision) {
+		return ""
+	}
+	return work.Revision
+}
+
+//OutputStatus returns the status of the test that generated the output. A test
+//that ran passed if its output ends with PASS.
+func OutputStatus(out rpc.Output) (status string, err error) {
+	switch out.Type {
+	case rpc.OutputSuccess:
+		if strings.HasSuffix(out.Output, "\nPASS\n") {
+			status = TestStatusPass
+		} else {
+			status = TestStatusFail
+		}
+	case rpc.OutputWontBuild:
+		status = TestStatusWontBuild
+	case rpc.OutputError:
+		status = TestStatusError
+	default:
+		err = fmt.Errorf("unknown output type: %s", out.Type)
+	}
+	return
+}
+
 //WorkResult is an entity type that represents the result of the work item being
 //run through the queue. It records any build failures or other errors in
 //generating the test results.
@@ -64,6 +131,10 @@ type WorkResult struct {
 	RevDate  time.Time //when the revision was commit (if known)
 	When     time.Time //the time the work response was recorded
 	Error    string    //a general error before any code could be built
+
+	//Commits are the commits since the revision tested before this one, newest
+	//first.
+	Commits []rpc.Commit
 }
 
 //Work is the datastore entity representing the work item that came in
@@ -76,19 +147,48 @@ type Work struct {
 	Status     string        //the current status of the work item
 	AttemptLog []WorkAttempt //the attempts building the work item
 	Revision   int           //the revision number of the work item
+
+	//fields used when the work item is leased instead of dispatched
+	Lease        WorkLease       //the current lease on the work item
+	GOOS, GOARCH string          //what the leased work item was built for
+	Built        *rpc.RunnerTask //the task waiting for a runner once built
+	Requires     []string        //labels the runner needs from the built configs
 }
 
+//WorkLease represents a worker holding a Work item that it pulled.
+type WorkLease struct {
+	ID       bson.ObjectId `bson:",omitempty"` //handed to the worker to extend or release the lease
+	Phase    string        //the phase of the work item leased (build/run)
+	Worker   string        //the worker holding the lease
+	Deadline time.Time     //when the lease expires
+}
+
+//define some string constants for lease phases
+const (
+	LeasePhaseBuild = "build"
+	LeasePhaseRun   = "run"
+)
+
+//WorkMaxAttempts is the number of attempts a work item gets before it is
+//considered failed.
+const WorkMaxAttempts = 5
+
 //WorkAttempt represents an attempt to build a Work item
 type WorkAttempt struct {
 	ID      bson.ObjectId //a random id for the test attempt
 	When    time.Time     //when the attempt was started
 	Builder string        //the builder the attempt was for
 	Runner  string        //the runner the attempt was for
+
+	//Secret signs the responses for the attempt. It is never shown.
+	Secret string `json:"-"`
 }
 
 //define some string constants for statuses
 const (
 	WorkStatusWaiting    = "waiting"
 	WorkStatusProcessing = "processing"
+	WorkStatusBuilt      = "built" //leased work waiting for a runner
 	WorkStatusCompleted  = "completed"
+	WorkStatusCanceled   = "canceled" //never dispatched or leased again
 )
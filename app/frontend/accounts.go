@@ -0,0 +1,410 @@
+package frontend
+
+import (
+	"crypto/hmac"
+	"github.com/zeebo/goci/app/accounts"
+	"github.com/zeebo/goci/app/entities"
+	"github.com/zeebo/goci/app/httputil"
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/app/workqueue"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"strings"
+)
+
+//currentUser returns the user logged in with the request, or nil.
+var currentUser = accounts.CurrentUser
+
+//canSee returns if the user logged in with the request can see the output of
+//the tests of the import path.
+func canSee(req *http.Request, ctx httputil.Context, m queryManager, importPath string) (ok bool, err error) {
+	p, err := m.Project(importPath)
+	if err != nil || p == nil || !p.Private {
+		ok = err == nil
+		return
+	}
+	u, err := currentUser(ctx, req)
+	if err != nil {
+		return
+	}
+	ok = accounts.CanSee(p, u)
+	return
+}
+
+//login sends the user to the code host to log in.
+func login(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	if !accounts.Enabled() {
+		notFound(w, req)
+		return
+	}
+
+	u, err := accounts.StartLogin(w)
+	if err != nil {
+		e = httputil.Errorf(err, "error starting login")
+		return
+	}
+	http.Redirect(w, req, u, http.StatusFound)
+	return
+}
+
+//loginCallback logs in the user the code host sent back.
+func loginCallback(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	if !accounts.Enabled() {
+		notFound(w, req)
+		return
+	}
+
+	u, err := accounts.FinishLogin(ctx, w, req)
+	if err == accounts.ErrBadState {
+		http.Error(w, "login expired or wasn't started here. try logging in again.", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		e = httputil.Errorf(err, "error logging in")
+		return
+	}
+	flash(w, "Logged in as "+u.Login+".")
+	http.Redirect(w, req, "/account", http.StatusSeeOther)
+	return
+}
+
+//logout ends the session of the user.
+func logout(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	if err := accounts.Logout(ctx, w, req); err != nil {
+		e = httputil.Errorf(err, "error logging out")
+		return
+	}
+	flash(w, "Logged out.")
+	http.Redirect(w, req, "/", http.StatusSeeOther)
+	return
+}
+
+//account shows the logged in user and the projects they own.
+func account(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	u, err := currentUser(ctx, req)
+	if err != nil {
+		e = httputil.Errorf(err, "error loading user")
+		return
+	}
+	if u == nil {
+		if !accounts.Enabled() {
+			notFound(w, req)
+			return
+		}
+		http.Redirect(w, req, "/login", http.StatusFound)
+		return
+	}
+
+	projects, err := accounts.Owned(ctx, u)
+	if err != nil {
+		e = httputil.Errorf(err, "error loading projects")
+		return
+	}
+
+	data := d{"User": u, "Projects": projects, "Namespace": accounts.Config.Host + "/" + u.Login}
+	return render(w, req, ctx, "account/account.html", page{Title: u.Login, Section: "account"}, data)
+}
+
+//project shows the settings of the project an import path belongs to, with
+//forms to change them for its owners.
+func project(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	if err := req.ParseForm(); err != nil {
+		e = httputil.Errorf(err, "error parsing form")
+		return
+	}
+	imp := grab(req.Form, "import")
+	m := newManager(ctx)
+
+	p, err := m.Project(imp)
+	if err != nil {
+		e = httputil.Errorf(err, "error loading project")
+		return
+	}
+	u, err := currentUser(ctx, req)
+	if err != nil {
+		e = httputil.Errorf(err, "error loading user")
+		return
+	}
+
+	owned := p != nil && len(p.Owners) > 0
+	canChange := owned && accounts.IsOwner(p, u)
+	var owners []entities.User
+	if owned {
+		if owners, err = accounts.Owners(ctx, p); err != nil {
+			e = httputil.Errorf(err, "error loading owners")
+			return
+		}
+	}
+
+	//only the owners see if the project has credentials or masked values
+	var cred *accounts.Credential
+	var masked *accounts.Masked
+	if canChange {
+		if cred, err = accounts.StoredCredential(ctx, p.ImportPath); err != nil {
+			e = httputil.Errorf(err, "error loading credentials")
+			return
+		}
+		if masked, err = accounts.StoredMasked(ctx, p.ImportPath); err != nil {
+			e = httputil.Errorf(err, "error loading masked values")
+			return
+		}
+	}
+
+	data := d{
+		"ImportPath":  imp,
+		"Project":     p,
+		"Parent":      p != nil && p.ImportPath != imp,
+		"Owners":      owners,
+		"User":        u,
+		"Login":       accounts.Enabled(),
+		"CanChange":   canChange,
+		"CanRebuild":  accounts.IsOwner(p, u),
+		"CanClaim":    !owned && accounts.CanClaim(u, imp),
+		"Credentials": accounts.CredentialsEnabled(),
+		"Credential":  cred,
+		"Masked":      masked,
+		"CSRF":        accounts.CSRFToken(req),
+	}
+	return render(w, req, ctx, "project/project.html", page{
+		Title:       imp + " project",
+		Breadcrumbs: []crumb{{"Results", "/result"}, {imp, "/result/" + imp}, {"Project", ""}},
+	}, data)
+}
+
+//sessionForm wraps the handler of a form posted by the logged in user so that
+//it is only served with the csrf token of their session. The lax session cookie
+//isn't sent with posts from other sites, but it is from other hosts on the same
+//site.
+func sessionForm(fn httputil.Handler) httputil.Handler {
+	return func(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+		token := accounts.CSRFToken(req)
+		if token == "" || !hmac.Equal([]byte(req.FormValue("csrf")), []byte(token)) {
+			http.Error(w, "invalid csrf token", http.StatusForbidden)
+			return
+		}
+		return fn(w, req, ctx)
+	}
+}
+
+//projectOwner returns the project of the import path and the logged in user,
+//responding with forbidden and returning nil if they don't own it.
+func projectOwner(w http.ResponseWriter, req *http.Request, ctx httputil.Context, importPath string) (p *entities.Project, ok bool, err error) {
+	if p, err = newManager(ctx).Project(importPath); err != nil {
+		return
+	}
+	u, err := currentUser(ctx, req)
+	if err != nil {
+		return
+	}
+	if ok = accounts.IsOwner(p, u); !ok {
+		http.Error(w, "only the owners of the project can do that", http.StatusForbidden)
+	}
+	return
+}
+
+//claimProject makes the logged in user the owner of the import path.
+func claimProject(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	if err := req.ParseForm(); err != nil {
+		e = httputil.Errorf(err, "error parsing form")
+		return
+	}
+	imp := grab(req.Form, "import")
+
+	u, err := currentUser(ctx, req)
+	if err != nil {
+		e = httputil.Errorf(err, "error loading user")
+		return
+	}
+	if u == nil {
+		http.Error(w, "log in to claim a project", http.StatusForbidden)
+		return
+	}
+
+	switch _, err := accounts.Claim(ctx, u, imp); err {
+	case nil:
+	case accounts.ErrNotYours:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case accounts.ErrClaimed:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		e = httputil.Errorf(err, "error claiming project")
+		return
+	}
+	flash(w, "You own "+imp+" now.")
+	http.Redirect(w, req, "/project/"+imp, http.StatusSeeOther)
+	return
+}
+
+//projectSettings changes the settings of a project: if it is private and who
+//owns it.
+func projectSettings(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	if err := req.ParseForm(); err != nil {
+		e = httputil.Errorf(err, "error parsing form")
+		return
+	}
+	imp := grab(req.Form, "import")
+
+	p, ok, err := projectOwner(w, req, ctx, imp)
+	if err != nil {
+		e = httputil.Errorf(err, "error loading project")
+		return
+	}
+	if !ok {
+		return
+	}
+	if p == nil || p.ImportPath != imp {
+		notFound(w, req)
+		return
+	}
+
+	switch {
+	case req.Form.Get("private") != "":
+		err = accounts.SetPrivate(ctx, imp, req.Form.Get("private") == "true")
+	case req.Form.Get("add") != "":
+		err = accounts.AddOwner(ctx, imp, req.Form.Get("add"))
+		if err == mgo.ErrNotFound {
+			http.Error(w, "nobody with that login has logged in", http.StatusBadRequest)
+			return
+		}
+	case bson.IsObjectIdHex(req.Form.Get("remove")):
+		err = accounts.RemoveOwner(ctx, imp, bson.ObjectIdHex(req.Form.Get("remove")))
+		if err == mgo.ErrNotFound {
+			http.Error(w, "a project needs an owner", http.StatusConflict)
+			return
+		}
+	default:
+		http.Error(w, "no setting to change", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		e = httputil.Errorf(err, "error changing project")
+		return
+	}
+	flash(w, "The settings were saved.")
+	http.Redirect(w, req, "/project/"+imp, http.StatusSeeOther)
+	return
+}
+
+//projectCredentials sets or removes the credentials a private project is
+//downloaded with.
+func projectCredentials(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	if !accounts.CredentialsEnabled() {
+		notFound(w, req)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		e = httputil.Errorf(err, "error parsing form")
+		return
+	}
+	imp := grab(req.Form, "import")
+
+	p, ok, err := projectOwner(w, req, ctx, imp)
+	if err != nil {
+		e = httputil.Errorf(err, "error loading project")
+		return
+	}
+	if !ok {
+		return
+	}
+	if p == nil || p.ImportPath != imp {
+		notFound(w, req)
+		return
+	}
+
+	if req.Form.Get("remove") == "true" {
+		err = accounts.RemoveCredential(ctx, imp)
+	} else {
+		err = accounts.SetCredential(ctx, imp, rpc.Auth{
+			SSHKey:   req.Form.Get("key"),
+			Username: req.Form.Get("username"),
+			Token:    req.Form.Get("token"),
+		})
+		if err == accounts.ErrBadCredential {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err != nil {
+		e = httputil.Errorf(err, "error changing credentials")
+		return
+	}
+	flash(w, "The credentials were saved.")
+	http.Redirect(w, req, "/project/"+imp, http.StatusSeeOther)
+	return
+}
+
+//projectMasked sets the secret values masked in the output of a project, one
+//per line.
+func projectMasked(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	if !accounts.CredentialsEnabled() {
+		notFound(w, req)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		e = httputil.Errorf(err, "error parsing form")
+		return
+	}
+	imp := grab(req.Form, "import")
+
+	p, ok, err := projectOwner(w, req, ctx, imp)
+	if err != nil {
+		e = httputil.Errorf(err, "error loading project")
+		return
+	}
+	if !ok {
+		return
+	}
+	if p == nil || p.ImportPath != imp {
+		notFound(w, req)
+		return
+	}
+
+	values := strings.Split(req.Form.Get("values"), "\n")
+	if err := accounts.SetMasked(ctx, imp, values); err != nil {
+		e = httputil.Errorf(err, "error changing masked values")
+		return
+	}
+	flash(w, "The masked secrets were saved.")
+	http.Redirect(w, req, "/project/"+imp, http.StatusSeeOther)
+	return
+}
+
+//rebuildProject tests the most recently tested work item of the import path
+//again.
+func rebuildProject(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	if err := req.ParseForm(); err != nil {
+		e = httputil.Errorf(err, "error parsing form")
+		return
+	}
+	imp := grab(req.Form, "import")
+
+	_, ok, err := projectOwner(w, req, ctx, imp)
+	if err != nil {
+		e = httputil.Errorf(err, "error loading project")
+		return
+	}
+	if !ok {
+		return
+	}
+
+	work, err := workqueue.Latest(ctx, imp)
+	if err == mgo.ErrNotFound {
+		notFound(w, req)
+		return
+	}
+	if err != nil {
+		e = httputil.Errorf(err, "error loading work item")
+		return
+	}
+	nw, err := workqueue.Queue(ctx, workqueue.Rerun{Work: work})
+	if err != nil {
+		e = httputil.Errorf(err, "error queuing work")
+		return
+	}
+	flash(w, "Queued a rebuild of "+imp+".")
+	http.Redirect(w, req, "/work/"+nw.ID.Hex(), http.StatusSeeOther)
+	return
+}
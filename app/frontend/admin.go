@@ -0,0 +1,300 @@
+package frontend
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"github.com/zeebo/goci/app/entities"
+	"github.com/zeebo/goci/app/httputil"
+	"github.com/zeebo/goci/app/response"
+	"github.com/zeebo/goci/app/tracker"
+	"github.com/zeebo/goci/app/workqueue"
+	"io"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//admin wraps a handler so that it is only served to requests with the admin
+//password. If there is no admin password the pages don't exist. Browsers send
+//the password with forms posted from other sites too, so posts also need the
+//csrf token the admin pages put in their forms.
+func admin(fn httputil.Handler) httputil.Handler {
+	return func(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+		if Config.AdminPassword == "" {
+			notFound(w, req)
+			return
+		}
+
+		_, pass, ok := req.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(pass), []byte(Config.AdminPassword)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="goci admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if req.Method == "POST" && !hmac.Equal([]byte(req.FormValue("csrf")), []byte(csrfToken())) {
+			http.Error(w, "invalid csrf token", http.StatusForbidden)
+			return
+		}
+
+		return fn(w, req, ctx)
+	}
+}
+
+//csrfToken returns the token the forms of the admin pages are posted with. It
+//is derived from the admin password so other sites can't know it.
+func csrfToken() string {
+	mac := hmac.New(sha256.New, []byte(Config.AdminPassword))
+	io.WriteString(mac, "goci admin csrf")
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
+
+//adminPage returns the page model of an admin page with the title.
+func adminPage(title string) page {
+	return page{
+		Title:       title,
+		Section:     "admin",
+		Breadcrumbs: []crumb{{"Admin", "/admin"}, {title, ""}},
+	}
+}
+
+//renderWorkers shows the workers page with the token that was just issued, if
+//any.
+func renderWorkers(w http.ResponseWriter, req *http.Request, ctx httputil.Context, issued string) (e *httputil.Error) {
+	tokens, err := tracker.Tokens(ctx)
+	if err != nil {
+		e = httputil.Errorf(err, "couldn't query for tokens")
+		return
+	}
+	builders, runners, err := tracker.Services(ctx)
+	if err != nil {
+		e = httputil.Errorf(err, "couldn't query for workers")
+		return
+	}
+
+	return render(w, req, ctx, "admin/workers.html", adminPage("Workers"), d{
+		"Issued":   issued,
+		"Tokens":   tokens,
+		"Builders": builders,
+		"Runners":  runners,
+		"CSRF":     csrfToken(),
+	})
+}
+
+//adminWorkers shows the enrollment tokens and the workers in the tracker
+func adminWorkers(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	return renderWorkers(w, req, ctx, "")
+}
+
+//issueToken creates an enrollment token for a worker and shows it once
+func issueToken(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	name := req.FormValue("name")
+	if name == "" {
+		http.Error(w, "name required", http.StatusBadRequest)
+		return
+	}
+
+	token, err := tracker.IssueToken(ctx, name)
+	if err != nil {
+		e = httputil.Errorf(err, "error issuing token")
+		return
+	}
+	return renderWorkers(w, req, ctx, token)
+}
+
+//revokeToken revokes an enrollment token and the credentials issued with it
+func revokeToken(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	if err := req.ParseForm(); err != nil {
+		e = httputil.Errorf(err, "error parsing form")
+		return
+	}
+
+	if err := tracker.RevokeToken(ctx, grab(req.Form, "id")); err != nil {
+		e = httputil.Errorf(err, "error revoking token")
+		return
+	}
+	flash(w, "The token was revoked.")
+	http.Redirect(w, req, "/admin/workers", http.StatusSeeOther)
+	return
+}
+
+//rebuildPackages fills in the latest status of every package from the test
+//results, for results recorded before the statuses were kept up to date.
+func rebuildPackages(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	if _, err := response.RebuildPackages(ctx); err != nil {
+		e = httputil.Errorf(err, "error rebuilding packages")
+		return
+	}
+	flash(w, "The package list was rebuilt.")
+	http.Redirect(w, req, "/pkg", http.StatusSeeOther)
+	return
+}
+
+//service is a builder or runner on the dashboard with the work items it is
+//building or running.
+type service struct {
+	Kind     string
+	ID       bson.ObjectId
+	URL      string
+	GOOS     string
+	GOARCH   string
+	Load     int
+	LastSeen time.Time
+	Tasks    []entities.Work
+}
+
+//services lists the builders and runners with the active work items their
+//current attempts are for. Dispatched attempts record the url of the service.
+func services(builders []tracker.Builder, runners []tracker.Runner, active []entities.Work) (svcs []service) {
+	tasks := map[string][]entities.Work{}
+	for _, w := range active {
+		if len(w.AttemptLog) == 0 {
+			continue
+		}
+		a := w.AttemptLog[0]
+		tasks["Builder "+a.Builder] = append(tasks["Builder "+a.Builder], w)
+		if a.Runner != "" {
+			tasks["Runner "+a.Runner] = append(tasks["Runner "+a.Runner], w)
+		}
+	}
+
+	for _, b := range builders {
+		svcs = append(svcs, service{"Builder", b.ID, b.URL, b.GOOS, b.GOARCH, b.Load, b.LastSeen, tasks["Builder "+b.URL]})
+	}
+	for _, r := range runners {
+		svcs = append(svcs, service{"Runner", r.ID, r.URL, r.GOOS, r.GOARCH, r.Load, r.LastSeen, tasks["Runner "+r.URL]})
+	}
+	return
+}
+
+//adminDashboard shows the workers with what they are working on, how many work
+//items are in each state and the work items that are stuck.
+func adminDashboard(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	if req.URL.Path != "/admin" {
+		notFound(w, req)
+		return
+	}
+
+	builders, runners, err := tracker.Services(ctx)
+	if err != nil {
+		e = httputil.Errorf(err, "couldn't query for workers")
+		return
+	}
+	active, err := workqueue.Active(ctx)
+	if err != nil {
+		e = httputil.Errorf(err, "couldn't query for active work items")
+		return
+	}
+	counts, err := workqueue.Counts(ctx)
+	if err != nil {
+		e = httputil.Errorf(err, "couldn't count work items")
+		return
+	}
+
+	now := time.Now()
+	var stuck []entities.Work
+	for _, work := range active {
+		if workqueue.Stuck(work, now) {
+			stuck = append(stuck, work)
+		}
+	}
+
+	p := page{Title: "Dashboard", Section: "admin", Breadcrumbs: []crumb{{"Admin", ""}}}
+	return render(w, req, ctx, "admin/dashboard.html", p, d{
+		"Services": services(builders, runners, active),
+		"Counts":   counts,
+		"Stuck":    stuck,
+		"CSRF":     csrfToken(),
+	})
+}
+
+//adminWork lists the work items matching the filter with their attempts and
+//actions to requeue or cancel them.
+func adminWork(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	f, n, ok := listPage(w, req)
+	if !ok {
+		return
+	}
+	m := newManager(ctx)
+
+	res, err := m.Work(f, (n-1)*perPage, perPage+1)
+	if err != nil {
+		e = httputil.Errorf(err, "couldn't query for work items")
+		return
+	}
+	p := paginate(n, len(res), f)
+	if len(res) > perPage {
+		res = res[:perPage]
+	}
+
+	data := d{"Work": res, "Pages": p, "Filter": f, "Back": req.URL.RequestURI(), "CSRF": csrfToken()}
+	return render(w, req, ctx, "admin/work.html", adminPage("Work Items"), data)
+}
+
+//changeWork returns a handler that changes the work item with the key and sends
+//the admin back to the page they came from, telling them what was done.
+func changeWork(change func(httputil.Context, bson.ObjectId) error, done string) httputil.Handler {
+	return func(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+		if err := req.ParseForm(); err != nil {
+			e = httputil.Errorf(err, "error parsing form")
+			return
+		}
+		key := grab(req.Form, "key")
+		if !bson.IsObjectIdHex(key) {
+			http.Error(w, "invalid work key", http.StatusBadRequest)
+			return
+		}
+
+		switch err := change(ctx, bson.ObjectIdHex(key)); err {
+		case nil:
+		case mgo.ErrNotFound:
+			notFound(w, req)
+			return
+		case workqueue.ErrCompleted, workqueue.ErrChanged:
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		default:
+			e = httputil.Errorf(err, "error changing work item")
+			return
+		}
+
+		//only send them back to admin pages
+		back := req.Form.Get("back")
+		if !strings.HasPrefix(back, "/admin") {
+			back = "/admin"
+		}
+		flash(w, done+" work item "+key+".")
+		http.Redirect(w, req, back, http.StatusSeeOther)
+		return
+	}
+}
+
+//removeService removes a builder or runner from the tracker
+func removeService(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	if err := req.ParseForm(); err != nil {
+		e = httputil.Errorf(err, "error parsing form")
+		return
+	}
+
+	kind, id := grab(req.Form, "kind"), grab(req.Form, "id")
+	if (kind != "Builder" && kind != "Runner") || !bson.IsObjectIdHex(id) {
+		http.Error(w, "invalid worker", http.StatusBadRequest)
+		return
+	}
+
+	err := tracker.RemoveService(ctx, kind, id)
+	if err == mgo.ErrNotFound {
+		notFound(w, req)
+		return
+	}
+	if err != nil {
+		e = httputil.Errorf(err, "error removing worker")
+		return
+	}
+	flash(w, "The "+strings.ToLower(kind)+" was removed.")
+	http.Redirect(w, req, "/admin", http.StatusSeeOther)
+	return
+}
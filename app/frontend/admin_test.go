@@ -0,0 +1,56 @@
+package frontend
+
+import (
+	"github.com/zeebo/goci/app/entities"
+	"github.com/zeebo/goci/app/tracker"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServices(t *testing.T) {
+	builders := []tracker.Builder{{URL: "b1"}, {URL: "b2"}}
+	runners := []tracker.Runner{{URL: "r1"}}
+	active := []entities.Work{
+		{AttemptLog: []entities.WorkAttempt{{Builder: "b1", Runner: "r1"}}},
+		{AttemptLog: []entities.WorkAttempt{{Builder: "b1"}, {Builder: "b2"}}},
+		{},
+	}
+
+	svcs := services(builders, runners, active)
+	if len(svcs) != 3 {
+		t.Fatal("Expected 3 services. Got", len(svcs))
+	}
+	for i, n := range []int{2, 0, 1} {
+		if len(svcs[i].Tasks) != n {
+			t.Error(svcs[i].Kind, svcs[i].URL, "Expected", n, "tasks. Got", len(svcs[i].Tasks))
+		}
+	}
+	if svcs[2].Kind != "Runner" {
+		t.Error("Expected a Runner. Got", svcs[2].Kind)
+	}
+}
+
+func TestAdminCSRF(t *testing.T) {
+	defer func(pass string) { Config.AdminPassword = pass }(Config.AdminPassword)
+	Config.AdminPassword = "hunter2"
+
+	token := csrfToken()
+	Config.AdminPassword = "other"
+	if csrfToken() == token {
+		t.Fatal("Expected the token to depend on the admin password")
+	}
+	Config.AdminPassword = "hunter2"
+
+	for _, body := range []string{"", "csrf=", "csrf=" + token[1:]} {
+		req, _ := http.NewRequest("POST", "/admin/packages/rebuild", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("admin", "hunter2")
+		rec := httptest.NewRecorder()
+		Mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%q: Expected a 403. Got %d", body, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package frontend
+
+import (
+	"fmt"
+	"github.com/zeebo/goci/app/entities"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+//badgeLabel is the text on the left of every badge.
+const badgeLabel = "goci"
+
+//badgeStatusUnknown is the status of an import path without any test results.
+const badgeStatusUnknown = "unknown"
+
+//badgeColors maps the status of a test to the color of the right of the badge.
+var badgeColors = map[string]color.RGBA{
+	entities.TestStatusPass:      {0x44, 0xcc, 0x11, 0xff},
+	entities.TestStatusFail:      {0xe0, 0x5d, 0x44, 0xff},
+	entities.TestStatusWontBuild: {0xfe, 0x7d, 0x37, 0xff},
+	entities.TestStatusError:     {0xdf, 0xb3, 0x17, 0xff},
+	badgeStatusUnknown:           {0x9f, 0x9f, 0x9f, 0xff},
+}
+
+//badgeLabelColor is the color of the left of every badge.
+var badgeLabelColor = color.RGBA{0x55, 0x55, 0x55, 0xff}
+
+//badgeKey is what the latest status of a badge is looked up by.
+type badgeKey struct {
+	ImportPath string
+	Branch     string
+	Toolchain  string
+}
+
+type badgeEntry struct {
+	status  string
+	expires time.Time
+}
+
+//badgeTTL is how long the status of a badge is cached before looking it up
+//again. Badges are fetched every time a README is viewed so this keeps them
+//from querying the database each time.
+var badgeTTL = time.Minute
+
+//bcache caches the latest status of badges.
+var (
+	bcache = map[badgeKey]badgeEntry{}
+	bmut   sync.Mutex
+)
+
+//badgeStatus returns the latest status for the badge, using the cache if it
+//hasn't expired.
+func badgeStatus(m queryManager, key badgeKey) (status string, err error) {
+	now := time.Now()
+
+	bmut.Lock()
+	ent, ok := bcache[key]
+	bmut.Unlock()
+	if ok && now.Before(ent.expires) {
+		status = ent.status
+		return
+	}
+
+	res, err := m.LatestTest(key.ImportPath, key.Branch, key.Toolchain)
+	if err != nil {
+		return
+	}
+	status = badgeStatusUnknown
+	if res != nil {
+		status = res.Status
+	}
+
+	bmut.Lock()
+	bcache[key] = badgeEntry{status: status, expires: now.Add(badgeTTL)}
+	bmut.Unlock()
+	return
+}
+
+//badgeText returns the text shown for a status.
+func badgeText(status string) string {
+	return strings.ToLower(status)
+}
+
+//badgeColor returns the color of the badge for the status.
+func badgeColor(status string) color.RGBA {
+	if c, ok := badgeColors[status]; ok {
+		return c
+	}
+	return badgeColors[badgeStatusUnknown]
+}
+
+//svgTemplate draws a badge in the usual flat style.
+var svgTemplate = template.Must(template.New("badge").Parse(
+	`<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="20" role="img" aria-label="{{.Label}}: {{.Text}}">` +
+		`<title>{{.Label}}: {{.Text}}</title>` +
+		`<rect width="{{.LabelWidth}}" height="20" rx="3" fill="{{.LabelColor}}"/>` +
+		`<rect x="{{.LabelWidth}}" width="{{.TextWidth}}" height="20" rx="3" fill="{{.Color}}"/>` +
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,DejaVu Sans,sans-serif" font-size="11">` +
+		`<text x="{{.LabelX}}" y="14">{{.Label}}</text>` +
+		`<text x="{{.TextX}}" y="14">{{.Text}}</text>` +
+		`</g></svg>`))
+
+//svgCharWidth is about how wide a character is in the font of the svg.
+const svgCharWidth = 7
+
+//hex returns the color as an html hex string.
+func hex(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+//writeSVG writes the badge for the status as an svg.
+func writeSVG(w io.Writer, status string) error {
+	text := badgeText(status)
+	lw := len(badgeLabel)*svgCharWidth + 10
+	tw := len(text)*svgCharWidth + 10
+	return svgTemplate.Execute(w, d{
+		"Label":      badgeLabel,
+		"Text":       text,
+		"Width":      lw + tw,
+		"LabelWidth": lw,
+		"TextWidth":  tw,
+		"LabelX":     lw / 2,
+		"TextX":      lw + tw/2,
+		"LabelColor": hex(badgeLabelColor),
+		"Color":      hex(badgeColor(status)),
+	})
+}
+
+//glyphs is a tiny 3x5 font with the letters the badges need.
+var glyphs = map[rune][5]string{
+	'a': {".#.", "#.#", "###", "#.#", "#.#"},
+	'b': {"##.", "#.#", "##.", "#.#", "##."},
+	'c': {".##", "#..", "#..", "#..", ".##"},
+	'd': {"##.", "#.#", "#.#", "#.#", "##."},
+	'e': {"###", "#..", "##.", "#..", "###"},
+	'f': {"###", "#..", "##.", "#..", "#.."},
+	'g': {".##", "#..", "#.#", "#.#", ".##"},
+	'i': {"###", ".#.", ".#.", ".#.", "###"},
+	'k': {"#.#", "#.#", "##.", "#.#", "#.#"},
+	'l': {"#..", "#..", "#..", "#..", "###"},
+	'n': {"##.", "#.#", "#.#", "#.#", "#.#"},
+	'o': {".#.", "#.#", "#.#", "#.#", ".#."},
+	'p': {"##.", "#.#", "##.", "#..", "#.."},
+	'r': {"##.", "#.#", "##.", "#.#", "#.#"},
+	's': {".##", "#..", ".#.", "..#", "##."},
+	't': {"###", ".#.", ".#.", ".#.", ".#."},
+	'u': {"#.#", "#.#", "#.#", "#.#", "###"},
+	'w': {"#.#", "#.#", "#.#", "###", "#.#"},
+}
+
+//pngScale is how many pixels wide each dot of a glyph is, and pngAdvance is
+//how far apart the glyphs start.
+const (
+	pngScale   = 2
+	pngAdvance = 4 * pngScale
+)
+
+//drawText draws the text in white starting at x, skipping any characters
+//without a glyph.
+func drawText(img *image.RGBA, x int, text string) {
+	white := image.NewUniform(color.White)
+	for _, r := range text {
+		for row, line := range glyphs[r] {
+			for col, c := range line {
+				if c != '#' {
+					continue
+				}
+				px, py := x+col*pngScale, 5+row*pngScale
+				rect := image.Rect(px, py, px+pngScale, py+pngScale)
+				draw.Draw(img, rect, white, image.ZP, draw.Src)
+			}
+		}
+		x += pngAdvance
+	}
+}
+
+//writePNG writes the badge for the status as a png for clients that can't
+//show svgs.
+func writePNG(w io.Writer, status string) error {
+	text := badgeText(status)
+	lw := len(badgeLabel)*pngAdvance + 10
+	tw := len(text)*pngAdvance + 10
+
+	img := image.NewRGBA(image.Rect(0, 0, lw+tw, 20))
+	draw.Draw(img, image.Rect(0, 0, lw, 20), image.NewUniform(badgeLabelColor), image.ZP, draw.Src)
+	draw.Draw(img, image.Rect(lw, 0, lw+tw, 20), image.NewUniform(badgeColor(status)), image.ZP, draw.Src)
+	drawText(img, 6, badgeLabel)
+	drawText(img, lw+6, text)
+	return png.Encode(w, img)
+}
@@ -0,0 +1,191 @@
+package frontend
+
+import (
+	"github.com/zeebo/goci/app/entities"
+	"sort"
+	"strings"
+)
+
+//change is how the test of a package changed between two revisions.
+type change struct {
+	ImportPath string
+	From, To   *entities.TestResult //nil if the package wasn't tested
+	Diff       []diffLine           //the difference in output if it changed
+}
+
+//passed returns if the test result passed.
+func passed(t *entities.TestResult) bool {
+	return t != nil && t.Status == entities.TestStatusPass
+}
+
+//NewlyFailing returns if the package passed and doesn't anymore.
+func (c change) NewlyFailing() bool { return passed(c.From) && !passed(c.To) }
+
+//NewlyPassing returns if the package passes and didn't before.
+func (c change) NewlyPassing() bool { return !passed(c.From) && passed(c.To) }
+
+//Summary describes the change in a few words.
+func (c change) Summary() string {
+	switch {
+	case c.From == nil:
+		return "added"
+	case c.To == nil:
+		return "removed"
+	case c.NewlyFailing():
+		return "newly failing"
+	case c.NewlyPassing():
+		return "newly passing"
+	case c.From.Status != c.To.Status:
+		return "changed"
+	case c.Diff != nil:
+		return "output changed"
+	}
+	return "unchanged"
+}
+
+//compareTests pairs up the test results of each package at two revisions and
+//diffs their output, sorted by import path.
+func compareTests(from, to []entities.TestResult) (changes []change) {
+	byPath := map[string]*change{}
+	var paths []string
+	get := func(imp string) *change {
+		if c, ok := byPath[imp]; ok {
+			return c
+		}
+		c := &change{ImportPath: imp}
+		byPath[imp] = c
+		paths = append(paths, imp)
+		return c
+	}
+	for i := range from {
+		get(from[i].ImportPath).From = &from[i]
+	}
+	for i := range to {
+		get(to[i].ImportPath).To = &to[i]
+	}
+
+	sort.Strings(paths)
+	for _, imp := range paths {
+		c := byPath[imp]
+		if c.From != nil && c.To != nil && c.From.Output != c.To.Output {
+			c.Diff = diffLines(c.From.Output, c.To.Output)
+		}
+		changes = append(changes, *c)
+	}
+	return
+}
+
+//diffLine is a line of a diff. Op is " " for a line in both, "-" for a line
+//that was removed, "+" for a line that was added and "@" for where unchanged
+//lines were left out.
+type diffLine struct {
+	Op   string
+	Text string
+}
+
+//diffContext is how many unchanged lines are kept around changes, and
+//maxDiffCells bounds the work done finding the lines in common.
+const (
+	diffContext  = 3
+	maxDiffCells = 1 << 20
+)
+
+//diffLines returns a line diff from a to b with only the unchanged lines near
+//the changes.
+func diffLines(a, b string) (lines []diffLine) {
+	al, bl := strings.Split(a, "\n"), strings.Split(b, "\n")
+
+	//trim the common prefix and suffix
+	var pre, suf int
+	for pre < len(al) && pre < len(bl) && al[pre] == bl[pre] {
+		pre++
+	}
+	for suf < len(al)-pre && suf < len(bl)-pre && al[len(al)-1-suf] == bl[len(bl)-1-suf] {
+		suf++
+	}
+
+	var all []diffLine
+	for _, l := range al[:pre] {
+		all = append(all, diffLine{" ", l})
+	}
+	all = append(all, diffMiddle(al[pre:len(al)-suf], bl[pre:len(bl)-suf])...)
+	for _, l := range al[len(al)-suf:] {
+		all = append(all, diffLine{" ", l})
+	}
+
+	//keep only the context around the changes
+	keep := make([]bool, len(all))
+	for i, l := range all {
+		if l.Op == " " {
+			continue
+		}
+		for j := i - diffContext; j <= i+diffContext; j++ {
+			if j >= 0 && j < len(all) {
+				keep[j] = true
+			}
+		}
+	}
+	for i, l := range all {
+		switch {
+		case keep[i]:
+			lines = append(lines, l)
+		case len(lines) == 0 || lines[len(lines)-1].Op != "@":
+			lines = append(lines, diffLine{"@", "..."})
+		}
+	}
+	return
+}
+
+//diffMiddle diffs the lines with the longest common subsequence, or just
+//removes a and adds b if that would take too long.
+func diffMiddle(a, b []string) (lines []diffLine) {
+	if len(a)*len(b) > maxDiffCells {
+		for _, l := range a {
+			lines = append(lines, diffLine{"-", l})
+		}
+		for _, l := range b {
+			lines = append(lines, diffLine{"+", l})
+		}
+		return
+	}
+
+	//lcs[i][j] is the length of the lcs of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, diffLine{" ", a[i]})
+			i, j = i+1, j+1
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, diffLine{"-", a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{"+", b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, diffLine{"-", a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, diffLine{"+", b[j]})
+	}
+	return
+}
@@ -0,0 +1,54 @@
+package frontend
+
+import (
+	"github.com/zeebo/goci/app/entities"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompareTests(t *testing.T) {
+	from := []entities.TestResult{
+		{ImportPath: "a", Status: entities.TestStatusPass, Output: "PASS\n"},
+		{ImportPath: "b", Status: entities.TestStatusFail, Output: "FAIL\n"},
+		{ImportPath: "c", Status: entities.TestStatusPass, Output: "PASS\n"},
+	}
+	to := []entities.TestResult{
+		{ImportPath: "d", Status: entities.TestStatusPass, Output: "PASS\n"},
+		{ImportPath: "c", Status: entities.TestStatusPass, Output: "PASS\n"},
+		{ImportPath: "b", Status: entities.TestStatusPass, Output: "PASS\n"},
+		{ImportPath: "a", Status: entities.TestStatusFail, Output: "FAIL\n"},
+	}
+
+	var got []string
+	for _, c := range compareTests(from, to) {
+		got = append(got, c.ImportPath+": "+c.Summary())
+	}
+	exp := []string{"a: newly failing", "b: newly passing", "c: unchanged", "d: added"}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("Expected %v. Got %v", exp, got)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	var a, b []string
+	for i := 0; i < 20; i++ {
+		a = append(a, string('a'+rune(i)))
+	}
+	b = append(b, a...)
+	b[10] = "changed"
+	b = append(b[:15], b[16:]...)
+
+	var got []string
+	for _, l := range diffLines(strings.Join(a, "\n"), strings.Join(b, "\n")) {
+		got = append(got, l.Op+l.Text)
+	}
+	exp := []string{
+		"@...",
+		" h", " i", " j", "-k", "+changed", " l", " m", " n", " o", "-p", " q", " r", " s",
+		"@...",
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("Expected %v. Got %v", exp, got)
+	}
+}
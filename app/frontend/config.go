@@ -1,36 +1,47 @@
 package frontend
 
 import (
-	"errors"
+	"github.com/zeebo/goci/static"
+	"github.com/zeebo/goci/templates"
+	"io/fs"
 	"net/http"
 	"os"
-	"path"
-	"path/filepath"
-	"strings"
 )
 
 //Conf represents the configuration for the frontend.
 type Conf struct {
-	Templates string //the path to the templates
-	Static    string //the path to static assets
-	Debug     bool   //if true templates will be compiled on every invocation
+	//Templates and Static are paths to the templates and static assets on
+	//disk. They are only used with Debug so that changes show up without
+	//building again, otherwise the ones built in to the binary are served.
+	Templates string
+	Static    string
+	Debug     bool //if true templates will be compiled on every invocation
+
+	//AdminPassword is the password for the admin pages. If it is empty the
+	//admin pages are disabled.
+	AdminPassword string
 }
 
 //Config is the configuration for the frontend.
 var Config = new(Conf)
 
-//Open makes Config an http.FileServer for static files.
-func (c *Conf) Open(name string) (http.File, error) {
-	if filepath.Separator != '/' && strings.IndexRune(name, filepath.Separator) >= 0 {
-		return nil, errors.New("http: invalid character in file path")
+//templateFS returns the file system the templates are loaded from.
+func (c *Conf) templateFS() fs.FS {
+	if c.Debug && c.Templates != "" {
+		return os.DirFS(c.Templates)
 	}
-	dir := c.Static
-	if dir == "" {
-		dir = "."
-	}
-	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name))))
-	if err != nil {
-		return nil, err
+	return templates.FS
+}
+
+//staticFS returns the file system the static assets are served from.
+func (c *Conf) staticFS() fs.FS {
+	if c.Debug && c.Static != "" {
+		return os.DirFS(c.Static)
 	}
-	return f, nil
+	return static.FS
+}
+
+//Open makes Config an http.FileServer for static files.
+func (c *Conf) Open(name string) (http.File, error) {
+	return http.FS(c.staticFS()).Open(name)
 }
@@ -0,0 +1,129 @@
+package frontend
+
+import (
+	"fmt"
+	"html/template"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+//dateFormat is the format of the dates in filters.
+const dateFormat = "2006-01-02"
+
+//filter narrows down a list. Empty fields match everything.
+type filter struct {
+	Status string    //the exact status
+	Import string    //a prefix of the import path
+	Search string    //text anywhere in the import path, ignoring case
+	From   time.Time //the first day included
+	To     time.Time //the last day included
+}
+
+//parseFilter returns the filter given by the query parameters of the request.
+func parseFilter(req *http.Request) (f filter, err error) {
+	f.Status = req.FormValue("status")
+	f.Import = req.FormValue("import")
+	f.Search = req.FormValue("q")
+	if v := req.FormValue("from"); v != "" {
+		if f.From, err = time.Parse(dateFormat, v); err != nil {
+			err = fmt.Errorf("invalid from date %q", v)
+			return
+		}
+	}
+	if v := req.FormValue("to"); v != "" {
+		if f.To, err = time.Parse(dateFormat, v); err != nil {
+			err = fmt.Errorf("invalid to date %q", v)
+		}
+	}
+	return
+}
+
+//Values returns the filter as query parameters.
+func (f filter) Values() url.Values {
+	v := url.Values{}
+	set := func(key, val string) {
+		if val != "" {
+			v.Set(key, val)
+		}
+	}
+	set("status", f.Status)
+	set("import", f.Import)
+	set("q", f.Search)
+	if !f.From.IsZero() {
+		v.Set("from", f.From.Format(dateFormat))
+	}
+	if !f.To.IsZero() {
+		v.Set("to", f.To.Format(dateFormat))
+	}
+	return v
+}
+
+//FromDate and ToDate return the dates of the filter for filling in forms.
+func (f filter) FromDate() string { return f.Values().Get("from") }
+func (f filter) ToDate() string   { return f.Values().Get("to") }
+
+//HasStatus returns if the filter is for the status so forms can select it.
+func (f filter) HasStatus(status string) bool { return f.Status == status }
+
+//selector returns the query for the filter with the names of the fields to
+//match the status, import path and date against.
+func (f filter) selector(status, importPath, date string) bson.M {
+	sel := bson.M{}
+	if f.Status != "" {
+		sel[status] = f.Status
+	}
+	switch {
+	case f.Import != "":
+		sel[importPath] = bson.RegEx{Pattern: "^" + regexp.QuoteMeta(f.Import)}
+	case f.Search != "":
+		sel[importPath] = bson.RegEx{Pattern: regexp.QuoteMeta(f.Search), Options: "i"}
+	}
+	dates := bson.M{}
+	if !f.From.IsZero() {
+		dates["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		dates["$lt"] = f.To.AddDate(0, 0, 1)
+	}
+	if len(dates) > 0 {
+		sel[date] = dates
+	}
+	return sel
+}
+
+//perPage is how many items are shown on a page of a list.
+const perPage = 20
+
+//pagination is where a page is in a list so templates can link to the pages
+//around it. Prev and Next are 0 if there is no such page, and Query is the
+//filter of the list to put in front of the page in links.
+type pagination struct {
+	Page, Prev, Next int
+	Query            template.URL
+}
+
+//pageNumber returns the page asked for by the page query parameter, starting
+//at 1.
+func pageNumber(req *http.Request) (n int) {
+	fmt.Sscan(req.FormValue("page"), &n)
+	if n < 1 {
+		n = 1
+	}
+	return
+}
+
+//paginate returns where page n is in a list when n items were loaded for it,
+//which should include one more than perPage to know if there is a next page.
+func paginate(page, n int, f filter) (p pagination) {
+	p = pagination{Page: page, Prev: page - 1}
+	if n > perPage {
+		p.Next = page + 1
+	}
+	if q := f.Values().Encode(); q != "" {
+		p.Query = template.URL(q + "&")
+	}
+	return
+}
@@ -0,0 +1,48 @@
+package frontend
+
+import (
+	"labix.org/v2/mgo/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseFilter(t *testing.T) {
+	req := makeGETRequest("/result?status=Fail&import=github.com/zeebo&q=irc&from=2013-01-02&to=2013-02-03")
+	f, err := parseFilter(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := filter{
+		Status: "Fail",
+		Import: "github.com/zeebo",
+		Search: "irc",
+		From:   time.Date(2013, 1, 2, 0, 0, 0, 0, time.UTC),
+		To:     time.Date(2013, 2, 3, 0, 0, 0, 0, time.UTC),
+	}
+	if f != exp {
+		t.Fatal("Expected", exp, "Got", f)
+	}
+	if f.FromDate() != "2013-01-02" || f.ToDate() != "2013-02-03" {
+		t.Fatal("Got", f.FromDate(), f.ToDate())
+	}
+}
+
+func TestFilterSelector(t *testing.T) {
+	from := time.Date(2013, 1, 2, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		f   filter
+		sel bson.M
+	}{
+		{filter{}, bson.M{}},
+		{filter{Status: "Pass"}, bson.M{"s": "Pass"}},
+		{filter{Import: "a.b/c"}, bson.M{"i": bson.RegEx{Pattern: `^a\.b/c`}}},
+		{filter{Search: "C"}, bson.M{"i": bson.RegEx{Pattern: "C", Options: "i"}}},
+		{filter{From: from, To: from}, bson.M{"d": bson.M{"$gte": from, "$lt": from.AddDate(0, 0, 1)}}},
+	}
+	for _, c := range cases {
+		if sel := c.f.selector("s", "i", "d"); !reflect.DeepEqual(sel, c.sel) {
+			t.Error(c.f, "Expected", c.sel, "Got", sel)
+		}
+	}
+}
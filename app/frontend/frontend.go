@@ -4,6 +4,7 @@ package frontend
 import (
 	"github.com/gorilla/pat"
 	"github.com/zeebo/goci/app/httputil"
+	"github.com/zeebo/goci/app/workqueue"
 	"net/http"
 )
 
@@ -18,8 +19,30 @@ func init() {
 	Mux.Add("GET", "/result/{import:[^@]+}@{rev:.*}", httputil.Handler(specificImportResult))
 	Mux.Add("GET", "/result/{import:[^@]+}", httputil.Handler(importResult))
 	Mux.Add("GET", "/result", httputil.Handler(result))
-	Mux.Add("GET", "/image/{import:.+}", httputil.Handler(image))
+	Mux.Add("GET", "/compare/{import:.+}", httputil.Handler(compare))
+	Mux.Add("GET", "/image/{import:.+}", httputil.Handler(badge))
 	Mux.Add("GET", "/how", httputil.Handler(how))
+	Mux.Add("GET", "/login/callback", httputil.Handler(loginCallback))
+	Mux.Add("GET", "/login", httputil.Handler(login))
+	Mux.Add("POST", "/logout", httputil.Handler(logout))
+	Mux.Add("GET", "/account", httputil.Handler(account))
+	Mux.Add("POST", "/project/claim/{import:.+}", sessionForm(claimProject))
+	Mux.Add("POST", "/project/settings/{import:.+}", sessionForm(projectSettings))
+	Mux.Add("POST", "/project/rebuild/{import:.+}", sessionForm(rebuildProject))
+	Mux.Add("POST", "/project/credentials/{import:.+}", sessionForm(projectCredentials))
+	Mux.Add("POST", "/project/masked/{import:.+}", sessionForm(projectMasked))
+	Mux.Add("GET", "/project/{import:.+}", httputil.Handler(project))
+	Mux.Add("POST", "/admin/packages/rebuild", admin(rebuildPackages))
+	Mux.Add("POST", "/admin/workers/revoke/{id}", admin(revokeToken))
+	Mux.Add("POST", "/admin/workers/remove/{kind}/{id}", admin(removeService))
+	Mux.Add("POST", "/admin/workers", admin(issueToken))
+	Mux.Add("GET", "/admin/workers", admin(adminWorkers))
+	Mux.Add("POST", "/admin/work/{key}/requeue", admin(changeWork(workqueue.Requeue, "Requeued")))
+	Mux.Add("POST", "/admin/work/{key}/cancel", admin(changeWork(workqueue.Cancel, "Canceled")))
+	Mux.Add("GET", "/admin/work", admin(adminWork))
+	Mux.Add("GET", "/admin/logs/tail", admin(tailLogs))
+	Mux.Add("GET", "/admin/logs", admin(adminLogs))
+	Mux.Add("GET", "/admin", admin(adminDashboard))
 	Mux.Add("GET", "/pkg", httputil.Handler(pkg))
 	Mux.Add("GET", "/", httputil.Handler(index))
 }
@@ -0,0 +1,134 @@
+package frontend
+
+import (
+	"encoding/json"
+	"github.com/zeebo/goci/app/httputil"
+	"html/template"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+//maxLogs is how many log entries are shown or sent at once.
+const maxLogs = 100
+
+//logFilter picks the log entries to show. Empty fields match everything.
+type logFilter struct {
+	Severity string //info or error
+	Search   string //text anywhere in the message, ignoring case
+	Key      string //the work item or notification key
+	Request  string //the request id
+}
+
+//parseLogFilter returns the log filter given by the query parameters of the
+//request.
+func parseLogFilter(req *http.Request) logFilter {
+	return logFilter{
+		Severity: req.FormValue("severity"),
+		Search:   req.FormValue("q"),
+		Key:      req.FormValue("key"),
+		Request:  req.FormValue("request"),
+	}
+}
+
+//HasSeverity returns if the filter is for the severity so forms can select it.
+func (f logFilter) HasSeverity(severity string) bool { return f.Severity == severity }
+
+//Query returns the filter as query parameters to put in front of others in
+//links.
+func (f logFilter) Query() template.URL {
+	v := url.Values{}
+	set := func(key, val string) {
+		if val != "" {
+			v.Set(key, val)
+		}
+	}
+	set("severity", f.Severity)
+	set("q", f.Search)
+	set("key", f.Key)
+	set("request", f.Request)
+	if len(v) == 0 {
+		return ""
+	}
+	return template.URL(v.Encode() + "&")
+}
+
+//selector returns the query for the filter.
+func (f logFilter) selector() bson.M {
+	sel := bson.M{}
+	if f.Severity != "" {
+		sel["severity"] = f.Severity
+	}
+	if f.Search != "" {
+		sel["text"] = bson.RegEx{Pattern: regexp.QuoteMeta(f.Search), Options: "i"}
+	}
+	if f.Key != "" {
+		sel["key"] = f.Key
+	}
+	if f.Request != "" {
+		sel["requestid"] = f.Request
+	}
+	return sel
+}
+
+//adminLogs shows the most recent log entries matching the filter, and tails
+//new ones as they are logged.
+func adminLogs(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	f := parseLogFilter(req)
+	m := newManager(ctx)
+
+	logs, err := m.Logs(f, "", maxLogs)
+	if err != nil {
+		e = httputil.Errorf(err, "couldn't query for logs")
+		return
+	}
+
+	//the page tails from the newest entry it shows
+	var last string
+	if len(logs) > 0 {
+		last = logs[0].ID.Hex()
+	}
+
+	data := d{"Logs": logs, "Filter": f, "Last": last}
+	return render(w, req, ctx, "admin/logs.html", adminPage("Logs"), data)
+}
+
+//tailLogs sends the log entries matching the filter that were logged after the
+//entry given by the after query parameter as json, oldest first.
+func tailLogs(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	f := parseLogFilter(req)
+
+	var after bson.ObjectId
+	if v := req.FormValue("after"); v != "" {
+		if !bson.IsObjectIdHex(v) {
+			http.Error(w, "invalid after entry", http.StatusBadRequest)
+			return
+		}
+		after = bson.ObjectIdHex(v)
+	}
+	m := newManager(ctx)
+
+	logs, err := m.Logs(f, after, maxLogs)
+	if err != nil {
+		e = httputil.Errorf(err, "couldn't query for logs")
+		return
+	}
+
+	//without an entry to start after we got the newest ones first
+	if after == "" {
+		for i, j := 0, len(logs)-1; i < j; i, j = i+1, j-1 {
+			logs[i], logs[j] = logs[j], logs[i]
+		}
+	}
+	if logs == nil {
+		logs = []httputil.LogEntry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := json.NewEncoder(w).Encode(logs); err != nil {
+		e = httputil.Errorf(err, "error encoding logs")
+	}
+	return
+}
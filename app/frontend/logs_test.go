@@ -0,0 +1,69 @@
+package frontend
+
+import (
+	"encoding/json"
+	"github.com/zeebo/goci/app/httputil"
+	"labix.org/v2/mgo/bson"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestLogFilter(t *testing.T) {
+	req := makeGETRequest("/admin/logs?severity=error&q=a.b&key=k&request=r")
+	f := parseLogFilter(req)
+	exp := bson.M{
+		"severity":  "error",
+		"text":      bson.RegEx{Pattern: `a\.b`, Options: "i"},
+		"key":       "k",
+		"requestid": "r",
+	}
+	if sel := f.selector(); !reflect.DeepEqual(sel, exp) {
+		t.Fatal("Expected", exp, "Got", sel)
+	}
+	if q := f.Query(); q != "key=k&q=a.b&request=r&severity=error&" {
+		t.Fatal("Got", q)
+	}
+	if q := (logFilter{}).Query(); q != "" {
+		t.Fatal("Got", q)
+	}
+}
+
+func TestTailLogs(t *testing.T) {
+	defer func(p string) { Config.AdminPassword = p }(Config.AdminPassword)
+	Config.AdminPassword = "secret"
+
+	cases := []struct {
+		path  string
+		code  int
+		texts []string
+	}{
+		{"/admin/logs/tail", 200, []string{"first", "second"}},
+		{"/admin/logs/tail?after=50dfac94346bea11bb000001", 200, []string{"first", "second"}},
+		{"/admin/logs/tail?after=nope", 400, nil},
+	}
+	for _, c := range cases {
+		req := makeGETRequest(c.path)
+		req.SetBasicAuth("admin", "secret")
+		rec := httptest.NewRecorder()
+		Mux.ServeHTTP(rec, req)
+		if rec.Code != c.code {
+			t.Fatal(c.path, "Expected", c.code, "Got", rec.Code)
+		}
+		if c.code != 200 {
+			continue
+		}
+
+		var logs []httputil.LogEntry
+		if err := json.Unmarshal(rec.Body.Bytes(), &logs); err != nil {
+			t.Fatal(c.path, err)
+		}
+		var texts []string
+		for _, l := range logs {
+			texts = append(texts, l.Text)
+		}
+		if !reflect.DeepEqual(texts, c.texts) {
+			t.Error(c.path, "Expected", c.texts, "Got", texts)
+		}
+	}
+}
@@ -1,7 +1,10 @@
 package frontend
 
 import (
+	"fmt"
+	"github.com/zeebo/goci/app/entities"
 	"github.com/zeebo/goci/app/httputil"
+	"labix.org/v2/mgo"
 	"net/http"
 	"net/url"
 )
@@ -28,141 +31,341 @@ func notFound(w http.ResponseWriter, req *http.Request) {
 	http.NotFound(w, req)
 }
 
+//listPage returns the filter and page number of a list, responding with a bad
+//request if the filter is invalid.
+func listPage(w http.ResponseWriter, req *http.Request) (f filter, n int, ok bool) {
+	f, err := parseFilter(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n, ok = pageNumber(req), true
+	return
+}
+
 //index shows the main homepage of goci
 func index(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
 	if req.URL.Path != "/" {
 		notFound(w, req)
 		return
 	}
+	return results(w, req, ctx, "index/index.html", page{Title: "Recent Test Results"})
+}
+
+//results shows the recent test results matching the filter with the template.
+func results(w http.ResponseWriter, req *http.Request, ctx httputil.Context, name string, p page) (e *httputil.Error) {
+	f, n, ok := listPage(w, req)
+	if !ok {
+		return
+	}
 	m := newManager(ctx)
 
-	res, err := m.Index()
+	res, err := m.Results(f, (n-1)*perPage, perPage+1)
 	if err != nil {
 		e = httputil.Errorf(err, "couldn't query for test results")
 		return
 	}
-
-	w.Header().Set("Content-Type", "text/html")
-	if err := T("index/index.html").Execute(w, res); err != nil {
-		e = httputil.Errorf(err, "error executing index template")
+	pages := paginate(n, len(res), f)
+	if len(res) > perPage {
+		res = res[:perPage]
 	}
-	return
+
+	data := d{"Results": res, "Pages": pages, "Filter": f}
+	return render(w, req, ctx, name, p, data)
 }
 
 //work shows recent work items
 func work(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	f, n, ok := listPage(w, req)
+	if !ok {
+		return
+	}
 	m := newManager(ctx)
 
-	res, err := m.Work(0, 20)
+	res, err := m.Work(f, (n-1)*perPage, perPage+1)
 	if err != nil {
-		e = httputil.Errorf(err, "couldn't query for work results")
+		e = httputil.Errorf(err, "couldn't query for work items")
 		return
 	}
-
-	w.Header().Set("Content-Type", "text/html")
-	if err := T("work/work.html").Execute(w, res); err != nil {
-		e = httputil.Errorf(err, "error executing index template")
+	p := paginate(n, len(res), f)
+	if len(res) > perPage {
+		res = res[:perPage]
 	}
-	return
+
+	data := d{"Work": res, "Pages": p, "Filter": f}
+	return render(w, req, ctx, "work/work.html", page{Title: "Recent Work Items", Section: "work"}, data)
 }
 
 //specificWork shows a work item with the given key
 func specificWork(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
-	w.Header().Set("Content-Type", "text/html")
 	if err := req.ParseForm(); err != nil {
 		e = httputil.Errorf(err, "error parsing form")
 		return
 	}
 	m := newManager(ctx)
 
-	work, err := m.SpecificWork(grab(req.Form, "key"))
+	key := grab(req.Form, "key")
+	work, err := m.SpecificWork(key)
 	if err != nil {
 		e = httputil.Errorf(err, "error grabbing work item")
 		return
 	}
 
-	if err := T("work/specific_work.html").Execute(w, work); err != nil {
-		e = httputil.Errorf(err, "error executing index template")
+	p := page{
+		Title:       "Work Item " + key,
+		Section:     "work",
+		Breadcrumbs: []crumb{{"Work", "/work"}, {key, ""}},
 	}
-	return
+	return render(w, req, ctx, "work/specific_work.html", p, work)
 }
 
 //result shows recent result items
 func result(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
-	w.Header().Set("Content-Type", "text/html")
-
-	if err := T("result/result.html").Execute(w, nil); err != nil {
-		e = httputil.Errorf(err, "error executing index template")
-	}
-	return
+	return results(w, req, ctx, "result/result.html", page{Title: "Test Results", Section: "result"})
 }
 
 //importResult shows recent result items for an import path
 func importResult(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
-	w.Header().Set("Content-Type", "text/html")
 	if err := req.ParseForm(); err != nil {
 		e = httputil.Errorf(err, "error parsing form")
 		return
 	}
+	m := newManager(ctx)
 
-	imp := grab(req.Form, "import")
-	if err := T("result/import_result.html").Execute(w, imp); err != nil {
-		e = httputil.Errorf(err, "error executing index template")
+	imp, n := grab(req.Form, "import"), pageNumber(req)
+	res, err := m.ImportResults(imp, (n-1)*perPage, perPage+1)
+	if err != nil {
+		e = httputil.Errorf(err, "couldn't query for test results")
+		return
 	}
-	return
+	p := paginate(n, len(res), filter{})
+	if len(res) > perPage {
+		res = res[:perPage]
+	}
+
+	data := d{"ImportPath": imp, "Results": res, "Pages": p}
+	return render(w, req, ctx, "result/import_result.html", page{
+		Title:       imp,
+		Section:     "result",
+		Breadcrumbs: []crumb{{"Results", "/result"}, {imp, ""}},
+	}, data)
 }
 
 //specificImportResult shows a result item for an import path and given revision
 func specificImportResult(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
-	w.Header().Set("Content-Type", "text/html")
 	if err := req.ParseForm(); err != nil {
 		e = httputil.Errorf(err, "error parsing form")
 		return
 	}
+	m := newManager(ctx)
 
 	imp, rev := grab(req.Form, "import"), grab(req.Form, "rev")
-	if err := T("result/specific_import_result.html").Execute(w, []string{imp, rev}); err != nil {
-		e = httputil.Errorf(err, "error executing index template")
+	ok, err := canSee(req, ctx, m, imp)
+	if err != nil {
+		e = httputil.Errorf(err, "couldn't check access to the project")
+		return
+	}
+	if !ok {
+		notFound(w, req)
+		return
+	}
+
+	wr, res, err := m.RevisionResult(imp, rev)
+	if err == mgo.ErrNotFound {
+		notFound(w, req)
+		return
+	}
+	if err != nil {
+		e = httputil.Errorf(err, "couldn't query for test results")
+		return
+	}
+	if e = maskOutput(m, imp, res); e != nil {
+		return
+	}
+
+	data := d{"ImportPath": imp, "Revision": rev, "WorkResult": wr, "Results": res}
+	return render(w, req, ctx, "result/specific_import_result.html", page{
+		Title:       imp + "@" + rev,
+		Section:     "result",
+		Breadcrumbs: []crumb{{"Results", "/result"}, {imp, "/result/" + imp}, {rev, ""}},
+	}, data)
+}
+
+//maskOutput masks the secrets in the output of the test results of the import
+//path, including ones registered after the output was stored.
+func maskOutput(m queryManager, importPath string, res []entities.TestResult) (e *httputil.Error) {
+	mask, err := m.Masker(importPath)
+	if err != nil {
+		e = httputil.Errorf(err, "couldn't load secrets")
+		return
+	}
+	for i := range res {
+		res[i].Output = mask.Mask(res[i].Output)
 	}
 	return
 }
 
-//image returns an image representing the most recent build status for an import path
-func image(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
-	w.Header().Set("Content-Type", "text/html")
+//compare shows what changed between the from and to revisions of an import
+//path: the tests that newly fail or pass, how their output changed, and the
+//commits in between.
+func compare(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	if err := req.ParseForm(); err != nil {
+		e = httputil.Errorf(err, "error parsing form")
+		return
+	}
+	imp, from, to := grab(req.Form, "import"), req.Form.Get("from"), req.Form.Get("to")
+	if from == "" || to == "" {
+		http.Error(w, "compare needs a from and to revision", http.StatusBadRequest)
+		return
+	}
+	m := newManager(ctx)
+
+	ok, err := canSee(req, ctx, m, imp)
+	if err != nil {
+		e = httputil.Errorf(err, "couldn't check access to the project")
+		return
+	}
+	if !ok {
+		notFound(w, req)
+		return
+	}
+
+	fwr, fres, err := m.RevisionResult(imp, from)
+	if err == mgo.ErrNotFound {
+		notFound(w, req)
+		return
+	}
+	if err != nil {
+		e = httputil.Errorf(err, "couldn't query for test results")
+		return
+	}
+	twr, tres, err := m.RevisionResult(imp, to)
+	if err == mgo.ErrNotFound {
+		notFound(w, req)
+		return
+	}
+	if err != nil {
+		e = httputil.Errorf(err, "couldn't query for test results")
+		return
+	}
+	if e = maskOutput(m, imp, fres); e != nil {
+		return
+	}
+	if e = maskOutput(m, imp, tres); e != nil {
+		return
+	}
+
+	//the commits are the same whichever way the revisions are compared
+	after, upto := fwr.RevDate, twr.RevDate
+	if upto.Before(after) {
+		after, upto = upto, after
+	}
+	commits, err := m.Commits(imp, after, upto)
+	if err != nil {
+		e = httputil.Errorf(err, "couldn't query for commits")
+		return
+	}
+
+	changes := compareTests(fres, tres)
+	var failing, passing []string
+	for _, c := range changes {
+		switch {
+		case c.NewlyFailing():
+			failing = append(failing, c.ImportPath)
+		case c.NewlyPassing():
+			passing = append(passing, c.ImportPath)
+		}
+	}
+
+	data := d{
+		"ImportPath": imp,
+		"From":       from,
+		"To":         to,
+		"FromResult": fwr,
+		"ToResult":   twr,
+		"Changes":    changes,
+		"Failing":    failing,
+		"Passing":    passing,
+		"Commits":    commits,
+	}
+	return render(w, req, ctx, "result/compare.html", page{
+		Title:       imp + " " + from + "..." + to,
+		Section:     "result",
+		Breadcrumbs: []crumb{{"Results", "/result"}, {imp, "/result/" + imp}, {from + "..." + to, ""}},
+	}, data)
+}
+
+//badge returns a badge for the most recent build status of an import path.
+//The status can be for a branch or toolchain with the branch and toolchain
+//query parameters, and the badge is a png instead of an svg with format=png.
+func badge(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
 	if err := req.ParseForm(); err != nil {
 		e = httputil.Errorf(err, "error parsing request form")
 		return
 	}
 
-	imp := grab(req.Form, "import")
-	_ = imp
+	key := badgeKey{
+		ImportPath: grab(req.Form, "import"),
+		Branch:     req.Form.Get("branch"),
+		Toolchain:  req.Form.Get("toolchain"),
+	}
+	status, err := badgeStatus(newManager(ctx), key)
+	if err != nil {
+		e = httputil.Errorf(err, "error finding the latest status")
+		return
+	}
+
+	format := req.Form.Get("format")
+	if format != "png" {
+		format = "svg"
+	}
+
+	//the badge only depends on the status so let clients keep theirs until it
+	//changes
+	etag := fmt.Sprintf(`"%s-%s"`, format, status)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(badgeTTL.Seconds())))
+	if req.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if format == "png" {
+		w.Header().Set("Content-Type", "image/png")
+		err = writePNG(w, status)
+	} else {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		err = writeSVG(w, status)
+	}
+	if err != nil {
+		e = httputil.Errorf(err, "error writing badge")
+	}
 	return
 }
 
 //pkg displays a list of import paths tested by goci
 func pkg(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
+	f, n, ok := listPage(w, req)
+	if !ok {
+		return
+	}
 	m := newManager(ctx)
 
-	res, err := m.Packages()
+	res, err := m.Packages(f, (n-1)*perPage, perPage+1)
 	if err != nil {
 		e = httputil.Errorf(err, "error grabbing package list")
 		return
 	}
-
-	w.Header().Set("Content-Type", "text/html")
-	if err := T("pkg/pkg.html").Execute(w, res); err != nil {
-		e = httputil.Errorf(err, "error executing index template")
+	p := paginate(n, len(res), f)
+	if len(res) > perPage {
+		res = res[:perPage]
 	}
-	return
+
+	data := d{"Packages": res, "Pages": p, "Filter": f}
+	return render(w, req, ctx, "pkg/pkg.html", page{Title: "Tested Packages", Section: "pkg"}, data)
 }
 
 //how displays a page showing how to use the service
 func how(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
-	w.Header().Set("Content-Type", "text/html")
-
-	if err := T("how/how.html").Execute(w, nil); err != nil {
-		e = httputil.Errorf(err, "error executing index template")
-	}
-	return
+	return render(w, req, ctx, "how/how.html", page{Title: "How", Section: "how"}, nil)
 }
@@ -1,12 +1,22 @@
 package frontend
 
 import (
+	"bytes"
+	"github.com/zeebo/goci/app/accounts"
 	"github.com/zeebo/goci/app/entities"
 	"github.com/zeebo/goci/app/httputil"
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/redact"
 	"html/template"
+	"image/color"
+	"image/png"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func makeGETRequest(path string) *http.Request {
@@ -17,26 +27,115 @@ func makeGETRequest(path string) *http.Request {
 	return req
 }
 
+//makeSessionPOST returns a form posted to the path by a user logged in with the
+//session "session".
+func makeSessionPOST(path, body string) *http.Request {
+	req, err := http.NewRequest("POST", path, strings.NewReader(body))
+	if err != nil {
+		panic(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: accounts.CookieName, Value: "session"})
+	return req
+}
+
+//sessionCSRF returns the csrf token of the session.
+func sessionCSRF(session string) string {
+	req := &http.Request{Header: http.Header{}}
+	req.AddCookie(&http.Cookie{Name: accounts.CookieName, Value: session})
+	return accounts.CSRFToken(req)
+}
+
 type testQueryManager struct{}
 
-func (testQueryManager) Index() ([]entities.TestResult, error)               { return nil, nil }
-func (testQueryManager) SpecificWork(string) (*entities.Work, error)         { return nil, nil }
-func (testQueryManager) Work(skip, limit int) ([]entities.WorkResult, error) { return nil, nil }
-func (testQueryManager) Packages() (pkgListJobResult, error)                 { return nil, nil }
+func (testQueryManager) SpecificWork(string) (*entities.Work, error) { return nil, nil }
+
+func (testQueryManager) Work(f filter, skip, limit int) ([]entities.Work, error) {
+	return nil, nil
+}
+
+func (testQueryManager) Packages(f filter, skip, limit int) ([]entities.Package, error) {
+	return nil, nil
+}
+
+func (testQueryManager) Results(f filter, skip, limit int) ([]entities.TestResult, error) {
+	return make([]entities.TestResult, limit), nil
+}
+
+func (testQueryManager) ImportResults(imp string, skip, limit int) ([]entities.TestResult, error) {
+	return nil, nil
+}
+
+func (testQueryManager) RevisionResult(imp, rev string) (*entities.WorkResult, []entities.TestResult, error) {
+	if rev != "foo" {
+		return nil, nil, mgo.ErrNotFound
+	}
+	return &entities.WorkResult{}, []entities.TestResult{{ImportPath: imp, Revision: rev, Output: "token hunter22"}}, nil
+}
+
+func (testQueryManager) Commits(imp string, after, upto time.Time) ([]rpc.Commit, error) {
+	return []rpc.Commit{{Revision: "foo", Subject: "the change"}}, nil
+}
+
+//Logs returns two entries, newest first unless tailing.
+func (testQueryManager) Logs(f logFilter, after bson.ObjectId, limit int) ([]httputil.LogEntry, error) {
+	logs := []httputil.LogEntry{
+		{ID: bson.NewObjectId(), Text: "second"},
+		{ID: bson.NewObjectId(), Text: "first"},
+	}
+	if after != "" {
+		logs[0], logs[1] = logs[1], logs[0]
+	}
+	return logs, nil
+}
+
+//LatestTest says the tests of github.com/zeebo/irc pass on go1.0.3 and fail
+//with any other toolchain.
+func (testQueryManager) LatestTest(imp, branch, toolchain string) (*entities.TestResult, error) {
+	switch {
+	case imp != "github.com/zeebo/irc":
+		return nil, nil
+	case toolchain == "go1.0.3":
+		return &entities.TestResult{Status: entities.TestStatusPass}, nil
+	}
+	return &entities.TestResult{Status: entities.TestStatusFail}, nil
+}
+
+//Project says github.com/zeebo/private is a private project with an owner.
+func (testQueryManager) Project(imp string) (*entities.Project, error) {
+	if !strings.HasPrefix(imp, "github.com/zeebo/private") {
+		return nil, nil
+	}
+	return &entities.Project{
+		ImportPath: "github.com/zeebo/private",
+		Owners:     []bson.ObjectId{bson.NewObjectId()},
+		Private:    true,
+	}, nil
+}
+
+//Masker masks hunter22 like it was registered for every project.
+func (testQueryManager) Masker(imp string) (*redact.Masker, error) {
+	return redact.New("hunter22"), nil
+}
 
 func init() {
 	//stub out contextfunc for tests
 	httputil.Config.ContextFunc = func(*http.Request) (c httputil.Context) { return }
 
 	//stub out the template func
-	T = func(unused string) *template.Template {
-		return template.Must(template.New("").Parse("{{.}}"))
+	T = func(unused string) (*template.Template, error) {
+		return template.New("").Parse("{{.}}")
 	}
 
 	//stub out the query manager
 	newManager = func(httputil.Context) queryManager {
 		return testQueryManager{}
 	}
+
+	//stub out the logged in user
+	currentUser = func(httputil.Context, *http.Request) (*entities.User, error) {
+		return nil, nil
+	}
 }
 
 func TestIndex(t *testing.T) {
@@ -71,6 +170,164 @@ func TestSpecificImportResult(t *testing.T) {
 	}
 }
 
+func TestSpecificImportResultMasked(t *testing.T) {
+	paths := []string{
+		"/result/github.com/zeebo/irc@foo",
+		"/compare/github.com/zeebo/irc?from=foo&to=foo",
+	}
+	for _, path := range paths {
+		rec := httptest.NewRecorder()
+		Mux.ServeHTTP(rec, makeGETRequest(path))
+		if rec.Code != 200 || strings.Contains(rec.Body.String(), "hunter22") {
+			t.Error(path, "Secret not masked:", rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestSpecificImportResultNotFound(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Mux.ServeHTTP(rec, makeGETRequest("/result/github.com/zeebo/irc@bar"))
+	if rec.Code != 404 {
+		t.Fatal("Invalid response code:", rec.Code)
+	}
+}
+
+func TestPrivateResultNotFound(t *testing.T) {
+	paths := []string{
+		"/result/github.com/zeebo/private@foo",
+		"/result/github.com/zeebo/private/sub@foo",
+		"/compare/github.com/zeebo/private?from=foo&to=foo",
+	}
+	for _, path := range paths {
+		rec := httptest.NewRecorder()
+		Mux.ServeHTTP(rec, makeGETRequest(path))
+		if rec.Code != 404 {
+			t.Error(path, "Invalid response code:", rec.Code)
+		}
+	}
+}
+
+func TestProject(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Mux.ServeHTTP(rec, makeGETRequest("/project/github.com/zeebo/irc"))
+	if rec.Code != 200 {
+		t.Fatal("Invalid response code:", rec.Code)
+	}
+}
+
+func TestLoginDisabled(t *testing.T) {
+	paths := []string{"/login", "/login/callback", "/account"}
+	for _, path := range paths {
+		rec := httptest.NewRecorder()
+		Mux.ServeHTTP(rec, makeGETRequest(path))
+		if rec.Code != 404 {
+			t.Error(path, "Invalid response code:", rec.Code)
+		}
+	}
+}
+
+func TestProjectForbidden(t *testing.T) {
+	paths := []string{
+		"/project/claim/github.com/zeebo/irc",
+		"/project/rebuild/github.com/zeebo/private",
+		"/project/settings/github.com/zeebo/private",
+	}
+	for _, path := range paths {
+		rec := httptest.NewRecorder()
+		Mux.ServeHTTP(rec, makeSessionPOST(path, "private=false&csrf="+sessionCSRF("session")))
+		if rec.Code != 403 {
+			t.Error(path, "Invalid response code:", rec.Code)
+		}
+		if strings.Contains(rec.Body.String(), "csrf") {
+			t.Error(path, "Rejected a valid csrf token")
+		}
+	}
+}
+
+func TestProjectCSRF(t *testing.T) {
+	token := sessionCSRF("session")
+	if sessionCSRF("other") == token {
+		t.Fatal("Expected the token to depend on the session")
+	}
+
+	paths := []string{
+		"/project/claim/github.com/zeebo/irc",
+		"/project/rebuild/github.com/zeebo/irc",
+		"/project/settings/github.com/zeebo/irc",
+		"/project/credentials/github.com/zeebo/irc",
+		"/project/masked/github.com/zeebo/irc",
+	}
+	for _, path := range paths {
+		for _, csrf := range []string{"", token[1:], sessionCSRF("other")} {
+			rec := httptest.NewRecorder()
+			Mux.ServeHTTP(rec, makeSessionPOST(path, "private=false&csrf="+csrf))
+			if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "csrf") {
+				t.Errorf("%s %q: Expected a csrf 403. Got %d", path, csrf, rec.Code)
+			}
+		}
+	}
+
+	//without a session there is no token to post
+	req, _ := http.NewRequest("POST", paths[0], strings.NewReader("csrf="))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	Mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Error("Expected a 403 without a session. Got", rec.Code)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	cases := []struct {
+		path string
+		code int
+	}{
+		{"/compare/github.com/zeebo/irc?from=foo&to=foo", 200},
+		{"/compare/github.com/zeebo/irc?from=bar&to=foo", 404},
+		{"/compare/github.com/zeebo/irc?from=foo&to=bar", 404},
+		{"/compare/github.com/zeebo/irc?from=foo", 400},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		Mux.ServeHTTP(rec, makeGETRequest(c.path))
+		if rec.Code != c.code {
+			t.Error(c.path, "Expected", c.code, "Got", rec.Code)
+		}
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	p := paginate(2, perPage+1, filter{Status: "Pass"})
+	if p != (pagination{Page: 2, Prev: 1, Next: 3, Query: "status=Pass&"}) {
+		t.Fatal("Got", p)
+	}
+	p = paginate(1, perPage, filter{})
+	if p != (pagination{Page: 1}) {
+		t.Fatal("Got", p)
+	}
+}
+
+func TestListFilters(t *testing.T) {
+	cases := []struct {
+		path string
+		code int
+	}{
+		{"/?status=Pass&page=2", 200},
+		{"/result?import=github.com/zeebo&from=2013-01-02&to=2013-02-03", 200},
+		{"/work?status=waiting", 200},
+		{"/pkg?q=irc", 200},
+		{"/result?from=yesterday", 400},
+		{"/pkg?to=2013-13-01", 400},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		Mux.ServeHTTP(rec, makeGETRequest(c.path))
+		if rec.Code != c.code {
+			t.Error(c.path, "Expected", c.code, "Got", rec.Code)
+		}
+	}
+}
+
 func TestImportResult(t *testing.T) {
 	rec := httptest.NewRecorder()
 	Mux.ServeHTTP(rec, makeGETRequest("/result/github.com/zeebo/irc"))
@@ -80,8 +337,63 @@ func TestImportResult(t *testing.T) {
 }
 
 func TestImage(t *testing.T) {
+	bcache = map[badgeKey]badgeEntry{}
+
+	cases := []struct {
+		path, ctype, etag string
+	}{
+		{"/image/github.com/zeebo/irc", "image/svg+xml", `"svg-Fail"`},
+		{"/image/github.com/zeebo/irc?toolchain=go1.0.3", "image/svg+xml", `"svg-Pass"`},
+		{"/image/github.com/zeebo/irc?format=png", "image/png", `"png-Fail"`},
+		{"/image/github.com/zeebo/nothing", "image/svg+xml", `"svg-unknown"`},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		Mux.ServeHTTP(rec, makeGETRequest(c.path))
+		if rec.Code != 200 {
+			t.Fatal(c.path, "Invalid response code:", rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != c.ctype {
+			t.Error(c.path, "Expected", c.ctype, "Got", ct)
+		}
+		if etag := rec.Header().Get("ETag"); etag != c.etag {
+			t.Error(c.path, "Expected", c.etag, "Got", etag)
+		}
+		if rec.Body.Len() == 0 {
+			t.Error(c.path, "Empty badge")
+		}
+	}
+
+	//the same status isn't sent again
+	req := makeGETRequest("/image/github.com/zeebo/irc")
+	req.Header.Set("If-None-Match", `"svg-Fail"`)
 	rec := httptest.NewRecorder()
-	Mux.ServeHTTP(rec, makeGETRequest("/image/github.com/zeebo/irc"))
+	Mux.ServeHTTP(rec, req)
+	if rec.Code != 304 || rec.Body.Len() != 0 {
+		t.Fatal("Invalid response code:", rec.Code)
+	}
+}
+
+func TestBadgePNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writePNG(&buf, entities.TestStatusWontBuild); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dy() != 20 || b.Dx() <= len("gociwontbuild")*pngAdvance {
+		t.Fatal("Invalid size:", b)
+	}
+	if c := color.RGBAModel.Convert(img.At(img.Bounds().Max.X-1, 0)); c != badgeColors[entities.TestStatusWontBuild] {
+		t.Fatal("Invalid color:", c)
+	}
+}
+
+func TestResult(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Mux.ServeHTTP(rec, makeGETRequest("/result?page=3"))
 	if rec.Code != 200 {
 		t.Fatal("Invalid response code:", rec.Code)
 	}
@@ -113,3 +425,68 @@ func TestNotFound(t *testing.T) {
 		}
 	}
 }
+
+func TestAdminRequiresPassword(t *testing.T) {
+	defer func(p string) { Config.AdminPassword = p }(Config.AdminPassword)
+
+	//disabled without a password
+	Config.AdminPassword = ""
+	rec := httptest.NewRecorder()
+	Mux.ServeHTTP(rec, makeGETRequest("/admin/workers"))
+	if rec.Code != 404 {
+		t.Fatal("Invalid response code:", rec.Code)
+	}
+
+	Config.AdminPassword = "secret"
+	req := makeGETRequest("/admin/workers")
+	req.SetBasicAuth("admin", "wrong")
+	rec = httptest.NewRecorder()
+	Mux.ServeHTTP(rec, req)
+	if rec.Code != 401 {
+		t.Fatal("Invalid response code:", rec.Code)
+	}
+}
+
+func TestAdminBadRequests(t *testing.T) {
+	defer func(p string) { Config.AdminPassword = p }(Config.AdminPassword)
+	Config.AdminPassword = "secret"
+
+	paths := []string{
+		"/admin/work/nope/requeue",
+		"/admin/work/nope/cancel",
+		"/admin/workers/remove/Builder/nope",
+		"/admin/workers/remove/Thing/50dfac94346bea11bb000001",
+	}
+	for _, path := range paths {
+		req, _ := http.NewRequest("POST", path, strings.NewReader("csrf="+csrfToken()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("admin", "secret")
+		rec := httptest.NewRecorder()
+		Mux.ServeHTTP(rec, req)
+		if rec.Code != 400 {
+			t.Error(path, "Invalid response code:", rec.Code)
+		}
+	}
+}
+
+func TestProjectCredentials(t *testing.T) {
+	defer func(k []byte) { accounts.Config.SecretKey = k }(accounts.Config.SecretKey)
+
+	post := func() int {
+		req := makeSessionPOST("/project/credentials/github.com/zeebo/private", "token=hunter2&csrf="+sessionCSRF("session"))
+		rec := httptest.NewRecorder()
+		Mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	//disabled without a key
+	accounts.Config.SecretKey = nil
+	if code := post(); code != 404 {
+		t.Fatal("Invalid response code:", code)
+	}
+
+	accounts.Config.SecretKey = make([]byte, 32)
+	if code := post(); code != 403 {
+		t.Fatal("Invalid response code:", code)
+	}
+}
@@ -1,77 +1,181 @@
 package frontend
 
 import (
+	"github.com/zeebo/goci/app/accounts"
 	"github.com/zeebo/goci/app/entities"
 	"github.com/zeebo/goci/app/httputil"
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/redact"
 	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
 	"sort"
 	"time"
 )
 
-type pkgListJobResult []*struct {
-	ImportPath string `bson:"_id"`
-	Value      struct {
-		When   time.Time
-		Status string
-	}
-}
-
-func (p pkgListJobResult) Len() int      { return len(p) }
-func (p pkgListJobResult) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
-func (p pkgListJobResult) Less(i, j int) bool {
-	return p[i].Value.When.After(p[j].Value.When)
-}
-
 var newManager = func(ctx httputil.Context) queryManager {
-	return &mgoQueryManager{db: ctx.DB}
+	return &mgoQueryManager{ctx: ctx, db: ctx.DB}
 }
 
 type queryManager interface {
-	Index() ([]entities.TestResult, error)
 	SpecificWork(id string) (*entities.Work, error)
-	Work(skip, limit int) ([]entities.WorkResult, error)
-	Packages() (pkgListJobResult, error)
+	Work(f filter, skip, limit int) ([]entities.Work, error)
+	Packages(f filter, skip, limit int) ([]entities.Package, error)
+	LatestTest(importPath, branch, toolchain string) (*entities.TestResult, error)
+	Results(f filter, skip, limit int) ([]entities.TestResult, error)
+	ImportResults(importPath string, skip, limit int) ([]entities.TestResult, error)
+	RevisionResult(importPath, rev string) (*entities.WorkResult, []entities.TestResult, error)
+	Commits(importPath string, after, upto time.Time) ([]rpc.Commit, error)
+	Logs(f logFilter, after bson.ObjectId, limit int) ([]httputil.LogEntry, error)
+	Project(importPath string) (*entities.Project, error)
+	Masker(importPath string) (*redact.Masker, error)
 }
 
 type mgoQueryManager struct {
-	db *mgo.Database
+	ctx httputil.Context
+	db  *mgo.Database
 }
 
-func (m *mgoQueryManager) Index() (res []entities.TestResult, err error) {
-	err = m.db.C("TestResult").Find(nil).Sort("-when").Limit(20).All(&res)
+func (m *mgoQueryManager) SpecificWork(id string) (work *entities.Work, err error) {
+	err = m.db.C("Work").FindId(bson.ObjectIdHex(id)).One(&work)
 	return
 }
 
-func (m *mgoQueryManager) SpecificWork(id string) (work *entities.Work, err error) {
-	err = m.db.C("Work").FindId(bson.ObjectIdHex(id)).One(&work)
+//Work returns the most recently created work items matching the filter.
+func (m *mgoQueryManager) Work(f filter, skip, limit int) (res []entities.Work, err error) {
+	sel := f.selector("status", "work.importpath", "created")
+	err = m.db.C("Work").Find(sel).Sort("-created").Skip(skip).Limit(limit).All(&res)
 	return
 }
 
-func (m *mgoQueryManager) Work(skip, limit int) (res []entities.WorkResult, err error) {
-	err = m.db.C("WorkResult").Find(nil).Sort("-when").Skip(skip).Limit(limit).All(&res)
+//Packages returns the latest status of the packages matching the filter, most
+//recently committed first.
+func (m *mgoQueryManager) Packages(f filter, skip, limit int) (res []entities.Package, err error) {
+	sel := f.selector("status", "_id", "revdate")
+	err = m.db.C("Package").Find(sel).Sort("-revdate").Skip(skip).Limit(limit).All(&res)
 	return
 }
 
-var pkgListJob = &mgo.MapReduce{
-	Map: `function() { emit(this.importpath, {
-			when: this.revdate,
-			status: this.status
-		});
-	}`,
-	Reduce: `function(key, values) {
-		var result = values.shift();
-		values.forEach(function(value) {
-			if (result.when < value.when) {
-				result = value;
+//LatestTest returns the test result for the most recent revision of the import
+//path, only looking at the branch and toolchain if they are given. It returns
+//nil if there are no test results.
+func (m *mgoQueryManager) LatestTest(importPath, branch, toolchain string) (res *entities.TestResult, err error) {
+	sel := bson.M{"importpath": importPath}
+	if branch != "" {
+		sel["branch"] = branch
+	}
+	if toolchain != "" {
+		sel["toolchain"] = toolchain
+	}
+	err = m.db.C("TestResult").Find(sel).Select(noOutput).Sort("-revdate", "-when").One(&res)
+	if err == mgo.ErrNotFound {
+		res, err = nil, nil
+	}
+	return
+}
+
+//noOutput leaves the output out of test results in lists.
+var noOutput = bson.M{"output": 0}
+
+//Results returns the most recent test results matching the filter without
+//their output.
+func (m *mgoQueryManager) Results(f filter, skip, limit int) (res []entities.TestResult, err error) {
+	sel := f.selector("status", "importpath", "when")
+	err = m.db.C("TestResult").Find(sel).Select(noOutput).Sort("-when").Skip(skip).Limit(limit).All(&res)
+	return
+}
+
+//ImportResults returns the test results of the import path without their
+//output, most recent revision first.
+func (m *mgoQueryManager) ImportResults(importPath string, skip, limit int) (res []entities.TestResult, err error) {
+	err = m.db.C("TestResult").
+		Find(bson.M{"importpath": importPath}).
+		Select(noOutput).
+		Sort("-revdate", "-when").
+		Skip(skip).
+		Limit(limit).
+		All(&res)
+	return
+}
+
+//RevisionResult returns the most recent work result that tested the revision of
+//the import path along with the test results of every package it tested.
+func (m *mgoQueryManager) RevisionResult(importPath, rev string) (wr *entities.WorkResult, res []entities.TestResult, err error) {
+	var tr entities.TestResult
+	err = m.db.C("TestResult").
+		Find(bson.M{"importpath": importPath, "revision": rev}).
+		Select(bson.M{"workresultid": 1}).
+		Sort("-when").
+		One(&tr)
+	if err != nil {
+		return
+	}
+
+	if err = m.db.C("WorkResult").FindId(tr.WorkResultID).One(&wr); err != nil {
+		return
+	}
+	err = m.db.C("TestResult").Find(bson.M{"workresultid": wr.ID}).Sort("importpath").All(&res)
+	return
+}
+
+type byDate []rpc.Commit
+
+func (b byDate) Len() int           { return len(b) }
+func (b byDate) Less(i, j int) bool { return b[i].Date.After(b[j].Date) }
+func (b byDate) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+//Commits returns the commits logged by the builds of the import path for the
+//revisions committed after after up to upto, newest first.
+func (m *mgoQueryManager) Commits(importPath string, after, upto time.Time) (commits []rpc.Commit, err error) {
+	var ids []bson.ObjectId
+	err = m.db.C("TestResult").
+		Find(bson.M{"importpath": importPath, "revdate": bson.M{"$gt": after, "$lte": upto}}).
+		Distinct("workresultid", &ids)
+	if err != nil {
+		return
+	}
+
+	var res []entities.WorkResult
+	err = m.db.C("WorkResult").
+		Find(bson.M{"_id": bson.M{"$in": ids}}).
+		Select(bson.M{"commits": 1}).
+		All(&res)
+	if err != nil {
+		return
+	}
+
+	//the logs of builds can overlap if revisions were tested out of order
+	seen := map[string]bool{}
+	for _, r := range res {
+		for _, c := range r.Commits {
+			if !seen[c.Revision] {
+				seen[c.Revision] = true
+				commits = append(commits, c)
 			}
-		});
-		return result;
-	}`,
+		}
+	}
+	sort.Sort(byDate(commits))
+	return
 }
 
-func (m *mgoQueryManager) Packages() (res pkgListJobResult, err error) {
-	_, err = m.db.C("TestResult").Find(nil).MapReduce(pkgListJob, &res)
-	sort.Sort(res)
+//Logs returns the log entries matching the filter. With after set it returns
+//the entries logged after it oldest first, so they can be tailed. Otherwise it
+//returns the most recent entries newest first.
+func (m *mgoQueryManager) Logs(f logFilter, after bson.ObjectId, limit int) (res []httputil.LogEntry, err error) {
+	sel, sort := f.selector(), "-_id"
+	if after != "" {
+		sel["_id"] = bson.M{"$gt": after}
+		sort = "_id"
+	}
+	err = m.db.C("logs").Find(sel).Sort(sort).Limit(limit).All(&res)
 	return
 }
+
+//Project returns the project the import path belongs to, or nil.
+func (m *mgoQueryManager) Project(importPath string) (*entities.Project, error) {
+	return accounts.Project(m.ctx, importPath)
+}
+
+//Masker returns what masks the secrets in the output of the import path.
+func (m *mgoQueryManager) Masker(importPath string) (*redact.Masker, error) {
+	return accounts.Masker(m.ctx, importPath)
+}
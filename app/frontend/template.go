@@ -1,8 +1,12 @@
 package frontend
 
 import (
+	"bytes"
+	"github.com/zeebo/goci/app/accounts"
+	"github.com/zeebo/goci/app/httputil"
 	"html/template"
-	"path/filepath"
+	"net/http"
+	"net/url"
 	"sync"
 )
 
@@ -12,29 +16,123 @@ var (
 	tmut sync.RWMutex
 )
 
-//T looks up the template at the given name and returns it. It panics if there
-//are any errors parsing the template.
-var T = func(name string) *template.Template {
-	baseTemplate := filepath.Join(Config.Templates, "_base.html")
-	path := filepath.Join(Config.Templates, name)
-
+//T looks up the template at the given name parsed with the base layout and
+//returns it, or the error parsing it.
+var T = func(name string) (t *template.Template, err error) {
 	//look up the template name in the cache if debug is not set
 	tmut.RLock()
-	if t, ok := tmap[name]; ok && !Config.Debug {
-		tmut.RUnlock()
-		return t.Lookup("_base.html")
-	}
+	t, ok := tmap[name]
 	tmut.RUnlock()
+	if ok && !Config.Debug {
+		return
+	}
 
 	//parse the template and add it to the cache
-	t, err := template.New("_base.html").ParseFiles(baseTemplate, path)
+	t, err = template.New("_base.html").ParseFS(Config.templateFS(), "_base.html", name)
 	if err != nil {
-		panic(err)
+		return
 	}
 
 	tmut.Lock()
 	tmap[name] = t
 	tmut.Unlock()
+	return
+}
+
+//page is the model shared by every page for the layout. It is also embedded in
+//the page as json for scripts to use.
+type page struct {
+	Title       string    `json:"title"`
+	Section     string    `json:"section"` //the navbar item the page is under
+	Breadcrumbs []crumb   `json:"breadcrumbs"`
+	Flashes     []string  `json:"flashes"`
+	User        *pageUser `json:"user"`     //nil if nobody is logged in
+	CanLogin    bool      `json:"canLogin"` //if users can log in
+}
+
+//crumb is a breadcrumb leading to the page. The last one is the page itself
+//and has no URL.
+type crumb struct {
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+//pageUser is the logged in user shown on every page.
+type pageUser struct {
+	Login string `json:"login"`
+	Name  string `json:"name"`
+}
+
+//In returns if the page is under the section of the navbar.
+func (p page) In(section string) bool { return p.Section == section }
+
+//view is what templates are executed with. The base layout uses the Page and
+//the content of the page gets the Data.
+type view struct {
+	Page page
+	Data interface{}
+}
+
+//flashCookie is the cookie holding a message to show on the next page.
+const flashCookie = "goci_flash"
+
+//flash sets a message to show on the next page rendered, like the one a form
+//redirects to.
+func flash(w http.ResponseWriter, msg string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookie,
+		Value:    url.QueryEscape(msg),
+		Path:     "/",
+		HttpOnly: true,
+	})
+}
 
-	return t.Lookup("_base.html")
+//flashes returns the messages set by flash for the request.
+func flashes(req *http.Request) (msgs []string) {
+	c, err := req.Cookie(flashCookie)
+	if err != nil {
+		return
+	}
+	if msg, err := url.QueryUnescape(c.Value); err == nil && msg != "" {
+		msgs = append(msgs, msg)
+	}
+	return
+}
+
+//render executes the template with the given name and the base layout, filling
+//in the user and flash messages of the page model. Errors parsing or executing
+//the template are returned so they are served as a 500, and nothing is written
+//unless it succeeds.
+func render(w http.ResponseWriter, req *http.Request, ctx httputil.Context, name string, p page, data interface{}) (e *httputil.Error) {
+	t, err := T(name)
+	if err != nil {
+		e = httputil.Errorf(err, "error parsing template %s", name)
+		return
+	}
+
+	u, err := currentUser(ctx, req)
+	if err != nil {
+		e = httputil.Errorf(err, "error loading user")
+		return
+	}
+	if u != nil {
+		p.User = &pageUser{Login: u.Login, Name: u.Name}
+	}
+	p.CanLogin = accounts.Enabled()
+	msgs := flashes(req)
+	p.Flashes = append(p.Flashes, msgs...)
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, view{Page: p, Data: data}); err != nil {
+		e = httputil.Errorf(err, "error executing template %s", name)
+		return
+	}
+
+	//the messages have been shown so they are cleared
+	if len(msgs) > 0 {
+		http.SetCookie(w, &http.Cookie{Name: flashCookie, Path: "/", MaxAge: -1})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+	return
 }
@@ -0,0 +1,126 @@
+package frontend
+
+import (
+	"errors"
+	"github.com/zeebo/goci/app/httputil"
+	"github.com/zeebo/goci/templates"
+	"html/template"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//loadTemplate is the template loader before the tests stub it out.
+var loadTemplate = T
+
+func TestEmbeddedTemplates(t *testing.T) {
+	names, err := fs.Glob(templates.FS, "*/*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) == 0 {
+		t.Fatal("No templates are embedded")
+	}
+	for _, name := range names {
+		if _, err := loadTemplate(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestTemplateParseError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"_base.html": `{{ template "content" .Data }}`,
+		"bad.html":   `{{ define "content" }}{{ .Foo `,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer func(c Conf) { *Config = c }(*Config)
+	Config.Debug, Config.Templates = true, dir
+
+	if _, err := loadTemplate("bad.html"); err == nil {
+		t.Fatal("Expected an error parsing the template")
+	}
+	if _, err := loadTemplate("missing.html"); err == nil {
+		t.Fatal("Expected an error for a missing template")
+	}
+}
+
+func TestRenderError(t *testing.T) {
+	defer func(old func(string) (*template.Template, error)) { T = old }(T)
+	T = func(string) (*template.Template, error) {
+		return nil, errors.New("unexpected EOF")
+	}
+
+	rec := httptest.NewRecorder()
+	e := render(rec, makeGETRequest("/how"), httputil.Context{}, "how/how.html", page{}, nil)
+	if e == nil || e.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected a 500. Got %+v", e)
+	}
+	if rec.Body.Len() > 0 {
+		t.Fatalf("Expected nothing written. Got %q", rec.Body)
+	}
+}
+
+func TestRenderPage(t *testing.T) {
+	defer func(old func(string) (*template.Template, error)) { T = old }(T)
+	T = loadTemplate
+
+	req := makeGETRequest("/how")
+	req.AddCookie(&http.Cookie{Name: flashCookie, Value: "Logged+out."})
+	rec := httptest.NewRecorder()
+	p := page{Title: "How", Section: "how", Breadcrumbs: []crumb{{"Docs", "/docs"}, {"How", ""}}}
+	if e := render(rec, req, httputil.Context{}, "how/how.html", p, nil); e != nil {
+		t.Fatal(e.Error)
+	}
+
+	body := rec.Body.String()
+	for _, exp := range []string{
+		`<title>How - GoCI</title>`,
+		`<a href="/how" aria-current="page">How</a>`,
+		`<li class="active" aria-current="page">How</li>`,
+		`<div class="alert alert-info">Logged out.</div>`,
+		`"title":"How"`,
+		`"flashes":["Logged out."]`,
+		`<h1>How to use GoCI</h1>`,
+	} {
+		if !strings.Contains(body, exp) {
+			t.Errorf("Expected %q in the page", exp)
+		}
+	}
+	if c := rec.Header().Get("Set-Cookie"); !strings.Contains(c, flashCookie+"=;") {
+		t.Errorf("Expected the flash cookie to be cleared. Got %q", c)
+	}
+}
+
+func TestStatic(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Mux.ServeHTTP(rec, makeGETRequest("/static/css/main.css"))
+	if rec.Code != 200 {
+		t.Fatal("Invalid response code:", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "prefers-color-scheme") {
+		t.Fatal("Expected the embedded stylesheet")
+	}
+
+	rec = httptest.NewRecorder()
+	Mux.ServeHTTP(rec, makeGETRequest("/static/../templates/_base.html"))
+	if rec.Code == 200 {
+		t.Fatal("Served a file outside of the static files")
+	}
+}
@@ -3,12 +3,14 @@ package httputil
 
 import (
 	"fmt"
+	"github.com/zeebo/goci/redact"
 	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
 	"labix.org/v2/mgo/txn"
 	"log"
 	"net/http"
 	"os"
+	"time"
 )
 
 //Config is the package level config for httputil.
@@ -16,6 +18,8 @@ var Config struct {
 	DB     *mgo.Database //Database to use
 	Txn    string        //name of transaction collection
 	Domain string        //domain name of website
+	TLS    bool          //if the website is served over https
+	LogTTL time.Duration //how long log entries are kept
 
 	ContextFunc func(req *http.Request) Context //function to create contexts
 }
@@ -27,15 +31,21 @@ var logger = log.New(os.Stdout, "", log.Lshortfile)
 func init() {
 	Config.Domain = "localhost:9080"
 	Config.Txn = "txns"
+	Config.LogTTL = 7 * 24 * time.Hour
 	Config.ContextFunc = NewContext
 
 	//set the display logs to include filename/num
 	log.SetFlags(log.Lshortfile)
 }
 
-//Absolute returns an absolute url for a given path.
+//Absolute returns an absolute url for a given path. The url is https if the
+//website is served over tls.
 func Absolute(req string) string {
-	return fmt.Sprintf("http://%s%s", Config.Domain, req)
+	scheme := "http"
+	if Config.TLS {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, Config.Domain, req)
 }
 
 //NewContext returns a new context for the given request.
@@ -46,16 +56,33 @@ func NewContext(req *http.Request) Context {
 
 	db := Config.DB.Session.Clone().DB(Config.DB.Name)
 
-	return Context{
-		DB: db,
-		R:  txn.NewRunner(db.C(Config.Txn)),
+	c := Context{
+		DB:        db,
+		R:         txn.NewRunner(db.C(Config.Txn)),
+		RequestID: bson.NewObjectId().Hex(),
+	}
+	if req != nil {
+		c.Handler = req.URL.Path
+		if id := req.Header.Get("X-Request-Id"); id != "" {
+			c.RequestID = id
+		}
 	}
+	return c
 }
 
 //Context represents the set of information a request needs to execute.
 type Context struct {
 	DB *mgo.Database
 	R  *txn.Runner
+
+	//these are recorded with every log entry
+	RequestID string //identifies the request the context was made for
+	Handler   string //the path of the request, if any
+	Key       string //the work item or notification being handled, if any
+
+	//Masker masks the secrets of the project being handled in the log
+	//entries, if any.
+	Masker *redact.Masker
 }
 
 //close closes the context, cleaning up any resources it acquired.
@@ -65,13 +92,54 @@ func (c *Context) Close() {
 	}
 }
 
-//logf pushes the log message into the capped collection with the given format
+//LogEntry is a log message stored in the logs collection.
+type LogEntry struct {
+	ID        bson.ObjectId `bson:"_id,omitempty"`
+	When      time.Time
+	Severity  string //info or error
+	Text      string
+	RequestID string
+	Handler   string
+	Key       string
+}
+
+//EnsureLogs sets up the logs collection so that entries expire after
+//Config.LogTTL and can be looked up by key and request.
+func EnsureLogs(db *mgo.Database) (err error) {
+	logs := db.C("logs")
+	err = logs.EnsureIndex(mgo.Index{
+		Key:         []string{"when"},
+		ExpireAfter: Config.LogTTL,
+	})
+	if err != nil {
+		return
+	}
+	if err = logs.EnsureIndexKey("key"); err != nil {
+		return
+	}
+	err = logs.EnsureIndexKey("requestid")
+	return
+}
+
+//logf pushes the log message into the logs collection with the given format
 //and severity.
 func (c *Context) logf(severity, format string, items ...interface{}) {
-	logger.Output(3, fmt.Sprintf("%s: %s", severity, fmt.Sprintf(format, items...)))
-	c.DB.C("logs").Insert(bson.M{
-		"severity": severity,
-		"text":     fmt.Sprintf(format, items...),
+	//errors can have output with secrets in them
+	text := c.Masker.Mask(fmt.Sprintf(format, items...))
+	logger.Output(3, fmt.Sprintf("%s: [%s] %s", severity, c.RequestID, text))
+
+	//contexts without a database, like in tests, only log to stdout
+	if c.DB == nil {
+		return
+	}
+	c.DB.C("logs").Insert(LogEntry{
+		ID:        bson.NewObjectId(),
+		When:      time.Now(),
+		Severity:  severity,
+		Text:      text,
+		RequestID: c.RequestID,
+		Handler:   c.Handler,
+		Key:       c.Key,
 	})
 }
 
@@ -5,8 +5,16 @@ import (
 	"github.com/zeebo/goci/app/httputil"
 )
 
-// http://goci.me/some/path
+//http://goci.me/some/path
 func ExampleAbsolute() {
 	httputil.Config.Domain = "goci.me"
 	fmt.Println(httputil.Absolute("/some/path"))
 }
+
+//https://goci.me/some/path
+func ExampleAbsolute_tls() {
+	httputil.Config.Domain = "goci.me"
+	httputil.Config.TLS = true
+	defer func() { httputil.Config.TLS = false }()
+	fmt.Println(httputil.Absolute("/some/path"))
+}
@@ -139,6 +139,8 @@ func (m multiError) isNil() bool {
 }
 
 func dispatchNotificationItem(ctx httputil.Context, n *entities.Notification) (err error) {
+	ctx.Key = n.ID.Hex()
+
 	//create an attempt for this notification
 	a := entities.NotifAttempt{
 		When: time.Now(),
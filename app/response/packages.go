@@ -0,0 +1,66 @@
+package response
+
+import (
+	"github.com/zeebo/goci/app/entities"
+	"github.com/zeebo/goci/app/httputil"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+)
+
+//UpdatePackage stores the status of the package unless a more recently
+//committed revision of it has already been stored.
+func UpdatePackage(db *mgo.Database, pkg entities.Package) (err error) {
+	sel := bson.M{
+		"_id":     pkg.ImportPath,
+		"revdate": bson.M{"$lte": pkg.RevDate},
+	}
+
+	//if a newer revision is stored the selector doesn't match and the upsert
+	//fails inserting a second document with the import path
+	_, err = db.C("Package").Upsert(sel, pkg)
+	if mgo.IsDup(err) {
+		err = nil
+	}
+	return
+}
+
+//RebuildPackages stores the status of every package from the most recently
+//committed revision in the test results. It is for filling in the packages
+//from test results recorded before they were kept up to date.
+func RebuildPackages(ctx httputil.Context) (n int, err error) {
+	var paths []string
+	if err = ctx.DB.C("TestResult").Find(nil).Distinct("importpath", &paths); err != nil {
+		return
+	}
+
+	for _, path := range paths {
+		var res entities.TestResult
+		err = ctx.DB.C("TestResult").
+			Find(bson.M{"importpath": path}).
+			Select(bson.M{"output": 0}).
+			Sort("-revdate", "-when").
+			One(&res)
+		if err != nil {
+			return
+		}
+		if err = UpdatePackage(ctx.DB, packageOf(res)); err != nil {
+			return
+		}
+		n++
+	}
+
+	ctx.Infof("Rebuilt %d packages", n)
+	return
+}
+
+//packageOf returns the package status from the test result.
+func packageOf(res entities.TestResult) entities.Package {
+	return entities.Package{
+		ImportPath: res.ImportPath,
+		Status:     res.Status,
+		Revision:   res.Revision,
+		RevDate:    res.RevDate,
+		When:       res.When,
+		Test:       res.ID,
+	}
+}
@@ -2,20 +2,66 @@
 package response
 
 import (
-	"fmt"
+	"github.com/zeebo/goci/app/accounts"
 	"github.com/zeebo/goci/app/entities"
 	"github.com/zeebo/goci/app/httputil"
 	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/app/rpc/client"
+	"github.com/zeebo/goci/app/tracker"
+	"github.com/zeebo/goci/redact"
 	"labix.org/v2/mgo/bson"
 	"labix.org/v2/mgo/txn"
 	"net/http"
-	"strings"
 	"time"
 )
 
 //Response is a service that records Runner responses
 type Response struct{}
 
+//ErrBadSignature is returned when a response isn't signed by the secret of the
+//attempt it is for.
+var ErrBadSignature = rpc.Error("invalid signature")
+
+//checkSignature makes sure the response is for the current attempt on the work
+//item and was signed with the secret of the attempt by calling valid with the
+//secret, and returns the work item. Responses for earlier attempts and invalid
+//signatures are logged.
+func checkSignature(ctx httputil.Context, req *http.Request, key, id string, valid func(secret string) bool) (work *entities.Work, err error) {
+	if !bson.IsObjectIdHex(key) || !bson.IsObjectIdHex(id) {
+		err = rpc.Errorf("invalid key or attempt id")
+		return
+	}
+
+	if err = ctx.DB.C("Work").FindId(bson.ObjectIdHex(key)).One(&work); err != nil {
+		return
+	}
+
+	//only the current attempt can respond, so a secret leaked by an earlier
+	//attempt can't be used to sign results
+	if len(work.AttemptLog) > 0 {
+		a := work.AttemptLog[0]
+		if a.ID == bson.ObjectIdHex(id) && a.Secret != "" && valid(a.Secret) {
+			return
+		}
+	}
+
+	work = nil
+	ctx.Errorf("Rejected a response from %s with an invalid signature for attempt %s of %s", req.RemoteAddr, id, key)
+	err = ErrBadSignature
+	return
+}
+
+//masker returns what masks the secrets in the output of the import path. If
+//the secrets can't be loaded only what looks like a secret is masked, so the
+//results are still stored.
+func masker(ctx httputil.Context, importPath string) *redact.Masker {
+	m, err := accounts.Masker(ctx, importPath)
+	if err != nil {
+		ctx.Errorf("Error loading the secrets of %s: %v", importPath, err)
+	}
+	return m
+}
+
 //Post is the rpc method that the Runner uses to give a response about an item.
 func (Response) Post(req *http.Request, args *rpc.RunnerResponse, resp *rpc.None) (err error) {
 	//wrap our error on the way out
@@ -24,6 +70,17 @@ func (Response) Post(req *http.Request, args *rpc.RunnerResponse, resp *rpc.None
 	//create our context
 	ctx := httputil.NewContext(req)
 	defer ctx.Close()
+	ctx.Key = args.Key
+
+	//only enrolled runners can post results for the attempt they were given
+	if err = tracker.Authenticate(ctx, args.Credential, "Runner"); err != nil {
+		return
+	}
+	work, err := checkSignature(ctx, req, args.Key, args.ID, args.Verify)
+	if err != nil {
+		return
+	}
+	ctx.Masker = masker(ctx, work.Work.ImportPath)
 
 	//build the keys we need to reference
 	key := bson.ObjectIdHex(args.Key)
@@ -50,48 +107,46 @@ func (Response) Post(req *http.Request, args *rpc.RunnerResponse, resp *rpc.None
 			Revision: args.Revision,
 			RevDate:  args.RevDate,
 			When:     time.Now(),
+			Commits:  args.Commits,
 		},
 	}}
 
 	//operations for notifications
 	var nots []txn.Op
 
-	//store the test results
+	//the latest status of each package once the results are stored
+	var pkgs []entities.Package
+
+	//store the test results with the secrets in their output masked
 	for _, out := range args.Tests {
 
 		//get the status from the output type and output
 		var status string
-		switch out.Type {
-		case rpc.OutputSuccess:
-			if strings.HasSuffix(out.Output, "\nPASS\n") {
-				status = entities.TestStatusPass
-			} else {
-				status = entities.TestStatusFail
-			}
-		case rpc.OutputWontBuild:
-			status = entities.TestStatusWontBuild
-		case rpc.OutputError:
-			status = entities.TestStatusError
-		default:
-			err = fmt.Errorf("unknown output type: %s", out.Type)
+		if status, err = entities.OutputStatus(out); err != nil {
 			return
 		}
 
 		//add the test result to the operation
 		tid := bson.NewObjectId()
+		test := entities.TestResult{
+			ID:           tid,
+			WorkResultID: wkey,
+			ImportPath:   out.ImportPath,
+			Revision:     args.Revision,
+			RevDate:      args.RevDate,
+			When:         time.Now(),
+			Output:       ctx.Masker.Mask(out.Output),
+			Status:       status,
+			Branch:       entities.Branch(work.Work, args.Revision),
+			Toolchain:    work.Work.Toolchain,
+			Duration:     out.Duration,
+		}
 		ops = append(ops, txn.Op{
-			C:  "TestResult",
-			Id: tid,
-			Insert: entities.TestResult{
-				WorkResultID: wkey,
-				ImportPath:   out.ImportPath,
-				Revision:     args.Revision,
-				RevDate:      args.RevDate,
-				When:         time.Now(),
-				Output:       out.Output,
-				Status:       status,
-			},
+			C:      "TestResult",
+			Id:     tid,
+			Insert: test,
 		})
+		pkgs = append(pkgs, packageOf(test))
 
 		//skip if we don't have a notification
 		if out.Config.NotifyOn == "" {
@@ -115,17 +170,51 @@ func (Response) Post(req *http.Request, args *rpc.RunnerResponse, resp *rpc.None
 	ops = append(ops, nots...)
 
 	//run the transaction
-	err = ctx.R.Run(ops, bson.NewObjectId(), nil)
+	applied, err := storeResults(ctx, ops, pkgs)
+	if err != nil {
+		return
+	}
+
+	//tell it to dispatch notifications
+	if applied && len(nots) > 0 {
+		go client.Default().Get(httputil.Absolute("/notifications/dispatch"))
+	}
+
+	return
+}
+
+//runOps runs the operations in a transaction. It is a variable so the tests
+//can run without a database.
+var runOps = func(ctx httputil.Context, ops []txn.Op) error {
+	return ctx.R.Run(ops, bson.NewObjectId(), nil)
+}
+
+//updatePackage stores the status of a package. It is a variable so the tests
+//can run without a database.
+var updatePackage = UpdatePackage
+
+//storeResults runs the operations storing the results of a response and then
+//updates the status of the packages, reporting if the results were stored. A
+//response that lost the race, like a stale or replayed one, stores nothing so
+//the packages are left alone.
+func storeResults(ctx httputil.Context, ops []txn.Op, pkgs []entities.Package) (applied bool, err error) {
+	err = runOps(ctx, ops)
 	if err == txn.ErrAborted {
 		ctx.Infof("Lost the race inserting result.")
 		err = nil
+		return
 	}
-
-	//tell it to dispatch notifications
-	if len(nots) > 0 {
-		go http.Get(httputil.Absolute("/notifications/dispatch"))
+	if err != nil {
+		return
 	}
+	applied = true
 
+	//update the packages, logging any errors because the results are stored
+	for _, pkg := range pkgs {
+		if e := updatePackage(ctx.DB, pkg); e != nil {
+			ctx.Errorf("Error updating package %s: %v", pkg.ImportPath, e)
+		}
+	}
 	return
 }
 
@@ -137,6 +226,17 @@ func (Response) Error(req *http.Request, args *rpc.BuilderResponse, resp *rpc.No
 	//create the context
 	ctx := httputil.NewContext(req)
 	defer ctx.Close()
+	ctx.Key = args.Key
+
+	//only enrolled builders can post errors for the attempt they were given
+	if err = tracker.Authenticate(ctx, args.Credential, "Builder"); err != nil {
+		return
+	}
+	work, err := checkSignature(ctx, req, args.Key, args.ID, args.Verify)
+	if err != nil {
+		return
+	}
+	ctx.Masker = masker(ctx, work.Work.ImportPath)
 
 	//get the key of the work item
 	key := bson.ObjectIdHex(args.Key)
@@ -162,7 +262,7 @@ func (Response) Error(req *http.Request, args *rpc.BuilderResponse, resp *rpc.No
 			Revision: args.Revision,
 			RevDate:  args.RevDate,
 			When:     time.Now(),
-			Error:    args.Error,
+			Error:    ctx.Masker.Mask(args.Error),
 		},
 	}}
 
@@ -187,6 +287,14 @@ func (Response) DispatchError(req *http.Request, args *rpc.DispatchResponse, res
 	//create the context
 	ctx := httputil.NewContext(req)
 	defer ctx.Close()
+	ctx.Key = args.Key
+
+	//only the dispatcher knows the secret of the attempt
+	work, err := checkSignature(ctx, req, args.Key, args.ID, args.Verify)
+	if err != nil {
+		return
+	}
+	ctx.Masker = masker(ctx, work.Work.ImportPath)
 
 	//get the key of the work item
 	key := bson.ObjectIdHex(args.Key)
@@ -195,7 +303,10 @@ func (Response) DispatchError(req *http.Request, args *rpc.DispatchResponse, res
 		C:  "Work",
 		Id: key,
 		Assert: bson.M{
-			"status":   entities.WorkStatusProcessing,
+			"status": bson.M{"$in": []string{
+				entities.WorkStatusProcessing,
+				entities.WorkStatusBuilt,
+			}},
 			"revision": args.WorkRev,
 		},
 		Update: bson.M{
@@ -209,7 +320,7 @@ func (Response) DispatchError(req *http.Request, args *rpc.DispatchResponse, res
 			WorkID:  key,
 			Success: false,
 			When:    time.Now(),
-			Error:   args.Error,
+			Error:   ctx.Masker.Mask(args.Error),
 		},
 	}}
 
@@ -0,0 +1,52 @@
+package response
+
+import (
+	"github.com/zeebo/goci/app/entities"
+	"github.com/zeebo/goci/app/httputil"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/txn"
+	"testing"
+)
+
+//stubStore stands in for the database, running transactions with the given
+//error and keeping the stored packages.
+func stubStore(runErr error) (pkgs map[string]entities.Package, restore func()) {
+	oldRun, oldUpdate := runOps, updatePackage
+	pkgs = map[string]entities.Package{}
+	runOps = func(httputil.Context, []txn.Op) error { return runErr }
+	updatePackage = func(db *mgo.Database, pkg entities.Package) error {
+		pkgs[pkg.ImportPath] = pkg
+		return nil
+	}
+	restore = func() { runOps, updatePackage = oldRun, oldUpdate }
+	return
+}
+
+func TestStoreResults(t *testing.T) {
+	pkgs, restore := stubStore(nil)
+	defer restore()
+
+	pkg := entities.Package{ImportPath: "example.com/ex", Status: entities.TestStatusPass}
+	applied, err := storeResults(httputil.Context{}, nil, []entities.Package{pkg})
+	if err != nil || !applied {
+		t.Fatalf("Expected the results to be stored. Got %v %v", applied, err)
+	}
+	if pkgs[pkg.ImportPath] != pkg {
+		t.Fatalf("Expected the package to be updated. Got %+v", pkgs)
+	}
+}
+
+func TestStoreResultsAborted(t *testing.T) {
+	//a stale or replayed response fails the assertions on the work item
+	pkgs, restore := stubStore(txn.ErrAborted)
+	defer restore()
+
+	pkg := entities.Package{ImportPath: "example.com/ex", Status: entities.TestStatusFail}
+	applied, err := storeResults(httputil.Context{}, nil, []entities.Package{pkg})
+	if err != nil || applied {
+		t.Fatalf("Expected nothing stored without an error. Got %v %v", applied, err)
+	}
+	if len(pkgs) != 0 {
+		t.Fatalf("Expected the packages to be unchanged. Got %+v", pkgs)
+	}
+}
@@ -2,77 +2,213 @@ package client
 
 import (
 	"bytes"
-	"errors"
+	"context"
+	"crypto/tls"
+	"github.com/zeebo/goci/app/rpc"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"time"
 )
 
 //Codec implements the functionality that a client needs to send requests to
-//a service.
+//a service. DecodeResponse returns an rpc.Error if the service responded with
+//an error.
 type Codec interface {
 	ContentType() string
 	EncodeRequest(method string, args interface{}) ([]byte, error)
 	DecodeResponse(r io.Reader, reply interface{}) error
 }
 
+//DefaultTimeout is how long a call is given if its context has no deadline.
+const DefaultTimeout = time.Minute
+
+//Retry is a policy for retrying calls that fail before a response is read
+//from the service. Only idempotent methods are retried, since the service may
+//have handled the call even though we didn't hear back.
+type Retry struct {
+	Attempts   int           //the most times to make a call, including the first
+	Backoff    time.Duration //the wait before the first retry, doubled after each
+	Idempotent []string      //the methods that are safe to call more than once
+}
+
+//DefaultRetry is the policy new Clients retry calls with.
+var DefaultRetry = Retry{
+	Attempts: 3,
+	Backoff:  time.Second,
+	Idempotent: []string{
+		"Pinger.Ping",
+		"Runner.Request",
+		"Tracker.Extend",
+		"Tracker.Heartbeat",
+		"Tracker.Release",
+		"Tracker.Remove",
+	},
+}
+
+//allows returns if the method can be retried under the policy.
+func (r Retry) allows(method string) bool {
+	for _, m := range r.Idempotent {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
 //Client represents an RPC client that can be used to make requests to an rpc
 //service.
 type Client struct {
-	path   string
-	codec  Codec
-	client *http.Client
+	path    string
+	codec   Codec
+	client  *http.Client
+	timeout time.Duration
+	retry   Retry
+}
+
+//defaultClient is the http.Client returned by Default.
+var defaultClient = http.DefaultClient
+
+//Default returns the http.Client that rpc calls should be made with. It is
+//http.DefaultClient unless SetTLS has been called.
+func Default() *http.Client {
+	return defaultClient
+}
+
+//SetTLS makes Default return a client that uses the tls config for https
+//requests, so that it can present a certificate to services that verify their
+//peers. A nil config goes back to http.DefaultClient. It should be called
+//before any requests are made.
+func SetTLS(config *tls.Config) {
+	if config == nil {
+		defaultClient = http.DefaultClient
+		return
+	}
+	defaultClient = TLS(config)
+}
+
+//TLS returns an http.Client that uses the tls config for https requests.
+func TLS(config *tls.Config) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: config,
+		},
+	}
 }
 
 //New returns a new Client to handle requests to the service at the
 //location specified by path. The codec is used to encode and decode the requests
-//performed by the given client.
+//performed by the given client. Calls are given DefaultTimeout and retried with
+//DefaultRetry unless changed with SetTimeout and SetRetry.
 func New(path string, client *http.Client, codec Codec) *Client {
 	return &Client{
-		path:   path,
-		codec:  codec,
-		client: client,
+		path:    path,
+		codec:   codec,
+		client:  client,
+		timeout: DefaultTimeout,
+		retry:   DefaultRetry,
 	}
 }
 
+//SetTimeout sets how long calls are given if their context has no deadline. A
+//zero timeout lets them run until the context is canceled.
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+//SetRetry sets the policy for retrying calls that fail in transport.
+func (c *Client) SetRetry(retry Retry) {
+	c.retry = retry
+}
+
 //Call invokes the named method, waits for it to complete, and returns the results.
-func (c *Client) Call(method string, args interface{}, reply interface{}) (err error) {
-	//encode our request into a buffer
+func (c *Client) Call(method string, args interface{}, reply interface{}) error {
+	return c.CallContext(context.Background(), method, args, reply)
+}
+
+//CallContext invokes the named method, waits for it to complete or the context
+//to be done, and returns the results. Errors from the service are returned as
+//an rpc.Error, a response other than 200 OK as a *StatusError and anything
+//that kept us from reading a response as a *TransportError. Idempotent methods
+//are retried under the retry policy of the Client when that happens.
+func (c *Client) CallContext(ctx context.Context, method string, args interface{}, reply interface{}) (err error) {
+	if _, ok := ctx.Deadline(); !ok && c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	//encode our request once for every attempt
 	buf, err := c.codec.EncodeRequest(method, args)
 	if err != nil {
 		return
 	}
-	body := bytes.NewReader(buf)
 
+	start := time.Now()
+	attempts, backoff := 1, c.retry.Backoff
+	defer func() { record(method, time.Since(start), attempts, err) }()
+
+	for {
+		err = c.do(ctx, method, buf, reply)
+		if !retryable(err) || !c.retry.allows(method) || attempts >= c.retry.Attempts {
+			return
+		}
+
+		//wait to try again unless we run out of time first
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		attempts++
+		backoff *= 2
+	}
+}
+
+//do makes a single attempt at the call with the encoded request.
+func (c *Client) do(ctx context.Context, method string, buf []byte, reply interface{}) (err error) {
 	//create the post request for the client
-	req, err := http.NewRequest("POST", c.path, body)
+	req, err := http.NewRequestWithContext(ctx, "POST", c.path, bytes.NewReader(buf))
 	if err != nil {
 		return
 	}
-
-	//dev_appserver hack. It doesn't handle chunked transfer encoding.
-	req.TransferEncoding = []string{"identity"}
-	req.ContentLength = int64(len(buf))
-
-	//nonhacks
 	req.Header.Add("Content-Type", c.codec.ContentType())
-	req.Close = true
 
 	//invoke the method
 	resp, err := c.client.Do(req)
 	if err != nil {
+		//report running out of time plainly instead of how it interrupted us
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		err = &TransportError{Method: method, Err: err}
 		return
 	}
 	defer resp.Body.Close()
 
+	//read the whole body so the connection can be reused
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		err = &TransportError{Method: method, Err: err}
+		return
+	}
+
 	//make sure we got an ok response, or copy an error out
 	if resp.StatusCode != http.StatusOK {
-		var body bytes.Buffer
-		io.Copy(&body, resp.Body)
-		err = errors.New(body.String())
+		err = &StatusError{
+			Method: method,
+			Code:   resp.StatusCode,
+			Body:   string(body),
+		}
 		return
 	}
 
-	//read back the response
-	err = c.codec.DecodeResponse(resp.Body, reply)
+	//read back the response. errors from the service are passed through but a
+	//response we can't make sense of is treated like one we never got
+	err = c.codec.DecodeResponse(bytes.NewReader(body), reply)
+	if _, ok := err.(rpc.Error); err != nil && !ok {
+		err = &TransportError{Method: method, Err: err}
+	}
 	return
 }
@@ -2,9 +2,15 @@ package client
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"github.com/zeebo/goci/app/rpc"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 //tripper is a RoundTripper that returns the given response and error for every
@@ -70,3 +76,123 @@ func TestInvalidResponse(t *testing.T) {
 		t.Fatalf("Expected %q. Got %q", string(res), err.Error())
 	}
 }
+
+//flaky is a RoundTripper that fails the first n requests and then returns the
+//response.
+type flaky struct {
+	n     int
+	calls int
+	res   string
+}
+
+func (f *flaky) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.calls++
+	if f.calls <= f.n {
+		return nil, errors.New("connection reset")
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader(f.res)),
+	}, nil
+}
+
+func TestServerError(t *testing.T) {
+	res := []byte(`{"result":null,"error":"bad args","id":0}`)
+	h := given_response(&http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(bytes.NewReader(res)),
+	}, nil)
+	cl := New("http://localhost/", h, JsonCodec)
+
+	var x int
+	err := cl.Call("Foo.Bar", 0, &x)
+	if err != rpc.Error("bad args") {
+		t.Fatalf("Expected rpc.Error %q. Got %#v", "bad args", err)
+	}
+}
+
+func TestTransportError(t *testing.T) {
+	h := given_response(nil, errors.New("connection refused"))
+	cl := New("http://localhost/", h, JsonCodec)
+
+	var x int
+	err := cl.Call("Foo.Bar", 0, &x)
+	if _, ok := err.(*TransportError); !ok {
+		t.Fatalf("Expected a *TransportError. Got %#v", err)
+	}
+}
+
+func TestRetryIdempotent(t *testing.T) {
+	f := &flaky{n: 2, res: `{"result":2,"error":null,"id":0}`}
+	cl := New("http://localhost/", &http.Client{Transport: f}, JsonCodec)
+	cl.SetRetry(Retry{
+		Attempts:   3,
+		Backoff:    time.Millisecond,
+		Idempotent: []string{"Foo.Get"},
+	})
+
+	var x int
+	if err := cl.Call("Foo.Get", 0, &x); err != nil {
+		t.Fatal(err)
+	}
+	if x != 2 || f.calls != 3 {
+		t.Fatalf("Expected 2 after 3 calls. Got %d after %d", x, f.calls)
+	}
+
+	//methods that aren't idempotent are only called once
+	f.calls = 0
+	if err := cl.Call("Foo.Set", 0, &x); err == nil {
+		t.Fatal("expected error")
+	}
+	if f.calls != 1 {
+		t.Fatalf("Expected 1 call. Got %d", f.calls)
+	}
+}
+
+func TestCallContextDeadline(t *testing.T) {
+	done := make(chan bool)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-done
+	}))
+	defer s.Close()
+	defer close(done)
+	cl := New(s.URL, http.DefaultClient, JsonCodec)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	var x int
+	err := cl.CallContext(ctx, "Pinger.Ping", 0, &x)
+	te, ok := err.(*TransportError)
+	if !ok || !te.Timeout() {
+		t.Fatalf("Expected a timeout *TransportError. Got %#v", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	//start from nothing so the counts hold when the test runs more than once
+	stats.Lock()
+	stats.methods = map[string]MethodStats{}
+	stats.Unlock()
+
+	res := `{"result":2,"error":null,"id":0}`
+	f := &flaky{n: 1, res: res}
+	cl := New("http://localhost/", &http.Client{Transport: f}, JsonCodec)
+	cl.SetRetry(Retry{
+		Attempts:   2,
+		Idempotent: []string{"Stats.Get"},
+	})
+
+	var x int
+	for i := 0; i < 2; i++ {
+		cl.Call("Stats.Get", 0, &x)
+	}
+
+	m := Stats()["Stats.Get"]
+	if m.Calls != 2 || m.Retries != 1 || m.Errors != 0 {
+		t.Fatalf("Expected 2 calls, 1 retry and no errors. Got %+v", m)
+	}
+	if m.Max < m.Last || m.Mean() > m.Max {
+		t.Fatalf("Inconsistent latencies: %+v", m)
+	}
+}
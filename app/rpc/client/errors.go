@@ -0,0 +1,56 @@
+package client
+
+import (
+	"context"
+	"fmt"
+)
+
+//TransportError is returned when a call fails before a response is read from
+//the service, like when the service can't be reached or the deadline passes.
+//The service may or may not have handled the call.
+type TransportError struct {
+	Method string //the method that was called
+	Err    error  //the underlying error
+}
+
+func (t *TransportError) Error() string {
+	return fmt.Sprintf("calling %s: %s", t.Method, t.Err)
+}
+
+//Timeout returns if the call failed because its deadline passed.
+func (t *TransportError) Timeout() bool {
+	return t.Err == context.DeadlineExceeded || isTimeout(t.Err)
+}
+
+//isTimeout returns if the error says it is a timeout, like a net.Error does.
+func isTimeout(err error) bool {
+	t, ok := err.(interface {
+		Timeout() bool
+	})
+	return ok && t.Timeout()
+}
+
+//StatusError is returned when the service responds with a status other than
+//200 OK, like when the method doesn't exist or a proxy in front of the service
+//fails.
+type StatusError struct {
+	Method string //the method that was called
+	Code   int    //the status code of the response
+	Body   string //the body of the response
+}
+
+func (s *StatusError) Error() string {
+	return s.Body
+}
+
+//retryable returns if a call that failed with the error might succeed if it
+//is made again.
+func retryable(err error) bool {
+	switch err := err.(type) {
+	case *TransportError:
+		return err.Err != context.Canceled && err.Err != context.DeadlineExceeded
+	case *StatusError:
+		return err.Code >= 500
+	}
+	return false
+}
@@ -1,7 +1,11 @@
 package client
 
 import (
-	"github.com/gorilla/rpc/json"
+	"encoding/json"
+	"errors"
+	"fmt"
+	gjson "github.com/gorilla/rpc/json"
+	"github.com/zeebo/goci/app/rpc"
 	"io"
 )
 
@@ -10,11 +14,33 @@ import (
 type jsonCodec struct{}
 
 func (jsonCodec) EncodeRequest(method string, args interface{}) ([]byte, error) {
-	return json.EncodeClientRequest(method, args)
+	return gjson.EncodeClientRequest(method, args)
 }
 
-func (jsonCodec) DecodeResponse(r io.Reader, reply interface{}) error {
-	return json.DecodeClientResponse(r, reply)
+//jsonResponse is the response sent by services using the rpc/json codec.
+type jsonResponse struct {
+	Result *json.RawMessage `json:"result"`
+	Error  interface{}      `json:"error"`
+}
+
+//DecodeResponse decodes the response like the rpc/json package, but returns
+//errors from the service as an rpc.Error so they can be told apart from a
+//response that couldn't be decoded.
+func (jsonCodec) DecodeResponse(r io.Reader, reply interface{}) (err error) {
+	var resp jsonResponse
+	if err = json.NewDecoder(r).Decode(&resp); err != nil {
+		return
+	}
+	if resp.Error != nil {
+		err = rpc.Error(fmt.Sprint(resp.Error))
+		return
+	}
+	if resp.Result == nil {
+		err = errors.New("result is null")
+		return
+	}
+	err = json.Unmarshal(*resp.Result, reply)
+	return
 }
 
 func (jsonCodec) ContentType() string {
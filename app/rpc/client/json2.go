@@ -0,0 +1,75 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/app/rpc/jsonrpc"
+	"io"
+	"sync/atomic"
+)
+
+//json2Codec is a client codec for services using the JSON-RPC 2.0 server in
+//the jsonrpc package.
+type json2Codec struct{}
+
+//json2ID is the id of the last JSON-RPC 2.0 request.
+var json2ID uint64
+
+//json2Request is a JSON-RPC 2.0 request.
+type json2Request struct {
+	Version string         `json:"jsonrpc"`
+	Method  string         `json:"method"`
+	Params  [1]interface{} `json:"params"`
+	ID      uint64         `json:"id"`
+}
+
+//json2Response is a JSON-RPC 2.0 response.
+type json2Response struct {
+	Result *json.RawMessage `json:"result"`
+	Error  *jsonrpc.Error   `json:"error"`
+}
+
+func (json2Codec) EncodeRequest(method string, args interface{}) ([]byte, error) {
+	return json.Marshal(&json2Request{
+		Version: jsonrpc.Version,
+		Method:  method,
+		Params:  [1]interface{}{args},
+		ID:      atomic.AddUint64(&json2ID, 1),
+	})
+}
+
+//DecodeResponse decodes the response into the reply. Errors returned by the
+//service come back as the rpc.Error it returned, and other errors, like an
+//unknown method, as an rpc.Error with the code and message.
+func (json2Codec) DecodeResponse(r io.Reader, reply interface{}) (err error) {
+	var resp json2Response
+	if err = json.NewDecoder(r).Decode(&resp); err != nil {
+		return
+	}
+	if e := resp.Error; e != nil {
+		if data, ok := e.Data.(string); ok && e.Code == jsonrpc.CodeServerError {
+			err = rpc.Error(data)
+			return
+		}
+		err = rpc.Errorf("%s (%d)", e.Error(), e.Code)
+		return
+	}
+	if resp.Result == nil {
+		err = errors.New("result is null")
+		return
+	}
+	if err = json.Unmarshal(*resp.Result, reply); err != nil {
+		err = fmt.Errorf("decoding result: %s", err)
+	}
+	return
+}
+
+func (json2Codec) ContentType() string {
+	return "application/json"
+}
+
+//Json2Codec is a client Codec for interacting with services using the JSON-RPC
+//2.0 server in the jsonrpc package.
+var Json2Codec Codec = json2Codec{}
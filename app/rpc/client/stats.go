@@ -0,0 +1,62 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+//MethodStats are the metrics for the calls made to a method by every Client.
+type MethodStats struct {
+	Calls   int64         //the number of calls made
+	Retries int64         //the number of attempts made after the first
+	Errors  int64         //the number of calls that returned an error
+	Total   time.Duration //the time spent in calls, including retries
+	Max     time.Duration //the longest a call took
+	Last    time.Duration //how long the latest call took
+}
+
+//Mean returns the average time a call took.
+func (m MethodStats) Mean() time.Duration {
+	if m.Calls == 0 {
+		return 0
+	}
+	return m.Total / time.Duration(m.Calls)
+}
+
+//stats holds the metrics for every method called.
+var stats = struct {
+	sync.Mutex
+	methods map[string]MethodStats
+}{methods: map[string]MethodStats{}}
+
+//record adds a call to the metrics for the method.
+func record(method string, latency time.Duration, attempts int, err error) {
+	stats.Lock()
+	defer stats.Unlock()
+
+	m := stats.methods[method]
+	m.Calls++
+	m.Retries += int64(attempts - 1)
+	if err != nil {
+		m.Errors++
+	}
+	m.Total += latency
+	m.Last = latency
+	if latency > m.Max {
+		m.Max = latency
+	}
+	stats.methods[method] = m
+}
+
+//Stats returns a copy of the metrics for every method called, keyed by the
+//name of the method.
+func Stats() map[string]MethodStats {
+	stats.Lock()
+	defer stats.Unlock()
+
+	ret := make(map[string]MethodStats, len(stats.methods))
+	for name, m := range stats.methods {
+		ret[name] = m
+	}
+	return ret
+}
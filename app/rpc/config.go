@@ -12,4 +12,9 @@ type Config struct {
 	NotifyJabber string `json:",omitempty"` // a jabber address for an XMPP message
 	NotifyOn     string `json:",omitempty"` // one of: `pass`, `fail`, `error`, `wontbuild`, `problem`, `always`, `change`
 	NotifyURL    string `json:",omitempty"` // a URL that will be posted with the result data
+
+	//Requires lists labels the runner must have to run the test. It can only
+	//be honored when runners lease work, because pushed work has its runner
+	//picked before the config is loaded.
+	Requires []string `json:",omitempty"`
 }
@@ -0,0 +1,58 @@
+package rpc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+//Enrollment proves that a request was made by a worker holding an enrollment
+//token issued by an admin.
+type Enrollment struct {
+	Token     string    //the id of the enrollment token
+	Nonce     string    //random value so the tracker only accepts the signature once
+	Time      time.Time //when the request was signed
+	Signature string    //hex encoded HMAC-SHA256 of the request by the token secret
+}
+
+//Credential is issued by the tracker to an enrolled worker and must be sent
+//with every rpc the worker makes afterward.
+type Credential struct {
+	Key    string //the key the worker is stored under
+	Secret string //the secret only the worker and the tracker know
+}
+
+//String keeps the secret out of logs and errors.
+func (c Credential) String() string {
+	return fmt.Sprintf("{Key:%s Secret:%s}", c.Key, hide(c.Secret))
+}
+
+//Sign returns an Enrollment for a request made now with the given token, which
+//is in the form "id:secret" as it was shown when it was issued. The parts
+//identify the request so that the signature can't be used for another one, and
+//the random nonce lets the tracker refuse to see the same signature twice. An
+//empty token returns an empty Enrollment.
+func Sign(token string, parts ...string) (e Enrollment, err error) {
+	if token == "" {
+		return
+	}
+
+	i := strings.Index(token, ":")
+	if i < 0 {
+		err = Errorf("invalid enrollment token")
+		return
+	}
+
+	if e.Nonce, err = NewSecret(); err != nil {
+		return
+	}
+	e.Token, e.Time = token[:i], time.Now()
+	e.Signature = sign(token[i+1:], append([]string{e.Token, e.Nonce, stamp(e.Time)}, parts...)...)
+	return
+}
+
+//Verify reports if the Enrollment is a valid signature of the request
+//identified by parts by the given token secret.
+func (e Enrollment) Verify(secret string, parts ...string) bool {
+	return verify(secret, e.Signature, append([]string{e.Token, e.Nonce, stamp(e.Time)}, parts...)...)
+}
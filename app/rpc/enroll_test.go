@@ -0,0 +1,74 @@
+package rpc
+
+import (
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	e, err := Sign("abc:secret", "Builder", "http://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Token != "abc" {
+		t.Fatalf("Expected token abc. Got %q", e.Token)
+	}
+	if !e.Verify("secret", "Builder", "http://example.com") {
+		t.Fatal("Expected the signature to verify")
+	}
+	if e.Verify("wrong", "Builder", "http://example.com") {
+		t.Fatal("Expected the signature to fail with the wrong secret")
+	}
+	if e.Verify("secret", "Runner", "http://example.com") {
+		t.Fatal("Expected the signature to fail for another request")
+	}
+}
+
+func TestSignNonce(t *testing.T) {
+	e1, err := Sign("abc:secret", "Builder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e2, err := Sign("abc:secret", "Builder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e1.Nonce == "" || e1.Nonce == e2.Nonce {
+		t.Fatalf("Expected distinct nonces. Got %q and %q", e1.Nonce, e2.Nonce)
+	}
+	e1.Nonce = e2.Nonce
+	if e1.Verify("secret", "Builder") {
+		t.Fatal("Expected the signature to fail with another nonce")
+	}
+}
+
+func TestAnnounceParts(t *testing.T) {
+	args := &AnnounceArgs{
+		Type:         "Builder",
+		URL:          "http://example.com",
+		Capabilities: Capabilities{Labels: []string{"cgo"}},
+	}
+	e, err := Sign("abc:secret", args.Parts()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Verify("secret", args.Parts()...) {
+		t.Fatal("Expected the signature to verify")
+	}
+	args.Labels = append(args.Labels, "docker")
+	if e.Verify("secret", args.Parts()...) {
+		t.Fatal("Expected the signature to fail for other capabilities")
+	}
+}
+
+func TestSignEmpty(t *testing.T) {
+	e, err := Sign("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e != (Enrollment{}) {
+		t.Fatalf("Expected an empty enrollment. Got %+v", e)
+	}
+	if _, err := Sign("nocolon"); err == nil {
+		t.Fatal("Expected an error for an invalid token")
+	}
+}
@@ -0,0 +1,2 @@
+//package heartbeat keeps announced workers alive in the tracker
+package heartbeat
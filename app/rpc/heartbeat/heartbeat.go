@@ -0,0 +1,54 @@
+package heartbeat
+
+import (
+	"context"
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/app/rpc/client"
+	"log"
+	"time"
+)
+
+//Interval is how often workers send heartbeats to the tracker.
+const Interval = 30 * time.Second
+
+//Beat sends a heartbeat for the worker with the given kind and credential
+//every interval until stop is closed, reporting the load returned by load. If
+//the tracker has evicted the worker, announce is called to announce it again.
+func Beat(cl *client.Client, kind string, cred rpc.Credential, interval time.Duration, load func() int, announce func() error, stop <-chan bool) {
+	args := &rpc.HeartbeatArgs{
+		Credential: cred,
+		Kind:       kind,
+	}
+	for {
+		select {
+		case <-time.After(interval):
+		case <-stop:
+			return
+		}
+
+		//a heartbeat that takes longer than the interval is as good as missed,
+		//but give it long enough that a slow tracker still hears from us
+		timeout := interval
+		if timeout < time.Second {
+			timeout = time.Second
+		}
+
+		args.Load = load()
+		rep := new(rpc.HeartbeatReply)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := cl.CallContext(ctx, "Tracker.Heartbeat", args, rep)
+		cancel()
+		if err != nil {
+			log.Printf("Error sending heartbeat: %s", err)
+			continue
+		}
+		if !rep.Evicted {
+			continue
+		}
+
+		log.Printf("%s %s was evicted. Announcing again.", kind, cred.Key)
+		if err := announce(); err != nil {
+			log.Printf("Error announcing again: %s", err)
+		}
+	}
+}
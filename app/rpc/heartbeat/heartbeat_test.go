@@ -0,0 +1,67 @@
+package heartbeat
+
+import (
+	gorpc "github.com/gorilla/rpc"
+	"github.com/gorilla/rpc/json"
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/app/rpc/client"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+//fakeTracker counts the heartbeats it gets and reports the worker as evicted
+//for the first one.
+type fakeTracker struct {
+	sync.Mutex
+	beats int
+}
+
+func (f *fakeTracker) Heartbeat(req *http.Request, args *rpc.HeartbeatArgs, rep *rpc.HeartbeatReply) (err error) {
+	f.Lock()
+	defer f.Unlock()
+	f.beats++
+	rep.Evicted = f.beats == 1
+	return
+}
+
+func TestBeatReannounce(t *testing.T) {
+	f := new(fakeTracker)
+	s := gorpc.NewServer()
+	s.RegisterCodec(json.NewCodec(), "application/json")
+	if err := s.RegisterService(f, "Tracker"); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	cl := client.New(srv.URL, http.DefaultClient, client.JsonCodec)
+
+	announced := make(chan bool, 10)
+	announce := func() error {
+		announced <- true
+		return nil
+	}
+
+	stop := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		Beat(cl, "Runner", rpc.Credential{Key: "key"}, time.Millisecond, func() int { return 0 }, announce, stop)
+		close(done)
+	}()
+
+	<-announced
+	<-time.After(50 * time.Millisecond)
+	close(stop)
+	<-done
+
+	if len(announced) != 0 {
+		t.Fatalf("Expected 1 announce. Got %d", len(announced)+1)
+	}
+	f.Lock()
+	defer f.Unlock()
+	if f.beats < 2 {
+		t.Fatalf("Expected heartbeats to continue after announcing. Got %d", f.beats)
+	}
+}
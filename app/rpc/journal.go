@@ -0,0 +1,189 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+//compactAfter is the number of pops a journal records before it is considered
+//for compaction.
+const compactAfter = 100
+
+//journal is an append only log of queue operations that can be replayed to
+//recover the items that were in the queue.
+type journal struct {
+	path string
+	f    *os.File
+	pops int //number of pops recorded since the last compaction
+
+	//record returns what is written for an item, so that things like
+	//credentials can be kept off of the disk. nil writes the item.
+	record func(interface{}) interface{}
+}
+
+//recorded returns what is written to the journal for the item.
+func (j *journal) recorded(item interface{}) interface{} {
+	if j.record == nil {
+		return item
+	}
+	return j.record(item)
+}
+
+//journalEntry is a single operation in the journal. If Pop is false, the entry
+//is a push of Item.
+type journalEntry struct {
+	Pop  bool            `json:",omitempty"`
+	Item json.RawMessage `json:",omitempty"`
+}
+
+//openJournal opens the journal at path, creating it if it doesn't exist, and
+//replays it to find the items left in the queue. A partially written entry at
+//the end, like from a crash during a write, is discarded.
+func openJournal(path string, decode func([]byte) (interface{}, error)) (j *journal, items []interface{}, err error) {
+	f, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+		err = nil
+	case err != nil:
+		return
+	default:
+		items, err = replay(f, decode)
+		f.Close()
+		if err != nil {
+			return
+		}
+	}
+
+	//start with a fresh journal of just the pending items
+	j = &journal{path: path}
+	err = j.compact(items)
+	return
+}
+
+//replay reads the entries in the journal and returns the items that are still
+//in the queue. Only the last line can be a torn write, so an entry that can't
+//be read anywhere else is an error.
+func replay(r io.Reader, decode func([]byte) (interface{}, error)) (items []interface{}, err error) {
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		buf, rerr := br.ReadBytes('\n')
+		if rerr != nil && rerr != io.EOF {
+			err = rerr
+			return
+		}
+		last := rerr == io.EOF
+		if !last {
+			_, perr := br.Peek(1)
+			last = perr == io.EOF
+		}
+
+		if len(bytes.TrimSpace(buf)) > 0 {
+			var e journalEntry
+			if er := json.Unmarshal(buf, &e); er != nil {
+				if !last {
+					err = fmt.Errorf("journal entry on line %d: %v", line, er)
+				}
+				return
+			}
+
+			if e.Pop {
+				if len(items) > 0 {
+					items = items[1:]
+				}
+			} else {
+				var item interface{}
+				if item, err = decode(e.Item); err != nil {
+					return
+				}
+				items = append(items, item)
+			}
+		}
+
+		if last {
+			return
+		}
+	}
+}
+
+//write appends the entry to the journal and makes sure it is on disk.
+func (j *journal) write(e journalEntry) (err error) {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if _, err = j.f.Write(append(buf, '\n')); err != nil {
+		return
+	}
+	err = j.f.Sync()
+	return
+}
+
+//push records an item being added to the queue.
+func (j *journal) push(item interface{}) (err error) {
+	buf, err := json.Marshal(j.recorded(item))
+	if err != nil {
+		return
+	}
+	err = j.write(journalEntry{Item: buf})
+	return
+}
+
+//pop records an item being removed from the queue. The remaining items are
+//used to compact the journal if enough pops have been recorded.
+func (j *journal) pop(remaining []interface{}) (err error) {
+	if err = j.write(journalEntry{Pop: true}); err != nil {
+		return
+	}
+	if j.pops++; j.pops >= compactAfter && j.pops > len(remaining) {
+		err = j.compact(remaining)
+	}
+	return
+}
+
+//compact rewrites the journal to contain only pushes of the given items and
+//opens it for appending.
+func (j *journal) compact(items []interface{}) (err error) {
+	tmp := j.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return
+	}
+
+	//write all the items into the temporary journal
+	enc := json.NewEncoder(f)
+	for _, item := range items {
+		var buf []byte
+		if buf, err = json.Marshal(j.recorded(item)); err != nil {
+			f.Close()
+			return
+		}
+		if err = enc.Encode(journalEntry{Item: buf}); err != nil {
+			f.Close()
+			return
+		}
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+
+	//swap it in place of the old journal
+	if j.f != nil {
+		j.f.Close()
+		j.f = nil
+	}
+	if err = os.Rename(tmp, j.path); err != nil {
+		return
+	}
+
+	j.f, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0666)
+	j.pops = 0
+	return
+}
@@ -0,0 +1,355 @@
+package jsonrpc
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+//None is an rpc.None inlined so we dont have the dependency.
+type None struct{}
+
+//Description describes the services registered on a Server.
+type Description struct {
+	Services []ServiceDescription
+}
+
+//ServiceDescription describes a registered service and its methods.
+type ServiceDescription struct {
+	Name    string
+	Methods []MethodDescription
+}
+
+//MethodDescription describes a method with the schemas of its args and reply.
+type MethodDescription struct {
+	Name  string //the name the method is called with, like "Tracker.Announce"
+	Args  *Schema
+	Reply *Schema
+}
+
+//Method returns the description of the named method and if it exists.
+func (d Description) Method(name string) (m MethodDescription, ok bool) {
+	for _, s := range d.Services {
+		for _, m = range s.Methods {
+			if m.Name == name {
+				return m, true
+			}
+		}
+	}
+	return MethodDescription{}, false
+}
+
+//Schema is a description of the json a Go type encodes to, loosely following
+//JSON schema. Name is the Go type when it has one.
+type Schema struct {
+	Type       string             `json:"type"` //object, array, string, integer, number, boolean or any
+	Name       string             `json:"name,omitempty"`
+	Format     string             `json:"format,omitempty"` //date-time for times, byte for base64 data
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Items      *Schema            `json:"items,omitempty"`                //the elements of an array
+	Values     *Schema            `json:"additionalProperties,omitempty"` //the values of a map
+}
+
+var typeOfTime = reflect.TypeOf(time.Time{})
+
+//SchemaOf returns the schema for the json encoding of the type.
+func SchemaOf(t reflect.Type) *Schema {
+	return schemaOf(t, map[reflect.Type]bool{})
+}
+
+//schemaOf returns the schema of the type. Structs already being described
+//higher up are only given by name so recursive types terminate.
+func schemaOf(t reflect.Type, seen map[reflect.Type]bool) (s *Schema) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	s = &Schema{}
+	if t.Name() != "" {
+		s.Name = t.String()
+	}
+
+	if t == typeOfTime {
+		s.Type, s.Format = "string", "date-time"
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		s.Type = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s.Type = "integer"
+	case reflect.Float32, reflect.Float64:
+		s.Type = "number"
+	case reflect.String:
+		s.Type = "string"
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			s.Type, s.Format = "string", "byte"
+			return
+		}
+		s.Type = "array"
+		s.Items = schemaOf(t.Elem(), seen)
+	case reflect.Map:
+		s.Type = "object"
+		s.Values = schemaOf(t.Elem(), seen)
+	case reflect.Struct:
+		s.Type = "object"
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		s.Properties = map[string]*Schema{}
+		addFields(s.Properties, t, seen)
+	default:
+		s.Type = "any"
+	}
+	return
+}
+
+//addFields adds the fields of the struct to props the way encoding/json
+//encodes them: unexported and "-" fields are skipped, tags rename fields and
+//embedded structs without a tag have their fields promoted.
+func addFields(props map[string]*Schema, t reflect.Type, seen map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			addFields(props, ft, seen)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = schemaOf(f.Type, seen)
+	}
+}
+
+//property returns the schema of the named property, matching names without
+//regard to case like encoding/json does.
+func (s *Schema) property(name string) (p *Schema, ok bool) {
+	if p, ok = s.Properties[name]; ok {
+		return
+	}
+	for key, p := range s.Properties {
+		if strings.EqualFold(key, name) {
+			return p, true
+		}
+	}
+	return
+}
+
+//Validate checks that the value, as decoded by encoding/json into an
+//interface{}, decodes into the type the schema describes.
+func (s *Schema) Validate(v interface{}) error {
+	return s.validate("args", v)
+}
+
+func (s *Schema) validate(path string, v interface{}) (err error) {
+	//null leaves the zero value
+	if v == nil {
+		return
+	}
+
+	mismatch := func() error {
+		return fmt.Errorf("%s: expected %s, got %v", path, s.Type, v)
+	}
+
+	switch s.Type {
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			err = mismatch()
+		}
+	case "integer":
+		if f, ok := v.(float64); !ok || f != float64(int64(f)) {
+			err = mismatch()
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			err = mismatch()
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			err = mismatch()
+			return
+		}
+		if s.Format == "date-time" {
+			if _, perr := time.Parse(time.RFC3339Nano, str); perr != nil {
+				err = fmt.Errorf("%s: expected an RFC 3339 time, got %q", path, str)
+			}
+		}
+	case "array":
+		list, ok := v.([]interface{})
+		if !ok {
+			err = mismatch()
+			return
+		}
+		for i, item := range list {
+			if err = s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return
+			}
+		}
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			err = mismatch()
+			return
+		}
+		for _, key := range sortedKeys(obj) {
+			var p *Schema
+			switch {
+			case s.Values != nil:
+				p = s.Values
+			case s.Properties != nil:
+				if p, ok = s.property(key); !ok {
+					err = fmt.Errorf("%s: unknown field %q", path, key)
+					return
+				}
+			default:
+				//a recursive reference we didn't describe
+				continue
+			}
+			if err = p.validate(path+"."+key, obj[key]); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+//Zero returns the json value of the zero value of the type, with every field
+//of a struct filled in.
+func (s *Schema) Zero() interface{} {
+	switch s.Type {
+	case "boolean":
+		return false
+	case "integer", "number":
+		return 0
+	case "string":
+		if s.Format == "date-time" {
+			return time.Time{}.Format(time.RFC3339)
+		}
+		return ""
+	case "array":
+		return []interface{}{}
+	case "object":
+		obj := map[string]interface{}{}
+		for key, p := range s.Properties {
+			obj[key] = p.Zero()
+		}
+		return obj
+	}
+	return nil
+}
+
+//Complete returns the value with any fields of structs that were left out
+//filled in with their zero values, so the full shape of the args can be seen.
+func (s *Schema) Complete(v interface{}) interface{} {
+	if v == nil {
+		return s.Zero()
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok || s.Type != "object" || s.Properties == nil {
+		return v
+	}
+
+	out := map[string]interface{}{}
+	for key, val := range obj {
+		if p, ok := s.property(key); ok && val != nil {
+			val = p.Complete(val)
+		}
+		out[key] = val
+	}
+	for key, p := range s.Properties {
+		if !hasKey(obj, key) {
+			out[key] = p.Zero()
+		}
+	}
+	return out
+}
+
+//hasKey returns if the object has the key, without regard to case.
+func hasKey(obj map[string]interface{}, key string) bool {
+	for k := range obj {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+//sortedKeys returns the keys of the map in sorted order.
+func sortedKeys(m map[string]interface{}) (keys []string) {
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return
+}
+
+//describe returns the description of every service in the map.
+func (m *serviceMap) describe() (d Description) {
+	m.RLock()
+	defer m.RUnlock()
+
+	for name, s := range m.services {
+		sd := ServiceDescription{Name: name}
+		for mname, meth := range s.methods {
+			sd.Methods = append(sd.Methods, MethodDescription{
+				Name:  name + "." + mname,
+				Args:  SchemaOf(meth.args),
+				Reply: SchemaOf(meth.reply),
+			})
+		}
+		sort.Sort(byMethod(sd.Methods))
+		d.Services = append(d.Services, sd)
+	}
+	sort.Sort(byService(d.Services))
+	return
+}
+
+type byService []ServiceDescription
+
+func (b byService) Len() int           { return len(b) }
+func (b byService) Less(i, j int) bool { return b[i].Name < b[j].Name }
+func (b byService) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+type byMethod []MethodDescription
+
+func (b byMethod) Len() int           { return len(b) }
+func (b byMethod) Less(i, j int) bool { return b[i].Name < b[j].Name }
+func (b byMethod) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+//System is the service every Server has for describing itself.
+type System struct {
+	services *serviceMap
+}
+
+//Describe returns the services registered on the server, including System,
+//with their methods and the schemas of their args and replies.
+func (s System) Describe(req *http.Request, args *None, rep *Description) (err error) {
+	*rep = s.services.describe()
+	return
+}
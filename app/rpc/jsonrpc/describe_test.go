@@ -0,0 +1,138 @@
+package jsonrpc_test
+
+import (
+	"encoding/json"
+	"github.com/zeebo/goci/app/rpc/client"
+	"github.com/zeebo/goci/app/rpc/jsonrpc"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type Embedded struct {
+	Labels []string
+}
+
+type Tree struct {
+	Embedded
+	Name     string    `json:"name"`
+	Hidden   string    `json:"-"`
+	When     time.Time //a time
+	Data     []byte
+	Children []*Tree
+	Extra    map[string]int
+	private  int
+}
+
+func TestSchemaOf(t *testing.T) {
+	s := jsonrpc.SchemaOf(reflect.TypeOf(&Tree{}))
+	if s.Type != "object" || s.Name != "jsonrpc_test.Tree" {
+		t.Fatalf("Expected a jsonrpc_test.Tree object. Got %+v", s)
+	}
+
+	exp := map[string]string{
+		"Labels":   "array",
+		"name":     "string",
+		"When":     "string",
+		"Data":     "string",
+		"Children": "array",
+		"Extra":    "object",
+	}
+	if len(s.Properties) != len(exp) {
+		t.Fatalf("Expected properties %v. Got %v", exp, s.Properties)
+	}
+	for name, typ := range exp {
+		if p := s.Properties[name]; p == nil || p.Type != typ {
+			t.Errorf("Expected %s to be a %s. Got %+v", name, typ, p)
+		}
+	}
+	if f := s.Properties["When"].Format; f != "date-time" {
+		t.Errorf("Expected a date-time. Got %q", f)
+	}
+
+	//the recursive reference is only named
+	if c := s.Properties["Children"].Items; c.Name != "jsonrpc_test.Tree" || c.Properties != nil {
+		t.Errorf("Expected a reference to jsonrpc_test.Tree. Got %+v", c)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s := jsonrpc.SchemaOf(reflect.TypeOf(Tree{}))
+
+	cases := []struct {
+		args  string
+		valid bool
+	}{
+		{`null`, true},
+		{`{}`, true},
+		{`{"NAME":"x","labels":["a"],"Extra":{"a":1}}`, true},
+		{`{"When":"2012-10-01T10:00:00Z"}`, true},
+		{`{"Children":[{"name":"y","Children":[{"anything":1}]}]}`, true},
+		{`[]`, false},
+		{`{"Bogus":1}`, false},
+		{`{"Hidden":"x"}`, false},
+		{`{"name":1}`, false},
+		{`{"Labels":[1]}`, false},
+		{`{"Extra":{"a":1.5}}`, false},
+		{`{"When":"yesterday"}`, false},
+	}
+
+	for _, c := range cases {
+		var v interface{}
+		if err := json.Unmarshal([]byte(c.args), &v); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Validate(v); (err == nil) != c.valid {
+			t.Errorf("%s: Expected valid %v. Got %v", c.args, c.valid, err)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	s := jsonrpc.SchemaOf(reflect.TypeOf(Tree{}))
+
+	var v interface{}
+	if err := json.Unmarshal([]byte(`{"NAME":"x"}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	out, err := json.Marshal(s.Complete(v))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `{"Children":[],"Data":"","Extra":{},"Labels":[],"NAME":"x","When":"0001-01-01T00:00:00Z"}`
+	if string(out) != exp {
+		t.Fatalf("Expected %s. Got %s", exp, out)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	s, _ := newServer(t)
+	defer s.Close()
+
+	cl := client.New(s.URL, http.DefaultClient, client.Json2Codec)
+	var desc jsonrpc.Description
+	if err := cl.Call("System.Describe", nil, &desc); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, s := range desc.Services {
+		names = append(names, s.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"Arith", "System"}) {
+		t.Fatalf("Expected Arith and System. Got %v", names)
+	}
+
+	m, ok := desc.Method("Arith.Add")
+	if !ok {
+		t.Fatal("Arith.Add wasn't described")
+	}
+	if m.Args.Name != "jsonrpc_test.Args" || m.Reply.Type != "integer" {
+		t.Fatalf("Expected Args and an integer reply. Got %+v %+v", m.Args, m.Reply)
+	}
+	if _, ok := desc.Method("Arith.NotRPC"); ok {
+		t.Fatal("Arith.NotRPC shouldn't be described")
+	}
+}
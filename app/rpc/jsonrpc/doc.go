@@ -0,0 +1,15 @@
+//package jsonrpc is a JSON-RPC 2.0 server for the rpc services
+/*
+Services are registered the same way as with gorilla/rpc: every exported method
+of the form
+
+	func (T) Method(req *http.Request, args *Args, reply *Reply) error
+
+is served as "T.Method". The server speaks JSON-RPC 2.0, including batches and
+notifications, and answers requests from the older gorilla/rpc json clients in
+the format they expect so both can be used while services migrate.
+
+Every server also has a System service whose Describe method lists the
+registered services, their methods and the schemas of their args and replies.
+*/
+package jsonrpc
@@ -0,0 +1,316 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+)
+
+//Version is the version of JSON-RPC the server speaks.
+const Version = "2.0"
+
+//The error codes defined by the JSON-RPC 2.0 spec. Errors returned by the
+//services use CodeServerError.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	CodeServerError    = -32000
+)
+
+//messages are the messages sent with each error code.
+var messages = map[int]string{
+	CodeParseError:     "Parse error",
+	CodeInvalidRequest: "Invalid Request",
+	CodeMethodNotFound: "Method not found",
+	CodeInvalidParams:  "Invalid params",
+	CodeInternalError:  "Internal error",
+	CodeServerError:    "Server error",
+}
+
+//Error is the error object of a JSON-RPC 2.0 response. Data has the details of
+//the error, like the error returned by a service.
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+//Error implements the error interface.
+func (e *Error) Error() string {
+	if e.Data == nil {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %v", e.Message, e.Data)
+}
+
+//newError returns an Error with the standard message for the code.
+func newError(code int, data interface{}) *Error {
+	return &Error{
+		Code:    code,
+		Message: messages[code],
+		Data:    data,
+	}
+}
+
+//response is a JSON-RPC 2.0 response.
+type response struct {
+	Version string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+//responseV1 is a response in the format gorilla/rpc json clients expect.
+type responseV1 struct {
+	Result interface{}     `json:"result"`
+	Error  interface{}     `json:"error"`
+	ID     json.RawMessage `json:"id"`
+}
+
+//Server serves registered services over JSON-RPC 2.0.
+type Server struct {
+	services serviceMap
+}
+
+//NewServer returns a new Server with only the System service, which describes
+//the services registered on the server.
+func NewServer() *Server {
+	s := &Server{}
+	if err := s.RegisterService(System{&s.services}, ""); err != nil {
+		panic(err)
+	}
+	return s
+}
+
+//RegisterService adds the methods of the receiver to the server as
+//"name.Method". If name is empty, the name of the type of the receiver is used.
+//Methods that don't have the right signature are skipped, and it is an error
+//if there aren't any that do.
+func (s *Server) RegisterService(rcvr interface{}, name string) error {
+	return s.services.register(rcvr, name)
+}
+
+//call invokes the named method with the params and returns the reply. A panic
+//in the method is returned as an internal error instead of taking down the rest
+//of a batch.
+func (s *Server) call(req *http.Request, name string, params json.RawMessage) (reply interface{}, e *Error) {
+	svc, meth, ok := s.services.get(name)
+	if !ok {
+		e = newError(CodeMethodNotFound, name)
+		return
+	}
+
+	args := reflect.New(meth.args)
+	if len(params) > 0 {
+		if err := json.Unmarshal(params, args.Interface()); err != nil {
+			e = newError(CodeInvalidParams, err.Error())
+			return
+		}
+	}
+	rep := reflect.New(meth.reply)
+
+	defer func() {
+		if r := recover(); r != nil {
+			reply, e = nil, newError(CodeInternalError, fmt.Sprint(r))
+		}
+	}()
+
+	out := meth.fn.Func.Call([]reflect.Value{svc.rcvr, reflect.ValueOf(req), args, rep})
+	if err, _ := out[0].Interface().(error); err != nil {
+		e = newError(CodeServerError, err.Error())
+		return
+	}
+	reply = rep.Interface()
+	return
+}
+
+//unwrap returns the args in the params. Params are either an object with the
+//args or an array with them as the only element, like gorilla/rpc sends.
+func unwrap(params json.RawMessage) (args json.RawMessage, e *Error) {
+	params = bytes.TrimSpace(params)
+	if len(params) == 0 || params[0] != '[' {
+		args = params
+		return
+	}
+
+	var list []json.RawMessage
+	if err := json.Unmarshal(params, &list); err != nil {
+		e = newError(CodeInvalidParams, err.Error())
+		return
+	}
+	switch len(list) {
+	case 0:
+	case 1:
+		args = list[0]
+	default:
+		e = newError(CodeInvalidParams, "expected at most one parameter")
+	}
+	return
+}
+
+//handle performs a single JSON-RPC 2.0 request. It returns nil if the request
+//was a notification.
+func (s *Server) handle(req *http.Request, raw json.RawMessage) *response {
+	resp := &response{Version: Version}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		resp.Error = newError(CodeInvalidRequest, err.Error())
+		return resp
+	}
+
+	//a request without an id is a notification and gets no response, even if
+	//it fails
+	id, hasID := fields["id"]
+	resp.ID = id
+
+	var version, name string
+	if err := json.Unmarshal(fields["jsonrpc"], &version); err != nil || version != Version {
+		resp.Error = newError(CodeInvalidRequest, `jsonrpc must be "2.0"`)
+		return resp
+	}
+	if err := json.Unmarshal(fields["method"], &name); err != nil || name == "" {
+		resp.Error = newError(CodeInvalidRequest, "method must be a string")
+		return resp
+	}
+
+	args, e := unwrap(fields["params"])
+	if e == nil {
+		resp.Result, e = s.call(req, name, args)
+	}
+	resp.Error = e
+
+	if !hasID {
+		return nil
+	}
+	return resp
+}
+
+//handleV1 performs a request from a gorilla/rpc json client and writes the
+//response in the format it expects.
+func (s *Server) handleV1(w http.ResponseWriter, req *http.Request, fields map[string]json.RawMessage) {
+	resp := &responseV1{ID: fields["id"]}
+	if resp.ID == nil {
+		resp.ID = json.RawMessage("null")
+	}
+
+	//gorilla/rpc uses a bad request for errors finding the method
+	status := http.StatusOK
+	var name string
+	args, e := unwrap(fields["params"])
+	if err := json.Unmarshal(fields["method"], &name); err != nil {
+		e = newError(CodeInvalidRequest, "method must be a string")
+	}
+	if e == nil {
+		resp.Result, e = s.call(req, name, args)
+	}
+	if e != nil {
+		resp.Error = e.Error()
+		if e.Code == CodeServerError {
+			resp.Error = e.Data
+		} else {
+			status = http.StatusBadRequest
+		}
+	}
+
+	write(w, status, resp)
+}
+
+//write sends the value as json with the status code.
+func write(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+//ServeHTTP handles JSON-RPC 2.0 requests and batches posted to the server, as
+//well as requests from gorilla/rpc json clients.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "rpc: POST method required, received "+req.Method, http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, "rpc: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	body = bytes.TrimSpace(body)
+
+	//batches are only part of JSON-RPC 2.0
+	if len(body) > 0 && body[0] == '[' {
+		s.serveBatch(w, req, body)
+		return
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		write(w, http.StatusOK, &response{
+			Version: Version,
+			Error:   newError(CodeParseError, err.Error()),
+			ID:      json.RawMessage("null"),
+		})
+		return
+	}
+
+	//requests without a version are from gorilla/rpc clients
+	if _, ok := fields["jsonrpc"]; !ok {
+		s.handleV1(w, req, fields)
+		return
+	}
+
+	resp := s.handle(req, body)
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if resp.ID == nil {
+		resp.ID = json.RawMessage("null")
+	}
+	write(w, http.StatusOK, resp)
+}
+
+//serveBatch handles a batch of JSON-RPC 2.0 requests, responding with an array
+//of the responses for the requests that weren't notifications.
+func (s *Server) serveBatch(w http.ResponseWriter, req *http.Request, body []byte) {
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil {
+		write(w, http.StatusOK, &response{
+			Version: Version,
+			Error:   newError(CodeParseError, err.Error()),
+			ID:      json.RawMessage("null"),
+		})
+		return
+	}
+	if len(batch) == 0 {
+		write(w, http.StatusOK, &response{
+			Version: Version,
+			Error:   newError(CodeInvalidRequest, "empty batch"),
+			ID:      json.RawMessage("null"),
+		})
+		return
+	}
+
+	resps := []*response{}
+	for _, raw := range batch {
+		if resp := s.handle(req, raw); resp != nil {
+			if resp.ID == nil {
+				resp.ID = json.RawMessage("null")
+			}
+			resps = append(resps, resp)
+		}
+	}
+	if len(resps) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	write(w, http.StatusOK, resps)
+}
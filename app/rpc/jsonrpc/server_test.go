@@ -0,0 +1,250 @@
+package jsonrpc_test
+
+import (
+	"encoding/json"
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/app/rpc/client"
+	"github.com/zeebo/goci/app/rpc/jsonrpc"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type Args struct {
+	A, B int
+}
+
+//Arith is a service for testing.
+type Arith struct {
+	notified *int
+}
+
+func (Arith) Add(req *http.Request, args *Args, reply *int) error {
+	*reply = args.A + args.B
+	return nil
+}
+
+func (Arith) Div(req *http.Request, args *Args, reply *int) error {
+	if args.B == 0 {
+		return rpc.Error("divide by zero")
+	}
+	*reply = args.A / args.B
+	return nil
+}
+
+func (a Arith) Notify(req *http.Request, args *Args, reply *rpc.None) error {
+	*a.notified++
+	return nil
+}
+
+func (Arith) Panic(req *http.Request, args *Args, reply *int) error {
+	panic("oops")
+}
+
+//NotRPC doesn't have the right signature and isn't served.
+func (Arith) NotRPC(a int) int { return a }
+
+//newServer returns a test server serving an Arith and the count of
+//notifications it has received.
+func newServer(t *testing.T) (s *httptest.Server, notified *int) {
+	notified = new(int)
+	srv := jsonrpc.NewServer()
+	if err := srv.RegisterService(Arith{notified}, ""); err != nil {
+		t.Fatal(err)
+	}
+	s = httptest.NewServer(srv)
+	return
+}
+
+//post sends the body to the server and returns the status and body of the
+//response.
+func post(t *testing.T, url, body string) (status int, resp string) {
+	r, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	buf, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.StatusCode, strings.TrimSpace(string(buf))
+}
+
+//response is a decoded JSON-RPC 2.0 response.
+type response struct {
+	Version string
+	Result  interface{}
+	Error   *jsonrpc.Error
+	ID      interface{}
+}
+
+func TestRegister(t *testing.T) {
+	srv := jsonrpc.NewServer()
+	if err := srv.RegisterService(Arith{}, "Math"); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.RegisterService(Arith{}, "Math"); err == nil {
+		t.Fatal("expected an error registering twice")
+	}
+	if err := srv.RegisterService(new(int), "Int"); err == nil {
+		t.Fatal("expected an error registering a type without methods")
+	}
+}
+
+func TestClients(t *testing.T) {
+	s, _ := newServer(t)
+	defer s.Close()
+
+	//both versions of the client can talk to the server
+	for _, codec := range []client.Codec{client.JsonCodec, client.Json2Codec} {
+		cl := client.New(s.URL, http.DefaultClient, codec)
+
+		var x int
+		if err := cl.Call("Arith.Add", &Args{2, 3}, &x); err != nil {
+			t.Fatal(err)
+		}
+		if x != 5 {
+			t.Fatalf("Expected %d. Got %d", 5, x)
+		}
+
+		//errors from the service come back as they were returned
+		err := cl.Call("Arith.Div", &Args{2, 0}, &x)
+		if err != rpc.Error("divide by zero") {
+			t.Fatalf("Expected %q. Got %#v", "divide by zero", err)
+		}
+
+	}
+
+	//errors finding the method are a bad request for gorilla/rpc clients, like
+	//a gorilla/rpc server sends, and an rpc.Error for JSON-RPC 2.0 clients
+	var x int
+	cl := client.New(s.URL, http.DefaultClient, client.JsonCodec)
+	if err, ok := cl.Call("Arith.Sub", &Args{2, 3}, &x).(*client.StatusError); !ok || err.Code != http.StatusBadRequest {
+		t.Fatalf("Expected a bad request. Got %#v", err)
+	}
+	cl = client.New(s.URL, http.DefaultClient, client.Json2Codec)
+	if _, ok := cl.Call("Arith.Sub", &Args{2, 3}, &x).(rpc.Error); !ok {
+		t.Fatal("expected an rpc.Error for an unknown method")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	s, _ := newServer(t)
+	defer s.Close()
+
+	cases := []struct {
+		body string
+		code int
+	}{
+		{`{"jsonrpc":"2.0","method":"Arith.Sub","id":1}`, jsonrpc.CodeMethodNotFound},
+		{`{"jsonrpc":"2.0","method":"Arith.NotRPC","id":1}`, jsonrpc.CodeMethodNotFound},
+		{`{"jsonrpc":"2.0","method":"Arith.Add","params":"x","id":1}`, jsonrpc.CodeInvalidParams},
+		{`{"jsonrpc":"2.0","method":"Arith.Add","params":[{},{}],"id":1}`, jsonrpc.CodeInvalidParams},
+		{`{"jsonrpc":"1.0","method":"Arith.Add","id":1}`, jsonrpc.CodeInvalidRequest},
+		{`{"jsonrpc":"2.0","method":1,"id":1}`, jsonrpc.CodeInvalidRequest},
+		{`{"jsonrpc":"2.0","method":"Arith.Div","params":{"A":1},"id":1}`, jsonrpc.CodeServerError},
+		{`{"jsonrpc":"2.0","method":"Arith.Panic","id":1}`, jsonrpc.CodeInternalError},
+		{`{"jsonrpc":"2.0","method"`, jsonrpc.CodeParseError},
+		{`[]`, jsonrpc.CodeInvalidRequest},
+	}
+
+	for _, c := range cases {
+		_, body := post(t, s.URL, c.body)
+		var resp response
+		if err := json.Unmarshal([]byte(body), &resp); err != nil {
+			t.Fatalf("%s: %v", c.body, err)
+		}
+		if resp.Error == nil || resp.Error.Code != c.code {
+			t.Errorf("%s: Expected code %d. Got %s", c.body, c.code, body)
+		}
+		if resp.Result != nil {
+			t.Errorf("%s: Expected no result. Got %s", c.body, body)
+		}
+	}
+}
+
+func TestParams(t *testing.T) {
+	s, _ := newServer(t)
+	defer s.Close()
+
+	bodies := []string{
+		`{"jsonrpc":"2.0","method":"Arith.Add","params":{"A":2,"B":3},"id":"x"}`,
+		`{"jsonrpc":"2.0","method":"Arith.Add","params":[{"A":2,"B":3}],"id":"x"}`,
+	}
+	for _, body := range bodies {
+		_, got := post(t, s.URL, body)
+		if exp := `{"jsonrpc":"2.0","result":5,"id":"x"}`; got != exp {
+			t.Errorf("%s: Expected %s. Got %s", body, exp, got)
+		}
+	}
+}
+
+func TestNotification(t *testing.T) {
+	s, notified := newServer(t)
+	defer s.Close()
+
+	status, body := post(t, s.URL, `{"jsonrpc":"2.0","method":"Arith.Notify","params":{}}`)
+	if status != http.StatusNoContent || body != "" {
+		t.Fatalf("Expected no content. Got %d %q", status, body)
+	}
+	if *notified != 1 {
+		t.Fatalf("Expected 1 notification. Got %d", *notified)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	s, notified := newServer(t)
+	defer s.Close()
+
+	_, body := post(t, s.URL, `[
+		{"jsonrpc":"2.0","method":"Arith.Add","params":{"A":1,"B":1},"id":1},
+		{"jsonrpc":"2.0","method":"Arith.Notify","params":{}},
+		{"jsonrpc":"2.0","method":"Arith.Div","params":{"A":1,"B":0},"id":2},
+		1
+	]`)
+
+	var resps []response
+	if err := json.Unmarshal([]byte(body), &resps); err != nil {
+		t.Fatal(err)
+	}
+	if len(resps) != 3 {
+		t.Fatalf("Expected 3 responses. Got %s", body)
+	}
+	if resps[0].Result != 2.0 || resps[0].ID != 1.0 {
+		t.Errorf("Expected a result of 2 for id 1. Got %+v", resps[0])
+	}
+	if e := resps[1].Error; e == nil || e.Code != jsonrpc.CodeServerError || e.Data != "divide by zero" {
+		t.Errorf("Expected divide by zero for id 2. Got %+v", resps[1])
+	}
+	if e := resps[2].Error; e == nil || e.Code != jsonrpc.CodeInvalidRequest || resps[2].ID != nil {
+		t.Errorf("Expected an invalid request with a null id. Got %+v", resps[2])
+	}
+	if *notified != 1 {
+		t.Fatalf("Expected 1 notification. Got %d", *notified)
+	}
+
+	//a batch of notifications gets no response
+	status, body := post(t, s.URL, `[{"jsonrpc":"2.0","method":"Arith.Notify","params":{}}]`)
+	if status != http.StatusNoContent || body != "" {
+		t.Fatalf("Expected no content. Got %d %q", status, body)
+	}
+}
+
+func TestV1Errors(t *testing.T) {
+	s, _ := newServer(t)
+	defer s.Close()
+
+	//gorilla/rpc sends a bad request when the method isn't found
+	status, _ := post(t, s.URL, `{"method":"Arith.Sub","params":[{}],"id":1}`)
+	if status != http.StatusBadRequest {
+		t.Fatalf("Expected %d. Got %d", http.StatusBadRequest, status)
+	}
+
+	status, body := post(t, s.URL, `{"method":"Arith.Div","params":[{"A":1}],"id":1}`)
+	if exp := `{"result":null,"error":"divide by zero","id":1}`; status != http.StatusOK || body != exp {
+		t.Fatalf("Expected %d %s. Got %d %s", http.StatusOK, exp, status, body)
+	}
+}
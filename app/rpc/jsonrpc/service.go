@@ -0,0 +1,120 @@
+package jsonrpc
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfRequest = reflect.TypeOf((*http.Request)(nil))
+)
+
+//method is a method of a service able to be called.
+type method struct {
+	fn    reflect.Method
+	args  reflect.Type //the type args decode into, without the pointer
+	reply reflect.Type //the type of the reply, without the pointer
+}
+
+//service is a registered receiver and its methods.
+type service struct {
+	rcvr    reflect.Value
+	methods map[string]*method
+}
+
+//serviceMap is a concurrent safe map of names to services.
+type serviceMap struct {
+	sync.RWMutex
+	services map[string]*service
+}
+
+//isExportedOrBuiltin returns if the type is exported or a builtin, so that
+//clients in other packages are able to create it.
+func isExportedOrBuiltin(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.PkgPath() == "" {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(t.Name())
+	return unicode.IsUpper(r)
+}
+
+//register adds the methods of the receiver that have the right signature to
+//the map under the given name, or the name of the type of the receiver if the
+//name is empty.
+func (m *serviceMap) register(rcvr interface{}, name string) (err error) {
+	s := &service{
+		rcvr:    reflect.ValueOf(rcvr),
+		methods: map[string]*method{},
+	}
+	if name == "" {
+		name = reflect.Indirect(s.rcvr).Type().Name()
+	}
+	if name == "" || !isExportedOrBuiltin(reflect.Indirect(s.rcvr).Type()) {
+		err = fmt.Errorf("jsonrpc: type %q is not exported", name)
+		return
+	}
+
+	rtyp := s.rcvr.Type()
+	for i := 0; i < rtyp.NumMethod(); i++ {
+		fn := rtyp.Method(i)
+		mtyp := fn.Type
+
+		//unexported methods and ones with the wrong signature are skipped
+		switch {
+		case fn.PkgPath != "":
+		case mtyp.NumIn() != 4 || mtyp.In(1) != typeOfRequest:
+		case mtyp.In(2).Kind() != reflect.Ptr || !isExportedOrBuiltin(mtyp.In(2)):
+		case mtyp.In(3).Kind() != reflect.Ptr || !isExportedOrBuiltin(mtyp.In(3)):
+		case mtyp.NumOut() != 1 || mtyp.Out(0) != typeOfError:
+		default:
+			s.methods[fn.Name] = &method{
+				fn:    fn,
+				args:  mtyp.In(2).Elem(),
+				reply: mtyp.In(3).Elem(),
+			}
+		}
+	}
+	if len(s.methods) == 0 {
+		err = fmt.Errorf("jsonrpc: %q has no exported methods of suitable type", name)
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if m.services == nil {
+		m.services = map[string]*service{}
+	}
+	if _, ok := m.services[name]; ok {
+		err = fmt.Errorf("jsonrpc: service already defined: %q", name)
+		return
+	}
+	m.services[name] = s
+	return
+}
+
+//get returns the service and method for the "Service.Method" name, and if
+//they exist.
+func (m *serviceMap) get(name string) (s *service, meth *method, ok bool) {
+	parts := strings.Split(name, ".")
+	if len(parts) != 2 {
+		return
+	}
+
+	m.RLock()
+	s = m.services[parts[0]]
+	m.RUnlock()
+	if s == nil {
+		return
+	}
+	meth, ok = s.methods[parts[1]]
+	return
+}
@@ -0,0 +1,2 @@
+//package lease helps workers pull work from the tracker and hold on to it
+package lease
@@ -0,0 +1,85 @@
+package lease
+
+import (
+	"context"
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/app/rpc/client"
+	"log"
+	"time"
+)
+
+//Enroll calls Tracker.Enroll until it issues a credential for the worker,
+//waiting interval between failed calls. The request is signed with token.
+func Enroll(cl *client.Client, kind, worker, token string, interval time.Duration) (cred rpc.Credential) {
+	for {
+		args := &rpc.EnrollArgs{
+			Type:   kind,
+			Worker: worker,
+		}
+		var err error
+		if args.Enrollment, err = rpc.Sign(token, args.Parts()...); err == nil {
+			if err = cl.Call("Tracker.Enroll", args, &cred); err == nil {
+				return
+			}
+		}
+		log.Printf("Error enrolling: %s", err)
+		<-time.After(interval)
+	}
+}
+
+//Poll calls Tracker.Lease until it returns a task, waiting interval between
+//calls that find no work or fail.
+func Poll(cl *client.Client, args *rpc.LeaseArgs, interval time.Duration) (rep *rpc.LeaseReply) {
+	for {
+		rep = new(rpc.LeaseReply)
+		err := cl.Call("Tracker.Lease", args, rep)
+		if err == nil && (rep.Builder != nil || rep.Runner != nil) {
+			return
+		}
+		if err != nil {
+			log.Printf("Error leasing work: %s", err)
+		}
+		<-time.After(interval)
+	}
+}
+
+//Keep extends the lease referenced by ref until the returned function is
+//called. The lease is extended halfway to its deadline each time, and failed
+//extensions are retried until the deadline passes.
+func Keep(cl *client.Client, ref rpc.LeaseRef, deadline time.Time) (stop func()) {
+	done := make(chan bool)
+	stop = func() { close(done) }
+
+	go func() {
+		args := &ref
+		for {
+			//wait halfway to the deadline, but not so little we spin
+			wait := deadline.Sub(time.Now()) / 2
+			if wait < time.Second {
+				wait = time.Second
+			}
+
+			select {
+			case <-time.After(wait):
+			case <-done:
+				return
+			}
+
+			//there's no point extending the lease after it expires
+			rep := new(rpc.ExtendReply)
+			ctx, cancel := context.WithDeadline(context.Background(), deadline)
+			err := cl.CallContext(ctx, "Tracker.Extend", args, rep)
+			cancel()
+			if err != nil {
+				log.Printf("Error extending lease on %s: %s", ref.Key, err)
+				if time.Now().After(deadline) {
+					return
+				}
+				continue
+			}
+			deadline = rep.Deadline
+		}
+	}()
+
+	return
+}
@@ -0,0 +1,83 @@
+package lease
+
+import (
+	gorpc "github.com/gorilla/rpc"
+	"github.com/gorilla/rpc/json"
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/app/rpc/client"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+//fakeTracker hands out a task after a number of empty leases and counts the
+//extensions it gets.
+type fakeTracker struct {
+	sync.Mutex
+	empty   int
+	extends int
+}
+
+func (f *fakeTracker) Lease(req *http.Request, args *rpc.LeaseArgs, rep *rpc.LeaseReply) (err error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.empty > 0 {
+		f.empty--
+		return
+	}
+	rep.Deadline = time.Now().Add(2 * time.Second)
+	rep.Runner = &rpc.RunnerTask{Key: "key", Lease: "lease"}
+	return
+}
+
+func (f *fakeTracker) Extend(req *http.Request, args *rpc.LeaseRef, rep *rpc.ExtendReply) (err error) {
+	f.Lock()
+	defer f.Unlock()
+	f.extends++
+	rep.Deadline = time.Now().Add(2 * time.Second)
+	return
+}
+
+func newFakeTracker(t *testing.T, f *fakeTracker) (cl *client.Client, close func()) {
+	s := gorpc.NewServer()
+	s.RegisterCodec(json.NewCodec(), "application/json")
+	if err := s.RegisterService(f, "Tracker"); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s)
+	cl = client.New(srv.URL, http.DefaultClient, client.JsonCodec)
+	close = srv.Close
+	return
+}
+
+func TestPoll(t *testing.T) {
+	f := &fakeTracker{empty: 2}
+	cl, close := newFakeTracker(t, f)
+	defer close()
+
+	rep := Poll(cl, &rpc.LeaseArgs{Type: "Runner"}, time.Millisecond)
+	if rep.Runner == nil || rep.Runner.Lease != "lease" {
+		t.Fatalf("Expected a leased task. Got %+v", rep)
+	}
+	if f.empty != 0 {
+		t.Fatalf("Expected all the empty leases to be used. %d left", f.empty)
+	}
+}
+
+func TestKeep(t *testing.T) {
+	f := new(fakeTracker)
+	cl, close := newFakeTracker(t, f)
+	defer close()
+
+	stop := Keep(cl, rpc.LeaseRef{Key: "key", Lease: "lease"}, time.Now().Add(2*time.Second))
+	<-time.After(1500 * time.Millisecond)
+	stop()
+
+	f.Lock()
+	defer f.Unlock()
+	if f.extends != 1 {
+		t.Fatalf("Expected 1 extension. Got %d", f.extends)
+	}
+}
@@ -0,0 +1,155 @@
+package mtls
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"time"
+)
+
+//keyBits is the size of the rsa keys we generate.
+const keyBits = 2048
+
+//CA is a certificate authority that issues the certificates for the app and
+//each of the workers.
+type CA struct {
+	Cert *x509.Certificate
+	Key  *rsa.PrivateKey
+}
+
+//serial returns a random serial number for a certificate.
+func serial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+//encode returns the pem encoding of the certificate and key.
+func encode(der []byte, key *rsa.PrivateKey) (certPEM, keyPEM []byte) {
+	certPEM = pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: der,
+	})
+	keyPEM = pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+	return
+}
+
+//NewCA creates a self signed certificate authority with the given name that is
+//valid for the lifetime.
+func NewCA(name string, lifetime time.Duration) (ca *CA, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return
+	}
+	sn, err := serial()
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          sn,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(lifetime),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return
+	}
+
+	ca = &CA{Cert: cert, Key: key}
+	return
+}
+
+//LoadCA loads a certificate authority from the pem encoded certificate and
+//key, like the ones returned by Encode.
+func LoadCA(certPEM, keyPEM []byte) (ca *CA, err error) {
+	cb, _ := pem.Decode(certPEM)
+	if cb == nil || cb.Type != "CERTIFICATE" {
+		err = errors.New("no certificate found")
+		return
+	}
+	kb, _ := pem.Decode(keyPEM)
+	if kb == nil || kb.Type != "RSA PRIVATE KEY" {
+		err = errors.New("no rsa private key found")
+		return
+	}
+
+	cert, err := x509.ParseCertificate(cb.Bytes)
+	if err != nil {
+		return
+	}
+	if !cert.IsCA {
+		err = errors.New("certificate is not a certificate authority")
+		return
+	}
+	key, err := x509.ParsePKCS1PrivateKey(kb.Bytes)
+	if err != nil {
+		return
+	}
+
+	ca = &CA{Cert: cert, Key: key}
+	return
+}
+
+//Encode returns the pem encoding of the certificate and key of the CA.
+func (c *CA) Encode() (certPEM, keyPEM []byte) {
+	return encode(c.Cert.Raw, c.Key)
+}
+
+//Issue creates a certificate and key for the named service valid for the
+//lifetime. The certificate can be used to both serve and make requests so that
+//the app and workers can verify each other. Hosts are the dns names and ip
+//addresses the service is reached at.
+func (c *CA) Issue(name string, hosts []string, lifetime time.Duration) (certPEM, keyPEM []byte, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return
+	}
+	sn, err := serial()
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: sn,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(lifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, c.Cert, &key.PublicKey, c.Key)
+	if err != nil {
+		return
+	}
+
+	certPEM, keyPEM = encode(der, key)
+	return
+}
@@ -0,0 +1,2 @@
+//package mtls sets up mutual tls between the app, builders and runners
+package mtls
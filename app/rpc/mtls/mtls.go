@@ -0,0 +1,71 @@
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+)
+
+//Load returns a tls config that presents the certificate and key in the given
+//pem files and only trusts peers with certificates issued by the CA in the ca
+//pem file. The config requires and verifies peer certificates when serving and
+//can be used for clients as well. If all of the paths are empty, tls is not
+//configured and a nil config is returned.
+func Load(cert, key, ca string) (config *tls.Config, err error) {
+	if cert == "" && key == "" && ca == "" {
+		return
+	}
+	if cert == "" || key == "" || ca == "" {
+		err = errors.New("mtls needs a certificate, key and ca")
+		return
+	}
+
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return
+	}
+
+	data, err := ioutil.ReadFile(ca)
+	if err != nil {
+		return
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		err = errors.New("no certificates found in " + ca)
+		return
+	}
+
+	config = &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	return
+}
+
+//Listen wraps the listener so that connections are served over tls with the
+//config. If the config is nil the listener is returned unchanged.
+func Listen(l net.Listener, config *tls.Config) net.Listener {
+	if config == nil {
+		return l
+	}
+	return tls.NewListener(l, config)
+}
+
+//Require wraps the handler so that requests served over tls must come from a
+//peer with a verified certificate. This lets a server that only verifies
+//certificates if they're given, like the app serving browsers, still protect
+//its rpc services. Requests not served over tls are passed through.
+func Require(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.TLS != nil && len(req.TLS.VerifiedChains) == 0 {
+			http.Error(w, "client certificate required", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
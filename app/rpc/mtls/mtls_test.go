@@ -0,0 +1,167 @@
+package mtls
+
+import (
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//issue creates a CA and issues a certificate for localhost, writing them into
+//a temporary directory. It returns the config loaded from the files.
+func issue(t *testing.T, ca *CA) *tls.Config {
+	dir, err := ioutil.TempDir("", "mtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert, key, err := ca.Issue("localhost", []string{"localhost", "127.0.0.1"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := ca.Encode()
+
+	files := map[string][]byte{"cert": cert, "key": key, "ca": caCert}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config, err := Load(
+		filepath.Join(dir, "cert"),
+		filepath.Join(dir, "key"),
+		filepath.Join(dir, "ca"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+//serve starts serving the handler over tls with the config and returns the
+//https url of the server.
+func serve(t *testing.T, config *tls.Config, h http.Handler) (url string, l net.Listener) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(Listen(l, config), h)
+	url = "https://" + l.Addr().String() + "/"
+	return
+}
+
+//get makes a request to the url with the tls config.
+func get(url string, config *tls.Config) (err error) {
+	cl := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	resp, err := cl.Get(url)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = errors.New(resp.Status)
+	}
+	return
+}
+
+var ok = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+
+func TestLoadEmpty(t *testing.T) {
+	config, err := Load("", "", "")
+	if config != nil || err != nil {
+		t.Fatalf("expected no config. got %v %v", config, err)
+	}
+	if _, err := Load("cert", "", ""); err == nil {
+		t.Fatal("expected an error with only a certificate")
+	}
+}
+
+func TestMutual(t *testing.T) {
+	ca, err := NewCA("test ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := issue(t, ca)
+
+	url, l := serve(t, config, ok)
+	defer l.Close()
+
+	//a peer with a certificate from the ca gets through
+	if err := get(url, config); err != nil {
+		t.Fatalf("error with a valid certificate: %v", err)
+	}
+
+	//a peer without a certificate doesn't
+	anon := &tls.Config{RootCAs: config.RootCAs}
+	if err := get(url, anon); err == nil {
+		t.Fatal("expected an error without a certificate")
+	}
+
+	//neither does a peer with a certificate from a different ca
+	other, err := NewCA("other ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imposter := issue(t, other)
+	imposter.RootCAs = config.RootCAs
+	if err := get(url, imposter); err == nil {
+		t.Fatal("expected an error with a certificate from another ca")
+	}
+}
+
+func TestRequire(t *testing.T) {
+	ca, err := NewCA("test ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := issue(t, ca)
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+
+	mux := http.NewServeMux()
+	mux.Handle("/open", ok)
+	mux.Handle("/rpc", Require(ok))
+	url, l := serve(t, config, mux)
+	defer l.Close()
+
+	anon := &tls.Config{RootCAs: config.RootCAs}
+	if err := get(url+"open", anon); err != nil {
+		t.Fatalf("error getting open page without a certificate: %v", err)
+	}
+	if err := get(url+"rpc", anon); err == nil {
+		t.Fatal("expected an error getting rpc without a certificate")
+	}
+	if err := get(url+"rpc", config); err != nil {
+		t.Fatalf("error getting rpc with a certificate: %v", err)
+	}
+}
+
+func TestLoadCA(t *testing.T) {
+	ca, err := NewCA("test ca", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, key := ca.Encode()
+	loaded, err := LoadCA(cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Cert.Equal(ca.Cert) {
+		t.Fatal("loaded a different certificate")
+	}
+
+	//issued certificates aren't authorities
+	cert, key, err = ca.Issue("worker", nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadCA(cert, key); err == nil {
+		t.Fatal("expected an error loading a worker certificate as a ca")
+	}
+}
@@ -1,7 +1,12 @@
 package rpc
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -22,3 +27,156 @@ func TestQueue(t *testing.T) {
 		}
 	}
 }
+
+func TestQueueMax(t *testing.T) {
+	q, err := openQueue("", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.push(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.push(2); err != ErrQueueFull {
+		t.Fatalf("Expected %v. Got %v", ErrQueueFull, err)
+	}
+}
+
+func TestQueueJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "builder.journal")
+
+	q, err := OpenBuilderQueue(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//push some tasks and pop one off
+	for _, key := range []string{"a", "b", "c"} {
+		if err := q.Push(nil, &BuilderTask{Key: key}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w := q.Pop(); w.Key != "a" {
+		t.Fatalf("Expected %q. Got %q", "a", w.Key)
+	}
+
+	//reopen the queue and make sure the rest are still there
+	q, err = OpenBuilderQueue(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := q.Len(nil, nil, &n); err != nil || n != 2 {
+		t.Fatalf("Expected 2 items. Got %d (%v)", n, err)
+	}
+	var w BuilderTask
+	if err := q.Peek(nil, nil, &w); err != nil || w.Key != "b" {
+		t.Fatalf("Expected %q. Got %q (%v)", "b", w.Key, err)
+	}
+}
+
+func TestJournalCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "runner.journal")
+
+	q, err := OpenRunnerQueue(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < compactAfter; i++ {
+		if err := q.Push(nil, &RunnerTask{WorkRev: i}, nil); err != nil {
+			t.Fatal(err)
+		}
+		q.Pop()
+	}
+	if err := q.Push(nil, &RunnerTask{WorkRev: -1}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	//the journal should only have the one push left in it
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 1 {
+		t.Fatalf("Expected 1 entry after compaction. Got %d", lines)
+	}
+}
+
+func TestJournalReplay(t *testing.T) {
+	decode := func(buf []byte) (item interface{}, err error) {
+		var w BuilderTask
+		err = json.Unmarshal(buf, &w)
+		item = w.Key
+		return
+	}
+	entries := `{"Item":{"Key":"a"}}` + "\n" + `{"Item":{"Key":"b"}}` + "\n" + `{"Pop":true}` + "\n"
+
+	//a torn write at the end is dropped
+	items, err := replay(strings.NewReader(entries+`{"Item":{"Ke`), decode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(items, []interface{}{"b"}) {
+		t.Fatalf("Expected [b]. Got %v", items)
+	}
+
+	//anywhere else it loses the entries after it
+	_, err = replay(strings.NewReader(`{"Item":{"Ke`+"\n"+entries), decode)
+	if err == nil {
+		t.Fatal("Expected an error for a corrupt entry before the end")
+	}
+}
+
+func TestJournalWithoutAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "builder.journal")
+
+	q, err := OpenBuilderQueue(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &BuilderTask{Key: "a", Auth: &Auth{Host: "github.com", Token: "hunter2"}}
+	if err := q.Push(nil, task, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	//the credentials are only kept in memory
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hunter2") {
+		t.Fatal("Credentials written to the journal:", string(data))
+	}
+	var w BuilderTask
+	if err := q.Peek(nil, nil, &w); err != nil || w.Auth != nil {
+		t.Fatalf("Expected no credentials. Got %v (%v)", w.Auth, err)
+	}
+
+	//a reloaded task knows it lost its credentials
+	rq, err := OpenBuilderQueue(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := rq.Pop(); w.Auth == nil || !w.Auth.Dropped || w.Auth.Token != "" {
+		t.Fatalf("Expected dropped credentials. Got %+v", w.Auth)
+	}
+
+	if w := q.Pop(); w.Auth == nil || w.Auth.Token != "hunter2" || w.Auth.Dropped {
+		t.Fatalf("Expected the credentials. Got %v", w.Auth)
+	}
+}
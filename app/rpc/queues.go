@@ -1,100 +1,248 @@
 package rpc
 
-import "net/http"
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+)
 
-//queue is a general purpose queue of interface items
+var (
+	//ErrQueueFull is returned by Push when the queue is at its maximum size.
+	ErrQueueFull = Error("queue is full")
+
+	//ErrQueueEmpty is returned by Peek when there are no items in the queue.
+	ErrQueueEmpty = Error("queue is empty")
+)
+
+//queue is a general purpose queue of interface items that is optionally
+//bounded and backed by a journal on disk.
 type queue struct {
-	in  chan interface{}
-	out chan interface{}
-}
-
-//run handles the input and output of the queue
-func (q queue) run() {
-	items := make([]interface{}, 0)
-	var out chan<- interface{}
-	var item interface{}
-
-	for {
-		select {
-		case w := <-q.in:
-			items = append(items, w)
-			if out == nil {
-				out = q.out
-				item = items[0]
-			}
-		case out <- item:
-			items = items[1:]
-			if len(items) == 0 {
-				out = nil
-			} else {
-				item = items[0]
-			}
-		}
+	mu    sync.Mutex
+	ready *sync.Cond
+	items []interface{}
+	max   int      //maximum number of items. zero means unlimited
+	j     *journal //where operations are recorded. nil if not durable
+}
+
+//newQueue returns an unbounded in memory queue.
+func newQueue() (q *queue) {
+	q = &queue{}
+	q.ready = sync.NewCond(&q.mu)
+	return
+}
+
+//openQueue returns a queue holding at most max items. If path is not empty,
+//operations are recorded in a journal at that path and any items left in it
+//are loaded using decode.
+func openQueue(path string, max int, decode func([]byte) (interface{}, error)) (q *queue, err error) {
+	q = newQueue()
+	q.max = max
+	if path == "" {
+		return
 	}
+	q.j, q.items, err = openJournal(path, decode)
+	return
 }
 
 //push puts an item in to the queue.
-func (q queue) push(w interface{}) {
-	q.in <- w
+func (q *queue) push(w interface{}) (err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.max > 0 && len(q.items) >= q.max {
+		err = ErrQueueFull
+		return
+	}
+
+	//make sure the item is recorded before we accept it
+	if q.j != nil {
+		if err = q.j.push(w); err != nil {
+			return
+		}
+	}
+
+	q.items = append(q.items, w)
+	q.ready.Signal()
+	return
 }
 
-//pop pulls an item from the queue
-func (q queue) pop() (w interface{}) {
-	w = <-q.out
+//pop pulls an item from the queue, waiting for one if it is empty.
+func (q *queue) pop() (w interface{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.items) == 0 {
+		q.ready.Wait()
+	}
+	w, q.items = q.items[0], q.items[1:]
+
+	//the item is already out of the queue so all we can do is log
+	if q.j != nil {
+		if err := q.j.pop(q.items); err != nil {
+			log.Printf("error journaling pop: %s", err)
+		}
+	}
 	return
 }
 
-func newQueue() (q queue) {
-	q = queue{
-		in:  make(chan interface{}),
-		out: make(chan interface{}),
+//peek returns the next item in the queue without removing it.
+func (q *queue) peek() (w interface{}, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if ok = len(q.items) > 0; ok {
+		w = q.items[0]
 	}
-	go q.run()
 	return
 }
 
-//BuilderQueue is a queue with unlimited buffer for BuilderTask items.
+//len returns the number of items in the queue.
+func (q *queue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.items)
+}
+
+//BuilderQueue is a queue of BuilderTask items.
 type BuilderQueue struct {
-	queue
+	*queue
 }
 
-//Push is an RPC method for pushing things onto the queue.
+//Push is an RPC method for pushing things onto the queue. It returns
+//ErrQueueFull if the queue is at its maximum size.
 func (q BuilderQueue) Push(req *http.Request, work *BuilderTask, void *None) (err error) {
-	q.push(*work)
+	err = q.push(*work)
+	return
+}
+
+//Len is an RPC method that returns the number of tasks in the queue.
+func (q BuilderQueue) Len(req *http.Request, args *None, n *int) (err error) {
+	*n = q.len()
 	return
 }
 
+//Peek is an RPC method that returns the next task in the queue without
+//removing it. It returns ErrQueueEmpty if there are no tasks. The credentials
+//of the task are left out.
+func (q BuilderQueue) Peek(req *http.Request, args *None, work *BuilderTask) (err error) {
+	w, ok := q.peek()
+	if !ok {
+		err = ErrQueueEmpty
+		return
+	}
+	*work = withoutAuth(w).(BuilderTask)
+	return
+}
+
+//Size returns the number of tasks in the queue.
+func (q BuilderQueue) Size() int {
+	return q.len()
+}
+
 //Pop grabs an item from the queue.
 func (q BuilderQueue) Pop() (w BuilderTask) {
 	w = q.pop().(BuilderTask)
 	return
 }
 
-//NewBuilderQueue creates a new queue.
+//NewBuilderQueue creates a new in memory queue with an unlimited buffer.
 func NewBuilderQueue() (q BuilderQueue) {
 	q.queue = newQueue()
 	return
 }
 
-//RunnerQueue is a queue with an unlimited buffer of RunnerTask items.
+//OpenBuilderQueue creates a queue holding at most max tasks, or unlimited if
+//max is zero. If path is not empty the queue is journaled to that file and any
+//tasks pending from a previous run are loaded. Credentials aren't journaled, so
+//pending tasks for private repositories are loaded with their Auth marked as
+//Dropped and fail when they are built.
+func OpenBuilderQueue(path string, max int) (q BuilderQueue, err error) {
+	q.queue, err = openQueue(path, max, func(data []byte) (v interface{}, err error) {
+		var w BuilderTask
+		err = json.Unmarshal(data, &w)
+		v = w
+		return
+	})
+	if q.queue != nil && q.queue.j != nil {
+		q.queue.j.record = dropAuth
+	}
+	return
+}
+
+//dropAuth returns the BuilderTask with only a marker of its credentials.
+func dropAuth(w interface{}) interface{} {
+	task := w.(BuilderTask)
+	if task.Auth != nil {
+		task.Auth = &Auth{Host: task.Auth.Host, Dropped: true}
+	}
+	return task
+}
+
+//withoutAuth returns the BuilderTask without its credentials.
+func withoutAuth(w interface{}) interface{} {
+	task := w.(BuilderTask)
+	task.Auth = nil
+	return task
+}
+
+//RunnerQueue is a queue of RunnerTask items.
 type RunnerQueue struct {
-	queue
+	*queue
 }
 
-//Push is an rpc method for putting a task into the Runner queue.
+//Push is an rpc method for putting a task into the Runner queue. It returns
+//ErrQueueFull if the queue is at its maximum size.
 func (q RunnerQueue) Push(req *http.Request, work *RunnerTask, void *None) (err error) {
-	q.push(*work)
+	err = q.push(*work)
+	return
+}
+
+//Len is an RPC method that returns the number of tasks in the queue.
+func (q RunnerQueue) Len(req *http.Request, args *None, n *int) (err error) {
+	*n = q.len()
 	return
 }
 
+//Peek is an RPC method that returns the next task in the queue without
+//removing it. It returns ErrQueueEmpty if there are no tasks.
+func (q RunnerQueue) Peek(req *http.Request, args *None, work *RunnerTask) (err error) {
+	w, ok := q.peek()
+	if !ok {
+		err = ErrQueueEmpty
+		return
+	}
+	*work = w.(RunnerTask)
+	return
+}
+
+//Size returns the number of tasks in the queue.
+func (q RunnerQueue) Size() int {
+	return q.len()
+}
+
 //Pop grabs an item from the queue.
 func (q RunnerQueue) Pop() (w RunnerTask) {
 	w = q.pop().(RunnerTask)
 	return
 }
 
-//NewRunnerQueue returns a new RunnerQueue ready to use
+//NewRunnerQueue returns a new in memory RunnerQueue with an unlimited buffer.
 func NewRunnerQueue() (q RunnerQueue) {
 	q.queue = newQueue()
 	return
 }
+
+//OpenRunnerQueue creates a queue holding at most max tasks, or unlimited if
+//max is zero. If path is not empty the queue is journaled to that file and any
+//tasks pending from a previous run are loaded.
+func OpenRunnerQueue(path string, max int) (q RunnerQueue, err error) {
+	q.queue, err = openQueue(path, max, func(data []byte) (v interface{}, err error) {
+		var w RunnerTask
+		err = json.Unmarshal(data, &w)
+		v = w
+		return
+	})
+	return
+}
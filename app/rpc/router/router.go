@@ -2,8 +2,8 @@
 package router
 
 import (
-	"github.com/gorilla/rpc"
-	"github.com/gorilla/rpc/json"
+	"github.com/zeebo/goci/app/rpc/jsonrpc"
+	"github.com/zeebo/goci/app/rpc/mtls"
 	"net/http"
 	"sort"
 )
@@ -12,12 +12,12 @@ import (
 var servers = map[string]string{}
 
 //Serve adds the rpc service t to the net/http DefaultServeMux at the given path
-//and allows future lookup with name.
+//and allows future lookup with name. Requests served over tls must come from a
+//peer with a verified certificate.
 func Serve(t interface{}, name string, path string) {
-	s := rpc.NewServer()
-	s.RegisterCodec(json.NewCodec(), "application/json")
+	s := jsonrpc.NewServer()
 	s.RegisterService(t, name)
-	http.Handle(path, s)
+	http.Handle(path, mtls.Require(s))
 	servers[name] = path
 }
 
@@ -2,6 +2,8 @@ package rpc
 
 import (
 	"fmt"
+	"github.com/zeebo/goci/redact"
+	"strings"
 	"time"
 )
 
@@ -30,24 +32,120 @@ func Wrap(errp *error) {
 	}
 }
 
+//Capabilities describes what a worker is able to do so that it is only handed
+//work it can complete.
+type Capabilities struct {
+	Toolchains []string //the toolchains the worker has available
+	Labels     []string //labels for features of the worker like "cgo" or "docker"
+}
+
 //AnnounceArgs is the argument type of the Announce function
 type AnnounceArgs struct {
 	GOOS, GOARCH string //the goos/goarch of the service
 	Type         string //either "Builder" or "Runner"
 	URL          string //the url of the service to make rpc calls
+	Capabilities
+
+	//Enrollment signs the Parts of the announcement with the enrollment token
+	//of the service.
+	Enrollment Enrollment
+
+	//Credential is the credential from a previous Announce. It is set when
+	//announcing again after being evicted so the service keeps the same key.
+	Credential Credential
+}
+
+//Parts returns the fields of the announcement that its Enrollment signs, so
+//that it can't be replayed for another url or set of capabilities.
+func (a *AnnounceArgs) Parts() []string {
+	return []string{
+		a.Type, a.URL, a.GOOS, a.GOARCH,
+		strings.Join(a.Toolchains, ","),
+		strings.Join(a.Labels, ","),
+	}
 }
 
 //AnnounceReply is the reply type of the Announce function
 type AnnounceReply struct {
-	//Key is the datastore key that corresponds to the service if successful
-	Key string
+	//Credential has the datastore key that corresponds to the service and the
+	//secret to send with future rpcs if successful.
+	Credential Credential
+}
+
+//EnrollArgs is the argument type of the Enroll function
+type EnrollArgs struct {
+	Type   string //either "Builder" or "Runner"
+	Worker string //a name for the worker
+
+	//Enrollment signs the Parts of the request with the enrollment token of
+	//the worker.
+	Enrollment Enrollment
+}
+
+//Parts returns the fields of the request that its Enrollment signs.
+func (e *EnrollArgs) Parts() []string {
+	return []string{e.Type, e.Worker}
 }
 
 //RemoveArgs is the argument type of the Remove function
 type RemoveArgs struct {
-	//Key is the datastore key that corresponds to the service to be removed
-	Key  string
-	Kind string
+	//Credential has the datastore key that corresponds to the service to be
+	//removed
+	Credential Credential
+	Kind       string
+}
+
+//LeaseArgs is the argument type of the Lease function
+type LeaseArgs struct {
+	Type         string //either "Builder" or "Runner"
+	GOOS, GOARCH string //the goos/goarch of the worker
+	Worker       string //a name for the worker recorded in the attempt log
+	Capabilities
+	Credential Credential //the credential from Enroll
+}
+
+//LeaseReply is the reply type of the Lease function. Builder is set if a
+//Builder leased work, Runner is set if a Runner leased work and neither is set
+//if there was no work available.
+type LeaseReply struct {
+	Deadline time.Time    //when the lease expires unless it is extended
+	Builder  *BuilderTask //the task for a Builder
+	Runner   *RunnerTask  //the task for a Runner
+}
+
+//LeaseRef is the argument type of the Extend and Release functions.
+type LeaseRef struct {
+	Key        string     //the datastore key of the Work item
+	Lease      string     //the lease given with the task
+	Kind       string     //either "Builder" or "Runner"
+	Credential Credential //the credential of the worker holding the lease
+}
+
+//ExtendReply is the reply type of the Extend function.
+type ExtendReply struct {
+	Deadline time.Time //the new deadline for the lease
+}
+
+//BuiltArgs is the argument type of the Built function. It hands off a task
+//built from a leased work item to be leased by a Runner.
+type BuiltArgs struct {
+	Lease      string     //the lease given with the BuilderTask
+	Task       RunnerTask //the task for the runner
+	Credential Credential //the credential of the Builder
+}
+
+//HeartbeatArgs is the argument type of the Heartbeat function
+type HeartbeatArgs struct {
+	Credential Credential //the credential returned by Announce
+	Kind       string     //either "Builder" or "Runner"
+	Load       int        //the number of tasks the service has queued or running
+}
+
+//HeartbeatReply is the reply type of the Heartbeat function
+type HeartbeatReply struct {
+	//Evicted is true if the tracker no longer knows about the service and it
+	//should announce itself again.
+	Evicted bool
 }
 
 //None is an empty rpc element
@@ -66,6 +164,14 @@ type Work struct {
 	//used by the package. If set to the empty string, we will search for the
 	//system by looking for the metadata directory.
 	VCSHint string
+
+	//Toolchain is an optional parameter that specifies the toolchain a worker
+	//must have to lease the Work. If empty, any worker can lease it.
+	Toolchain string
+
+	//Requires is an optional list of labels the builder and runner must both
+	//have to be handed the Work.
+	Requires []string
 }
 
 //Distill makes a Work able to be sent in to the queue.
@@ -79,8 +185,62 @@ type BuilderTask struct {
 	Key      string //the datastore key for the Work item (forward to runner)
 	ID       string //the id of the test (forward to runner)
 	WorkRev  int    //the revision of the work item (forward to runner)
-	Runner   string //the rpc url of the runner for this task
+	Runner   string //the rpc url of the runner for this task (empty if leased)
 	Response string //the rpc url of the response (forward to the runner)
+	Lease    string //the lease on the work item if it was leased
+	Secret   string //signs the responses for the attempt (forward to runner)
+	Since    string //the last revision tested, to log the commits after it
+	Auth     *Auth  //credentials to download a private repository, if any
+}
+
+//String keeps the secret of the attempt out of logs.
+func (b BuilderTask) String() string {
+	type task BuilderTask //without the String method
+	b.Secret = hide(b.Secret)
+	return fmt.Sprintf("%+v", task(b))
+}
+
+//Auth is the credentials a Builder uses to download a private repository. It
+//has either an SSHKey or a Username and Token for https.
+type Auth struct {
+	Host     string //the host the credentials are for, like github.com
+	SSHKey   string //a pem encoded private deploy key
+	Username string //the username for https
+	Token    string //the password or access token for https
+
+	//Dropped is set when the secrets were left out of a queue journal, so the
+	//task can't download the repository after being reloaded.
+	Dropped bool `json:",omitempty"`
+}
+
+//String keeps the credentials out of logs.
+func (a *Auth) String() string {
+	if a == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("credentials for %s", a.Host)
+}
+
+//Secrets returns the secret values in the credentials.
+func (a *Auth) Secrets() []string {
+	if a == nil {
+		return nil
+	}
+	return []string{a.Token, a.SSHKey}
+}
+
+//Scrub masks the secrets in s, and anything that looks like one, so it can be
+//shown to anyone.
+func (a *Auth) Scrub(s string) string {
+	return redact.New(a.Secrets()...).Mask(s)
+}
+
+//Commit is a commit in the log of the package being tested.
+type Commit struct {
+	Revision string
+	Author   string
+	Date     time.Time
+	Subject  string //the first line of the message
 }
 
 //RunnerTask is a task sent by a Builder to a runner
@@ -93,6 +253,24 @@ type RunnerTask struct {
 	Tests      []RunTest //the set of binarys to be executed
 	WontBuilds []Output  //the set of tests that failed to build
 	Response   string    //the rpc url of the response
+	Lease      string    //the lease on the work item if it was leased
+	Secret     string    //signs the response for the attempt
+	Commits    []Commit  //the commits since the last revision tested
+}
+
+//String keeps the secret of the attempt out of logs.
+func (r RunnerTask) String() string {
+	type task RunnerTask //without the String method
+	r.Secret = hide(r.Secret)
+	return fmt.Sprintf("%+v", task(r))
+}
+
+//hide replaces a secret with a mask so it can be logged.
+func hide(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redact.Mask
 }
 
 //RunTest represents an individual binary to be installed and run.
@@ -105,41 +283,49 @@ type RunTest struct {
 
 //RunnerResponse is the response from the Runner to the tracker
 type RunnerResponse struct {
-	Key      string    //the key for the Work item
-	ID       string    //the ID of the test
-	WorkRev  int       //the revision of the work item
-	Revision string    //the revision we ended up testing
-	RevDate  time.Time //the time this revision was made
-	Tests    []Output  //the list of tests
+	Key        string     //the key for the Work item
+	ID         string     //the ID of the test
+	WorkRev    int        //the revision of the work item
+	Revision   string     //the revision we ended up testing
+	RevDate    time.Time  //the time this revision was made
+	Tests      []Output   //the list of tests
+	Commits    []Commit   //the commits since the last revision tested
+	Credential Credential //the credential of the Runner
+	Signature  string     //signature by the secret of the attempt
 }
 
 //BuilderResponse is the response from the Builder if the build failed for any
 //reason.
 type BuilderResponse struct {
-	Key      string    //the key of the work item
-	ID       string    //the ID of the test
-	WorkRev  int       //the expected revision of work item
-	Error    string    //the error in setting up the builds
-	Revision string    //the revision of the work item (if known)
-	RevDate  time.Time //the time the revision was commit (if known)
+	Key        string     //the key of the work item
+	ID         string     //the ID of the test
+	WorkRev    int        //the expected revision of work item
+	Error      string     //the error in setting up the builds
+	Revision   string     //the revision of the work item (if known)
+	RevDate    time.Time  //the time the revision was commit (if known)
+	Credential Credential //the credential of the Builder
+	Signature  string     //signature by the secret of the attempt
 }
 
 //DispatchResponse is the response from the dispatcher to the response handler
 //saying that it is unable to get a successful response from the work item and
 //it has failed too many times.
 type DispatchResponse struct {
-	Key     string //the key of the work item
-	Error   string //the error in the dispatch
-	WorkRev int    //the revision of the work document
+	Key       string //the key of the work item
+	ID        string //the ID of the latest attempt
+	Error     string //the error in the dispatch
+	WorkRev   int    //the revision of the work document
+	Signature string //signature by the secret of the latest attempt
 }
 
 //Output is a type that wraps the output of a build, be it the actual output or
 //the error produced.
 type Output struct {
-	ImportPath string     //the import path of the binary that produced the output
-	Config     Config     //the configuration for the test
-	Type       OutputType //the type of output (Success/WontBuild/Error)
-	Output     string     //the output of the test
+	ImportPath string        //the import path of the binary that produced the output
+	Config     Config        //the configuration for the test
+	Type       OutputType    //the type of output (Success/WontBuild/Error)
+	Output     string        //the output of the test
+	Duration   time.Duration //how long the test ran, if it ran
 }
 
 //OutputType is an enumeration of types of outputs.
@@ -1 +1,48 @@
 package rpc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestAuthScrub(t *testing.T) {
+	a := &Auth{
+		Host:   "github.com",
+		SSHKey: "-----BEGIN KEY-----\nc2VjcmV0\nbGluZXM=\n-----END KEY-----\n",
+		Token:  "hunter2",
+	}
+	got := a.Scrub("token hunter2 key c2VjcmV0 bGluZXM= -----BEGIN KEY-----")
+	exp := "token ******** key ******** ******** -----BEGIN KEY-----"
+	if got != exp {
+		t.Fatalf("Expected %q. Got %q", exp, got)
+	}
+	if s := fmt.Sprintf("%+v", BuilderTask{Auth: a}); strings.Contains(s, "hunter2") {
+		t.Fatal("Credentials in the formatted task:", s)
+	}
+	if s := (*Auth)(nil).Scrub("hunter2"); s != "hunter2" {
+		t.Fatal("Got", s)
+	}
+}
+
+func TestSecretsNotFormatted(t *testing.T) {
+	cred := Credential{Key: "key", Secret: "hunter2"}
+	vals := []interface{}{
+		cred,
+		LeaseRef{Key: "key", Credential: cred},
+		BuilderTask{Key: "key", Secret: "hunter2"},
+		RunnerTask{Key: "key", Secret: "hunter2"},
+		&RunnerResponse{Key: "key", Credential: cred},
+	}
+	for _, v := range vals {
+		for _, format := range []string{"%v", "%+v", "%s"} {
+			s := fmt.Sprintf(format, v)
+			if strings.Contains(s, "hunter2") {
+				t.Errorf("Secret in the formatted %T: %s", v, s)
+			}
+			if !strings.Contains(s, "key") {
+				t.Errorf("Expected the key in the formatted %T: %s", v, s)
+			}
+		}
+	}
+}
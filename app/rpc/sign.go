@@ -0,0 +1,111 @@
+package rpc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+//NewSecret returns a new random hex encoded secret.
+func NewSecret() (s string, err error) {
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return
+	}
+	s = hex.EncodeToString(buf)
+	return
+}
+
+//mac returns the HMAC-SHA256 of the parts keyed by the secret. Each part is
+//prefixed with its length so that moving bytes between parts changes the mac.
+func mac(secret string, parts ...string) []byte {
+	m := hmac.New(sha256.New, []byte(secret))
+	for _, p := range parts {
+		fmt.Fprintf(m, "%d:", len(p))
+		m.Write([]byte(p))
+	}
+	return m.Sum(nil)
+}
+
+//sign returns the hex encoded mac of the parts keyed by the secret.
+func sign(secret string, parts ...string) string {
+	return hex.EncodeToString(mac(secret, parts...))
+}
+
+//verify reports if sig is the hex encoded mac of the parts keyed by the secret.
+func verify(secret, sig string, parts ...string) bool {
+	buf, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(buf, mac(secret, parts...))
+}
+
+//stamp formats a time for signing so that it survives being sent over the wire.
+func stamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+//configPart formats a config for signing. A Config always marshals, and the
+//fields are written in the same order every time.
+func configPart(c Config) string {
+	buf, _ := json.Marshal(c)
+	return string(buf)
+}
+
+//parts returns the parts of the response that are signed. The config of each
+//test is included because it says where notifications are sent.
+func (r *RunnerResponse) parts() (ps []string) {
+	ps = []string{r.Key, r.ID, fmt.Sprint(r.WorkRev), r.Revision, stamp(r.RevDate)}
+	for _, t := range r.Tests {
+		ps = append(ps, t.ImportPath, configPart(t.Config), string(t.Type), t.Output, fmt.Sprint(int64(t.Duration)))
+	}
+	for _, c := range r.Commits {
+		ps = append(ps, c.Revision, c.Author, stamp(c.Date), c.Subject)
+	}
+	return
+}
+
+//Sign signs the response with the secret of the attempt.
+func (r *RunnerResponse) Sign(secret string) {
+	r.Signature = sign(secret, r.parts()...)
+}
+
+//Verify reports if the response was signed with the secret of the attempt.
+func (r *RunnerResponse) Verify(secret string) bool {
+	return verify(secret, r.Signature, r.parts()...)
+}
+
+//parts returns the parts of the response that are signed.
+func (r *BuilderResponse) parts() []string {
+	return []string{r.Key, r.ID, fmt.Sprint(r.WorkRev), r.Error, r.Revision, stamp(r.RevDate)}
+}
+
+//Sign signs the response with the secret of the attempt.
+func (r *BuilderResponse) Sign(secret string) {
+	r.Signature = sign(secret, r.parts()...)
+}
+
+//Verify reports if the response was signed with the secret of the attempt.
+func (r *BuilderResponse) Verify(secret string) bool {
+	return verify(secret, r.Signature, r.parts()...)
+}
+
+//parts returns the parts of the response that are signed.
+func (r *DispatchResponse) parts() []string {
+	return []string{r.Key, r.ID, r.Error, fmt.Sprint(r.WorkRev)}
+}
+
+//Sign signs the response with the secret of the attempt.
+func (r *DispatchResponse) Sign(secret string) {
+	r.Signature = sign(secret, r.parts()...)
+}
+
+//Verify reports if the response was signed with the secret of the attempt.
+func (r *DispatchResponse) Verify(secret string) bool {
+	return verify(secret, r.Signature, r.parts()...)
+}
@@ -0,0 +1,68 @@
+package rpc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRunnerResponseSign(t *testing.T) {
+	resp := &RunnerResponse{
+		Key:     "key",
+		ID:      "id",
+		WorkRev: 2,
+		RevDate: time.Now(),
+		Tests:   []Output{{ImportPath: "a", Type: OutputSuccess, Output: "PASS"}},
+	}
+	resp.Sign("secret")
+	if !resp.Verify("secret") {
+		t.Fatal("Expected the signature to verify")
+	}
+	if resp.Verify("other") {
+		t.Fatal("Expected the signature to fail with the wrong secret")
+	}
+
+	//the time is sent in another zone over the wire
+	resp.RevDate = resp.RevDate.In(time.FixedZone("x", 3600))
+	if !resp.Verify("secret") {
+		t.Fatal("Expected the signature to verify in another zone")
+	}
+
+	resp.Tests[0].Output = "FAIL"
+	if resp.Verify("secret") {
+		t.Fatal("Expected the signature to fail after tampering")
+	}
+	resp.Tests[0].Output = "PASS"
+
+	//the config says where notifications go, so it can't be changed either
+	resp.Tests[0].Config.NotifyURL = "http://example.com"
+	if resp.Verify("secret") {
+		t.Fatal("Expected the signature to fail after changing the config")
+	}
+	resp.Tests[0].Config.NotifyURL = ""
+
+	resp.Tests[0].Duration = time.Second
+	if resp.Verify("secret") {
+		t.Fatal("Expected the signature to fail after changing the duration")
+	}
+}
+
+func TestSignFraming(t *testing.T) {
+	if verify("secret", sign("secret", "a\nb", "c"), "a", "b\nc") {
+		t.Fatal("Expected the signature to fail when bytes move between parts")
+	}
+	if verify("secret", sign("secret", "1:a", "b"), "1:a1:b") {
+		t.Fatal("Expected the signature to fail when parts are joined")
+	}
+}
+
+func TestDispatchResponseSign(t *testing.T) {
+	resp := &DispatchResponse{Key: "key", ID: "id", Error: "err", WorkRev: 1}
+	resp.Sign("secret")
+	if !resp.Verify("secret") {
+		t.Fatal("Expected the signature to verify")
+	}
+	resp.WorkRev++
+	if resp.Verify("secret") {
+		t.Fatal("Expected the signature to fail after tampering")
+	}
+}
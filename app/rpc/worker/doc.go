@@ -0,0 +1,2 @@
+//package worker is the part of builders and runners that talks to the tracker
+package worker
@@ -0,0 +1,156 @@
+package worker
+
+import (
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/app/rpc/client"
+	"github.com/zeebo/goci/app/rpc/heartbeat"
+	"github.com/zeebo/goci/app/rpc/lease"
+	"sync"
+	"time"
+)
+
+//PollInterval is how long Lease waits after finding no work to lease.
+const PollInterval = 10 * time.Second
+
+//Worker announces a builder or runner to the tracker and keeps it there with
+//heartbeats, or leases work from the tracker for it. Builders and runners
+//embed it.
+type Worker struct {
+	tcl    *client.Client //the client for the tracker
+	kind   string         //Builder or Runner
+	base   string         //the url the worker is hosted at
+	goos   string
+	goarch string
+	load   func() int //the number of tasks queued or in progress
+
+	caps  rpc.Capabilities //what we report to the tracker
+	token string           //the enrollment token
+	cmu   sync.Mutex       //protects cred
+	cred  rpc.Credential   //the credential the tracker issued us
+	stop  chan bool        //closed to stop sending heartbeats
+}
+
+//New returns a Worker of the given kind, Builder or Runner, that reports to the
+//tracker through tcl. It is hosted at base and builds or runs for goos and
+//goarch. Heartbeats report the load returned by load.
+func New(tcl *client.Client, kind, base, goos, goarch string, load func() int) *Worker {
+	return &Worker{
+		tcl:    tcl,
+		kind:   kind,
+		base:   base,
+		goos:   goos,
+		goarch: goarch,
+		load:   load,
+	}
+}
+
+//Announce tells the tracker that we're available for work, and keeps sending
+//heartbeats until Remove is called.
+func (w *Worker) Announce() (err error) {
+	args, err := w.announceArgs(rpc.Credential{})
+	if err != nil {
+		return
+	}
+	reply := new(rpc.AnnounceReply)
+	if err = w.tcl.Call("Tracker.Announce", args, reply); err != nil {
+		return
+	}
+	cred := reply.Credential
+	w.setCredential(cred)
+
+	//come back with the same credential if the tracker evicts us
+	reannounce := func() error {
+		args, err := w.announceArgs(cred)
+		if err != nil {
+			return err
+		}
+		return w.tcl.Call("Tracker.Announce", args, new(rpc.AnnounceReply))
+	}
+	if w.stop == nil {
+		w.stop = make(chan bool)
+		go heartbeat.Beat(w.tcl, w.kind, cred, heartbeat.Interval, w.load, reannounce, w.stop)
+	}
+	return
+}
+
+//announceArgs returns the arguments the Worker announces itself with, signed
+//with its enrollment token. The credential is set when announcing again.
+func (w *Worker) announceArgs(cred rpc.Credential) (args *rpc.AnnounceArgs, err error) {
+	args = &rpc.AnnounceArgs{
+		GOOS:         w.goos,
+		GOARCH:       w.goarch,
+		Type:         w.kind,
+		URL:          w.base,
+		Capabilities: w.caps,
+		Credential:   cred,
+	}
+	args.Enrollment, err = rpc.Sign(w.token, args.Parts()...)
+	return
+}
+
+//SetToken sets the enrollment token the Worker signs its requests to the
+//tracker with. It must be called before Announce or Lease.
+func (w *Worker) SetToken(token string) {
+	w.token = token
+}
+
+//SetCapabilities sets the capabilities the Worker reports to the tracker when
+//it announces itself or leases work. It must be called before either.
+func (w *Worker) SetCapabilities(caps rpc.Capabilities) {
+	w.caps = caps
+}
+
+//Credential returns the credential the tracker issued to the Worker.
+func (w *Worker) Credential() rpc.Credential {
+	w.cmu.Lock()
+	defer w.cmu.Unlock()
+	return w.cred
+}
+
+//setCredential stores the credential the tracker issued to the Worker.
+func (w *Worker) setCredential(cred rpc.Credential) {
+	w.cmu.Lock()
+	defer w.cmu.Unlock()
+	w.cred = cred
+}
+
+//Remove removes the Worker from the tracker.
+func (w *Worker) Remove() (err error) {
+	//stop sending heartbeats
+	if w.stop != nil {
+		close(w.stop)
+		w.stop = nil
+	}
+
+	args := &rpc.RemoveArgs{
+		Credential: w.Credential(),
+		Kind:       w.kind,
+	}
+	err = w.tcl.Call("Tracker.Remove", args, new(rpc.None))
+	return
+}
+
+//Lease enrolls with the tracker and leases work from it forever, instead of
+//waiting for work to be pushed. The lease on each work item is extended until
+//the stop function passed to do with it is called.
+func (w *Worker) Lease(do func(rep *rpc.LeaseReply, stop func())) {
+	args := &rpc.LeaseArgs{
+		Type:         w.kind,
+		GOOS:         w.goos,
+		GOARCH:       w.goarch,
+		Worker:       w.base,
+		Capabilities: w.caps,
+	}
+	args.Credential = lease.Enroll(w.tcl, w.kind, w.base, w.token, PollInterval)
+	w.setCredential(args.Credential)
+	for {
+		rep := lease.Poll(w.tcl, args, PollInterval)
+		ref := rpc.LeaseRef{Kind: w.kind, Credential: args.Credential}
+		if rep.Builder != nil {
+			ref.Key, ref.Lease = rep.Builder.Key, rep.Builder.Lease
+		} else {
+			ref.Key, ref.Lease = rep.Runner.Key, rep.Runner.Lease
+		}
+		do(rep, lease.Keep(w.tcl, ref, rep.Deadline))
+	}
+}
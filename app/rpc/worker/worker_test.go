@@ -0,0 +1,121 @@
+package worker
+
+import (
+	gorpc "github.com/gorilla/rpc"
+	"github.com/gorilla/rpc/json"
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/app/rpc/client"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+//fakeTracker issues a credential to announces and enrollments, and hands out a
+//builder task to leases.
+type fakeTracker struct {
+	sync.Mutex
+	announced *rpc.AnnounceArgs
+	removed   *rpc.RemoveArgs
+	leased    *rpc.LeaseArgs
+}
+
+func (f *fakeTracker) Announce(req *http.Request, args *rpc.AnnounceArgs, rep *rpc.AnnounceReply) (err error) {
+	f.Lock()
+	defer f.Unlock()
+	f.announced = args
+	rep.Credential = rpc.Credential{Key: "key", Secret: "secret"}
+	return
+}
+
+func (f *fakeTracker) Remove(req *http.Request, args *rpc.RemoveArgs, rep *rpc.None) (err error) {
+	f.Lock()
+	defer f.Unlock()
+	f.removed = args
+	return
+}
+
+func (f *fakeTracker) Enroll(req *http.Request, args *rpc.EnrollArgs, rep *rpc.Credential) (err error) {
+	*rep = rpc.Credential{Key: "key", Secret: "secret"}
+	return
+}
+
+func (f *fakeTracker) Lease(req *http.Request, args *rpc.LeaseArgs, rep *rpc.LeaseReply) (err error) {
+	f.Lock()
+	defer f.Unlock()
+	f.leased = args
+	rep.Deadline = time.Now().Add(time.Minute)
+	rep.Builder = &rpc.BuilderTask{Key: "key", Lease: "lease"}
+	return
+}
+
+func (f *fakeTracker) Extend(req *http.Request, args *rpc.LeaseRef, rep *rpc.ExtendReply) (err error) {
+	rep.Deadline = time.Now().Add(time.Minute)
+	return
+}
+
+func newFakeTracker(t *testing.T, f *fakeTracker) (cl *client.Client, close func()) {
+	s := gorpc.NewServer()
+	s.RegisterCodec(json.NewCodec(), "application/json")
+	if err := s.RegisterService(f, "Tracker"); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s)
+	cl = client.New(srv.URL, http.DefaultClient, client.JsonCodec)
+	close = srv.Close
+	return
+}
+
+func TestAnnounceRemove(t *testing.T) {
+	f := new(fakeTracker)
+	cl, close := newFakeTracker(t, f)
+	defer close()
+
+	w := New(cl, "Builder", "http://builder", "linux", "amd64", func() int { return 0 })
+	w.SetCapabilities(rpc.Capabilities{Toolchains: []string{"go1.0.3"}})
+	w.SetToken("id:secret")
+	if err := w.Announce(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Credential().Key != "key" {
+		t.Fatalf("Expected the issued credential. Got %+v", w.Credential())
+	}
+	if err := w.Remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	if a := f.announced; a.Type != "Builder" || a.URL != "http://builder" || a.Enrollment.Signature == "" || len(a.Capabilities.Toolchains) != 1 {
+		t.Fatalf("Invalid announce: %+v", a)
+	}
+	if f.removed == nil || f.removed.Kind != "Builder" || f.removed.Credential.Key != "key" {
+		t.Fatalf("Invalid remove: %+v", f.removed)
+	}
+}
+
+func TestLease(t *testing.T) {
+	f := new(fakeTracker)
+	cl, close := newFakeTracker(t, f)
+	defer close()
+
+	w := New(cl, "Builder", "http://builder", "linux", "amd64", func() int { return 0 })
+	tasks := make(chan rpc.BuilderTask)
+	go w.Lease(func(rep *rpc.LeaseReply, stop func()) {
+		stop()
+		tasks <- *rep.Builder
+	})
+
+	select {
+	case task := <-tasks:
+		if task.Lease != "lease" {
+			t.Fatalf("Invalid task: %+v", task)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a lease")
+	}
+	if w.Credential().Key != "key" {
+		t.Fatalf("Expected the enrolled credential. Got %+v", w.Credential())
+	}
+}
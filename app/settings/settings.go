@@ -0,0 +1,67 @@
+//package settings reads the configuration shared by the goci processes from
+//the environment.
+package settings
+
+import (
+	"crypto/tls"
+	"github.com/zeebo/goci/app/rpc/mtls"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//Env gets an environment variable with a default
+func Env(key, def string) (r string) {
+	if r = os.Getenv(key); r == "" {
+		r = def
+	}
+	return
+}
+
+//MustEnv returns the environment variable and panics if its empty
+func MustEnv(key string) (r string) {
+	if r = os.Getenv(key); r == "" {
+		panic("must specify env variable: " + key)
+	}
+	return
+}
+
+//List returns the comma separated list in the environment variable.
+func List(key string) (vs []string) {
+	for _, v := range strings.Split(Env(key, ""), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			vs = append(vs, v)
+		}
+	}
+	return
+}
+
+//QueuePath returns the path to the named journal in QUEUE_DIR, or the empty
+//string if the queue should only be kept in memory.
+func QueuePath(name string) string {
+	dir := Env("QUEUE_DIR", "")
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, name)
+}
+
+//QueueMax returns the maximum size of the queues from QUEUE_MAX.
+func QueueMax() int {
+	max, err := strconv.Atoi(Env("QUEUE_MAX", "0"))
+	if err != nil {
+		panic("invalid QUEUE_MAX: " + err.Error())
+	}
+	return max
+}
+
+//TLSConfig returns the mutual tls config from TLS_CERT, TLS_KEY and TLS_CA, or
+//nil if tls isn't configured.
+func TLSConfig() *tls.Config {
+	config, err := mtls.Load(Env("TLS_CERT", ""), Env("TLS_KEY", ""), Env("TLS_CA", ""))
+	if err != nil {
+		panic("invalid tls config: " + err.Error())
+	}
+	return config
+}
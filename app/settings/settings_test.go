@@ -0,0 +1,39 @@
+package settings
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	os.Setenv("GOCI_TEST_LIST", " go1.0.3, ,cgo,")
+	defer os.Setenv("GOCI_TEST_LIST", "")
+
+	if vs := List("GOCI_TEST_LIST"); !reflect.DeepEqual(vs, []string{"go1.0.3", "cgo"}) {
+		t.Fatalf("Invalid list: %q", vs)
+	}
+	if vs := List("GOCI_TEST_MISSING"); vs != nil {
+		t.Fatalf("Expected no values. Got %q", vs)
+	}
+}
+
+func TestQueue(t *testing.T) {
+	defer os.Setenv("QUEUE_DIR", os.Getenv("QUEUE_DIR"))
+	defer os.Setenv("QUEUE_MAX", os.Getenv("QUEUE_MAX"))
+
+	os.Setenv("QUEUE_DIR", "")
+	if p := QueuePath("builder.journal"); p != "" {
+		t.Fatalf("Expected an in memory queue. Got %q", p)
+	}
+	os.Setenv("QUEUE_DIR", "/var/goci")
+	if p := QueuePath("builder.journal"); p != filepath.Join("/var/goci", "builder.journal") {
+		t.Fatalf("Invalid queue path: %q", p)
+	}
+
+	os.Setenv("QUEUE_MAX", "10")
+	if max := QueueMax(); max != 10 {
+		t.Fatalf("Invalid queue max: %d", max)
+	}
+}
@@ -16,7 +16,7 @@ func init() {
 }
 
 func lease(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
-	b, r, err := tracker.LeasePair(ctx)
+	b, r, err := tracker.LeasePair(ctx, rpc.Work{}, false)
 	if err != nil {
 		e = httputil.Errorf(err, "error leasing pair")
 		return
@@ -37,11 +37,11 @@ func ping(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *ht
 
 func addwork(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
 	//create our little work item
-	// q := rpc.Work{
-	// 	Revision:    "8488aea525fb04d90328917112b30e5ec01f4895",
-	// 	ImportPath:  "github.com/zeebo/goci",
-	// 	Subpackages: true,
-	// }
+	//q := rpc.Work{
+	//	Revision:    "8488aea525fb04d90328917112b30e5ec01f4895",
+	//	ImportPath:  "github.com/zeebo/goci",
+	//	Subpackages: true,
+	//}
 
 	q := rpc.Work{
 		Revision:   "e9dd26552f10d390b5f9f59c6a9cfdc30ed1431c",
@@ -0,0 +1,339 @@
+package tracker
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/zeebo/goci/app/httputil"
+	"github.com/zeebo/goci/app/rpc"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"time"
+)
+
+//Config is the package level config for the tracker.
+var Config struct {
+	//RequireEnrollment makes services sign their announcements with an
+	//enrollment token issued by an admin even before any have been issued.
+	//Once an admin issues a token enrollment is always required.
+	RequireEnrollment bool
+}
+
+//enrollWindow is how far the time on a signed enrollment can be from ours.
+const enrollWindow = 5 * time.Minute
+
+//nonce records an enrollment signature the tracker has accepted so it can't be
+//replayed. They only need to be kept while the signature is in the window.
+type nonce struct {
+	ID      string    `bson:"_id"` //the token id and the nonce
+	Created time.Time //when the signature was accepted
+}
+
+//EnsureNonces sets up the nonces collection so that the record of accepted
+//enrollments expires once they are too old to be accepted again.
+func EnsureNonces(db *mgo.Database) (err error) {
+	err = db.C("Nonce").EnsureIndex(mgo.Index{
+		Key:         []string{"created"},
+		ExpireAfter: 2 * enrollWindow,
+	})
+	return
+}
+
+//localToken is the name of the token used by the workers that run inside the
+//app itself.
+const localToken = "local"
+
+//Token is an enrollment token issued by an admin that lets workers announce
+//themselves.
+type Token struct {
+	ID      bson.ObjectId `bson:"_id,omitempty"`
+	Name    string        //who the token was issued to
+	Secret  string        //the secret enrollments are signed with
+	Created time.Time     //when the token was issued
+}
+
+//String returns the token in the form workers are configured with.
+func (t Token) String() string {
+	return t.ID.Hex() + ":" + t.Secret
+}
+
+//Credential is the credential issued to an enrolled worker. Only a hash of the
+//secret is stored.
+type Credential struct {
+	ID      bson.ObjectId `bson:"_id"` //the key of the worker
+	Kind    string        //either "Builder" or "Runner"
+	Token   bson.ObjectId `bson:",omitempty"` //the token the worker enrolled with
+	Hash    string        //the hex encoded sha256 of the secret
+	Created time.Time     //when the credential was issued
+}
+
+//ErrBadCredential is returned when a request doesn't carry a valid credential.
+var ErrBadCredential = rpc.Error("invalid credential")
+
+//hashSecret returns the hex encoded sha256 of the secret.
+func hashSecret(secret string) string {
+	h := sha256.New()
+	h.Write([]byte(secret))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+//IssueToken creates a new enrollment token for the named worker and returns it
+//in the form the worker is configured with.
+func IssueToken(ctx httputil.Context, name string) (token string, err error) {
+	t := Token{
+		ID:      bson.NewObjectId(),
+		Name:    name,
+		Created: time.Now(),
+	}
+	if t.Secret, err = rpc.NewSecret(); err != nil {
+		return
+	}
+	if err = ctx.DB.C("Token").Insert(t); err != nil {
+		return
+	}
+	token = t.String()
+	return
+}
+
+//LocalToken returns the token for the workers that run inside the app, issuing
+//it the first time.
+func LocalToken(ctx httputil.Context) (token string, err error) {
+	var t Token
+	err = ctx.DB.C("Token").Find(bson.M{"name": localToken}).One(&t)
+	switch err {
+	case nil:
+		token = t.String()
+	case mgo.ErrNotFound:
+		token, err = IssueToken(ctx, localToken)
+	}
+	return
+}
+
+//Tokens returns all of the enrollment tokens sorted by when they were issued.
+func Tokens(ctx httputil.Context) (ts []Token, err error) {
+	err = ctx.DB.C("Token").Find(nil).Sort("created").All(&ts)
+	return
+}
+
+//Services returns all of the builders and runners announced to the tracker.
+func Services(ctx httputil.Context) (bs []Builder, rs []Runner, err error) {
+	if err = ctx.DB.C("Builder").Find(nil).Sort("url").All(&bs); err != nil {
+		return
+	}
+	err = ctx.DB.C("Runner").Find(nil).Sort("url").All(&rs)
+	return
+}
+
+//RemoveService removes the builder or runner with the given id from the tracker
+//along with its credential, so it can't heartbeat or lease work until it
+//enrolls again. Revoke the token it enrolled with to keep it out.
+func RemoveService(ctx httputil.Context, kind, id string) (err error) {
+	if !isEntity(kind) {
+		err = rpc.Errorf("kind is not Builder or Runner")
+		return
+	}
+	if !bson.IsObjectIdHex(id) {
+		err = rpc.Errorf("invalid service id: %q", id)
+		return
+	}
+	key := bson.ObjectIdHex(id)
+
+	if err = ctx.DB.C(kind).RemoveId(key); err != nil {
+		return
+	}
+	if err = ctx.DB.C("Credential").RemoveId(key); err == mgo.ErrNotFound {
+		err = nil
+	}
+	if err != nil {
+		return
+	}
+
+	ctx.Infof("Removed %s %s", kind, id)
+	return
+}
+
+//RevokeToken removes the enrollment token with the given id along with the
+//credentials of every worker that enrolled with it, and removes those workers
+//from the tracker.
+func RevokeToken(ctx httputil.Context, id string) (err error) {
+	if !bson.IsObjectIdHex(id) {
+		err = rpc.Errorf("invalid token id: %q", id)
+		return
+	}
+	tid := bson.ObjectIdHex(id)
+
+	//remove the token first so nothing can enroll with it while we clean up
+	if err = ctx.DB.C("Token").RemoveId(tid); err != nil {
+		return
+	}
+
+	var creds []Credential
+	if err = ctx.DB.C("Credential").Find(bson.M{"token": tid}).All(&creds); err != nil {
+		return
+	}
+	for _, c := range creds {
+		if err = ctx.DB.C("Credential").RemoveId(c.ID); err != nil {
+			return
+		}
+		//pull workers aren't in the tracker so don't worry if it's missing
+		if err = ctx.DB.C(c.Kind).RemoveId(c.ID); err == mgo.ErrNotFound {
+			err = nil
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	ctx.Infof("Revoked token %s and %d credentials", id, len(creds))
+	return
+}
+
+//enrollmentRequired reports if requests must be signed with an enrollment
+//token, which is once an admin has issued one.
+func enrollmentRequired(ctx httputil.Context) (req bool, err error) {
+	if Config.RequireEnrollment {
+		req = true
+		return
+	}
+	n, err := ctx.DB.C("Token").Find(bson.M{"name": bson.M{"$ne": localToken}}).Count()
+	req = n > 0
+	return
+}
+
+//checkEnrollment verifies the enrollment signed the request identified by
+//parts and returns the id of the token that signed it. Each signature is only
+//accepted once. An unsigned request is allowed unless enrollment is required.
+func checkEnrollment(ctx httputil.Context, e rpc.Enrollment, parts ...string) (id bson.ObjectId, err error) {
+	if e.Token == "" {
+		var req bool
+		if req, err = enrollmentRequired(ctx); err == nil && req {
+			err = rpc.Errorf("enrollment required")
+		}
+		return
+	}
+
+	if !bson.IsObjectIdHex(e.Token) {
+		err = rpc.Errorf("invalid enrollment token")
+		return
+	}
+
+	var t Token
+	err = ctx.DB.C("Token").FindId(bson.ObjectIdHex(e.Token)).One(&t)
+	if err == mgo.ErrNotFound {
+		err = rpc.Errorf("unknown enrollment token")
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	if d := time.Since(e.Time); d > enrollWindow || d < -enrollWindow {
+		err = rpc.Errorf("enrollment signed at %s is too old", e.Time)
+		return
+	}
+	if e.Nonce == "" || !e.Verify(t.Secret, parts...) {
+		err = rpc.Errorf("invalid enrollment signature")
+		return
+	}
+
+	//remember the nonce so the same signature can't be used again
+	err = ctx.DB.C("Nonce").Insert(nonce{
+		ID:      t.ID.Hex() + ":" + e.Nonce,
+		Created: time.Now(),
+	})
+	if mgo.IsDup(err) {
+		err = rpc.Errorf("enrollment signature already used")
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	id = t.ID
+	return
+}
+
+//issueCredential creates and stores a new credential for the worker.
+func issueCredential(ctx httputil.Context, key bson.ObjectId, kind string, token bson.ObjectId) (c rpc.Credential, err error) {
+	secret, err := rpc.NewSecret()
+	if err != nil {
+		return
+	}
+
+	cred := Credential{
+		ID:      key,
+		Kind:    kind,
+		Token:   token,
+		Hash:    hashSecret(secret),
+		Created: time.Now(),
+	}
+	if _, err = ctx.DB.C("Credential").UpsertId(key, cred); err != nil {
+		return
+	}
+
+	c = rpc.Credential{
+		Key:    key.Hex(),
+		Secret: secret,
+	}
+	return
+}
+
+//findCredential returns the stored credential with the key. It is a variable
+//so the tests can run without a database.
+var findCredential = func(ctx httputil.Context, key bson.ObjectId) (cred Credential, err error) {
+	err = ctx.DB.C("Credential").FindId(key).One(&cred)
+	return
+}
+
+//Authenticate makes sure the credential was issued by the tracker to a worker
+//of the given kind and hasn't been revoked, so that a Runner can't act as a
+//Builder. It returns ErrBadCredential if it isn't valid.
+func Authenticate(ctx httputil.Context, c rpc.Credential, kind string) (err error) {
+	if !bson.IsObjectIdHex(c.Key) {
+		err = ErrBadCredential
+		return
+	}
+
+	cred, err := findCredential(ctx, bson.ObjectIdHex(c.Key))
+	if err == mgo.ErrNotFound {
+		err = ErrBadCredential
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashSecret(c.Secret)), []byte(cred.Hash)) != 1 {
+		err = ErrBadCredential
+		return
+	}
+	if cred.Kind != kind {
+		err = ErrBadCredential
+	}
+	return
+}
+
+//Enroll issues a credential to a worker that leases work instead of
+//announcing itself.
+func (Tracker) Enroll(req *http.Request, args *rpc.EnrollArgs, rep *rpc.Credential) (err error) {
+	//wrap our error on the way out
+	defer rpc.Wrap(&err)
+
+	if !isEntity(args.Type) {
+		err = rpc.Errorf("unknown Type: %s", args.Type)
+		return
+	}
+
+	ctx := httputil.NewContext(req)
+	defer ctx.Close()
+	ctx.Infof("Got an enroll request from %s: %s %s", req.RemoteAddr, args.Type, args.Worker)
+
+	token, err := checkEnrollment(ctx, args.Enrollment, args.Parts()...)
+	if err != nil {
+		return
+	}
+
+	*rep, err = issueCredential(ctx, bson.NewObjectId(), args.Type, token)
+	return
+}
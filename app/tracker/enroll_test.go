@@ -0,0 +1,67 @@
+package tracker
+
+import (
+	"github.com/zeebo/goci/app/entities"
+	"github.com/zeebo/goci/app/httputil"
+	"github.com/zeebo/goci/app/rpc"
+	"labix.org/v2/mgo/bson"
+	"testing"
+)
+
+//stubCredential stands in for the database, storing a single credential of
+//the given kind.
+func stubCredential(kind string) (c rpc.Credential, restore func()) {
+	old := findCredential
+	id := bson.NewObjectId()
+	findCredential = func(ctx httputil.Context, key bson.ObjectId) (cred Credential, err error) {
+		if key != id {
+			err = ErrBadCredential
+			return
+		}
+		cred = Credential{ID: id, Kind: kind, Hash: hashSecret("secret")}
+		return
+	}
+	c = rpc.Credential{Key: id.Hex(), Secret: "secret"}
+	restore = func() { findCredential = old }
+	return
+}
+
+func TestAuthenticateKind(t *testing.T) {
+	cred, restore := stubCredential("Runner")
+	defer restore()
+
+	if err := Authenticate(httputil.Context{}, cred, "Runner"); err != nil {
+		t.Fatal(err)
+	}
+
+	//a runner can't lease builder work, which carries the auth for the repo
+	if err := Authenticate(httputil.Context{}, cred, "Builder"); err != ErrBadCredential {
+		t.Fatalf("runner leased builder work: %v", err)
+	}
+
+	bad := cred
+	bad.Secret = "wrong"
+	if err := Authenticate(httputil.Context{}, bad, "Runner"); err != ErrBadCredential {
+		t.Fatalf("bad secret authenticated: %v", err)
+	}
+}
+
+func TestLeaseAssertPhase(t *testing.T) {
+	ref := rpc.LeaseRef{
+		Key:   bson.NewObjectId().Hex(),
+		Lease: bson.NewObjectId().Hex(),
+		Kind:  "Runner",
+	}
+	_, assert, err := leaseAssert(&ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert["lease.phase"] != entities.LeasePhaseRun {
+		t.Fatalf("expected run phase. got %v", assert["lease.phase"])
+	}
+
+	ref.Kind = "Foo"
+	if _, _, err := leaseAssert(&ref); err == nil {
+		t.Fatal("expected an error for an unknown kind")
+	}
+}
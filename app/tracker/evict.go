@@ -0,0 +1,52 @@
+package tracker
+
+import (
+	"github.com/zeebo/goci/app/httputil"
+	"github.com/zeebo/goci/app/rpc/heartbeat"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"log"
+	"time"
+)
+
+const (
+	//staleTime is how long a service can go without a heartbeat before it is
+	//no longer handed out and can be evicted.
+	staleTime = 4 * heartbeat.Interval
+
+	//evictInterval is how often the Evictor looks for stale services.
+	evictInterval = time.Minute
+)
+
+//Evict removes all of the services that haven't sent a heartbeat within the
+//stale time from the tracker.
+func Evict(ctx httputil.Context) (err error) {
+	//services that never sent a heartbeat are stale too
+	sel := bson.M{
+		"lastseen": bson.M{"$not": bson.M{"$gte": time.Now().Add(-staleTime)}},
+	}
+	for _, kind := range []string{"Builder", "Runner"} {
+		var info *mgo.ChangeInfo
+		if info, err = ctx.DB.C(kind).RemoveAll(sel); err != nil {
+			return
+		}
+		if info.Removed > 0 {
+			ctx.Infof("Evicted %d stale %s services", info.Removed, kind)
+		}
+	}
+	return
+}
+
+//Evictor evicts stale services from the tracker forever. It should be run in
+//its own goroutine after the database has been configured.
+func Evictor() {
+	for {
+		<-time.After(evictInterval)
+
+		ctx := httputil.NewContext(nil)
+		if err := Evict(ctx); err != nil {
+			log.Printf("Error evicting stale services: %s", err)
+		}
+		ctx.Close()
+	}
+}
@@ -0,0 +1,441 @@
+package tracker
+
+import (
+	"github.com/zeebo/goci/app/accounts"
+	"github.com/zeebo/goci/app/entities"
+	"github.com/zeebo/goci/app/httputil"
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/app/rpc/router"
+	"labix.org/v2/mgo/bson"
+	"labix.org/v2/mgo/txn"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const (
+	//leaseTime is how long a lease lasts without being extended.
+	leaseTime = 2 * time.Minute
+
+	//leaseCandidates is how many work items Lease tries to acquire before
+	//giving up on losing races.
+	leaseCandidates = 10
+)
+
+//verifyLease makes sure the lease arguments are specified correctly.
+func verifyLease(args *rpc.LeaseArgs) (err error) {
+	switch {
+	case args.GOARCH == "":
+		err = rpc.Errorf("GOARCH unspecified")
+	case args.GOOS == "":
+		err = rpc.Errorf("GOOS unspecified")
+	case !isEntity(args.Type):
+		err = rpc.Errorf("unknown Type: %s", args.Type)
+	}
+	return
+}
+
+//leaseSelector returns the query for work items that the worker described by
+//args can lease.
+func leaseSelector(args *rpc.LeaseArgs, now time.Time) (sel bson.M) {
+	type L []interface{}
+
+	//a processing item whose lease in the phase expired can be taken over
+	expired := func(phase string) bson.M {
+		return bson.M{
+			"status":         entities.WorkStatusProcessing,
+			"lease.phase":    phase,
+			"lease.deadline": bson.M{"$lt": now},
+		}
+	}
+
+	//every required label has to be one of the labels of the worker
+	labels := L{}
+	for _, l := range args.Labels {
+		labels = append(labels, l)
+	}
+	covered := bson.M{"$not": bson.M{"$elemMatch": bson.M{"$nin": labels}}}
+
+	switch args.Type {
+	case "Builder":
+		//empty and missing toolchains mean any toolchain will do
+		toolchains := L{"", nil}
+		for _, t := range args.Toolchains {
+			toolchains = append(toolchains, t)
+		}
+		sel = bson.M{
+			"$or": L{
+				bson.M{"status": entities.WorkStatusWaiting},
+				expired(entities.LeasePhaseBuild),
+			},
+			"work.toolchain": bson.M{"$in": toolchains},
+			"work.requires":  covered,
+		}
+	case "Runner":
+		sel = bson.M{
+			"$or": L{
+				bson.M{"status": entities.WorkStatusBuilt},
+				expired(entities.LeasePhaseRun),
+			},
+			"goos":          args.GOOS,
+			"goarch":        args.GOARCH,
+			"work.requires": covered,
+			"requires":      covered,
+		}
+	default:
+		panic("unreachable")
+	}
+	return
+}
+
+//Lease atomically claims the next work item the worker can handle. If there is
+//no work available, the reply has no task in it. The lease must be extended
+//before the deadline in the reply or another worker may claim the work item.
+func (Tracker) Lease(req *http.Request, args *rpc.LeaseArgs, rep *rpc.LeaseReply) (err error) {
+	//wrap our error on the way out
+	defer rpc.Wrap(&err)
+
+	if err = verifyLease(args); err != nil {
+		return
+	}
+
+	ctx := httputil.NewContext(req)
+	defer ctx.Close()
+
+	if err = Authenticate(ctx, args.Credential, args.Type); err != nil {
+		return
+	}
+
+	now := time.Now()
+	iter := ctx.DB.C("Work").
+		Find(leaseSelector(args, now)).
+		Sort("created").
+		Limit(leaseCandidates).
+		Iter()
+
+	for {
+		//decode into a fresh value so fields don't leak between items
+		var work entities.Work
+		if !iter.Next(&work) {
+			break
+		}
+
+		//leave it for the dispatcher to give up on
+		if len(work.AttemptLog) >= entities.WorkMaxAttempts {
+			continue
+		}
+
+		var ok bool
+		if ok, err = acquireLease(ctx, args, work, now, req.TLS != nil, rep); err != nil || ok {
+			iter.Close()
+			return
+		}
+	}
+
+	err = iter.Close()
+	return
+}
+
+//acquireLease attempts to lease the work item to the worker, filling in the
+//reply if it succeeds. It reports false if it lost the race for the item, or if
+//the item needs credentials and the worker didn't connect over tls.
+func acquireLease(ctx httputil.Context, args *rpc.LeaseArgs, work entities.Work, now time.Time, secure bool, rep *rpc.LeaseReply) (ok bool, err error) {
+	ctx.Key = work.ID.Hex()
+	lease := entities.WorkLease{
+		ID:       bson.NewObjectId(),
+		Worker:   args.Worker,
+		Deadline: now.Add(leaseTime),
+	}
+
+	var set bson.M
+	var auth *rpc.Auth
+	switch args.Type {
+	case "Builder":
+		lease.Phase = entities.LeasePhaseBuild
+
+		//only the builder downloading a private project gets its credentials
+		if auth, err = accounts.Auth(ctx, work.Work.ImportPath); err != nil {
+			return
+		}
+		if auth != nil && !secure {
+			ctx.Infof("Not leasing private work item %s to %s without tls", work.ID, args.Worker)
+			return
+		}
+
+		//a build is a new attempt with a secret for signing the responses
+		a := entities.WorkAttempt{
+			ID:      bson.NewObjectId(),
+			When:    now,
+			Builder: args.Worker,
+		}
+		if a.Secret, err = rpc.NewSecret(); err != nil {
+			return
+		}
+		work.AttemptLog = append([]entities.WorkAttempt{a}, work.AttemptLog...)
+
+		set = bson.M{
+			"attemptlog": work.AttemptLog,
+			"goos":       args.GOOS,
+			"goarch":     args.GOARCH,
+		}
+	case "Runner":
+		lease.Phase = entities.LeasePhaseRun
+
+		//a run finishes the attempt started by the build
+		set = bson.M{"attemptlog.0.runner": args.Worker}
+	}
+	set["status"] = entities.WorkStatusProcessing
+	set["lease"] = lease
+
+	ops := []txn.Op{{
+		C:  "Work",
+		Id: work.ID,
+		Assert: bson.M{
+			"revision": work.Revision,
+		},
+		Update: bson.M{
+			"$inc": bson.M{"revision": 1},
+			"$set": set,
+		},
+	}}
+
+	err = ctx.R.Run(ops, bson.NewObjectId(), nil)
+	if err == txn.ErrAborted {
+		ctx.Infof("Lost the race leasing work item %s", work.ID)
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	ok = true
+
+	ctx.Infof("Leased work item %s to %s %s", work.ID, args.Type, args.Worker)
+
+	//build the task for the worker
+	rep.Deadline = lease.Deadline
+	switch args.Type {
+	case "Builder":
+		rep.Builder = &rpc.BuilderTask{
+			Work:     work.Work,
+			Key:      work.ID.Hex(),
+			ID:       work.AttemptLog[0].ID.Hex(),
+			WorkRev:  work.Revision + 1,
+			Response: httputil.Absolute(router.Lookup("Response")),
+			Lease:    lease.ID.Hex(),
+			Secret:   work.AttemptLog[0].Secret,
+			Since:    LastTested(ctx, work.Work.ImportPath),
+			Auth:     auth,
+		}
+	case "Runner":
+		task := *work.Built
+		task.WorkRev = work.Revision + 1
+		task.Lease = lease.ID.Hex()
+		rep.Runner = &task
+	}
+
+	return
+}
+
+//LastTested returns the most recently committed revision of the import path
+//that was tested, or the empty string if it hasn't been.
+func LastTested(ctx httputil.Context, importPath string) (rev string) {
+	var res entities.TestResult
+	sel := bson.M{"importpath": bson.RegEx{Pattern: "^" + regexp.QuoteMeta(importPath) + "(/|$)"}}
+	err := ctx.DB.C("TestResult").Find(sel).Select(bson.M{"revision": 1}).Sort("-revdate").One(&res)
+	if err == nil {
+		rev = res.Revision
+	}
+	return
+}
+
+//leasePhases maps the kind of a worker to the phase of the leases it holds.
+var leasePhases = map[string]string{
+	"Builder": entities.LeasePhaseBuild,
+	"Runner":  entities.LeasePhaseRun,
+}
+
+//leaseAssert returns the id of the work item and an assertion that the lease
+//on it is held by a worker of the kind in the reference.
+func leaseAssert(args *rpc.LeaseRef) (id bson.ObjectId, assert bson.M, err error) {
+	if !bson.IsObjectIdHex(args.Key) || !bson.IsObjectIdHex(args.Lease) {
+		err = rpc.Errorf("invalid lease: %s %s", args.Key, args.Lease)
+		return
+	}
+	if !isEntity(args.Kind) {
+		err = rpc.Errorf("kind is not Builder or Runner")
+		return
+	}
+	id = bson.ObjectIdHex(args.Key)
+	assert = bson.M{
+		"status":      entities.WorkStatusProcessing,
+		"lease.id":    bson.ObjectIdHex(args.Lease),
+		"lease.phase": leasePhases[args.Kind],
+	}
+	return
+}
+
+//Extend pushes back the deadline of a lease so that the worker can keep
+//working on the item. It returns an error if the lease is no longer held.
+func (Tracker) Extend(req *http.Request, args *rpc.LeaseRef, rep *rpc.ExtendReply) (err error) {
+	//wrap our error on the way out
+	defer rpc.Wrap(&err)
+
+	id, assert, err := leaseAssert(args)
+	if err != nil {
+		return
+	}
+
+	ctx := httputil.NewContext(req)
+	defer ctx.Close()
+	ctx.Key = args.Key
+
+	if err = Authenticate(ctx, args.Credential, args.Kind); err != nil {
+		return
+	}
+
+	//the revision is left alone so the tasks handed out stay valid
+	deadline := time.Now().Add(leaseTime)
+	ops := []txn.Op{{
+		C:      "Work",
+		Id:     id,
+		Assert: assert,
+		Update: bson.M{
+			"$set": bson.M{"lease.deadline": deadline},
+		},
+	}}
+
+	err = ctx.R.Run(ops, bson.NewObjectId(), nil)
+	if err == txn.ErrAborted {
+		err = rpc.Errorf("lease %s on %s is no longer held", args.Lease, args.Key)
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	rep.Deadline = deadline
+	return
+}
+
+//Release gives up a lease before it expires so the work item can be leased by
+//another worker. A released build does not count as an attempt.
+func (Tracker) Release(req *http.Request, args *rpc.LeaseRef, rep *rpc.None) (err error) {
+	//wrap our error on the way out
+	defer rpc.Wrap(&err)
+
+	id, assert, err := leaseAssert(args)
+	if err != nil {
+		return
+	}
+
+	ctx := httputil.NewContext(req)
+	defer ctx.Close()
+	ctx.Key = args.Key
+	ctx.Infof("Got a release request from %s: %s %s", req.RemoteAddr, args.Key, args.Lease)
+
+	if err = Authenticate(ctx, args.Credential, args.Kind); err != nil {
+		return
+	}
+
+	var work entities.Work
+	if err = ctx.DB.C("Work").FindId(id).One(&work); err != nil {
+		return
+	}
+
+	//put it back to where it was before it was leased
+	update := bson.M{
+		"$inc":   bson.M{"revision": 1},
+		"$unset": bson.M{"lease": 1},
+	}
+	switch work.Lease.Phase {
+	case entities.LeasePhaseBuild:
+		update["$set"] = bson.M{"status": entities.WorkStatusWaiting}
+		update["$pop"] = bson.M{"attemptlog": -1}
+	case entities.LeasePhaseRun:
+		update["$set"] = bson.M{"status": entities.WorkStatusBuilt}
+	}
+	assert["revision"] = work.Revision
+
+	ops := []txn.Op{{
+		C:      "Work",
+		Id:     id,
+		Assert: assert,
+		Update: update,
+	}}
+
+	err = ctx.R.Run(ops, bson.NewObjectId(), nil)
+	if err == txn.ErrAborted {
+		ctx.Infof("Lost the race releasing work item %s", work.ID)
+		err = nil
+	}
+	return
+}
+
+//requires returns the labels the configs of the tests in the task require from
+//the runner.
+func requires(task rpc.RunnerTask) (labels []string) {
+	seen := map[string]bool{}
+	for _, test := range task.Tests {
+		for _, l := range test.Config.Requires {
+			if !seen[l] {
+				seen[l] = true
+				labels = append(labels, l)
+			}
+		}
+	}
+	return
+}
+
+//Built is called by a Builder that leased a work item to hand off the built
+//task to be leased by a Runner.
+func (Tracker) Built(req *http.Request, args *rpc.BuiltArgs, rep *rpc.None) (err error) {
+	//wrap our error on the way out
+	defer rpc.Wrap(&err)
+
+	id, assert, err := leaseAssert(&rpc.LeaseRef{Key: args.Task.Key, Lease: args.Lease, Kind: "Builder"})
+	if err != nil {
+		return
+	}
+	if !bson.IsObjectIdHex(args.Task.ID) {
+		err = rpc.Errorf("invalid attempt id: %q", args.Task.ID)
+		return
+	}
+
+	ctx := httputil.NewContext(req)
+	defer ctx.Close()
+	ctx.Key = args.Task.Key
+	ctx.Infof("Got a built request from %s: %s", req.RemoteAddr, args.Task.Key)
+
+	if err = Authenticate(ctx, args.Credential, "Builder"); err != nil {
+		return
+	}
+
+	//make sure the task is from the current attempt
+	assert["attemptlog.0.id"] = bson.ObjectIdHex(args.Task.ID)
+	assert["revision"] = args.Task.WorkRev
+
+	//the runner gets a new lease when it claims the task
+	task := args.Task
+	task.Lease = ""
+	ops := []txn.Op{{
+		C:      "Work",
+		Id:     id,
+		Assert: assert,
+		Update: bson.M{
+			"$inc": bson.M{"revision": 1},
+			"$set": bson.M{
+				"status":   entities.WorkStatusBuilt,
+				"built":    task,
+				"requires": requires(task),
+			},
+			"$unset": bson.M{"lease": 1},
+		},
+	}}
+
+	err = ctx.R.Run(ops, bson.NewObjectId(), nil)
+	if err == txn.ErrAborted {
+		err = rpc.Errorf("lease %s on %s is no longer held", args.Lease, args.Task.Key)
+	}
+	return
+}
@@ -9,16 +9,10 @@ import (
 	"github.com/zeebo/goci/app/rpc/client"
 	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
-	"math/rand"
 	"net/http"
-	"sync"
 	"time"
 )
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
-}
-
 //Tracker is an rpc for announcing and managing the presence of services.
 type Tracker struct {
 	pinger.Pinger
@@ -63,55 +57,115 @@ func (Tracker) Announce(req *http.Request, args *rpc.AnnounceArgs, rep *rpc.Anno
 
 	ctx := httputil.NewContext(req)
 	defer ctx.Close()
-	ctx.Infof("Got announce request from %s: %+v", req.RemoteAddr, args)
+	ctx.Infof("Got announce request from %s: %s %s %s/%s", req.RemoteAddr, args.Type, args.URL, args.GOOS, args.GOARCH)
+
+	//make sure an admin let the service in
+	token, err := checkEnrollment(ctx, args.Enrollment, args.Parts()...)
+	if err != nil {
+		return
+	}
 
 	//ping them to make sure we can make valid rpc calls
-	cl := client.New(args.URL, http.DefaultClient, client.JsonCodec)
+	cl := client.New(args.URL, client.Default(), client.JsonCodec)
 	err = cl.Call("Pinger.Ping", nil, new(rpc.None))
 	if err != nil {
 		ctx.Infof("Failed to Ping the announce.")
 		return
 	}
 
+	key, fresh, err := announceKey(ctx, args)
+	if err != nil {
+		return
+	}
+
 	//create the entity
 	var e interface{}
-
-	//make sure we have a nonzero seed
-	var seed int64
-	for seed == 0 {
-		seed = rand.Int63()
-	}
-	key := bson.NewObjectId()
+	now := time.Now()
 	switch args.Type {
 	case "Builder":
 		e = &Builder{
-			ID:     key,
-			GOOS:   args.GOOS,
-			GOARCH: args.GOARCH,
-			URL:    args.URL,
-			Seed:   seed,
+			ID:           key,
+			GOOS:         args.GOOS,
+			GOARCH:       args.GOARCH,
+			URL:          args.URL,
+			Capabilities: args.Capabilities,
+			LastSeen:     now,
 		}
 	case "Runner":
 		e = &Runner{
-			ID:     key,
-			GOOS:   args.GOOS,
-			GOARCH: args.GOARCH,
-			URL:    args.URL,
-			Seed:   seed,
+			ID:           key,
+			GOOS:         args.GOOS,
+			GOARCH:       args.GOARCH,
+			URL:          args.URL,
+			Capabilities: args.Capabilities,
+			LastSeen:     now,
 		}
 	default:
 		panic("unreachable")
 	}
 
-	//TODO(zeebo): check if we have the URL already and grab that key to update
+	//save the service in the database, replacing any previous announce
+	if _, err = ctx.DB.C(args.Type).UpsertId(key, e); err != nil {
+		return
+	}
 
-	//save the service in the database
-	if err = ctx.DB.C(args.Type).Insert(e); err != nil {
+	//services announcing again keep the credential they have
+	if !fresh {
+		rep.Credential = args.Credential
 		return
 	}
+	rep.Credential, err = issueCredential(ctx, key, args.Type, token)
+	return
+}
 
-	//return the hex representation of the key
-	rep.Key = key.Hex()
+//announceKey returns the key to store the announced service under and if it is
+//a fresh key. Services announcing again with their credential, like after
+//being evicted, keep the key they had before. Otherwise any service previously
+//announced at the same url is replaced.
+func announceKey(ctx httputil.Context, args *rpc.AnnounceArgs) (key bson.ObjectId, fresh bool, err error) {
+	if args.Credential.Key != "" {
+		if err = Authenticate(ctx, args.Credential, args.Type); err != nil {
+			return
+		}
+		key = bson.ObjectIdHex(args.Credential.Key)
+		return
+	}
+
+	//clear out whatever was announced at the url before
+	if _, err = ctx.DB.C(args.Type).RemoveAll(bson.M{"url": args.URL}); err != nil {
+		return
+	}
+	key, fresh = bson.NewObjectId(), true
+	return
+}
+
+//Heartbeat records that a service is still alive. If the service has been
+//evicted, the reply tells it to announce itself again.
+func (Tracker) Heartbeat(req *http.Request, args *rpc.HeartbeatArgs, rep *rpc.HeartbeatReply) (err error) {
+	//wrap our error on the way out
+	defer rpc.Wrap(&err)
+
+	if !isEntity(args.Kind) {
+		err = rpc.Errorf("kind is not Builder or Runner")
+		return
+	}
+
+	ctx := httputil.NewContext(req)
+	defer ctx.Close()
+
+	if err = Authenticate(ctx, args.Credential, args.Kind); err != nil {
+		return
+	}
+
+	//the reported load replaces any load we estimated when handing it out
+	key := bson.ObjectIdHex(args.Credential.Key)
+	err = ctx.DB.C(args.Kind).UpdateId(key, bson.M{
+		"$set": bson.M{"lastseen": time.Now(), "load": args.Load},
+	})
+	if err == mgo.ErrNotFound {
+		ctx.Infof("Heartbeat from evicted %s %s", args.Kind, key.Hex())
+		rep.Evicted, err = true, nil
+	}
 	return
 }
 
@@ -123,10 +177,7 @@ func (Tracker) Remove(req *http.Request, args *rpc.RemoveArgs, rep *rpc.None) (e
 	//create our context
 	ctx := httputil.NewContext(req)
 	defer ctx.Close()
-	ctx.Infof("Got a remove request from %s: %+v", req.RemoteAddr, args)
-
-	//get the key from the argument
-	key := bson.ObjectIdHex(args.Key)
+	ctx.Infof("Got a remove request from %s: %s %s", req.RemoteAddr, args.Kind, args.Credential.Key)
 
 	//make sure its an entity
 	if !isEntity(args.Kind) {
@@ -134,8 +185,21 @@ func (Tracker) Remove(req *http.Request, args *rpc.RemoveArgs, rep *rpc.None) (e
 		return
 	}
 
-	//remove it from the database
+	//only the service itself can remove itself
+	if err = Authenticate(ctx, args.Credential, args.Kind); err != nil {
+		return
+	}
+
+	//get the key from the argument
+	key := bson.ObjectIdHex(args.Credential.Key)
+
+	//remove it and its credential from the database. it may have already been
+	//evicted.
 	err = ctx.DB.C(args.Kind).Remove(bson.M{"_id": key})
+	if err != nil && err != mgo.ErrNotFound {
+		return
+	}
+	err = ctx.DB.C("Credential").RemoveId(key)
 	return
 }
 
@@ -145,9 +209,17 @@ type Builder struct {
 
 	GOOS, GOARCH string
 	URL          string
+	Capabilities rpc.Capabilities `bson:",inline"`
+
+	//Load is the number of tasks the builder has, as of its last heartbeat
+	//plus the tasks handed to it since then
+	Load int
 
-	//Seed is used to distribute work among builders
-	Seed int64
+	//Leased is when the builder was last handed a task
+	Leased time.Time
+
+	//LastSeen is when the builder last announced or sent a heartbeat
+	LastSeen time.Time
 }
 
 //Runner is an entity that represents a runner in the tracker.
@@ -156,22 +228,35 @@ type Runner struct {
 
 	GOOS, GOARCH string
 	URL          string
+	Capabilities rpc.Capabilities `bson:",inline"`
+
+	//Load is the number of tasks the runner has, as of its last heartbeat plus
+	//the tasks handed to it since then
+	Load int
+
+	//Leased is when the runner was last handed a task
+	Leased time.Time
 
-	//Seed is used to distribute work among builders
-	Seed int64
+	//LastSeen is when the runner last announced or sent a heartbeat
+	LastSeen time.Time
 }
 
 //ErrNoneAvailable is the error that Lease returns if there are no available
 //services matching the criteri
 var ErrNoneAvailable = errors.New("no services available")
 
-func baseQuery(db *mgo.Database, GOOS, GOARCH, Type string, Seed int64) (q *mgo.Query) {
+//baseQuery returns the filters for live services of the given type that are
+//able to handle the work and run on the given GOOS and GOARCH.
+func baseQuery(GOOS, GOARCH, Type string, work rpc.Work) (filters bson.M) {
 	//check for programmer errors
 	if !isEntity(Type) {
 		panic("type not an entity: " + Type)
 	}
 
-	filters := bson.M{}
+	//only consider services that are still sending heartbeats
+	filters = bson.M{
+		"lastseen": bson.M{"$gte": time.Now().Add(-staleTime)},
+	}
 	//filter on GOOS and GOARCH if they are set
 	if GOOS != "" {
 		filters["goos"] = GOOS
@@ -179,116 +264,82 @@ func baseQuery(db *mgo.Database, GOOS, GOARCH, Type string, Seed int64) (q *mgo.
 	if GOARCH != "" {
 		filters["goarch"] = GOARCH
 	}
-	//if we have a Seed value make sure we get one greater than it
-	if Seed > 0 {
-		filters["seed"] = bson.M{"$gt": Seed}
+	//the service must have every label the work requires
+	if len(work.Requires) > 0 {
+		filters["labels"] = bson.M{"$all": work.Requires}
+	}
+	//only builders care about the toolchain
+	if Type == "Builder" && work.Toolchain != "" {
+		filters["toolchains"] = work.Toolchain
 	}
 
-	//set up the base query
-	q = db.C(Type).Find(filters).Limit(1).Sort("seed")
-
-	return
-}
-
-//seeds is a locked map of strings to seed values.
-type seeds struct {
-	c map[string]int64
-	sync.Mutex
-}
-
-//lastSeeds is a mapping of entity types to the last seed value seen of that
-//type so that we attempt to distribute load across the services.
-var lastSeeds = &seeds{c: map[string]int64{}}
-
-//key returns the key used in the map for the set of constrains.
-func (s *seeds) key(GOOS, GOARCH, Type string) string {
-	return GOOS + "," + GOARCH + "," + Type
-}
-
-//get looks up the cached seed value for the given set of constraints.
-func (s *seeds) get(GOOS, GOARCH, Type string) (r int64) {
-	s.Lock()
-	defer s.Unlock()
-
-	r = s.c[s.key(GOOS, GOARCH, Type)]
 	return
 }
 
-//set sets the cached seed value for the given set of constraints.
-func (s *seeds) set(GOOS, GOARCH, Type string, v int64) {
-	s.Lock()
-	defer s.Unlock()
-
-	s.c[s.key(GOOS, GOARCH, Type)] = v
-	return
-}
+//getService is a helper function that abstracts the logic of grabbing the
+//least loaded service matching the filters. The load of the service is
+//incremented in the database so that concurrent dispatchers, even in other
+//app instances, spread their work out. Ties go to the service that has gone
+//the longest without being handed a task.
+func getService(ctx httputil.Context, Type string, filters bson.M, s interface{}) (err error) {
+	ctx.Infof("Finding a %v: %v", Type, filters)
+
+	change := mgo.Change{
+		Update: bson.M{
+			"$inc": bson.M{"load": 1},
+			"$set": bson.M{"leased": time.Now()},
+		},
+		ReturnNew: true,
+	}
+	_, err = ctx.DB.C(Type).Find(filters).Sort("load", "leased").Apply(change, s)
 
-//getService is a helper function that abstracts the logic of grabbing a service
-//with a key greater than the one given, and looping back to zero if one wasn't
-//found.
-func getService(ctx httputil.Context, GOOS, GOARCH, Type string, s interface{}) (err error) {
-	//grab the most recent run key
-	seed := lastSeeds.get(GOOS, GOARCH, Type)
-again:
-	ctx.Infof("Finding a %v/%v/%v [%d]", Type, GOOS, GOARCH, seed)
-	//run the query
-	query := baseQuery(ctx.DB, GOOS, GOARCH, Type, seed)
-	err = query.One(s)
-
-	//if we didn't find a match
+	//there just arent any
 	if err == mgo.ErrNotFound {
-
-		//try again if we're limiting on the seed
-		if seed > 0 {
-			seed = 0
-			goto again
-		}
-
-		//there just arent any
 		err = ErrNoneAvailable
 	}
 
 	return
 }
 
-//getRunner grabs a runner from the set of runners matching the given criteria
-//in a fashion that attempts to distribute the workload.
-func getRunner(ctx httputil.Context, GOOS, GOARCH string) (r *Runner, err error) {
-	r = new(Runner)
-	err = getService(ctx, GOOS, GOARCH, "Runner", r)
-	return
-}
-
-//getBuilder grabs a builder from the set of runners matching the given criteria
-//in a fashion that attempts to distribute the workload.
-func getBuilder(ctx httputil.Context, GOOS, GOARCH string) (b *Builder, err error) {
-	b = new(Builder)
-	err = getService(ctx, GOOS, GOARCH, "Builder", b)
-	return
+//putService gives back the load that getService took on a service that we
+//ended up not handing a task to.
+func putService(ctx httputil.Context, Type string, id bson.ObjectId) {
+	err := ctx.DB.C(Type).UpdateId(id, bson.M{"$inc": bson.M{"load": -1}})
+	if err != nil {
+		ctx.Infof("couldn't give back load on %s %s: %s", Type, id, err)
+	}
 }
 
-//LeasePair returns a pair of Builder and Runners that can be used to run tests.
-//It doesn't let you specify the type of runner you want.
-func LeasePair(ctx httputil.Context) (b *Builder, r *Runner, err error) {
+//LeasePair returns the least loaded pair of Builder and Runner that are able to
+//handle the work. The Builder builds for the GOOS and GOARCH of the Runner. If
+//secure is set the Builder must be reached over https, because the task for it
+//carries the credentials of a private project.
+func LeasePair(ctx httputil.Context, work rpc.Work, secure bool) (b *Builder, r *Runner, err error) {
 	//grab a runner
-	r, err = getRunner(ctx, "", "")
-	if err != nil {
+	r = new(Runner)
+	if err = getService(ctx, "Runner", baseQuery("", "", "Runner", work), r); err != nil {
 		ctx.Infof("couldn't lease runner")
 		return
 	}
 
-	//update the key we're using
-	lastSeeds.set("", "", "Runner", r.Seed)
-
 	//grab a builder than can make a build for this runner
-	b, err = getBuilder(ctx, r.GOOS, r.GOARCH)
-	if err != nil {
+	filters := baseQuery(r.GOOS, r.GOARCH, "Builder", work)
+	if secure {
+		filters["url"] = bson.M{"$regex": "^https://"}
+	}
+	b = new(Builder)
+	if err = getService(ctx, "Builder", filters, b); err != nil {
 		ctx.Infof("couldn't lease builder")
+		putService(ctx, "Runner", r.ID)
 		return
 	}
 
-	//update the key we're using
-	lastSeeds.set(r.GOOS, r.GOARCH, "Builder", b.Seed)
-
 	return
 }
+
+//ReleasePair gives back a pair returned by LeasePair that wasn't handed a task,
+//so that they don't look busier than they are until their next heartbeat.
+func ReleasePair(ctx httputil.Context, b *Builder, r *Runner) {
+	putService(ctx, "Builder", b.ID)
+	putService(ctx, "Runner", r.ID)
+}
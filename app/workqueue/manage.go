@@ -0,0 +1,125 @@
+package workqueue
+
+import (
+	"errors"
+	"github.com/zeebo/goci/app/entities"
+	"github.com/zeebo/goci/app/httputil"
+	"labix.org/v2/mgo/bson"
+	"labix.org/v2/mgo/txn"
+	"time"
+)
+
+var (
+	//ErrCompleted is returned when changing a work item that has completed.
+	ErrCompleted = errors.New("work item already completed")
+
+	//ErrChanged is returned when a work item changes while it is being changed.
+	ErrChanged = errors.New("work item changed while updating it")
+)
+
+//setStatus sets the status of a work item that hasn't completed and drops its
+//lease and any task built for it, so responses for the attempts already handed
+//out are rejected.
+func setStatus(ctx httputil.Context, key bson.ObjectId, status string) (err error) {
+	var work entities.Work
+	if err = ctx.DB.C("Work").FindId(key).One(&work); err != nil {
+		return
+	}
+	if work.Status == entities.WorkStatusCompleted {
+		err = ErrCompleted
+		return
+	}
+
+	ops := []txn.Op{{
+		C:  "Work",
+		Id: key,
+		Assert: bson.M{
+			"revision": work.Revision,
+		},
+		Update: bson.M{
+			"$inc":   bson.M{"revision": 1},
+			"$set":   bson.M{"status": status},
+			"$unset": bson.M{"lease": 1, "built": 1, "requires": 1},
+		},
+	}}
+
+	err = ctx.R.Run(ops, bson.NewObjectId(), nil)
+	if err == txn.ErrAborted {
+		err = ErrChanged
+	}
+	return
+}
+
+//Requeue puts a work item that hasn't completed, like one stuck processing or
+//one that was canceled, back in the queue to be dispatched right away. The
+//attempts it already had still count towards WorkMaxAttempts.
+func Requeue(ctx httputil.Context, key bson.ObjectId) (err error) {
+	if err = setStatus(ctx, key, entities.WorkStatusWaiting); err != nil {
+		return
+	}
+	ctx.Infof("Requeued work item %s", key.Hex())
+	dispatch()
+	return
+}
+
+//Cancel stops a work item that hasn't completed from being dispatched or leased
+//again.
+func Cancel(ctx httputil.Context, key bson.ObjectId) (err error) {
+	if err = setStatus(ctx, key, entities.WorkStatusCanceled); err != nil {
+		return
+	}
+	ctx.Infof("Canceled work item %s", key.Hex())
+	return
+}
+
+//Latest returns the most recently created work item for the import path that
+//has completed.
+func Latest(ctx httputil.Context, importPath string) (work *entities.Work, err error) {
+	sel := bson.M{
+		"work.importpath": importPath,
+		"status":          entities.WorkStatusCompleted,
+	}
+	err = ctx.DB.C("Work").Find(sel).Sort("-created").One(&work)
+	return
+}
+
+//Counts returns how many work items have each status.
+func Counts(ctx httputil.Context) (counts map[string]int, err error) {
+	statuses := []string{
+		entities.WorkStatusWaiting,
+		entities.WorkStatusProcessing,
+		entities.WorkStatusBuilt,
+		entities.WorkStatusCompleted,
+		entities.WorkStatusCanceled,
+	}
+	counts = map[string]int{}
+	for _, status := range statuses {
+		if counts[status], err = ctx.DB.C("Work").Find(bson.M{"status": status}).Count(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+//Active returns the work items that are being built or run, oldest first.
+func Active(ctx httputil.Context) (work []entities.Work, err error) {
+	sel := bson.M{"status": bson.M{"$in": []string{
+		entities.WorkStatusProcessing,
+		entities.WorkStatusBuilt,
+	}}}
+	err = ctx.DB.C("Work").Find(sel).Select(bson.M{"data": 0, "built": 0}).Sort("created").All(&work)
+	return
+}
+
+//Stuck returns if an active work item's latest attempt has gone on longer than
+//attempts are given. It is either waiting to be dispatched again or held by a
+//worker that keeps extending its lease.
+func Stuck(work entities.Work, now time.Time) bool {
+	switch {
+	case work.Status != entities.WorkStatusProcessing && work.Status != entities.WorkStatusBuilt:
+		return false
+	case len(work.AttemptLog) == 0:
+		return true
+	}
+	return work.AttemptLog[0].When.Before(now.Add(-attemptTime))
+}
@@ -2,6 +2,8 @@
 package workqueue
 
 import (
+	"context"
+	"github.com/zeebo/goci/app/accounts"
 	"github.com/zeebo/goci/app/entities"
 	"github.com/zeebo/goci/app/httputil"
 	"github.com/zeebo/goci/app/rpc"
@@ -28,11 +30,27 @@ type Distiller interface {
 	Distill() (rpc.Work, string)
 }
 
+//Rerun distills a work item into a new one with the same work and data.
+type Rerun struct {
+	*entities.Work
+}
+
+func (r Rerun) Distill() (rpc.Work, string) {
+	return r.Work.Work, r.Data
+}
+
 //QueueWork takes a Distiller and adds it into the work queue.
 func QueueWork(ctx httputil.Context, d Distiller) (err error) {
+	_, err = Queue(ctx, d)
+	return
+}
+
+//Queue takes a Distiller, adds it into the work queue and returns the work item
+//it was stored as.
+func Queue(ctx httputil.Context, d Distiller) (q *entities.Work, err error) {
 	//distill and create our work item
 	work, data := d.Distill()
-	q := &entities.Work{
+	q = &entities.Work{
 		ID:      bson.NewObjectId(),
 		Work:    work,
 		Data:    data,
@@ -45,30 +63,36 @@ func QueueWork(ctx httputil.Context, d Distiller) (err error) {
 		return
 	}
 
-	//send a request to dispatch the queue
-	go http.Get(httputil.Absolute(handleUrl))
-
+	dispatch()
 	return
 }
 
-const (
-	attemptTime = 10 * time.Minute
-	maxAttempts = 5
-)
+//dispatch sends a request to dispatch the queue.
+func dispatch() {
+	go client.Default().Get(httputil.Absolute(handleUrl))
+}
+
+const attemptTime = 10 * time.Minute
+
+//pushTimeout is how long a builder has to accept a task before the work item is
+//put back to be dispatched again.
+const pushTimeout = 30 * time.Second
 
 //dispatchWork is the handler that gets called for a queue item. It grabs a builder
 //and runner and dispatches the work item to them, recoding when that operation
 //started.
 func dispatchWork(w http.ResponseWriter, req *http.Request, ctx httputil.Context) (e *httputil.Error) {
-	//find all the documents that are waiting or (processing and their attempt is
-	//taking too long)
+	//find all the documents that are waiting or (processing/built and their
+	//attempt is taking too long and they aren't leased by a live worker)
 	type L []interface{}
+	now := time.Now()
 	selector := bson.M{
 		"$or": L{
 			bson.M{"status": entities.WorkStatusWaiting},
 			bson.M{
-				"status":            entities.WorkStatusProcessing,
-				"attemptlog.0.when": bson.M{"$lt": time.Now().Add(-1 * attemptTime)},
+				"status":            bson.M{"$in": L{entities.WorkStatusProcessing, entities.WorkStatusBuilt}},
+				"attemptlog.0.when": bson.M{"$lt": now.Add(-1 * attemptTime)},
+				"lease.deadline":    bson.M{"$not": bson.M{"$gte": now}},
 			},
 		},
 	}
@@ -78,18 +102,23 @@ func dispatchWork(w http.ResponseWriter, req *http.Request, ctx httputil.Context
 	for iter.Next(&work) {
 		//if its a processing task with too many attempts, store it as a dispatch
 		//error.
-		if len(work.AttemptLog) >= maxAttempts {
+		if len(work.AttemptLog) >= entities.WorkMaxAttempts {
 			ctx.Infof("Work item %s had too many attempts", work.ID)
 			args := &rpc.DispatchResponse{
 				Key:     work.ID.Hex(),
+				ID:      work.AttemptLog[0].ID.Hex(),
 				Error:   "Unable to complete Work item. Too many failed attempts.",
 				WorkRev: work.Revision,
 			}
+			args.Sign(work.AttemptLog[0].Secret)
 
 			//send it off to the response rpc
 			respUrl := httputil.Absolute(router.Lookup("Response"))
-			cl := client.New(respUrl, http.DefaultClient, client.JsonCodec)
-			if err := cl.Call("Response.DispatchError", args, new(rpc.None)); err != nil {
+			cl := client.New(respUrl, client.Default(), client.JsonCodec)
+			callCtx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+			err := cl.CallContext(callCtx, "Response.DispatchError", args, new(rpc.None))
+			cancel()
+			if err != nil {
 				ctx.Infof("Couldn't store a dispatch error for work item %s: %s", work.ID, err)
 			}
 
@@ -114,20 +143,41 @@ func dispatchWork(w http.ResponseWriter, req *http.Request, ctx httputil.Context
 }
 
 func dispatchWorkItem(ctx httputil.Context, work entities.Work) (err error) {
-	//lease a builder and runner
-	builder, runner, err := tracker.LeasePair(ctx)
+	ctx.Key = work.ID.Hex()
+
+	//only the builder downloading a private project gets its credentials
+	auth, err := accounts.Auth(ctx, work.Work.ImportPath)
+	if err != nil {
+		return
+	}
+
+	//keep the credentials out of the logs about the work item
+	if ctx.Masker, err = accounts.Masker(ctx, work.Work.ImportPath); err != nil {
+		return
+	}
+
+	//lease a builder and runner, making sure the credentials are only sent to
+	//a builder over tls
+	builder, runner, err := tracker.LeasePair(ctx, work.Work, auth != nil)
 	if err != nil {
 		return
 	}
 
 	log.Printf("Got:\nBuilder: %+v\nRunner: %+v", builder, runner)
 
-	//create an attempt
+	//create an attempt with a secret for signing the responses
+	secret, err := rpc.NewSecret()
+	if err != nil {
+		tracker.ReleasePair(ctx, builder, runner)
+		return
+	}
+
 	a := entities.WorkAttempt{
 		When:    time.Now(),
 		Builder: builder.URL,
 		Runner:  runner.URL,
 		ID:      bson.NewObjectId(),
+		Secret:  secret,
 	}
 
 	//push the new attempt at the start of the array
@@ -146,16 +196,20 @@ func dispatchWorkItem(ctx httputil.Context, work entities.Work) (err error) {
 				"attemptlog": log,
 				"status":     entities.WorkStatusProcessing,
 			},
+			//an expired lease shouldn't let a worker take it back
+			"$unset": bson.M{"lease": 1},
 		},
 	}}
 
 	err = ctx.R.Run(ops, bson.NewObjectId(), nil)
 	if err == txn.ErrAborted {
 		ctx.Infof("Lost the race dispatching a work item")
+		tracker.ReleasePair(ctx, builder, runner)
 		err = nil
 		return
 	}
 	if err != nil {
+		tracker.ReleasePair(ctx, builder, runner)
 		return
 	}
 
@@ -167,11 +221,50 @@ func dispatchWorkItem(ctx httputil.Context, work entities.Work) (err error) {
 		WorkRev:  work.Revision + 1,
 		Runner:   runner.URL,
 		Response: httputil.Absolute(router.Lookup("Response")),
+		Secret:   secret,
+		Since:    tracker.LastTested(ctx, work.Work.ImportPath),
+		Auth:     auth,
 	}
 
-	//send the task off to the builder queue
-	cl := client.New(builder.URL, http.DefaultClient, client.JsonCodec)
-	err = cl.Call("BuilderQueue.Push", task, new(rpc.None))
+	//send the task off to the builder queue, giving up on builders that are too
+	//slow to take it
+	callCtx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+	defer cancel()
+	cl := client.New(builder.URL, client.Default(), client.JsonCodec)
+	if err = cl.CallContext(callCtx, "BuilderQueue.Push", task, new(rpc.None)); err != nil {
+		ctx.Infof("Builder %s rejected work item %s: %s", builder.URL, work.ID, err)
+		releaseWorkItem(ctx, work, task.WorkRev)
+		tracker.ReleasePair(ctx, builder, runner)
+	}
 
 	return
 }
+
+//releaseWorkItem puts a work item we acquired back into the waiting state
+//without the attempt we added, so that it is dispatched again without waiting
+//for the attempt to time out.
+func releaseWorkItem(ctx httputil.Context, work entities.Work, rev int) {
+	ops := []txn.Op{{
+		C:  "Work",
+		Id: work.ID,
+		Assert: bson.M{
+			"revision": rev,
+		},
+		Update: bson.M{
+			"$inc": bson.M{"revision": 1},
+			"$set": bson.M{
+				"attemptlog": work.AttemptLog,
+				"status":     work.Status,
+			},
+		},
+	}}
+
+	err := ctx.R.Run(ops, bson.NewObjectId(), nil)
+	if err == txn.ErrAborted {
+		ctx.Infof("Lost the race releasing work item %s", work.ID)
+		err = nil
+	}
+	if err != nil {
+		ctx.Errorf("Error releasing work item %s: %s", work.ID, err)
+	}
+}
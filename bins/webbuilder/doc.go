@@ -8,6 +8,15 @@ the environment variables it looks for:
 	* TRACKER: The URL for the tracker. If unspecified uses http://goci.me/rpc/tracker
 	* HOSTED: The URL to reach the builder at for sending work. Panics if unspecified.
 	* PORT: The port the builder should bind to. Default 9080.
+	* PULL: If set the builder leases work from the tracker instead of announcing itself.
+	* TOOLCHAINS: Comma separated list of toolchains the builder has, like "go1.0.3".
+	* LABELS: Comma separated list of labels for features of the builder, like "cgo".
+	* ENROLL_TOKEN: The enrollment token issued on the admin page of the tracker.
+	* TLS_CERT: Path to the pem certificate issued by the goci ca tool. If set along with TLS_KEY and TLS_CA, the builder serves over https, requires peer certificates and presents its own in rpcs. HOSTED and TRACKER should be https urls.
+	* TLS_KEY: Path to the pem key for TLS_CERT.
+	* TLS_CA: Path to the pem certificate of the ca. Only peers with certificates issued by it are trusted.
+	* QUEUE_DIR: Directory to journal queued tasks in so they survive a restart. If unspecified tasks are only kept in memory.
+	* QUEUE_MAX: The maximum number of queued tasks before pushes are rejected. Default 0 (unlimited).
 
 webbuilder does not try to install any tools so you must have everything available
 in your path for building go code. This includes git, hg, bzr and go. All binaries
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/app/rpc/client"
+	"github.com/zeebo/goci/app/rpc/mtls"
+	"github.com/zeebo/goci/app/settings"
 	"github.com/zeebo/goci/builder"
 	"github.com/zeebo/goci/builder/web"
 	"log"
@@ -11,37 +15,49 @@ import (
 	"syscall"
 )
 
-//env gets an environment variable with a default
-func env(key, def string) (r string) {
-	if r = os.Getenv(key); r == "" {
-		r = def
-	}
-	return
-}
-
 func main() {
-	hosted := env("HOSTED", "")
+	hosted := settings.Env("HOSTED", "")
 	if hosted == "" {
 		panic("don't know where the builder lives. Please set the HOSTED env var.")
 	}
 
+	//rpcs to the tracker and runners present our certificate
+	tlsConf := settings.TLSConfig()
+	client.SetTLS(tlsConf)
+
+	bq, err := rpc.OpenBuilderQueue(settings.QueuePath("builder.journal"), settings.QueueMax())
+	if err != nil {
+		panic(err)
+	}
+
 	bu := web.New(
-		builder.New(env("GOOS", ""), env("GOARCH", ""), ""),
-		env("TRACKER", "http://goci.me/rpc/tracker"),
+		builder.New(settings.Env("GOOS", ""), settings.Env("GOARCH", ""), ""),
+		settings.Env("TRACKER", "http://goci.me/rpc/tracker"),
 		hosted,
+		bq,
 	)
+	bu.SetCapabilities(rpc.Capabilities{
+		Toolchains: settings.List("TOOLCHAINS"),
+		Labels:     settings.List("LABELS"),
+	})
+	bu.SetToken(settings.Env("ENROLL_TOKEN", ""))
 
-	l, err := net.Listen("tcp", "0.0.0.0:"+env("PORT", "9080"))
+	l, err := net.Listen("tcp", "0.0.0.0:"+settings.Env("PORT", "9080"))
 	if err != nil {
 		panic(err)
 	}
 	defer l.Close()
-	go http.Serve(l, bu)
+	go http.Serve(mtls.Listen(l, tlsConf), bu)
 
-	if err := bu.Announce(); err != nil {
-		panic(err)
+	//either pull work from the tracker or announce for it to be pushed
+	if settings.Env("PULL", "") != "" {
+		go bu.Pull()
+	} else {
+		if err := bu.Announce(); err != nil {
+			panic(err)
+		}
+		defer bu.Remove()
 	}
-	defer bu.Remove()
 
 	//wait for a signal
 	signals := []os.Signal{
@@ -10,6 +10,14 @@ the environment variables it looks for (all panics will only occur if the variab
 	* PORT: The port the builder should bind to. Default 9080.
 	* DIRECT: If set the runner will run tests locally instead of the heroku dyno mesh.
 	* RUNNER: The path to the runner binary for direct running. Panics if unspecified.
+	* PULL: If set the runner leases work from the tracker instead of announcing itself.
+	* LABELS: Comma separated list of labels for features of the runner, like "docker" or "large".
+	* ENROLL_TOKEN: The enrollment token issued on the admin page of the tracker.
+	* TLS_CERT: Path to the pem certificate issued by the goci ca tool. If set along with TLS_KEY and TLS_CA, the runner serves over https, requires peer certificates and presents its own in rpcs. HOSTED and TRACKER should be https urls.
+	* TLS_KEY: Path to the pem key for TLS_CERT.
+	* TLS_CA: Path to the pem certificate of the ca. Only peers with certificates issued by it are trusted.
+	* QUEUE_DIR: Directory to journal queued tasks in so they survive a restart. If unspecified tasks are only kept in memory.
+	* QUEUE_MAX: The maximum number of queued tasks before pushes are rejected. Default 0 (unlimited).
 
 In order for webrunner to run tests on heroku, the app must have the binary created
 by the import path github.com/zeebo/goci/runner installed to bin/runner. This can
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/app/rpc/client"
+	"github.com/zeebo/goci/app/rpc/mtls"
+	"github.com/zeebo/goci/app/settings"
 	"github.com/zeebo/goci/runner/direct"
 	"github.com/zeebo/goci/runner/web"
 	"log"
@@ -12,20 +16,24 @@ import (
 )
 
 //Service can handle http requests and announce and remove its presence to a
-//tracker.
+//tracker, or pull work from it.
 type Service interface {
 	http.Handler
 	Announce() error
 	Remove() error
+	Pull()
+	SetCapabilities(rpc.Capabilities)
+	SetToken(string)
 }
 
 //newWebRunner returns a service for running tests on the heroku dyno mesh.
 func newWebRunner() Service {
 	runner := web.New(
-		mustEnv("APP_NAME"),
-		mustEnv("API_KEY"),
-		env("TRACKER", "http://goci.me/rpc/tracker"),
-		mustEnv("HOSTED"),
+		settings.MustEnv("APP_NAME"),
+		settings.MustEnv("API_KEY"),
+		settings.Env("TRACKER", "http://goci.me/rpc/tracker"),
+		settings.MustEnv("HOSTED"),
+		runnerQueue(),
 	)
 	return runner
 }
@@ -33,49 +41,54 @@ func newWebRunner() Service {
 //newDirectRunner returns a service for running tests on the local machine.
 func newDirectRunner() Service {
 	runner := direct.New(
-		mustEnv("RUNNER"),
-		env("TRACKER", "http://goci.me/rpc/tracker"),
-		mustEnv("HOSTED"),
+		settings.MustEnv("RUNNER"),
+		settings.Env("TRACKER", "http://goci.me/rpc/tracker"),
+		settings.MustEnv("HOSTED"),
+		runnerQueue(),
 	)
 	return runner
 }
 
-//env gets an environment variable with a default
-func env(key, def string) (r string) {
-	if r = os.Getenv(key); r == "" {
-		r = def
-	}
-	return
-}
-
-//mustEnv panics if the environment variable is not set.
-func mustEnv(key string) (r string) {
-	if r = env(key, ""); r == "" {
-		panic("Please specify the " + key + " environment variable.")
+//runnerQueue opens the queue of tasks for the runner.
+func runnerQueue() rpc.RunnerQueue {
+	q, err := rpc.OpenRunnerQueue(settings.QueuePath("runner.journal"), settings.QueueMax())
+	if err != nil {
+		panic(err)
 	}
-	return
+	return q
 }
 
 func main() {
+	//rpcs to the tracker and the runner binaries present our certificate
+	tlsConf := settings.TLSConfig()
+	client.SetTLS(tlsConf)
+
 	//create the runner based on the DIRECT variable
 	var runner Service
-	if env("DIRECT", "") == "" {
+	if settings.Env("DIRECT", "") == "" {
 		runner = newWebRunner()
 	} else {
 		runner = newDirectRunner()
 	}
+	runner.SetCapabilities(rpc.Capabilities{Labels: settings.List("LABELS")})
+	runner.SetToken(settings.Env("ENROLL_TOKEN", ""))
 
-	l, err := net.Listen("tcp", "0.0.0.0:"+env("PORT", "9080"))
+	l, err := net.Listen("tcp", "0.0.0.0:"+settings.Env("PORT", "9080"))
 	if err != nil {
 		panic(err)
 	}
 	defer l.Close()
-	go http.Serve(l, runner)
+	go http.Serve(mtls.Listen(l, tlsConf), runner)
 
-	if err := runner.Announce(); err != nil {
-		panic(err)
+	//either pull work from the tracker or announce for it to be pushed
+	if settings.Env("PULL", "") != "" {
+		go runner.Pull()
+	} else {
+		if err := runner.Announce(); err != nil {
+			panic(err)
+		}
+		defer runner.Remove()
 	}
-	defer runner.Remove()
 
 	//wait for a signal
 	signals := []os.Signal{
@@ -0,0 +1,89 @@
+package builder
+
+import (
+	"fmt"
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/vcs"
+	"io/ioutil"
+	"net/url"
+	"os"
+	fp "path/filepath"
+	"strings"
+)
+
+//authFile is a file written in to the home directory of a build.
+type authFile struct {
+	name string
+	data string
+}
+
+//writeAuth writes the credentials in to the empty home directory so that git,
+//hg and bzr use them to download from the host, and returns the environment
+//variables the commands need to find them. SSH keys are used for every
+//download from the host, including the https import paths go get uses for git.
+//The host has to already be in the known hosts of the machine.
+func writeAuth(home string, a *rpc.Auth) (env []string, err error) {
+	env = []string{
+		"HOME=" + home,
+		"BZR_HOME=" + home,
+		//fail instead of waiting for someone to type a password
+		"GIT_TERMINAL_PROMPT=0",
+	}
+
+	var files []authFile
+	switch {
+	case a.SSHKey != "":
+		key := fp.Join(home, ".ssh", "id_goci")
+		config := fp.Join(home, ".ssh", "config")
+		ssh := "ssh -F " + config
+		env = append(env, "GIT_SSH_COMMAND="+ssh)
+
+		files = []authFile{
+			{key, strings.TrimSpace(a.SSHKey) + "\n"},
+			{config, fmt.Sprintf("Host %s\n\tIdentityFile %s\n\tIdentitiesOnly yes\n\tBatchMode yes\n", a.Host, key)},
+			{".gitconfig", fmt.Sprintf("[url \"git@%s:\"]\n\tinsteadOf = https://%s/\n", a.Host, a.Host)},
+			{".hgrc", fmt.Sprintf("[ui]\nssh = %s\n", ssh)},
+		}
+
+	case a.Token != "":
+		creds := fp.Join(home, ".git-credentials")
+		u := url.URL{Scheme: "https", Host: a.Host, User: url.UserPassword(a.Username, a.Token)}
+
+		files = []authFile{
+			{creds, u.String() + "\n"},
+			{".gitconfig", fmt.Sprintf("[credential]\n\thelper = store --file=%s\n", creds)},
+			{".hgrc", fmt.Sprintf("[auth]\ngoci.prefix = https://%s/\ngoci.username = %s\ngoci.password = %s\n", a.Host, a.Username, a.Token)},
+			{fp.Join(".bazaar", "authentication.conf"), fmt.Sprintf("[goci]\nscheme = https\nhost = %s\nuser = %s\npassword = %s\n", a.Host, a.Username, a.Token)},
+			{".netrc", fmt.Sprintf("machine %s login %s password %s\n", a.Host, a.Username, a.Token)},
+		}
+
+	default:
+		err = fmt.Errorf("no ssh key or token in the %s", a)
+		return
+	}
+
+	for _, f := range files {
+		path := f.name
+		if !fp.IsAbs(path) {
+			path = fp.Join(home, path)
+		}
+		if err = World.MkdirAll(fp.Dir(path), 0700); err != nil {
+			return
+		}
+		//the files are only for us, and ssh refuses keys anyone else can read
+		if err = ioutil.WriteFile(path, []byte(f.data), 0600); err != nil {
+			return
+		}
+	}
+	return
+}
+
+//withAuth returns the vcs that runs its commands with the credentials of the
+//build, if there are any.
+func (b Builder) withAuth(v vcs.VCS) vcs.VCS {
+	if v == nil || b.authEnv == nil {
+		return v
+	}
+	env := append(os.Environ(), b.authEnv...)
+	return vcs.WithEnv(v, env)
+}
@@ -18,14 +18,16 @@ import (
 
 type LocalWorld interface {
 	Exists(string) bool
-	// LookPath(string) (string, error)
+	//LookPath(string) (string, error)
 	TempDir(string) (string, error)
 	Open(string) (io.ReadCloser, error)
+	MkdirAll(string, os.FileMode) error
 }
 
 var (
 	ErrTooMany         = errors.New("too many revisions in that work item")
 	ErrUnknownWorkType = errors.New("unknown work type")
+	ErrAuthDropped     = errors.New("the credentials for the private repository were lost when the builder restarted")
 
 	World LocalWorld = environ.New()
 )
@@ -39,6 +41,7 @@ type Builder struct {
 	//generated
 	baseEnv []string
 	env     []string
+	authEnv []string //the environment to use the credentials of the build
 }
 
 //New returns a Builder that can be used for building Work objects.
@@ -49,7 +52,8 @@ type Builder struct {
 //If any error occurs creating the temporary directory, New will panic.
 //If a directory has been created for the GOPATH, the Cleanup method will remove
 //it.
-//Commands run by the Builder use the PATH variable from the environment.
+//Commands run by the Builder use the PATH variable from the environment, and
+//the GOCACHE variable or the default build cache of the user.
 func New(GOOS, GOARCH, GOROOT string) (b Builder) {
 	//fill in default values
 	if GOOS == "" {
@@ -72,9 +76,15 @@ func New(GOOS, GOARCH, GOROOT string) (b Builder) {
 			fmt.Sprintf("GOOS=%s", GOOS),
 			fmt.Sprintf("GOARCH=%s", GOARCH),
 			fmt.Sprintf("PATH=%s", mustEnv("PATH")),
+			"GO111MODULE=off", //the packages are found in the GOPATH
 		},
 	}
 
+	//the commands don't have a HOME to find the default build cache from
+	if cache := buildCache(); cache != "" {
+		b.baseEnv = append(b.baseEnv, fmt.Sprintf("GOCACHE=%s", cache))
+	}
+
 	//see if we should disable CGO based on GOOS/GOARCH
 	if runtime.GOOS != GOOS || runtime.GOARCH != GOARCH {
 		b.baseEnv = append(b.baseEnv, "CGO_ENABLED=0")
@@ -83,6 +93,19 @@ func New(GOOS, GOARCH, GOROOT string) (b Builder) {
 	return
 }
 
+//buildCache returns the build cache of the go tool for the user running the
+//Builder, or "" if there isn't one.
+func buildCache() string {
+	if cache := env("GOCACHE", ""); cache != "" {
+		return cache
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return fp.Join(dir, "go-build")
+}
+
 //GOOS returns the GOOS the builder will make binaries for.
 func (b Builder) GOOS() string { return b.goos }
 
@@ -150,37 +173,69 @@ func (b Build) CleanSource() {
 //Build converts a work item into a set of builds, the revision date for the
 //revision specified in the work item.
 func (b Builder) Build(w *rpc.Work) (builds []Build, revDate time.Time, err error) {
-	//create a GOPATH for this work item
-	b.gopath, err = World.TempDir("gopath")
+	builds, revDate, _, err = b.BuildSince(w, "")
+	return
+}
+
+//BuildSince is like Build but also returns the commits after the since
+//revision up to the revision that was built, newest first. The commits are
+//only a courtesy, so there are none if since is empty or they can't be logged.
+func (b Builder) BuildSince(w *rpc.Work, since string) (builds []Build, revDate time.Time, commits []rpc.Commit, err error) {
+	return b.buildWith(w, since, nil, Builder.download)
+}
+
+//BuildAuth is like BuildSince but downloads with the credentials, which are
+//removed before it returns. The secrets in the credentials are scrubbed from
+//the errors it returns. Credentials that were dropped fail with ErrAuthDropped
+//instead of trying to download without them.
+func (b Builder) BuildAuth(w *rpc.Work, since string, auth *rpc.Auth) (builds []Build, revDate time.Time, commits []rpc.Commit, err error) {
+	if auth != nil && auth.Dropped {
+		err = ErrAuthDropped
+		return
+	}
+	builds, revDate, commits, err = b.buildWith(w, since, auth, Builder.download)
+	if auth == nil {
+		return
+	}
+	for i := range builds {
+		builds[i].Error = auth.Scrub(builds[i].Error)
+	}
 	if err != nil {
-		panic(err)
+		err = errors.New(auth.Scrub(err.Error()))
 	}
-	defer os.RemoveAll(b.gopath)
+	return
+}
 
-	//set up the env to include the new gopath
-	b.env = nil
-	b.env = append(b.env, b.baseEnv...)
-	b.env = append(b.env, fmt.Sprintf("GOPATH=%s", b.gopath))
+//BuildDir is like Build but builds the source in dir as the import path of the
+//work item instead of downloading it, so changes that haven't been committed
+//are built too. The revision is the current revision of dir if it is in a
+//version control system and "local" otherwise.
+func (b Builder) BuildDir(dir string, w *rpc.Work) (builds []Build, revDate time.Time, err error) {
+	builds, revDate, _, err = b.buildWith(w, "", nil, func(b Builder, w *rpc.Work, packDir string) (time.Time, vcs.VCS, error) {
+		return b.copyDir(dir, w, packDir)
+	})
+	return
+}
 
-	tool := b.tool()
+//fetcher puts the source for the work item in packDir, filling in the revision
+//of the work item and returning its date and the vcs it is in, if any.
+type fetcher func(b Builder, w *rpc.Work, packDir string) (revDate time.Time, v vcs.VCS, err error)
 
+//download fetches the source for the work item with go get and checks out the
+//revision of the work item.
+func (b Builder) download(w *rpc.Work, packDir string) (revDate time.Time, v vcs.VCS, err error) {
 	//get the import path (just download the package)
-	if err = tool.Get(true, w.ImportPath); err != nil {
+	if err = b.tool().Get(true, w.ImportPath); err != nil {
 		return
 	}
 
-	//we can find the downloaded package in the first entry of the gopath
-	packDir := fp.Join(b.gopath, "src", w.ImportPath)
-
-	//set up the vcs
-	var v vcs.VCS
-
 	//check the hint for the vcs and fallback on searching the directories
 	if vc := vcs.New(vcs.VCSType(w.VCSHint)); vc != nil {
 		v = vc
 	} else {
 		v = vcs.FindVCS(packDir)
 	}
+	v = b.withAuth(v)
 
 	//if we don't have a vcs then we can't continue
 	if v == nil {
@@ -201,10 +256,117 @@ func (b Builder) Build(w *rpc.Work) (builds []Build, revDate time.Time, err erro
 	}
 
 	//set the date for the revision
-	if revDate, err = v.Date(packDir, w.Revision); err != nil {
+	revDate, err = v.Date(packDir, w.Revision)
+	return
+}
+
+//copyDir copies the source in dir to packDir and uses the current revision of
+//it if it is in a version control system.
+func (b Builder) copyDir(dir string, w *rpc.Work, packDir string) (revDate time.Time, v vcs.VCS, err error) {
+	if err = World.MkdirAll(packDir, 0777); err != nil {
+		return
+	}
+
+	//tarball the directory straight into the gopath
+	pr, pw := io.Pipe()
+	go func() { pw.CloseWithError(tarball.Compress(dir, pw)) }()
+	err = tarball.Extract(pr, packDir)
+	pr.Close()
+	if err != nil {
 		return
 	}
 
+	//without a vcs the build is of whatever is in the directory right now
+	w.Revision, revDate = "local", time.Now()
+	v = vcs.New(vcs.VCSType(w.VCSHint))
+	if v == nil {
+		v = vcs.FindVCS(packDir)
+	}
+	if v == nil {
+		return
+	}
+
+	rev, err := v.Current(packDir)
+	if err != nil {
+		err = nil
+		return
+	}
+	if date, err := v.Date(packDir, rev); err == nil {
+		w.Revision, revDate = rev, date
+	}
+	return
+}
+
+//maxCommits is the most commits logged for a build so a since revision that is
+//far behind doesn't make a huge response.
+const maxCommits = 100
+
+//logSince returns the commits in packDir after since up to rev, ignoring any
+//errors.
+func logSince(v vcs.VCS, packDir, since, rev string) (commits []rpc.Commit) {
+	log, err := v.Log(packDir, since, rev)
+	if err != nil {
+		return
+	}
+	if len(log) > maxCommits {
+		log = log[:maxCommits]
+	}
+	for _, c := range log {
+		commits = append(commits, rpc.Commit{
+			Revision: c.Revision,
+			Author:   c.Author,
+			Date:     c.Date,
+			Subject:  c.Subject,
+		})
+	}
+	return
+}
+
+//buildWith fetches the source for the work item in to a new GOPATH and builds
+//the tests of the packages it asks for. If there are credentials they are put
+//in a new home directory for the commands.
+func (b Builder) buildWith(w *rpc.Work, since string, auth *rpc.Auth, fetch fetcher) (builds []Build, revDate time.Time, commits []rpc.Commit, err error) {
+	//create a GOPATH for this work item
+	b.gopath, err = World.TempDir("gopath")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(b.gopath)
+
+	//set up the env to include the new gopath
+	b.env = nil
+	b.env = append(b.env, b.baseEnv...)
+	b.env = append(b.env, fmt.Sprintf("GOPATH=%s", b.gopath))
+
+	//write the credentials where the commands look for them
+	b.authEnv = nil
+	if auth != nil {
+		var home string
+		if home, err = World.TempDir("home"); err != nil {
+			return
+		}
+		defer os.RemoveAll(home)
+
+		if b.authEnv, err = writeAuth(home, auth); err != nil {
+			return
+		}
+		b.env = append(b.env, b.authEnv...)
+	}
+
+	tool := b.tool()
+
+	//we can find the package in the first entry of the gopath
+	packDir := fp.Join(b.gopath, "src", w.ImportPath)
+	revDate, v, err := fetch(b, w, packDir)
+	if err != nil {
+		return
+	}
+
+	//log the commits since the last revision tested while we have the source
+	if since != "" && since != w.Revision && v != nil {
+		commits = logSince(v, packDir, since, w.Revision)
+	}
+
 	//list the import path to determine how many builds there will be and what
 	//packages need to be installed for the tests to compile
 	path := w.ImportPath
@@ -1,9 +1,15 @@
-// +build !goci
+//go:build !goci
+//+build !goci
 
 package builder
 
 import (
+	"github.com/zeebo/goci/app/entities"
 	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/runner/run"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -58,3 +64,69 @@ func TestBazaarImport(t *testing.T) {
 		bu.Clean()
 	}
 }
+
+func TestBuildDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "builddir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"ex.go":           "package ex\nfunc Add(a, b int) int { return a + b }\n",
+		"ex_test.go":      "package ex\nimport \"testing\"\nfunc TestAdd(t *testing.T) { if Add(1, 2) != 3 { t.Fatal(\"bad\") } }\n",
+		".goci":           `{"NotifyOn": "always"}`,
+		"sub/sub.go":      "package sub\n",
+		"sub/sub_test.go": "package sub\nimport \"testing\"\nfunc TestFail(t *testing.T) { t.Fatal(\"fail\") }\n",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := rpc.Work{
+		ImportPath:  "example.com/ex",
+		Subpackages: true,
+	}
+	b := New("", "", "")
+	bs, _, err := b.BuildDir(dir, &w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Revision != "local" || len(bs) != 2 {
+		t.Fatalf("Expected 2 builds of the local revision. Got %q %+v", w.Revision, bs)
+	}
+
+	//run the builds the way a runner does
+	status := map[string]string{}
+	for _, bu := range bs {
+		if bu.Config.NotifyOn != "always" {
+			t.Errorf("%s: config wasn't loaded: %+v", bu.ImportPath, bu.Config)
+		}
+		src, err := os.Open(bu.SourcePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := run.Test(bu.BinaryPath, src, rpc.RunTest{ImportPath: bu.ImportPath})
+		src.Close()
+		bu.Clean()
+		status[out.ImportPath], _ = entities.OutputStatus(out)
+	}
+	if status["example.com/ex"] != entities.TestStatusPass || status["example.com/ex/sub"] != entities.TestStatusFail {
+		t.Fatalf("Got %v", status)
+	}
+}
+
+func TestBuildAuthDropped(t *testing.T) {
+	b := New("", "", "goroot")
+	w := rpc.Work{ImportPath: "github.com/zeebo/private"}
+	_, _, _, err := b.BuildAuth(&w, "", &rpc.Auth{Host: "github.com", Dropped: true})
+	if err != ErrAuthDropped {
+		t.Fatalf("Expected %v. Got %v", ErrAuthDropped, err)
+	}
+}
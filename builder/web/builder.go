@@ -1,44 +1,49 @@
 package web
 
 import (
-	gorpc "github.com/gorilla/rpc"
-	"github.com/gorilla/rpc/json"
 	"github.com/zeebo/goci/app/pinger"
 	"github.com/zeebo/goci/app/rpc"
 	"github.com/zeebo/goci/app/rpc/client"
+	"github.com/zeebo/goci/app/rpc/jsonrpc"
+	"github.com/zeebo/goci/app/rpc/worker"
 	"github.com/zeebo/goci/builder"
 	"net/http"
 	"net/url"
 	"path"
+	"sync/atomic"
 )
 
 //Builder is a type that builds requests sent to it and hosts them temporarily.
 type Builder struct {
+	*worker.Worker
+
 	b    builder.Builder
 	tcl  *client.Client
 	base string
-	rpc  *gorpc.Server
+	rpc  *jsonrpc.Server
 	bq   rpc.BuilderQueue
 	mux  *http.ServeMux
 	dler *downloader
 
-	key string
+	active int32 //number of tasks being built
 }
 
 //New returns a new web Builder ready to Announce to the given tracker. It
 //announces that it is available at `hosted` which should be the full url of
-//where this builder resides on the internet.
-func New(b builder.Builder, tracker, hosted string) *Builder {
+//where this builder resides on the internet. Tasks pushed to the builder are
+//stored in bq until they are built.
+func New(b builder.Builder, tracker, hosted string, bq rpc.BuilderQueue) *Builder {
 	//create our new builder
 	n := &Builder{
 		b:    b,
 		base: hosted,
-		rpc:  gorpc.NewServer(),
-		tcl:  client.New(tracker, http.DefaultClient, client.JsonCodec),
-		bq:   rpc.NewBuilderQueue(),
+		rpc:  jsonrpc.NewServer(),
+		tcl:  client.New(tracker, client.Default(), client.JsonCodec),
+		bq:   bq,
 		mux:  http.NewServeMux(),
 		dler: newDownloader(),
 	}
+	n.Worker = worker.New(n.tcl, "Builder", hosted, b.GOOS(), b.GOARCH(), n.load)
 
 	//register the build service in the rpc
 	if err := n.rpc.RegisterService(n.bq, ""); err != nil {
@@ -50,9 +55,6 @@ func New(b builder.Builder, tracker, hosted string) *Builder {
 		panic(err)
 	}
 
-	//register the codec
-	n.rpc.RegisterCodec(json.NewCodec(), "application/json")
-
 	//add the handlers to our mux
 	n.mux.Handle("/", n.rpc)
 	n.mux.Handle("/download/", http.StripPrefix("/download/", n.dler))
@@ -63,30 +65,15 @@ func New(b builder.Builder, tracker, hosted string) *Builder {
 	return n
 }
 
-//Announce tells the tracker that we're available to build.
-func (b *Builder) Announce() (err error) {
-	args := &rpc.AnnounceArgs{
-		GOOS:   b.b.GOOS(),
-		GOARCH: b.b.GOARCH(),
-		Type:   "Builder",
-		URL:    b.base,
-	}
-	reply := new(rpc.AnnounceReply)
-	if err = b.tcl.Call("Tracker.Announce", args, reply); err != nil {
-		return
-	}
-	b.key = reply.Key
-	return
-}
-
-//Remove removes this Builder from the tracker.
-func (b *Builder) Remove() (err error) {
-	args := &rpc.RemoveArgs{
-		Key:  b.key,
-		Kind: "Builder",
-	}
-	err = b.tcl.Call("Tracker.Remove", args, new(rpc.None))
-	return
+//Pull leases work from the tracker instead of waiting for it to be pushed, so
+//the builder doesn't need to be Announced or reachable by the tracker. Runners
+//still download the binaries from the builder. Pull never returns.
+func (b *Builder) Pull() {
+	b.Lease(func(rep *rpc.LeaseReply, stop func()) {
+		//hold on to the work item while we build it
+		b.build(*rep.Builder)
+		stop()
+	})
 }
 
 //ServeHTTP allows the builder to be hosted like any other http.Handler.
@@ -98,10 +85,22 @@ func (b *Builder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 func (b *Builder) run() {
 	for {
 		task := b.bq.Pop()
-		b.process(task)
+		b.build(task)
 	}
 }
 
+//build processes the task, counting it as active while it does.
+func (b *Builder) build(task rpc.BuilderTask) {
+	atomic.AddInt32(&b.active, 1)
+	defer atomic.AddInt32(&b.active, -1)
+	b.process(task)
+}
+
+//load returns the number of tasks the Builder has queued or is building.
+func (b *Builder) load() int {
+	return b.bq.Size() + int(atomic.LoadInt32(&b.active))
+}
+
 //urlWithPath joins the provided path on the end of the base url.
 func (b *Builder) urlWithPath(p string) string {
 	u, err := url.Parse(b.base)
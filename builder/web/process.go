@@ -4,7 +4,6 @@ import (
 	"github.com/zeebo/goci/app/rpc"
 	"github.com/zeebo/goci/app/rpc/client"
 	"log"
-	"net/http"
 )
 
 //process takes a task and builds the result and either responds to the tracker
@@ -14,7 +13,7 @@ func (b *Builder) process(task rpc.BuilderTask) {
 	log.Printf("Incoming build: %+v", task)
 
 	//build the work item
-	builds, revDate, err := b.b.Build(&task.Work)
+	builds, revDate, commits, err := b.b.BuildAuth(&task.Work, task.Since, task.Auth)
 
 	//check if we have any errors
 	if err != nil {
@@ -32,9 +31,11 @@ func (b *Builder) process(task rpc.BuilderTask) {
 		}
 
 		log.Printf("Pushing error[%s]: %+v", task.Response, resp)
+		resp.Credential = b.Credential()
+		resp.Sign(task.Secret)
 
 		//send it off and ignore the error
-		cl := client.New(task.Response, http.DefaultClient, client.JsonCodec)
+		cl := client.New(task.Response, client.Default(), client.JsonCodec)
 		if err := cl.Call("Response.Error", resp, new(rpc.None)); err != nil {
 			//ignored
 		}
@@ -49,6 +50,8 @@ func (b *Builder) process(task rpc.BuilderTask) {
 		Revision: task.Work.Revision,
 		RevDate:  revDate,
 		Response: task.Response,
+		Secret:   task.Secret,
+		Commits:  commits,
 	}
 	for _, build := range builds {
 		//if the build has an error, then add it to the failures and continue
@@ -82,10 +85,25 @@ func (b *Builder) process(task rpc.BuilderTask) {
 		})
 	}
 
+	//leased tasks are handed back to the tracker for a runner to lease
+	if task.Lease != "" {
+		log.Printf("Handing off request: %+v", req)
+
+		args := &rpc.BuiltArgs{
+			Lease:      task.Lease,
+			Task:       *req,
+			Credential: b.Credential(),
+		}
+		if err := b.tcl.Call("Tracker.Built", args, new(rpc.None)); err != nil {
+			log.Printf("Error handing off request: %s", err)
+		}
+		return
+	}
+
 	log.Printf("Pushing request[%s]: %+v", task.Runner, req)
 
 	//send off to the runner and ignore the error
-	cl := client.New(task.Runner, http.DefaultClient, client.JsonCodec)
+	cl := client.New(task.Runner, client.Default(), client.JsonCodec)
 	if err := cl.Call("RunnerQueue.Push", req, new(rpc.None)); err != nil {
 
 	}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/zeebo/goci/environ"
+	"github.com/zeebo/goci/redact"
 	"io"
 	p "path"
 	fp "path/filepath"
@@ -17,8 +18,10 @@ type CmdError struct {
 	Output string
 }
 
+//Error masks anything that looks like a secret because the output is stored
+//and shown with the results.
 func (t *CmdError) Error() string {
-	return fmt.Sprintf("%s: %v\nargs: %s\noutput: %s", t.Msg, t.Err, t.Args, t.Output)
+	return redact.Patterns(fmt.Sprintf("%s: %v\nargs: %s\noutput: %s", t.Msg, t.Err, t.Args, t.Output))
 }
 
 func cmdErrorf(err error, args []string, buf string, format string, vals ...interface{}) error {
@@ -115,9 +118,12 @@ func (g *Gotool) Test(exeSuffix, path string) (bin string, err error) {
 	return
 }
 
+//parseImports returns the import paths listed one per line in data. The go
+//tool writes its warnings in with them, like when a pattern matches nothing,
+//so those lines are skipped.
 func parseImports(data string) (imps []string) {
 	for _, p := range strings.Split(data, "\n") {
-		if strings.HasPrefix(p, "_") {
+		if strings.HasPrefix(p, "_") || strings.HasPrefix(p, "go: ") {
 			continue
 		}
 		if tr := strings.TrimSpace(p); len(tr) > 0 {
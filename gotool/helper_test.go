@@ -28,3 +28,10 @@ func TestUniqueStrings(t *testing.T) {
 		}
 	}
 }
+
+func TestParseImports(t *testing.T) {
+	data := "go: warning: \"example.com/x/...\" matched no packages\n_/tmp/local\nfmt\nexample.com/x\n\nfmt\n"
+	if imps := parseImports(data); !reflect.DeepEqual(imps, []string{"example.com/x", "fmt"}) {
+		t.Fatalf("Got %q", imps)
+	}
+}
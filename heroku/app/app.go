@@ -1,11 +1,20 @@
 package main
 
 import (
+	"crypto/tls"
+	"encoding/hex"
+	"expvar"
 	"flag"
+	"github.com/zeebo/goci/app/accounts"
 	"github.com/zeebo/goci/app/frontend"
 	"github.com/zeebo/goci/app/httputil"
 	"github.com/zeebo/goci/app/notifications"
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/app/rpc/client"
+	"github.com/zeebo/goci/app/rpc/mtls"
 	"github.com/zeebo/goci/app/rpc/router"
+	"github.com/zeebo/goci/app/settings"
+	"github.com/zeebo/goci/app/tracker"
 	"github.com/zeebo/goci/builder"
 	buweb "github.com/zeebo/goci/builder/web"
 	"github.com/zeebo/goci/environ/loader"
@@ -22,22 +31,50 @@ import (
 	"path/filepath"
 	"runtime"
 	"syscall"
+	"time"
 )
 
-//env gets an environment variable with a default
-func env(key, def string) (r string) {
-	if r = os.Getenv(key); r == "" {
-		r = def
+//credentialKey returns the key the credentials of projects are encrypted with
+//from CREDENTIAL_KEY, which is 64 hex characters.
+func credentialKey() (key []byte) {
+	v := settings.Env("CREDENTIAL_KEY", "")
+	if v == "" {
+		return
+	}
+	key, err := hex.DecodeString(v)
+	if err != nil || len(key) != 32 {
+		panic("CREDENTIAL_KEY must be 64 hex characters")
 	}
 	return
 }
 
-//mustEnv returns the environment variable and panics if its empty
-func mustEnv(key string) (r string) {
-	if r = os.Getenv(key); r == "" {
-		panic("must specify env variable: " + key)
+//logTTL returns how long log entries are kept from LOG_TTL.
+func logTTL() time.Duration {
+	ttl, err := time.ParseDuration(settings.Env("LOG_TTL", "168h"))
+	if err != nil {
+		panic("invalid LOG_TTL: " + err.Error())
 	}
-	return
+	return ttl
+}
+
+//runnerQueue opens the queue of tasks for the runner.
+func runnerQueue() rpc.RunnerQueue {
+	q, err := rpc.OpenRunnerQueue(settings.QueuePath("runner.journal"), settings.QueueMax())
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+//tlsConfig returns the mutual tls config from TLS_CERT, TLS_KEY and TLS_CA, or
+//nil if tls isn't configured. Browsers don't have certificates so the app only
+//verifies them if they're given, and the rpc services require them instead.
+func tlsConfig() *tls.Config {
+	config := settings.TLSConfig()
+	if config != nil {
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config
 }
 
 //config stores the variables parsed by the flag package
@@ -52,31 +89,40 @@ func init() {
 }
 
 //Service can handle http requests and announce and remove its presence to a
-//tracker.
+//tracker, or pull work from it.
 type Service interface {
 	http.Handler
 	Announce() error
 	Remove() error
+	Pull()
+	SetCapabilities(rpc.Capabilities)
+	SetToken(string)
 }
 
 //newWebRunner returns a service for running tests on the heroku dyno mesh.
 func newWebRunner() Service {
 	//create a runner
 	ru := ruweb.New(
-		mustEnv("APP_NAME"),
-		mustEnv("API_KEY"),
+		settings.MustEnv("APP_NAME"),
+		settings.MustEnv("API_KEY"),
 		httputil.Absolute(router.Lookup("Tracker")),
 		httputil.Absolute("/runner/"),
+		runnerQueue(),
 	)
+
+	//export the stats about the dynos we're managing
+	expvar.Publish("Dynos", expvar.Func(func() interface{} { return ru.Dynos() }))
+
 	return ru
 }
 
 //newDirectRunner returns a service for running tests on the local machine.
 func newDirectRunner() Service {
 	ru := rudirect.New(
-		mustEnv("RUNPATH"),
+		settings.MustEnv("RUNPATH"),
 		httputil.Absolute(router.Lookup("Tracker")),
 		httputil.Absolute("/runner/"),
+		runnerQueue(),
 	)
 	return ru
 }
@@ -105,59 +151,118 @@ func main() {
 	}
 
 	//configure the frontend
-	frontend.Config.Templates = env("TEMPLATES", "./templates")
-	frontend.Config.Static = env("STATIC", "./static")
-	frontend.Config.Debug = env("DEBUG", "") != ""
+	frontend.Config.Templates = settings.Env("TEMPLATES", "./templates")
+	frontend.Config.Static = settings.Env("STATIC", "./static")
+	frontend.Config.Debug = settings.Env("DEBUG", "") != ""
+	frontend.Config.AdminPassword = settings.Env("ADMIN_PASSWORD", "")
+
+	//configure logging in, keeping the github defaults for anything unset
+	accounts.Config.ClientID = settings.Env("OAUTH_CLIENT_ID", "")
+	accounts.Config.ClientSecret = settings.Env("OAUTH_CLIENT_SECRET", "")
+	accounts.Config.AuthURL = settings.Env("OAUTH_AUTH_URL", accounts.Config.AuthURL)
+	accounts.Config.TokenURL = settings.Env("OAUTH_TOKEN_URL", accounts.Config.TokenURL)
+	accounts.Config.UserURL = settings.Env("OAUTH_USER_URL", accounts.Config.UserURL)
+	accounts.Config.Scope = settings.Env("OAUTH_SCOPE", "")
+	accounts.Config.Host = settings.Env("OAUTH_HOST", accounts.Config.Host)
+	accounts.Config.SecretKey = credentialKey()
+
+	//configure the tracker
+	tracker.Config.RequireEnrollment = settings.Env("REQUIRE_ENROLLMENT", "") != ""
 
 	//configure the notifications
-	notifications.Config.Username = mustEnv("XMPPUSER")
-	notifications.Config.Password = mustEnv("XMPPPASS")
-	notifications.Config.Domain = mustEnv("XMPPDOMAIN")
+	notifications.Config.Username = settings.MustEnv("XMPPUSER")
+	notifications.Config.Password = settings.MustEnv("XMPPPASS")
+	notifications.Config.Domain = settings.MustEnv("XMPPDOMAIN")
 
 	//connect to the mongo database.
-	sess, err := mgo.Dial(env("DATABASE", "mongodb://localhost/gocitest"))
+	sess, err := mgo.Dial(settings.Env("DATABASE", "mongodb://localhost/gocitest"))
 	if err != nil {
 		panic(err)
 	}
 	//empty implies whatever was specified in dial.
 	httputil.Config.DB = sess.DB("")
 
+	//expire old log entries
+	httputil.Config.LogTTL = logTTL()
+	if err := httputil.EnsureLogs(httputil.Config.DB); err != nil {
+		panic(err)
+	}
+
+	//expire old sessions
+	if err := accounts.EnsureSessions(httputil.Config.DB); err != nil {
+		panic(err)
+	}
+
+	//forget enrollment signatures once they are too old to replay
+	if err := tracker.EnsureNonces(httputil.Config.DB); err != nil {
+		panic(err)
+	}
+
 	//set up the httputil domain so we can build absolute urls
-	httputil.Config.Domain = mustEnv("DOMAIN")
+	httputil.Config.Domain = settings.MustEnv("DOMAIN")
+
+	//serve over mutual tls and present our certificate in rpcs if configured
+	tlsConf := tlsConfig()
+	httputil.Config.TLS = tlsConf != nil
+	client.SetTLS(tlsConf)
+
+	//export the latency of the rpcs we make
+	expvar.Publish("RPC", expvar.Func(func() interface{} { return client.Stats() }))
+
+	//clean out workers that stop sending heartbeats
+	go tracker.Evictor()
+
+	//the workers in the app enroll with a token of their own
+	ctx := httputil.NewContext(nil)
+	token, err := tracker.LocalToken(ctx)
+	ctx.Close()
+	if err != nil {
+		panic(err)
+	}
 
 	//start the server.
 	//we can't use listenandserve because the scheduler might not give it the
 	//opportunity to set up the listen socket before we attempt to announce.
-	l, err := net.Listen("tcp", "0.0.0.0:"+mustEnv("PORT"))
+	l, err := net.Listen("tcp", "0.0.0.0:"+settings.MustEnv("PORT"))
 	if err != nil {
 		panic(err)
 	}
 	defer l.Close()
-	go http.Serve(l, nil)
+	go http.Serve(mtls.Listen(l, tlsConf), nil)
 
 	//set up some vars for our target os and arch and the runner
 	var GOOS, GOARCH string
 	var runner Service
 
 	//check if we're running direct or not
-	if env("DIRECTRUN", "") == "" {
+	if settings.Env("DIRECTRUN", "") == "" {
 		//we're running on heroku so build for that target
 		GOOS, GOARCH = "linux", "amd64"
 		runner = newWebRunner()
 	} else {
 		//we're running things directly
-		GOOS, GOARCH = env("GOOS", runtime.GOOS), env("GOARCH", runtime.GOARCH)
+		GOOS, GOARCH = settings.Env("GOOS", runtime.GOOS), settings.Env("GOARCH", runtime.GOARCH)
 		runner = newDirectRunner()
 	}
 
+	//both workers share the features of the machine
+	labels := settings.List("LABELS")
+	runner.SetCapabilities(rpc.Capabilities{Labels: labels})
+	runner.SetToken(token)
+
 	//add the runner to our system
-	http.Handle("/runner/", http.StripPrefix("/runner", runner))
+	http.Handle("/runner/", mtls.Require(http.StripPrefix("/runner", runner)))
 
-	//announce the runner
-	if err := runner.Announce(); err != nil {
-		panic(err)
+	//either pull work for the runner or announce it
+	pull := settings.Env("PULL", "") != ""
+	if pull {
+		go runner.Pull()
+	} else {
+		if err := runner.Announce(); err != nil {
+			panic(err)
+		}
+		defer runner.Remove()
 	}
-	defer runner.Remove()
 
 	var goroot string
 	if err := checkTools(); err != nil {
@@ -199,17 +304,31 @@ func main() {
 	}
 
 	//create the builder and announce it
+	bq, err := rpc.OpenBuilderQueue(settings.QueuePath("builder.journal"), settings.QueueMax())
+	if err != nil {
+		panic(err)
+	}
 	bu := buweb.New(
 		builder.New(GOOS, GOARCH, goroot),
 		httputil.Absolute(router.Lookup("Tracker")),
 		httputil.Absolute("/builder/"),
+		bq,
 	)
-	http.Handle("/builder/", http.StripPrefix("/builder", bu))
+	bu.SetCapabilities(rpc.Capabilities{
+		Toolchains: settings.List("TOOLCHAINS"),
+		Labels:     labels,
+	})
+	bu.SetToken(token)
+	http.Handle("/builder/", mtls.Require(http.StripPrefix("/builder", bu)))
 
-	if err := bu.Announce(); err != nil {
-		panic(err)
+	if pull {
+		go bu.Pull()
+	} else {
+		if err := bu.Announce(); err != nil {
+			panic(err)
+		}
+		defer bu.Remove()
 	}
-	defer bu.Remove()
 
 	//wait for a signal
 	signals := []os.Signal{
@@ -9,9 +9,28 @@ Environment variables:
 	* DOMAIN: The domain of the hosted page to build absolute urls. Panics if empty.
 	* PORT: The port for the webserver to listen on. Panics if empty.
 	* DATABASE: URL to the mongo database. Default "mongodb://localhost/gocitest"
-	* TEMPLATES: Path to where the templates for the frontend live. Default "./templates"
-	* STATIC: Path to where the static files for the frontend live Default "./static"
-	* DEBUG: If set, will recompile the templates every invocation.
+	* LOG_TTL: How long log entries are kept, like "72h". Default "168h"
+	* TEMPLATES: Path to the templates for the frontend on disk, used instead of the ones built in to the binary when DEBUG is set. Default "./templates"
+	* STATIC: Path to the static files for the frontend on disk, used instead of the ones built in to the binary when DEBUG is set. Default "./static"
+	* DEBUG: If set, will load the templates and static files from TEMPLATES and STATIC and recompile the templates every invocation.
+	* ADMIN_PASSWORD: Password for the admin pages under /admin. If unset the admin pages are disabled.
+	* OAUTH_CLIENT_ID: Client id of goci on the code host users log in with. If unset logging in and claiming projects is disabled.
+	* OAUTH_CLIENT_SECRET: Client secret of goci on the code host.
+	* OAUTH_AUTH_URL: Where users are sent to log in. Default "https://github.com/login/oauth/authorize"
+	* OAUTH_TOKEN_URL: Where login codes are exchanged for access tokens. Default "https://github.com/login/oauth/access_token"
+	* OAUTH_USER_URL: Returns the account of an access token as json with id, login and name. Default "https://api.github.com/user"
+	* OAUTH_SCOPE: Scope of the access tokens asked for. Default empty, which is enough to read the account.
+	* OAUTH_HOST: Host of the import paths users can claim as github.com/login/project. Default "github.com"
+	* CREDENTIAL_KEY: 64 hex characters of the key the deploy keys, tokens and masked secrets of projects are encrypted with, like the output of "openssl rand -hex 32". If unset owners can't add them. Changing it makes the stored credentials unusable.
+	* REQUIRE_ENROLLMENT: If set, builders and runners must sign their announcements with a token issued on the admin page even before one has been issued. Enrollment is always required once an admin issues a token.
+	* TLS_CERT: Path to the pem certificate issued by the goci ca tool. If set along with TLS_KEY and TLS_CA, the app serves over https and builds https urls. Browsers aren't asked for a certificate but the rpc services require one. The certificate must be issued for DOMAIN.
+	* TLS_KEY: Path to the pem key for TLS_CERT.
+	* TLS_CA: Path to the pem certificate of the ca. Only peers with certificates issued by it are trusted.
+	* PULL: If set the builder and runner lease work from the tracker instead of announcing themselves.
+	* TOOLCHAINS: Comma separated list of toolchains the builder has, like "go1.0.3".
+	* LABELS: Comma separated list of labels for features of the machine, like "cgo" or "docker".
+	* QUEUE_DIR: Directory to journal the builder and runner queues in. If unspecified they are only kept in memory.
+	* QUEUE_MAX: The maximum number of tasks in each queue before pushes are rejected. Default 0 (unlimited).
 	* XMPPUSER: Username for sending XMPP notifications
 	* XMPPPASS: Password for sending XMPP notifications
 	* XMPPDOMAIN: Domain for sending XMPP notifications
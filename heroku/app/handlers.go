@@ -13,6 +13,7 @@ import (
 	"runtime"                          //for gomaxprocs
 
 	//normal handlers
+	"github.com/zeebo/goci/app/api"             //the json api for the goci command
 	"github.com/zeebo/goci/app/frontend"        //load up the web frontend for people
 	_ "github.com/zeebo/goci/app/notifications" //handle notifications
 	_ "github.com/zeebo/goci/app/workqueue"     //handle queuing/dispatching work
@@ -37,7 +38,8 @@ func init() {
 	router.Serve(tracker.Tracker{}, "Tracker", "/rpc/tracker")
 }
 
-//add our frontend
+//add our frontend and api
 func init() {
 	http.Handle("/", frontend.Mux)
+	http.Handle(api.Prefix+"/", api.Mux)
 }
@@ -66,7 +66,16 @@ func (c *Client) Kill(ps string) (err error) {
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.SetBasicAuth("", c.api)
 
-	_, err = http.DefaultClient.Do(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+
+	//make sure heroku actually stopped the process
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("error stopping %s: %s", ps, resp.Status)
+	}
 	return
 }
 
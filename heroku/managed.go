@@ -1,49 +1,138 @@
 package heroku
 
 import (
+	"context"
+	"fmt"
+	"log"
 	"sync"
 	"time"
 )
 
+//Action is a command to be run on a dyno along with a function to call with
+//a reason if the command is unable to finish.
 type Action struct {
 	Command string
 	Error   func(string)
 }
 
+//api is the set of heroku calls the ManagedClient makes. It is satisfied by
+//*Client and exists so that tests can stub out heroku.
+type api interface {
+	List() ([]*Process, error)
+	Kill(string) error
+	Run(string) (*Process, error)
+}
+
 type taskInfo struct {
-	a    Action
-	proc *Process
+	a      Action
+	proc   *Process
+	missed int //number of reconciliations the process was missing from List
+}
+
+//Stats is a snapshot of the counters kept by a ManagedClient.
+type Stats struct {
+	InFlight int   //processes currently running an action
+	Culled   int64 //processes killed for running longer than the ttl
+	Crashed  int64 //processes that went away without being Finished
+	Leaked   int64 //processes we gave up trying to kill
+}
+
+//LeakError is reported when a process could not be killed and may still be
+//running.
+type LeakError struct {
+	UPID     string
+	Attempts int
+	Err      error //the last error from Kill, if any
 }
 
+//Error implements the error interface for LeakError.
+func (l *LeakError) Error() string {
+	return fmt.Sprintf("leaked process %s after %d kill attempts: %v", l.UPID, l.Attempts, l.Err)
+}
+
+const (
+	reconcileInterval = 30 * time.Second
+	killAttempts      = 3           //attempts to kill a culled process before reaping it
+	killBackoff       = time.Second //wait before the second kill attempt, doubled after
+	reapAttempts      = 5           //reconciliations to try killing before a process is leaked
+	maxMissed         = 2           //reconciliations a process may be missing before it crashed
+)
+
+//ManagedClient runs a limited number of processes at once, killing them after
+//a ttl and periodically reconciling what it thinks is running with what heroku
+//reports.
 type ManagedClient struct {
-	client *Client
-	sem    chan bool
-	ttl    time.Duration
+	client  api
+	sem     chan bool
+	ttl     time.Duration
+	backoff time.Duration //wait before the second kill attempt, doubled after
+
+	//Report is called with errors found while supervising processes, like a
+	//*LeakError. It defaults to logging them.
+	Report func(error)
 
-	mu    sync.Mutex //to protect spawn
-	spawn map[string]taskInfo
+	mu    sync.Mutex //protects spawn, dead and stats
+	spawn map[string]*taskInfo
+	dead  map[string]int //processes to be reaped -> attempts so far
+	stats Stats
+
+	done chan bool
 }
 
+//NewManaged returns a ManagedClient that runs at most count processes on the
+//given app at once, each for at most ttl.
 func NewManaged(app, api string, count int, ttl time.Duration) *ManagedClient {
+	m := newManaged(New(app, api), count, ttl)
+	go m.supervise(reconcileInterval)
+	return m
+}
+
+//newManaged creates a ManagedClient without starting the supervisor.
+func newManaged(client api, count int, ttl time.Duration) *ManagedClient {
 	sem := make(chan bool, count)
 	for i := 0; i < count; i++ {
 		sem <- true
 	}
 
 	return &ManagedClient{
-		client: New(app, api),
-		sem:    sem,
-		ttl:    ttl,
-		spawn:  map[string]taskInfo{},
+		client:  client,
+		sem:     sem,
+		ttl:     ttl,
+		backoff: killBackoff,
+		Report:  func(err error) { log.Printf("managed client: %s", err) },
+		spawn:   map[string]*taskInfo{},
+		dead:    map[string]int{},
+		done:    make(chan bool),
 	}
 }
 
-func (m *ManagedClient) acquire() { <-m.sem }
+//acquire waits for a free slot or the context to be done.
+func (m *ManagedClient) acquire(ctx context.Context) (err error) {
+	select {
+	case <-m.sem:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
 func (m *ManagedClient) release() { m.sem <- true }
 
+//Run is RunContext without any deadline for acquiring a slot.
 func (m *ManagedClient) Run(a Action) (id string, err error) {
+	return m.RunContext(context.Background(), a)
+}
+
+//RunContext waits for a free slot and runs the action, returning the UPID of
+//the process. If the context is done before a slot is free, or the process
+//can't be spawned, the Error function of the action is called and an error is
+//returned.
+func (m *ManagedClient) RunContext(ctx context.Context, a Action) (id string, err error) {
 	//acquire the semaphore
-	m.acquire()
+	if err = m.acquire(ctx); err != nil {
+		a.Error("error waiting for a dyno: " + err.Error())
+		return
+	}
 
 	//run the command
 	p, err := m.client.Run(a.Command)
@@ -56,7 +145,7 @@ func (m *ManagedClient) Run(a Action) (id string, err error) {
 	//add process to our spawn map
 	id = p.UPID
 	m.mu.Lock()
-	m.spawn[id] = taskInfo{a, p}
+	m.spawn[id] = &taskInfo{a: a, proc: p}
 	m.mu.Unlock()
 
 	go m.cull(id)
@@ -64,37 +153,185 @@ func (m *ManagedClient) Run(a Action) (id string, err error) {
 	return
 }
 
-func (m *ManagedClient) Finished(id string) {
-	m.mu.Lock()
+//take removes the process from the spawn map and releases its slot. It
+//reports false if the process was not being tracked. m.mu must be held.
+func (m *ManagedClient) take(id string) (info *taskInfo, ok bool) {
 	//need to check so we don't over release
-	if _, ok := m.spawn[id]; ok {
+	if info, ok = m.spawn[id]; ok {
 		delete(m.spawn, id)
 		m.release()
 	}
-	m.mu.Unlock()
+	return
 }
 
-func (m *ManagedClient) cull(id string) {
-	//wait the ttl
-	<-time.After(m.ttl)
+//Finished signals that the process with the given id is done.
+func (m *ManagedClient) Finished(id string) {
+	m.mu.Lock()
+	m.take(id)
+	m.mu.Unlock()
+}
 
-	//grab the lock
+//Stats returns a snapshot of the counters for the client.
+func (m *ManagedClient) Stats() (s Stats) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	s = m.stats
+	s.InFlight = len(m.spawn)
+	return
+}
+
+//Close stops the supervisor. Processes that are running are left alone.
+func (m *ManagedClient) Close() {
+	close(m.done)
+}
+
+//cull kills the process if it is still running after the ttl.
+func (m *ManagedClient) cull(id string) {
+	//wait the ttl
+	select {
+	case <-time.After(m.ttl):
+	case <-m.done:
+		return
+	}
+
 	//see if we have the action still
-	info, ok := m.spawn[id]
+	m.mu.Lock()
+	info, ok := m.take(id)
+	if ok {
+		m.stats.Culled++
+	}
+	m.mu.Unlock()
 	if !ok {
 		return
 	}
-	delete(m.spawn, id)
-	defer m.release() //make sure we release no matter what
 
-	//kill the process, and panic if we don't (never leak processes!)
-	if err := m.client.Kill(info.proc.UPID); err != nil {
-		panic(err)
-	}
+	m.kill(id)
 
 	//run the action for failure
 	info.a.Error("process timed out")
 }
+
+//kill attempts to stop the process a few times, waiting longer between each
+//attempt so heroku has a chance to recover, before handing it to the reaper to
+//try again during reconciliation.
+func (m *ManagedClient) kill(id string) {
+	wait := m.backoff
+	for i := 0; i < killAttempts; i++ {
+		if i > 0 {
+			if !m.sleep(wait) {
+				break
+			}
+			wait *= 2
+		}
+		if err := m.client.Kill(id); err == nil {
+			return
+		}
+	}
+
+	m.mu.Lock()
+	m.dead[id] = 0
+	m.mu.Unlock()
+}
+
+//sleep waits for the duration, returning false if the client is closed first.
+func (m *ManagedClient) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-m.done:
+		return false
+	}
+}
+
+//supervise reconciles every interval until the client is closed.
+func (m *ManagedClient) supervise(interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			m.reconcile()
+		case <-m.done:
+			return
+		}
+	}
+}
+
+//reconcile compares the processes we're tracking with the processes heroku
+//lists. Processes that have gone missing are failed, and processes waiting to
+//be reaped are killed again.
+func (m *ManagedClient) reconcile() {
+	procs, err := m.client.List()
+	if err != nil {
+		m.Report(fmt.Errorf("error listing processes: %s", err))
+		return
+	}
+
+	running := map[string]bool{}
+	for _, p := range procs {
+		running[p.UPID] = true
+	}
+
+	m.mu.Lock()
+
+	//find the processes that went away without being finished. we give them a
+	//couple chances in case heroku hasn't listed them yet.
+	var crashed []*taskInfo
+	for id, info := range m.spawn {
+		if running[id] {
+			info.missed = 0
+			continue
+		}
+		if info.missed++; info.missed < maxMissed {
+			continue
+		}
+		m.take(id)
+		m.stats.Crashed++
+		crashed = append(crashed, info)
+	}
+
+	//find the processes we still need to reap
+	var reap []string
+	for id := range m.dead {
+		if !running[id] {
+			delete(m.dead, id)
+			continue
+		}
+		reap = append(reap, id)
+	}
+
+	m.mu.Unlock()
+
+	for _, info := range crashed {
+		info.a.Error("process exited without a response")
+	}
+	for _, id := range reap {
+		m.reap(id)
+	}
+}
+
+//reap attempts to kill a process that is still running, reporting it as
+//leaked if it has been attempted too many times.
+func (m *ManagedClient) reap(id string) {
+	err := m.client.Kill(id)
+
+	m.mu.Lock()
+	m.dead[id]++
+	attempts := m.dead[id]
+	leaked := attempts >= reapAttempts
+	if leaked {
+		delete(m.dead, id)
+		m.stats.Leaked++
+	}
+	m.mu.Unlock()
+
+	if leaked {
+		m.Report(&LeakError{
+			UPID:     id,
+			Attempts: attempts,
+			Err:      err,
+		})
+	}
+}
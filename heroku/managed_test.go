@@ -0,0 +1,173 @@
+package heroku
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+//fakeApi is an api that keeps its processes in memory and can be told to fail
+//kills.
+type fakeApi struct {
+	sync.Mutex
+	procs    map[string]bool
+	next     int
+	killFail bool
+	kills    []time.Time //when each kill was attempted
+}
+
+func newFakeApi() *fakeApi {
+	return &fakeApi{procs: map[string]bool{}}
+}
+
+func (f *fakeApi) List() (p []*Process, err error) {
+	f.Lock()
+	defer f.Unlock()
+	for id := range f.procs {
+		p = append(p, &Process{UPID: id})
+	}
+	return
+}
+
+func (f *fakeApi) Kill(id string) (err error) {
+	f.Lock()
+	defer f.Unlock()
+	f.kills = append(f.kills, time.Now())
+	if f.killFail {
+		err = errors.New("kill failed")
+		return
+	}
+	delete(f.procs, id)
+	return
+}
+
+func (f *fakeApi) Run(command string) (p *Process, err error) {
+	f.Lock()
+	defer f.Unlock()
+	f.next++
+	p = &Process{UPID: fmt.Sprint(f.next), Command: command}
+	f.procs[p.UPID] = true
+	return
+}
+
+//errAction returns an action that sends its errors down the channel.
+func errAction(errs chan string) Action {
+	return Action{
+		Command: "bin/runner",
+		Error:   func(s string) { errs <- s },
+	}
+}
+
+func TestRunContextTimeout(t *testing.T) {
+	m := newManaged(newFakeApi(), 1, time.Hour)
+	errs := make(chan string, 2)
+
+	if _, err := m.Run(errAction(errs)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := m.RunContext(ctx, errAction(errs)); err == nil {
+		t.Fatal("expected an error acquiring a slot")
+	}
+	if len(errs) != 1 {
+		t.Fatalf("Expected 1 error reported. Got %d", len(errs))
+	}
+	if s := m.Stats(); s.InFlight != 1 {
+		t.Fatalf("Expected 1 in flight. Got %d", s.InFlight)
+	}
+}
+
+func TestCullLeaks(t *testing.T) {
+	f := newFakeApi()
+	f.killFail = true
+
+	m := newManaged(f, 1, time.Millisecond)
+	m.backoff = time.Millisecond
+	var reported []error
+	m.Report = func(err error) { reported = append(reported, err) }
+
+	errs := make(chan string, 1)
+	if _, err := m.Run(errAction(errs)); err != nil {
+		t.Fatal(err)
+	}
+
+	//wait for the cull to fail the action
+	if s := <-errs; s != "process timed out" {
+		t.Fatalf("Expected a time out. Got %q", s)
+	}
+
+	//the slot should be free even though the kill failed
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	m.release()
+
+	for i := 0; i < reapAttempts; i++ {
+		m.reconcile()
+	}
+
+	if len(reported) != 1 {
+		t.Fatalf("Expected 1 reported error. Got %v", reported)
+	}
+	if _, ok := reported[0].(*LeakError); !ok {
+		t.Fatalf("Expected a *LeakError. Got %T", reported[0])
+	}
+	if s := m.Stats(); s.Culled != 1 || s.Leaked != 1 {
+		t.Fatalf("Unexpected stats: %+v", s)
+	}
+}
+
+func TestKillBackoff(t *testing.T) {
+	f := newFakeApi()
+	f.killFail = true
+
+	m := newManaged(f, 1, time.Hour)
+	m.backoff = 20 * time.Millisecond
+	m.kill("1")
+
+	f.Lock()
+	defer f.Unlock()
+	if len(f.kills) != killAttempts {
+		t.Fatalf("Expected %d kills. Got %d", killAttempts, len(f.kills))
+	}
+	for i := 1; i < len(f.kills); i++ {
+		want := m.backoff << uint(i-1)
+		if gap := f.kills[i].Sub(f.kills[i-1]); gap < want {
+			t.Errorf("Kill %d: Expected to wait at least %v. Got %v", i, want, gap)
+		}
+	}
+	if _, ok := m.dead["1"]; !ok {
+		t.Fatal("Expected the process to be handed to the reaper")
+	}
+}
+
+func TestReconcileCrashed(t *testing.T) {
+	f := newFakeApi()
+	m := newManaged(f, 1, time.Hour)
+
+	errs := make(chan string, 1)
+	id, err := m.Run(errAction(errs))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the process goes away without anyone calling Finished
+	f.Kill(id)
+	for i := 0; i < maxMissed; i++ {
+		m.reconcile()
+	}
+
+	if len(errs) != 1 {
+		t.Fatal("Expected the action to be failed")
+	}
+	if s := m.Stats(); s.InFlight != 0 || s.Crashed != 1 {
+		t.Fatalf("Unexpected stats: %+v", s)
+	}
+}
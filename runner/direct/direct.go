@@ -1,41 +1,48 @@
 package direct
 
 import (
-	gorpc "github.com/gorilla/rpc"
-	"github.com/gorilla/rpc/json"
 	"fmt"
 	"github.com/zeebo/goci/app/pinger"
 	"github.com/zeebo/goci/app/rpc"
 	"github.com/zeebo/goci/app/rpc/client"
+	"github.com/zeebo/goci/app/rpc/jsonrpc"
+	"github.com/zeebo/goci/app/rpc/worker"
 	"log"
 	"net/http"
 	"os/exec"
 	"runtime"
+	"sync"
+	"sync/atomic"
 )
 
 //Runner is an rpc service that runs tests locally.
 type Runner struct {
+	*worker.Worker
+
 	tcl    *client.Client  //the client for the tracker
 	base   string          //the url the rpc server is hosted at
-	rpc    *gorpc.Server   //the rpc server
+	rpc    *jsonrpc.Server //the rpc server
 	rq     rpc.RunnerQueue //the queue of run items
 	runner string          //the path to the runner binary
 	task   rpc.RunnerTask  //the current task being run
 	resp   chan rpc.Output //the channel of responses
+	mu     sync.Mutex      //makes sure only one task is processed at a time
 
-	key string //the key the tracker has stored us at
+	active int32 //number of tasks being run
 }
 
-//New returns a new Runner ready to be Announced and run tests locally.
-func New(runner, tracker, hosted string) *Runner {
+//New returns a new Runner ready to be Announced and run tests locally. Tasks
+//pushed to the runner are stored in rq until they are run.
+func New(runner, tracker, hosted string, rq rpc.RunnerQueue) *Runner {
 	n := &Runner{
-		tcl:    client.New(tracker, http.DefaultClient, client.JsonCodec),
+		tcl:    client.New(tracker, client.Default(), client.JsonCodec),
 		base:   hosted,
 		runner: runner,
-		rpc:    gorpc.NewServer(),
-		rq:     rpc.NewRunnerQueue(),
+		rpc:    jsonrpc.NewServer(),
+		rq:     rq,
 		resp:   make(chan rpc.Output),
 	}
+	n.Worker = worker.New(n.tcl, "Runner", hosted, runtime.GOOS, runtime.GOARCH, n.load)
 
 	//register the run service in the rpc
 	if err := n.rpc.RegisterService(n.rq, ""); err != nil {
@@ -52,39 +59,21 @@ func New(runner, tracker, hosted string) *Runner {
 		panic(err)
 	}
 
-	//register the codec
-	n.rpc.RegisterCodec(json.NewCodec(), "application/json")
-
 	//start processing
 	go n.run()
 
 	return n
 }
 
-//Announce tells the tracker that we're avaialable to run tests.
-func (r *Runner) Announce() (err error) {
-	args := &rpc.AnnounceArgs{
-		GOOS:   runtime.GOOS,
-		GOARCH: runtime.GOARCH,
-		Type:   "Runner",
-		URL:    r.base,
-	}
-	reply := new(rpc.AnnounceReply)
-	if err = r.tcl.Call("Tracker.Announce", args, reply); err != nil {
-		return
-	}
-	r.key = reply.Key
-	return
-}
-
-//Remove removes this Runner from the tracker.
-func (r *Runner) Remove() (err error) {
-	args := &rpc.RemoveArgs{
-		Key:  r.key,
-		Kind: "Runner",
-	}
-	err = r.tcl.Call("Tracker.Remove", args, new(rpc.None))
-	return
+//Pull leases work from the tracker instead of waiting for it to be pushed, so
+//the runner doesn't need to be Announced or reachable by the tracker. Pull
+//never returns.
+func (r *Runner) Pull() {
+	r.Lease(func(rep *rpc.LeaseReply, stop func()) {
+		//hold on to the work item while we run it
+		r.execute(*rep.Runner)
+		stop()
+	})
 }
 
 //ServeHTTP allows the runner to be hosted like any other http.Handler.
@@ -96,10 +85,22 @@ func (r *Runner) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 func (r *Runner) run() {
 	for {
 		task := r.rq.Pop()
-		r.process(task)
+		r.execute(task)
 	}
 }
 
+//execute processes the task, counting it as active while it does.
+func (r *Runner) execute(task rpc.RunnerTask) {
+	atomic.AddInt32(&r.active, 1)
+	defer atomic.AddInt32(&r.active, -1)
+	r.process(task)
+}
+
+//load returns the number of tasks the Runner has queued or is running.
+func (r *Runner) load() int {
+	return r.rq.Size() + int(atomic.LoadInt32(&r.active))
+}
+
 //Post grabs the test output and sends it to the corresponding task managing it.
 func (r *Runner) Post(req *http.Request, args *rpc.TestResponse, resp *rpc.None) (err error) {
 	//make sure its for the id we're managing
@@ -134,6 +135,9 @@ func (r *Runner) Request(req *http.Request, args *rpc.TestRequest, resp *rpc.Run
 }
 
 func (r *Runner) process(task rpc.RunnerTask) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	log.Printf("Incoming task: %+v", task)
 
 	//set the task and output slices up
@@ -162,12 +166,15 @@ func (r *Runner) process(task rpc.RunnerTask) {
 		Revision: task.Revision,
 		RevDate:  task.RevDate,
 		Tests:    outs,
+		Commits:  task.Commits,
 	}
 
 	log.Printf("Pushing response[%s]: %+v", task.Response, resp)
+	resp.Credential = r.Credential()
+	resp.Sign(task.Secret)
 
 	//send it off
-	cl := client.New(r.task.Response, http.DefaultClient, client.JsonCodec)
+	cl := client.New(r.task.Response, client.Default(), client.JsonCodec)
 	if err := cl.Call("Response.Post", resp, new(rpc.None)); err != nil {
 		log.Printf("Error pushing response: %s", err)
 	}
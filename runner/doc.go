@@ -1,2 +1,8 @@
 //runner executes a test and makes sure it doesn't run too long
+/*
+runner is started with the url of the runner rpc, the test ID and the index of
+the test. If the TLS_CERT, TLS_KEY and TLS_CA environment variables are set, it
+presents the certificate when loading the test, downloading the binaries and
+posting the results, as the runner and builder verify their peers.
+*/
 package main
@@ -0,0 +1,6 @@
+//package run runs a test binary the way the runners do
+/*
+The runners on heroku and the local machine, and the goci local command, all run
+tests with Test so a test behaves the same wherever it is run.
+*/
+package run
@@ -0,0 +1,104 @@
+package run
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/environ"
+	"github.com/zeebo/goci/tarball"
+	"io"
+	"os"
+	"time"
+)
+
+type LocalWorld interface {
+	TempDir(string) (string, error)
+	Make(environ.Command) environ.Proc
+	RemoveAll(string) error
+}
+
+var World LocalWorld = environ.New()
+
+//Timeout is how long a test is allowed to run.
+var Timeout = time.Minute
+
+//timeout runs the given proc with a timeout, and returns if the process
+//finished in the duration specified.
+func timeout(p environ.Proc, dur time.Duration) (ok bool, err error) {
+	done := make(chan bool, 1)
+	if err = p.Start(); err != nil {
+		return
+	}
+	defer p.Kill()
+
+	//start a race
+	go func() {
+		p.Wait()
+		done <- true
+	}()
+
+	go func() {
+		<-time.After(dur)
+		done <- false
+	}()
+
+	//see who won
+	ok = <-done
+	return
+}
+
+//Test extracts the tarball of the source into a temporary directory and runs
+//the test binary in it with -test.v, giving it Timeout to finish. The output is
+//a success with what the binary printed if it finished, and an error otherwise.
+func Test(binary string, source io.Reader, test rpc.RunTest) (out rpc.Output) {
+	out = rpc.Output{
+		ImportPath: test.ImportPath,
+		Config:     test.Config,
+		Type:       rpc.OutputError,
+	}
+
+	//create a temporary directory for the sources
+	sdir, err := World.TempDir("src")
+	if err != nil {
+		out.Output = err.Error()
+		return
+	}
+	defer World.RemoveAll(sdir)
+
+	//extract them into the directory
+	if err := tarball.Extract(source, sdir); err != nil {
+		out.Output = err.Error()
+		return
+	}
+
+	//create the command
+	env := []string{
+		//copy in some basic env vars if we have them
+		fmt.Sprintf("PATH=%s", os.Getenv("PATH")),
+		fmt.Sprintf("GOROOT=%s", os.Getenv("GOROOT")),
+		fmt.Sprintf("GOPATH=%s", os.Getenv("GOPATH")),
+	}
+	var buf bytes.Buffer
+	cmd := environ.Command{
+		W:    &buf,
+		Dir:  sdir,
+		Env:  env,
+		Path: binary,
+		Args: []string{binary, "-test.v"},
+	}
+	proc := World.Make(cmd)
+
+	//only allow the test to run for the timeout
+	start := time.Now()
+	finished, err := timeout(proc, Timeout)
+	out.Duration = time.Since(start)
+	switch {
+	case err != nil:
+		out.Output = "error starting command"
+	case !finished:
+		out.Output = fmt.Sprintf("test lasted more than %v", Timeout)
+	default:
+		out.Output, out.Type = buf.String(), rpc.OutputSuccess
+	}
+	return
+}
@@ -0,0 +1,78 @@
+package run
+
+import (
+	"bytes"
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/tarball"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//setup returns a tarball of a directory with a data file and a fake test
+//binary that runs the script.
+func setup(t *testing.T, script string) (bin string, src *bytes.Buffer, cleanup func()) {
+	dir, err := ioutil.TempDir("", "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup = func() { os.RemoveAll(dir) }
+
+	srcDir := filepath.Join(dir, "src")
+	if err := os.Mkdir(srcDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "data"), []byte("testdata\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	src = new(bytes.Buffer)
+	if err := tarball.Compress(srcDir, src); err != nil {
+		t.Fatal(err)
+	}
+
+	bin = filepath.Join(dir, "binary")
+	if err := ioutil.WriteFile(bin, []byte("#!/bin/sh\n"+script), 0777); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestTest(t *testing.T) {
+	bin, src, cleanup := setup(t, "cat data\necho \"$1\"\necho PASS\n")
+	defer cleanup()
+
+	out := Test(bin, src, rpc.RunTest{ImportPath: "github.com/zeebo/irc"})
+	if out.Type != rpc.OutputSuccess || out.ImportPath != "github.com/zeebo/irc" {
+		t.Fatalf("Got %+v", out)
+	}
+	//the binary is run in the source with -test.v
+	if exp := "testdata\n-test.v\nPASS\n"; out.Output != exp {
+		t.Fatalf("Expected %q. Got %q", exp, out.Output)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	bin, src, cleanup := setup(t, "sleep 5\n")
+	defer cleanup()
+
+	defer func(d time.Duration) { Timeout = d }(Timeout)
+	Timeout = 50 * time.Millisecond
+
+	out := Test(bin, src, rpc.RunTest{})
+	if out.Type != rpc.OutputError || !strings.Contains(out.Output, "lasted more than") {
+		t.Fatalf("Got %+v", out)
+	}
+}
+
+func TestBadSource(t *testing.T) {
+	bin, _, cleanup := setup(t, "echo PASS\n")
+	defer cleanup()
+
+	out := Test(bin, strings.NewReader("not a tarball"), rpc.RunTest{})
+	if out.Type != rpc.OutputError {
+		t.Fatalf("Got %+v", out)
+	}
+}
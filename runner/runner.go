@@ -1,25 +1,23 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
 	"github.com/zeebo/goci/app/rpc"
 	"github.com/zeebo/goci/app/rpc/client"
+	"github.com/zeebo/goci/app/rpc/mtls"
 	"github.com/zeebo/goci/environ"
-	"github.com/zeebo/goci/tarball"
+	"github.com/zeebo/goci/runner/run"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
-	"time"
 )
 
 type LocalWorld interface {
 	Create(string, os.FileMode) (io.WriteCloser, error)
 	TempDir(string) (string, error)
-	Make(environ.Command) environ.Proc
 	RemoveAll(string) error
 }
 
@@ -49,7 +47,7 @@ func (r *responder) createResponse(output string, typ rpc.OutputType) *rpc.TestR
 
 //post sends the TestResponse to the TestManager
 func (r *responder) post(args *rpc.TestResponse) {
-	cl := client.New(r.url, http.DefaultClient, client.JsonCodec)
+	cl := client.New(r.url, client.Default(), client.JsonCodec)
 	log.Printf("Posting response[%s]: %+v", r.url, args)
 	cl.Call("Runner.Post", args, new(rpc.None))
 }
@@ -60,15 +58,9 @@ func (r *responder) bail(e interface{}) {
 	r.post(resp)
 }
 
-//success is a helper function to post an output
-func (r *responder) success(e interface{}) {
-	resp := r.createResponse(fmt.Sprint(e), rpc.OutputSuccess)
-	r.post(resp)
-}
-
 //loadTest loads the test field of the responder, returning any errors.
 func (r *responder) loadTest() (err error) {
-	cl := client.New(r.url, http.DefaultClient, client.JsonCodec)
+	cl := client.New(r.url, client.Default(), client.JsonCodec)
 	args := &rpc.TestRequest{
 		ID:    r.id,
 		Index: r.index,
@@ -78,32 +70,6 @@ func (r *responder) loadTest() (err error) {
 	return
 }
 
-//timeout runs the given proc with a timeout, and returns if the process
-//finished in the duration specified.
-func timeout(r *responder, p environ.Proc, dur time.Duration) (ok bool) {
-	done := make(chan bool, 1)
-	if err := p.Start(); err != nil {
-		r.bail("error starting command")
-		return
-	}
-	defer p.Kill()
-
-	//start a race
-	go func() {
-		p.Wait()
-		done <- true
-	}()
-
-	go func() {
-		<-time.After(dur)
-		done <- false
-	}()
-
-	//see who won
-	ok = <-done
-	return
-}
-
 //turn off all the log flags
 func init() {
 	log.SetFlags(0)
@@ -127,35 +93,26 @@ func main() {
 	}
 	r.index = int(s64)
 
+	//present our certificate if the runner is served over mutual tls
+	config, err := mtls.Load(os.Getenv("TLS_CERT"), os.Getenv("TLS_KEY"), os.Getenv("TLS_CA"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	client.SetTLS(config)
+
 	//load the test description
 	if err := r.loadTest(); err != nil {
 		log.Fatal(err)
 	}
 	//now we can post results back.
 
-	//create a temporary directory for the sources
-	sdir, err := World.TempDir("src")
-	if err != nil {
-		r.bail(err)
-		return
-	}
-	defer World.RemoveAll(sdir)
-
 	//download the sources
-	sr, err := http.Get(r.test.SourceURL)
+	sr, err := client.Default().Get(r.test.SourceURL)
 	if err != nil || sr.StatusCode != http.StatusOK {
 		r.bail(fmt.Sprintf("%d: %v", sr.StatusCode, err))
 		return
 	}
-
-	//extract them into the directory
-	if err := tarball.Extract(sr.Body, sdir); err != nil {
-		r.bail(err)
-		return
-	}
-
-	//close the body
-	sr.Body.Close()
+	defer sr.Body.Close()
 
 	//create the directory for the binary
 	bdir, err := World.TempDir("bin")
@@ -174,7 +131,7 @@ func main() {
 	}
 
 	//download the binary
-	br, err := http.Get(r.test.BinaryURL)
+	br, err := client.Default().Get(r.test.BinaryURL)
 	if err != nil || br.StatusCode != http.StatusOK {
 		r.bail(fmt.Sprintf("%d: %v", br.StatusCode, err))
 		return
@@ -185,28 +142,9 @@ func main() {
 	bw.Close()
 	br.Body.Close()
 
-	//create the command
-	env := []string{
-		//copy in some basic env vars if we have them
-		fmt.Sprintf("PATH=%s", os.Getenv("PATH")),
-		fmt.Sprintf("GOROOT=%s", os.Getenv("GOROOT")),
-		fmt.Sprintf("GOPATH=%s", os.Getenv("GOPATH")),
-	}
-	var buf bytes.Buffer
-	cmd := environ.Command{
-		W:    &buf,
-		Dir:  sdir,
-		Env:  env,
-		Path: binFile,
-		Args: []string{binFile, "-test.v"},
-	}
-	proc := World.Make(cmd)
-
-	//only allow the test to run for one minute
-	finished := timeout(r, proc, time.Minute)
-	if finished {
-		r.success(buf.String())
-	} else {
-		r.bail("test lasted more than 1 minute")
-	}
+	//run the test in the sources and send back what happened
+	r.post(&rpc.TestResponse{
+		ID:     r.id,
+		Output: run.Test(binFile, sr.Body, r.test),
+	})
 }
@@ -1,17 +1,26 @@
 package web
 
 import (
+	"context"
 	"fmt"
 	"github.com/zeebo/goci/app/rpc"
 	"github.com/zeebo/goci/heroku"
 	"log"
+	"time"
 )
 
-func (r *Runner) process(task rpc.RunnerTask) {
+//acquireTimeout is how long a test waits for a free dyno before it is failed.
+const acquireTimeout = 5 * time.Minute
+
+//process starts running the tests in the task, calling done once the response
+//has been sent.
+func (r *Runner) process(task rpc.RunnerTask, done func()) {
 	//create a runner task for the incoming task
 	rtask := &runnerTask{
 		mc:    r.mc,
 		tm:    r.tm,
+		done:  done,
+		cred:  r.Credential(),
 		task:  task,
 		resps: make(chan rpc.Output, len(task.Tests)),
 		ids:   make(map[string]chan string),
@@ -25,6 +34,7 @@ func (r *Runner) process(task rpc.RunnerTask) {
 
 	//create the rpc url
 	for i, rt := range task.Tests {
+		rt := rt //captured by the Error closure
 
 		//create an action for our managed heroku client
 		action := heroku.Action{
@@ -43,10 +53,13 @@ func (r *Runner) process(task rpc.RunnerTask) {
 		ch := make(chan string, 1)
 		rtask.ids[rt.ImportPath] = ch
 
-		//run the action
-		id, err := r.mc.Run(action)
+		//run the action. if it fails the Error function has already sent an
+		//output, so send an empty id for the runner to finish with.
+		ctx, cancel := context.WithTimeout(context.Background(), acquireTimeout)
+		id, err := r.mc.RunContext(ctx, action)
+		cancel()
 		if err != nil {
-			return
+			log.Printf("Error running %s: %s", rt.ImportPath, err)
 		}
 
 		//send the id down the channel for the runner
@@ -1,11 +1,11 @@
 package web
 
 import (
-	gorpc "github.com/gorilla/rpc"
-	"github.com/gorilla/rpc/json"
 	"github.com/zeebo/goci/app/pinger"
 	"github.com/zeebo/goci/app/rpc"
 	"github.com/zeebo/goci/app/rpc/client"
+	"github.com/zeebo/goci/app/rpc/jsonrpc"
+	"github.com/zeebo/goci/app/rpc/worker"
 	"github.com/zeebo/goci/heroku"
 	"net/http"
 	"time"
@@ -13,30 +13,32 @@ import (
 
 //Runner is an rpc service that runs tests on the heroku dyno grid.
 type Runner struct {
+	*worker.Worker
+
 	app, api string
 	tcl      *client.Client
 	base     string
-	rpc      *gorpc.Server
+	rpc      *jsonrpc.Server
 	rq       rpc.RunnerQueue
 	mc       *heroku.ManagedClient
 	tm       *runnerTaskMap
-
-	key string
 }
 
 //New returns a new Runner ready to be Announced and run tests on the
-//heroku dyno grid.
-func New(app, api string, tracker, hosted string) *Runner {
+//heroku dyno grid. Tasks pushed to the runner are stored in rq until they are
+//run.
+func New(app, api string, tracker, hosted string, rq rpc.RunnerQueue) *Runner {
 	n := &Runner{
 		app:  app,
 		api:  api,
-		tcl:  client.New(tracker, http.DefaultClient, client.JsonCodec),
+		tcl:  client.New(tracker, client.Default(), client.JsonCodec),
 		base: hosted,
-		rpc:  gorpc.NewServer(),
-		rq:   rpc.NewRunnerQueue(),
+		rpc:  jsonrpc.NewServer(),
+		rq:   rq,
 		mc:   heroku.NewManaged(app, api, 2, 2*time.Minute),
 		tm:   &runnerTaskMap{items: map[string]*runnerTask{}},
 	}
+	n.Worker = worker.New(n.tcl, "Runner", hosted, "linux", "amd64", n.load)
 
 	//register the run service in the rpc
 	if err := n.rpc.RegisterService(n.rq, ""); err != nil {
@@ -53,39 +55,30 @@ func New(app, api string, tracker, hosted string) *Runner {
 		panic(err)
 	}
 
-	//register the codec
-	n.rpc.RegisterCodec(json.NewCodec(), "application/json")
-
 	//start processing
 	go n.run()
 
 	return n
 }
 
-//Announce tells the tracker that we're available to run tests.
-func (r *Runner) Announce() (err error) {
-	args := &rpc.AnnounceArgs{
-		GOOS:   "linux",
-		GOARCH: "amd64",
-		Type:   "Runner",
-		URL:    r.base,
-	}
-	reply := new(rpc.AnnounceReply)
-	if err = r.tcl.Call("Tracker.Announce", args, reply); err != nil {
-		return
-	}
-	r.key = reply.Key
-	return
+//Pull leases work from the tracker instead of waiting for it to be pushed, so
+//the runner doesn't need to be Announced. The dynos still call back to the
+//runner at its hosted url. Pull never returns.
+func (r *Runner) Pull() {
+	r.Lease(func(rep *rpc.LeaseReply, stop func()) {
+		//hold on to the work item until the response is sent
+		r.process(*rep.Runner, stop)
+	})
 }
 
-//Remove removes this Runner from the tracker.
-func (r *Runner) Remove() (err error) {
-	args := &rpc.RemoveArgs{
-		Key:  r.key,
-		Kind: "Runner",
-	}
-	err = r.tcl.Call("Tracker.Remove", args, new(rpc.None))
-	return
+//load returns the number of tasks the Runner has queued or running on dynos.
+func (r *Runner) load() int {
+	return r.rq.Size() + r.mc.Stats().InFlight
+}
+
+//Dynos returns the statistics of the dynos managed by the Runner.
+func (r *Runner) Dynos() heroku.Stats {
+	return r.mc.Stats()
 }
 
 //ServeHTTP allows the runner to be hosted like any other http.Handler.
@@ -97,6 +90,6 @@ func (r *Runner) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 func (r *Runner) run() {
 	for {
 		task := r.rq.Pop()
-		r.process(task)
+		r.process(task, func() {})
 	}
 }
@@ -5,7 +5,6 @@ import (
 	"github.com/zeebo/goci/app/rpc/client"
 	"github.com/zeebo/goci/heroku"
 	"log"
-	"net/http"
 	"sync"
 )
 
@@ -48,8 +47,10 @@ func (m *runnerTaskMap) Delete(id string) {
 
 //runnerTask represents a runner task in progress.
 type runnerTask struct {
-	mc *heroku.ManagedClient //client to interact with heroku
-	tm *runnerTaskMap        //the map of ids to runner tasks
+	mc   *heroku.ManagedClient //client to interact with heroku
+	tm   *runnerTaskMap        //the map of ids to runner tasks
+	done func()                //called after the response is sent
+	cred rpc.Credential        //the credential to send the response with
 
 	task  rpc.RunnerTask         //the task we're running
 	resps chan rpc.Output        //the channel of outputs
@@ -86,13 +87,17 @@ func (r *runnerTask) run() {
 		Revision: r.task.Revision,
 		RevDate:  r.task.RevDate,
 		Tests:    outs,
+		Commits:  r.task.Commits,
 	}
 
 	log.Printf("Pushing response[%s]: %+v", r.task.Response, resp)
+	resp.Credential = r.cred
+	resp.Sign(r.task.Secret)
 
 	//send if off
-	cl := client.New(r.task.Response, http.DefaultClient, client.JsonCodec)
+	cl := client.New(r.task.Response, client.Default(), client.JsonCodec)
 	if err := cl.Call("Response.Post", resp, new(rpc.None)); err != nil {
 		log.Printf("Error pushing response: %s", err)
 	}
+	r.done()
 }
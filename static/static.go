@@ -0,0 +1,10 @@
+//package static holds the static assets of the frontend so that they are built
+//in to the binary.
+package static
+
+import "embed"
+
+//FS contains the static assets by their path, like "css/main.css".
+//
+//go:embed css js
+var FS embed.FS
@@ -0,0 +1,10 @@
+//package templates holds the templates of the frontend so that they are built
+//in to the binary.
+package templates
+
+import "embed"
+
+//FS contains the templates by their path, like "index/index.html".
+//
+//go:embed *.html */*.html
+var FS embed.FS
@@ -0,0 +1,96 @@
+//ca issues the certificates for mutual tls between the app and the workers
+/*
+ca keeps a certificate authority in a directory and issues certificates signed
+by it.
+
+	ca init <dir>
+		creates the certificate authority in dir/ca.pem and dir/ca.key
+	ca issue <dir> <name> [hosts...]
+		issues a certificate for name in dir/name.pem and dir/name.key that is
+		valid for the dns names and ip addresses in hosts
+
+Give the app and every worker its own certificate, issued for the host it is
+reached at, and point TLS_CERT, TLS_KEY and TLS_CA at the files. Keep ca.key
+somewhere safe; anyone with it can issue certificates the app will trust.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/zeebo/goci/app/rpc/mtls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var days = flag.Int("days", 365, "number of days the certificate is valid for")
+
+func check(err error, hint string) {
+	if err == nil {
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, hint, "error:", err)
+	os.Exit(1)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: ca [-days n] init <dir>")
+	fmt.Fprintln(os.Stderr, "       ca [-days n] issue <dir> <name> [hosts...]")
+	os.Exit(2)
+}
+
+//write saves the pem encoded certificate and key as name.pem and name.key in
+//the directory. The key is only readable by the owner.
+func write(dir, name string, cert, key []byte) {
+	certPath := filepath.Join(dir, name+".pem")
+	keyPath := filepath.Join(dir, name+".key")
+	check(ioutil.WriteFile(certPath, cert, 0644), "write cert")
+	check(ioutil.WriteFile(keyPath, key, 0600), "write key")
+	fmt.Println("wrote", certPath, "and", keyPath)
+}
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		usage()
+	}
+	lifetime := time.Duration(*days) * 24 * time.Hour
+	dir := args[1]
+
+	switch args[0] {
+	case "init":
+		//don't clobber a ca that certificates were already issued from
+		if _, err := os.Stat(filepath.Join(dir, "ca.key")); err == nil {
+			check(fmt.Errorf("%s already has a ca", dir), "init")
+		}
+		check(os.MkdirAll(dir, 0700), "mkdir")
+
+		ca, err := mtls.NewCA("goci ca", lifetime)
+		check(err, "create")
+		cert, key := ca.Encode()
+		write(dir, "ca", cert, key)
+
+	case "issue":
+		if len(args) < 3 || args[2] == "ca" {
+			usage()
+		}
+
+		cert, err := ioutil.ReadFile(filepath.Join(dir, "ca.pem"))
+		check(err, "read ca")
+		key, err := ioutil.ReadFile(filepath.Join(dir, "ca.key"))
+		check(err, "read ca")
+		ca, err := mtls.LoadCA(cert, key)
+		check(err, "load ca")
+
+		cert, key, err = ca.Issue(args[2], args[3:], lifetime)
+		check(err, "issue")
+		write(dir, args[2], cert, key)
+
+	default:
+		usage()
+	}
+}
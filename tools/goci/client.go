@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/zeebo/goci/app/api"
+	"io"
+	"net/http"
+)
+
+//call makes a request to the api at the path with the body encoded as json, if
+//given, and decodes the response into v. Error responses are returned as an
+//api.ErrorResponse.
+func call(method, path string, body, v interface{}) (err error) {
+	var r io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequest(method, *server+api.Prefix+path, r)
+	if err != nil {
+		return
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		var e api.ErrorResponse
+		if json.NewDecoder(resp.Body).Decode(&e) != nil || e.Message == "" {
+			return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+		}
+		return e
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
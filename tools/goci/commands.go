@@ -0,0 +1,214 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/zeebo/goci/app/api"
+	"github.com/zeebo/goci/app/entities"
+	"github.com/zeebo/goci/app/rpc"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+//parseTarget splits an import path from the revision after the @, if any.
+func parseTarget(target string) (importPath, rev string) {
+	importPath = target
+	if i := strings.LastIndex(target, "@"); i >= 0 {
+		importPath, rev = target[:i], target[i+1:]
+	}
+	return
+}
+
+//splitList returns the comma separated list without empty entries.
+func splitList(list string) (vs []string) {
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			vs = append(vs, v)
+		}
+	}
+	return
+}
+
+//buildCmd queues a build of an import path.
+func buildCmd(args []string) {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	subpackages := fs.Bool("subpackages", false, "test the subpackages of the import path too")
+	toolchain := fs.String("toolchain", "", "the toolchain the build needs, like go1.0.3")
+	requires := fs.String("requires", "", "comma separated labels the workers need, like cgo")
+	vcs := fs.String("vcs", "", "the version control system of the package if it can't be detected")
+	follow := fs.Bool("follow", false, "wait for the build to complete and print its output")
+	rest := parse(fs, args)
+	if len(rest) != 1 {
+		usage()
+	}
+
+	work := rpc.Work{
+		Subpackages: *subpackages,
+		Toolchain:   *toolchain,
+		Requires:    splitList(*requires),
+		VCSHint:     *vcs,
+	}
+	work.ImportPath, work.Revision = parseTarget(rest[0])
+
+	var w api.Work
+	check(call("POST", "/work", work, &w), "build")
+	fmt.Printf("queued %s\n", w.Key)
+	if *follow {
+		followWork(w.Key)
+	}
+}
+
+//statusCmd shows the status of a work item.
+func statusCmd(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	follow := fs.Bool("follow", false, "wait for the work item to complete and print its output")
+	rest := parse(fs, args)
+	if len(rest) != 1 {
+		usage()
+	}
+
+	if *follow {
+		followWork(rest[0])
+		return
+	}
+
+	var w api.Work
+	check(call("GET", "/work/"+rest[0], nil, &w), "status")
+	printStatus(&w)
+	if w.Result != nil {
+		printResult(w.Result, false)
+	}
+}
+
+//rerunCmd queues a completed work item again.
+func rerunCmd(args []string) {
+	fs := flag.NewFlagSet("rerun", flag.ExitOnError)
+	follow := fs.Bool("follow", false, "wait for the build to complete and print its output")
+	rest := parse(fs, args)
+	if len(rest) != 1 {
+		usage()
+	}
+
+	var w api.Work
+	check(call("POST", "/work/"+rest[0]+"/rerun", nil, &w), "rerun")
+	fmt.Printf("queued %s\n", w.Key)
+	if *follow {
+		followWork(w.Key)
+	}
+}
+
+//resultsCmd lists the recent test results for an import path.
+func resultsCmd(args []string) {
+	fs := flag.NewFlagSet("results", flag.ExitOnError)
+	n := fs.Int("n", 20, "how many results to list")
+	rest := parse(fs, args)
+	if len(rest) != 1 {
+		usage()
+	}
+
+	var tests []api.Test
+	path := fmt.Sprintf("/tests?import=%s&limit=%d", url.QueryEscape(rest[0]), *n)
+	check(call("GET", path, nil, &api.Page{Items: &tests}), "results")
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATUS\tREVISION\tWHEN\tWORK")
+	for _, t := range tests {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", t.ID, t.Status, short(t.Revision), t.When.Format(time.Stamp), t.Work)
+	}
+	tw.Flush()
+}
+
+//outputCmd prints the output of a test result.
+func outputCmd(args []string) {
+	if len(args) != 1 {
+		usage()
+	}
+
+	var t api.Test
+	check(call("GET", "/tests/"+args[0], nil, &t), "output")
+	fmt.Print(t.Output)
+	if t.Status != entities.TestStatusPass {
+		os.Exit(1)
+	}
+}
+
+//short returns the first 12 characters of a revision.
+func short(rev string) string {
+	if len(rev) > 12 {
+		return rev[:12]
+	}
+	return rev
+}
+
+//printStatus prints a line with the status of the work item.
+func printStatus(w *api.Work) {
+	line := fmt.Sprintf("%s %s: %s", w.Work.ImportPath, w.Key, w.Status)
+	if w.Attempts > 0 {
+		line += fmt.Sprintf(" (attempt %d", w.Attempts)
+		if w.Worker != "" {
+			line += " leased by " + w.Worker
+		} else if w.Builder != "" {
+			line += " on " + w.Builder
+		}
+		line += ")"
+	}
+	fmt.Println(line)
+}
+
+//printResult prints the result of a work item with the status of each test,
+//and the output of each test if asked to. It returns if every test passed.
+func printResult(res *api.Result, output bool) (passed bool) {
+	if !res.Success {
+		fmt.Printf("error: %s\n", res.Error)
+		return false
+	}
+
+	passed = true
+	for _, t := range res.Tests {
+		if t.Status != entities.TestStatusPass {
+			passed = false
+		}
+		if !output {
+			fmt.Printf("%-9s %s (%s)\n", t.Status, t.ImportPath, t.ID)
+			continue
+		}
+
+		var full api.Test
+		if err := call("GET", "/tests/"+t.ID, nil, &full); err != nil {
+			fmt.Printf("--- %s %s: couldn't get output: %v\n", t.Status, t.ImportPath, err)
+			continue
+		}
+		fmt.Printf("--- %s %s\n%s", t.Status, t.ImportPath, full.Output)
+		if full.Output != "" && !strings.HasSuffix(full.Output, "\n") {
+			fmt.Println()
+		}
+	}
+	return
+}
+
+//followWork waits for the work item to complete, printing its status when it
+//changes, then prints the output of its tests and exits with a failure unless
+//they all passed.
+func followWork(key string) {
+	var last string
+	for {
+		var w api.Work
+		check(call("GET", "/work/"+key, nil, &w), "status")
+
+		if line := fmt.Sprintf("%s %d %s %s", w.Status, w.Attempts, w.Builder, w.Worker); line != last {
+			printStatus(&w)
+			last = line
+		}
+		if w.Result != nil {
+			if !printResult(w.Result, true) {
+				os.Exit(1)
+			}
+			return
+		}
+
+		time.Sleep(*every)
+	}
+}
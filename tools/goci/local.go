@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/zeebo/goci/app/entities"
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/builder"
+	"github.com/zeebo/goci/runner/run"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//goroot returns GOROOT from the environment or from where the go command is.
+func goroot() string {
+	if root := os.Getenv("GOROOT"); root != "" {
+		return root
+	}
+	path, err := exec.LookPath("go")
+	check(err, "goroot")
+	path, err = filepath.EvalSymlinks(path)
+	check(err, "goroot")
+	return filepath.Dir(filepath.Dir(path))
+}
+
+//importPathOf returns the import path of the directory from where it is in
+//GOPATH.
+func importPathOf(dir string) (importPath string, err error) {
+	for _, gopath := range filepath.SplitList(os.Getenv("GOPATH")) {
+		src, err := filepath.Abs(filepath.Join(gopath, "src"))
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(src, dir)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		return filepath.ToSlash(rel), nil
+	}
+	err = fmt.Errorf("%s isn't in GOPATH: give its import path with -import", dir)
+	return
+}
+
+//runBuild runs the test of a build the way a runner does and returns its
+//output.
+func runBuild(bu builder.Build) rpc.Output {
+	defer bu.Clean()
+
+	if bu.Error != "" {
+		return rpc.Output{
+			ImportPath: bu.ImportPath,
+			Config:     bu.Config,
+			Output:     bu.Error,
+			Type:       rpc.OutputWontBuild,
+		}
+	}
+
+	test := rpc.RunTest{
+		ImportPath: bu.ImportPath,
+		Config:     bu.Config,
+	}
+	src, err := os.Open(bu.SourcePath)
+	if err != nil {
+		return rpc.Output{
+			ImportPath: bu.ImportPath,
+			Config:     bu.Config,
+			Output:     err.Error(),
+			Type:       rpc.OutputError,
+		}
+	}
+	defer src.Close()
+	return run.Test(bu.BinaryPath, src, test)
+}
+
+//localResult is what a local build prints as json: the same outputs a runner
+//sends in its response.
+type localResult struct {
+	ImportPath string
+	Revision   string
+	RevDate    time.Time
+	Tests      []rpc.Output
+}
+
+//localCmd builds and runs the tests of a directory or import path on this
+//machine the way goci would, without an app.
+func localCmd(args []string) {
+	fs := flag.NewFlagSet("local", flag.ExitOnError)
+	subpackages := fs.Bool("subpackages", false, "test the subpackages of the import path too")
+	importPath := fs.String("import", "", "the import path of the directory if it isn't in GOPATH")
+	vcs := fs.String("vcs", "", "the version control system of the package if it can't be detected")
+	asJSON := fs.Bool("json", false, "print the outputs a runner would send as json")
+	timeout := fs.Duration("timeout", run.Timeout, "how long each test is allowed to run")
+	rest := parse(fs, args)
+	if len(rest) != 1 {
+		usage()
+	}
+	run.Timeout = *timeout
+
+	work := rpc.Work{
+		Subpackages: *subpackages,
+		VCSHint:     *vcs,
+	}
+
+	b := builder.New("", "", goroot())
+	var builds []builder.Build
+	var revDate time.Time
+	var err error
+
+	//a directory is built as it is, anything else is downloaded
+	if fi, serr := os.Stat(rest[0]); serr == nil && fi.IsDir() {
+		var dir string
+		dir, err = filepath.Abs(rest[0])
+		check(err, "dir")
+		work.ImportPath = *importPath
+		if work.ImportPath == "" {
+			work.ImportPath, err = importPathOf(dir)
+			check(err, "import")
+		}
+		builds, revDate, err = b.BuildDir(dir, &work)
+	} else {
+		work.ImportPath, work.Revision = parseTarget(rest[0])
+		builds, revDate, err = b.Build(&work)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error building %s: %v\n", work.ImportPath, err)
+		os.Exit(1)
+	}
+
+	resp := localResult{
+		ImportPath: work.ImportPath,
+		Revision:   work.Revision,
+		RevDate:    revDate,
+	}
+	passed := true
+	for _, bu := range builds {
+		out := runBuild(bu)
+		resp.Tests = append(resp.Tests, out)
+
+		status, _ := entities.OutputStatus(out)
+		if status != entities.TestStatusPass {
+			passed = false
+		}
+		if !*asJSON {
+			fmt.Printf("--- %s %s\n%s", status, out.ImportPath, out.Output)
+			if out.Output != "" && !strings.HasSuffix(out.Output, "\n") {
+				fmt.Println()
+			}
+		}
+	}
+
+	if *asJSON {
+		buf, err := json.MarshalIndent(resp, "", "\t")
+		check(err, "json")
+		fmt.Printf("%s\n", buf)
+	} else {
+		fmt.Printf("%s@%s: %d tests\n", work.ImportPath, work.Revision, len(resp.Tests))
+	}
+	if !passed {
+		os.Exit(1)
+	}
+}
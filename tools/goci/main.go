@@ -0,0 +1,114 @@
+//goci is a command for developers to build and inspect packages on goci
+/*
+goci talks to the json api of a goci app. The app is given with -server or the
+GOCI_SERVER environment variable, defaulting to http://localhost:9080.
+
+	goci build [-subpackages] [-toolchain t] [-requires a,b] [-vcs v] [-follow] <import>[@rev]
+		queues a build of the import path at the revision, or the revision go
+		get picks if none is given
+	goci status [-follow] <key>
+		shows the status of a work item and the results of its tests
+	goci results [-n count] <import>
+		lists the most recent test results for the import path
+	goci output <id>
+		prints the output of a test result
+	goci rerun [-follow] <key>
+		queues a completed work item again
+	goci local [-subpackages] [-import path] [-vcs v] [-json] [-timeout d] <dir or import[@rev]>
+		builds and runs the tests on this machine the same way goci does,
+		without talking to an app. A directory is built as it is, with its
+		import path taken from GOPATH if -import isn't given
+
+With -follow, goci waits for the work item to complete, printing its status as
+it changes and then the output of every test, and exits with a failure unless
+every test passed. local exits with a failure the same way. Flags may come
+before or after the arguments.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	server = flag.String("server", env("GOCI_SERVER", "http://localhost:9080"), "url of the goci app")
+	every  = flag.Duration("every", 2*time.Second, "how often to check on a work item when following it")
+)
+
+//env gets an environment variable with a default
+func env(key, def string) (r string) {
+	if r = os.Getenv(key); r == "" {
+		r = def
+	}
+	return
+}
+
+func check(err error, hint string) {
+	if err == nil {
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, hint, "error:", err)
+	os.Exit(1)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: goci [-server url] <command> [args]
+
+commands:
+	build [-subpackages] [-toolchain t] [-requires a,b] [-vcs v] [-follow] <import>[@rev]
+	status [-follow] <key>
+	results [-n count] <import>
+	output <id>
+	rerun [-follow] <key>
+	local [-subpackages] [-import path] [-vcs v] [-json] [-timeout d] <dir or import[@rev]>
+
+flags:`)
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+//parse parses the flags in args, which may come before or after the other
+//arguments, and returns the other arguments.
+func parse(fs *flag.FlagSet, args []string) (rest []string) {
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return
+		}
+		rest = append(rest, args[0])
+		args = args[1:]
+	}
+}
+
+//commands maps the name of a command to the function that runs it with the
+//rest of the arguments.
+var commands = map[string]func(args []string){
+	"build":   buildCmd,
+	"status":  statusCmd,
+	"results": resultsCmd,
+	"output":  outputCmd,
+	"rerun":   rerunCmd,
+	"local":   localCmd,
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		usage()
+	}
+	*server = strings.TrimRight(*server, "/")
+	cmd(args[1:])
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	fs := flag.NewFlagSet("build", flag.ContinueOnError)
+	sub := fs.Bool("subpackages", false, "")
+	tc := fs.String("toolchain", "", "")
+
+	rest := parse(fs, []string{"-toolchain", "go1", "github.com/x/y@rev", "--subpackages"})
+	if len(rest) != 1 || rest[0] != "github.com/x/y@rev" || !*sub || *tc != "go1" {
+		t.Fatalf("Got %v %v %q", rest, *sub, *tc)
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	cases := []struct {
+		target, imp, rev string
+	}{
+		{"github.com/x/y@abc", "github.com/x/y", "abc"},
+		{"github.com/x/y", "github.com/x/y", ""},
+		{"github.com/x/y@", "github.com/x/y", ""},
+	}
+	for _, c := range cases {
+		imp, rev := parseTarget(c.target)
+		if imp != c.imp || rev != c.rev {
+			t.Errorf("%s: Expected %s %s. Got %s %s", c.target, c.imp, c.rev, imp, rev)
+		}
+	}
+
+	if got := strings.Join(splitList(" cgo, ,docker"), "|"); got != "cgo|docker" {
+		t.Errorf("Expected cgo|docker. Got %s", got)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/zeebo/goci/app/rpc/jsonrpc"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+//describe asks the service at the url to describe itself. Services that aren't
+//served by the jsonrpc package return an error.
+func describe(url string) (desc jsonrpc.Description, err error) {
+	err = newClient(url).Call("System.Describe", nil, &desc)
+	return
+}
+
+//resolve finds the method named by name in the description. The name can be
+//any case and can leave off the service or the end of the method as long as
+//only one method matches.
+func resolve(desc jsonrpc.Description, name string) (m jsonrpc.MethodDescription, err error) {
+	if m, ok := desc.Method(name); ok {
+		return m, nil
+	}
+
+	lower := strings.ToLower(name)
+	var matches []jsonrpc.MethodDescription
+	for _, s := range desc.Services {
+		for _, cand := range s.Methods {
+			full := strings.ToLower(cand.Name)
+			short := full[strings.Index(full, ".")+1:]
+			if strings.HasPrefix(full, lower) || strings.HasPrefix(short, lower) {
+				matches = append(matches, cand)
+			}
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		err = fmt.Errorf("unknown method %q", name)
+	case 1:
+		m = matches[0]
+	default:
+		var names []string
+		for _, cand := range matches {
+			names = append(names, cand.Name)
+		}
+		err = fmt.Errorf("ambiguous method %q: %s", name, strings.Join(names, ", "))
+	}
+	return
+}
+
+//readArgs returns the json args given on the command line, or read from the
+//file if one was given with "-" meaning stdin. It is an error to give both.
+func readArgs(file string, rest []string) (arg string, err error) {
+	if len(rest) > 0 {
+		arg = rest[0]
+	}
+	if file == "" {
+		return
+	}
+	if arg != "" {
+		err = fmt.Errorf("args given on the command line and with -f")
+		return
+	}
+
+	var buf []byte
+	if file == "-" {
+		buf, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		buf, err = ioutil.ReadFile(file)
+	}
+	arg = string(buf)
+	return
+}
+
+//prepare decodes the json args for the method. If the service describes
+//itself, the method name is completed and the args are checked and filled out.
+func prepare(url, method, arg string) (name string, args interface{}) {
+	name = method
+	if strings.TrimSpace(arg) != "" {
+		check(json.Unmarshal([]byte(arg), &args), "args")
+	}
+
+	if desc, err := describe(url); err == nil {
+		m, err := resolve(desc, method)
+		check(err, "method")
+		check(m.Args.Validate(args), "args")
+		name, args = m.Name, m.Args.Complete(args)
+	}
+	return
+}
+
+//listCmd prints the methods of the service at the url with the shape of their
+//args and the type of their reply.
+func listCmd(args []string) {
+	if len(args) != 1 {
+		usage()
+	}
+	desc, err := describe(args[0])
+	check(err, "describe")
+
+	type row struct {
+		Method, Args, Reply string
+	}
+	var rows []row
+	for _, s := range desc.Services {
+		for _, m := range s.Methods {
+			args, err := json.Marshal(m.Args.Zero())
+			check(err, "marsh")
+			reply := m.Reply.Name
+			if reply == "" {
+				reply = m.Reply.Type
+			}
+			rows = append(rows, row{m.Name, string(args), reply})
+		}
+	}
+
+	if *format == "table" {
+		show(rows)
+		return
+	}
+	for _, r := range rows {
+		fmt.Fprintf(output, "%s\n\targs:  %s\n\treply: %s\n", r.Method, r.Args, r.Reply)
+	}
+}
+
+//callCmd invokes a method with the json args and prints the reply.
+func callCmd(args []string) {
+	fs := flag.NewFlagSet("call", flag.ExitOnError)
+	file := fs.String("f", "", `read the args from the file, or stdin if "-"`)
+	fs.Parse(args)
+	if fs.NArg() != 2 && fs.NArg() != 3 {
+		usage()
+	}
+
+	url, method := fs.Arg(0), fs.Arg(1)
+	arg, err := readArgs(*file, fs.Args()[2:])
+	check(err, "args")
+	method, x := prepare(url, method, arg)
+
+	var y interface{}
+	check(newClient(url).Call(method, x, &y), "call")
+	show(y)
+}
+
+//sleep waits for the duration. It is a variable so tests can skip the wait.
+var sleep = time.Sleep
+
+//watchCmd repeats a call and prints the first reply and then what changed
+//between each reply and the one before it.
+func watchCmd(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	every := fs.Duration("every", 2*time.Second, "how long to wait between calls")
+	count := fs.Int("n", 0, "how many calls to make (0 is forever)")
+	file := fs.String("f", "", `read the args from the file, or stdin if "-"`)
+	fs.Parse(args)
+	if fs.NArg() != 2 && fs.NArg() != 3 {
+		usage()
+	}
+
+	url, method := fs.Arg(0), fs.Arg(1)
+	arg, err := readArgs(*file, fs.Args()[2:])
+	check(err, "args")
+	method, x := prepare(url, method, arg)
+
+	cl := newClient(url)
+	var last interface{}
+	for i := 0; *count == 0 || i < *count; i++ {
+		if i > 0 {
+			sleep(*every)
+		}
+
+		var y interface{}
+		if err := cl.Call(method, x, &y); err != nil {
+			fmt.Fprintf(output, "%s error: %v\n", time.Now().Format(time.Stamp), err)
+			continue
+		}
+		if i == 0 || last == nil {
+			show(y)
+			last = y
+			continue
+		}
+
+		changes := diff(last, y)
+		if len(changes) == 0 {
+			continue
+		}
+		fmt.Fprintln(output, time.Now().Format(time.Stamp))
+		for _, c := range changes {
+			fmt.Fprintf(output, "\t%s\n", c)
+		}
+		last = y
+	}
+}
@@ -1,17 +1,58 @@
+//rpc is a command for calling the rpc services of goci
+/*
+rpc talks to the app, builders and runners. Global flags come before the
+command:
+
+	-v2          speak JSON-RPC 2.0 instead of the gorilla/rpc json format
+	-trace       print every request and response body to stderr
+	-o format    print results as "json" (default) or "table"
+	-timeout d   how long to give each call (default 1m)
+
+The commands are:
+
+	rpc list <url>
+		lists the methods of the service with the shape of their args
+	rpc call [-f file] <url> <method> [args]
+		calls the method with the json args, read from the file if given or
+		stdin if the file is "-"
+	rpc watch [-every d] [-n count] [-f file] <url> <method> [args]
+		repeats the call and prints what changed in the result each time
+	rpc ping [-f file] [url...]
+		pings the workers at the urls, read one per line from the file if given
+	rpc queue push [-f file] <url> builder|runner [task]
+		pushes a BuilderTask or RunnerTask on to the queue of a worker
+
+Services that verify their peers with mutual tls are reached by setting
+TLS_CERT, TLS_KEY and TLS_CA to the certificate the command presents and the
+authority that signed the services.
+
+Services that describe themselves have the method name completed and the args
+checked and filled out before they are sent. The old form of "rpc <url> list"
+and "rpc <url> <method> [args]" still works.
+*/
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/zeebo/goci/app/rpc/client"
-	"io"
-	"io/ioutil"
+	"github.com/zeebo/goci/app/settings"
 	"net/http"
 	"os"
+	"strings"
 )
 
+var (
+	v2      = flag.Bool("v2", false, "speak JSON-RPC 2.0 instead of the gorilla/rpc json format")
+	trace   = flag.Bool("trace", false, "print every request and response body to stderr")
+	format  = flag.String("o", "json", `output format: "json" or "table"`)
+	timeout = flag.Duration("timeout", client.DefaultTimeout, "how long to give each call")
+)
+
+//exitCode is the code the command exits with, set by commands that partially
+//fail like ping.
+var exitCode = 0
+
 func check(err error, hint string) {
 	if err == nil {
 		return
@@ -21,45 +62,86 @@ func check(err error, hint string) {
 	os.Exit(1)
 }
 
-type rt struct{}
-
-func (rt) RoundTrip(req *http.Request) (resp *http.Response, err error) {
-	var buf bytes.Buffer
-	io.Copy(io.MultiWriter(&buf, os.Stdout), req.Body)
-	fmt.Println("")
-	req.Body = ioutil.NopCloser(&buf)
-	resp, err = http.DefaultTransport.RoundTrip(req)
-	buf.Reset()
-	io.Copy(io.MultiWriter(&buf, os.Stdout), resp.Body)
-	fmt.Println("")
-	resp.Body = ioutil.NopCloser(&buf)
-	return
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: rpc [flags] <command> [args]
+
+commands:
+	list <url>
+	call [-f file] <url> <method> [args]
+	watch [-every d] [-n count] [-f file] <url> <method> [args]
+	ping [-f file] [url...]
+	queue push [-f file] <url> builder|runner [task]
+
+flags:`)
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+//codec returns the codec to talk to the service with.
+func codec() client.Codec {
+	if *v2 {
+		return client.Json2Codec
+	}
+	return client.JsonCodec
+}
+
+//newClient returns a client for the service at the url that presents the
+//certificate from the environment and traces its calls if asked to.
+func newClient(url string) *client.Client {
+	hcl := client.Default()
+	if *trace {
+		rt := hcl.Transport
+		if rt == nil {
+			rt = http.DefaultTransport
+		}
+		hcl = &http.Client{Transport: tracer{rt}}
+	}
+	cl := client.New(url, hcl, codec())
+	cl.SetTimeout(*timeout)
+	return cl
+}
+
+//commands maps the name of a command to the function that runs it with the
+//rest of the arguments.
+var commands = map[string]func(args []string){
+	"list":  listCmd,
+	"call":  callCmd,
+	"watch": watchCmd,
+	"ping":  pingCmd,
+	"queue": queueCmd,
 }
 
 func main() {
+	flag.Usage = usage
 	flag.Parse()
 	args := flag.Args()
-	if len(args) != 3 {
-		check(fmt.Errorf("usage: rpc <url> <method> <args>"), "args")
+	if len(args) == 0 {
+		usage()
 	}
-	url, method, arg := args[0], args[1], args[2]
-	hcl := &http.Client{
-		Transport: rt{},
+	if *format != "json" && *format != "table" {
+		check(fmt.Errorf("unknown output format %q", *format), "args")
 	}
-	cl := client.New(url, hcl, client.JsonCodec)
-
-	var x, y interface{}
-	var err error
-
-	err = json.Unmarshal([]byte(arg), &x)
-	check(err, "unmar")
 
-	err = cl.Call(method, x, &y)
-	check(err, "call")
+	//speak mutual tls if it's configured
+	client.SetTLS(settings.TLSConfig())
 
-	var b []byte
-	b, err = json.MarshalIndent(y, "", "\t")
-	check(err, "marsh")
+	//the old form starts with the url
+	if strings.Contains(args[0], "://") {
+		switch {
+		case len(args) == 2 && args[1] == "list":
+			listCmd(args[:1])
+		case len(args) == 2 || len(args) == 3:
+			callCmd(args)
+		default:
+			usage()
+		}
+		os.Exit(exitCode)
+	}
 
-	fmt.Printf("%s\n", b)
+	cmd, ok := commands[args[0]]
+	if !ok {
+		usage()
+	}
+	cmd(args[1:])
+	os.Exit(exitCode)
 }
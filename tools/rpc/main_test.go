@@ -1 +1,142 @@
 package main
+
+import (
+	"bytes"
+	"github.com/zeebo/goci/app/pinger"
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/app/rpc/jsonrpc"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	desc := jsonrpc.Description{Services: []jsonrpc.ServiceDescription{
+		{Name: "Tracker", Methods: []jsonrpc.MethodDescription{
+			{Name: "Tracker.Announce"},
+			{Name: "Tracker.Enroll"},
+			{Name: "Tracker.Extend"},
+		}},
+		{Name: "System", Methods: []jsonrpc.MethodDescription{
+			{Name: "System.Describe"},
+		}},
+	}}
+
+	cases := []struct {
+		name, exp string
+	}{
+		{"Tracker.Announce", "Tracker.Announce"},
+		{"tracker.announce", "Tracker.Announce"},
+		{"Tracker.Ann", "Tracker.Announce"},
+		{"describe", "System.Describe"},
+		{"Tracker.Ex", "Tracker.Extend"},
+		{"Tracker.E", ""},
+		{"Tracker.Remove", ""},
+	}
+	for _, c := range cases {
+		m, err := resolve(desc, c.name)
+		if c.exp == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error. Got %s", c.name, m.Name)
+			}
+			continue
+		}
+		if err != nil || m.Name != c.exp {
+			t.Errorf("%s: Expected %s. Got %s %v", c.name, c.exp, m.Name, err)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := map[string]interface{}{
+		"Status": "running",
+		"Tests":  []interface{}{map[string]interface{}{"Passed": false}},
+		"Gone":   1.0,
+	}
+	new := map[string]interface{}{
+		"Status": "done",
+		"Tests":  []interface{}{map[string]interface{}{"Passed": true}},
+		"Added":  "x",
+	}
+
+	var got []string
+	for _, c := range diff(old, new) {
+		got = append(got, c.String())
+	}
+	exp := []string{
+		`+ Added: "x"`,
+		`- Gone: 1`,
+		`~ Status: "running" -> "done"`,
+		`~ Tests[0].Passed: false -> true`,
+	}
+	if strings.Join(got, "\n") != strings.Join(exp, "\n") {
+		t.Fatalf("Expected\n%s\nGot\n%s", strings.Join(exp, "\n"), strings.Join(got, "\n"))
+	}
+
+	if changes := diff(new, new); len(changes) != 0 {
+		t.Fatalf("Expected no changes. Got %v", changes)
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	cases := []struct {
+		v   interface{}
+		exp string
+	}{
+		{
+			[]pingResult{{"http://a", "ok", "1ms"}, {"http://bb", "down", "2ms"}},
+			"LATENCY  STATUS  URL\n1ms      ok      http://a\n2ms      down    http://bb\n",
+		},
+		{
+			map[string]interface{}{"B": []int{1, 2}, "A": nil},
+			"A  -\nB  [1,2]\n",
+		},
+		{"hello", "hello\n"},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := writeTable(&buf, c.v); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != c.exp {
+			t.Errorf("Expected\n%q\nGot\n%q", c.exp, buf.String())
+		}
+	}
+}
+
+func TestDecodeTask(t *testing.T) {
+	method, task, err := decodeTask("runner", `{"ID":"x","WorkRev":2}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt, ok := task.(*rpc.RunnerTask); method != "RunnerQueue.Push" || !ok || rt.ID != "x" || rt.WorkRev != 2 {
+		t.Fatalf("Got %s %+v", method, task)
+	}
+
+	if _, _, err := decodeTask("builder", `{"Wrok":{}}`); err == nil {
+		t.Fatal("expected an error for an unknown field")
+	}
+	if _, _, err := decodeTask("tracker", `{}`); err == nil {
+		t.Fatal("expected an error for an unknown queue")
+	}
+}
+
+func TestPing(t *testing.T) {
+	srv := jsonrpc.NewServer()
+	if err := srv.RegisterService(pinger.Pinger{}, ""); err != nil {
+		t.Fatal(err)
+	}
+	up := httptest.NewServer(srv)
+	defer up.Close()
+	down := httptest.NewServer(jsonrpc.NewServer())
+	down.Close()
+
+	urls, err := readURLs(strings.NewReader("# workers\n" + up.URL + "\n\n" + down.URL + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := ping(urls)
+	if len(results) != 2 || results[0].Status != "ok" || results[1].Status == "ok" {
+		t.Fatalf("Expected the first to be up and the second down. Got %+v", results)
+	}
+}
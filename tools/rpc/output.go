@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+//output is where results are written.
+var output io.Writer = os.Stdout
+
+//show writes the value in the format given by the -o flag.
+func show(v interface{}) {
+	var err error
+	if *format == "table" {
+		err = writeTable(output, v)
+	} else {
+		err = writeJSON(output, v)
+	}
+	check(err, "output")
+}
+
+//writeJSON writes the value as indented json.
+func writeJSON(w io.Writer, v interface{}) (err error) {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return
+}
+
+//generic round trips the value through json so it is made of maps, slices and
+//basic values, the same as any other decoded reply.
+func generic(v interface{}) (g interface{}, err error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(b, &g)
+	return
+}
+
+//writeTable writes the value as a table. Lists of objects get a column for
+//every key, objects get a row for every key, and anything else is written
+//alone. Nested values are written as compact json.
+func writeTable(w io.Writer, v interface{}) (err error) {
+	if v, err = generic(v); err != nil {
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	switch v := v.(type) {
+	case []interface{}:
+		keys := columns(v)
+		if keys == nil {
+			for _, item := range v {
+				fmt.Fprintln(tw, cell(item))
+			}
+			break
+		}
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(keys, "\t")))
+		for _, item := range v {
+			obj, _ := item.(map[string]interface{})
+			row := make([]string, len(keys))
+			for i, key := range keys {
+				row[i] = cell(obj[key])
+			}
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			fmt.Fprintf(tw, "%s\t%s\n", key, cell(v[key]))
+		}
+	default:
+		fmt.Fprintln(tw, cell(v))
+	}
+	return tw.Flush()
+}
+
+//columns returns the sorted keys of every object in the list, or nil if any
+//item isn't an object.
+func columns(list []interface{}) (keys []string) {
+	set := map[string]interface{}{}
+	for _, item := range list {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for key := range obj {
+			set[key] = nil
+		}
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	return sortedKeys(set)
+}
+
+//cell returns how the value is written in a table.
+func cell(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "-"
+	case string:
+		return v
+	case float64, bool:
+		return fmt.Sprint(v)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+//sortedKeys returns the keys of the map in sorted order.
+func sortedKeys(m map[string]interface{}) (keys []string) {
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return
+}
+
+//flatten returns the leaves of the value keyed by their path, like
+//"Tests[0].Status", so two values can be compared field by field.
+func flatten(v interface{}) map[string]string {
+	out := map[string]string{}
+	flattenInto(out, "", v)
+	return out
+}
+
+func flattenInto(out map[string]string, path string, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			out[path] = "{}"
+		}
+		for key, val := range v {
+			p := key
+			if path != "" {
+				p = path + "." + key
+			}
+			flattenInto(out, p, val)
+		}
+	case []interface{}:
+		if len(v) == 0 {
+			out[path] = "[]"
+		}
+		for i, val := range v {
+			flattenInto(out, fmt.Sprintf("%s[%d]", path, i), val)
+		}
+	default:
+		b, _ := json.Marshal(v)
+		out[path] = string(b)
+	}
+}
+
+//change is a difference in the value at a path between two results.
+type change struct {
+	Path     string
+	Old, New string //empty when the path was added or removed
+}
+
+func (c change) String() string {
+	path := c.Path
+	if path == "" {
+		path = "."
+	}
+	switch {
+	case c.Old == "":
+		return fmt.Sprintf("+ %s: %s", path, c.New)
+	case c.New == "":
+		return fmt.Sprintf("- %s: %s", path, c.Old)
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", path, c.Old, c.New)
+}
+
+//diff returns the changes between two values, sorted by path.
+func diff(old, new interface{}) (changes []change) {
+	o, n := flatten(old), flatten(new)
+	paths := map[string]interface{}{}
+	for p := range o {
+		paths[p] = nil
+	}
+	for p := range n {
+		paths[p] = nil
+	}
+	for _, p := range sortedKeys(paths) {
+		if o[p] != n[p] {
+			changes = append(changes, change{p, o[p], n[p]})
+		}
+	}
+	return
+}
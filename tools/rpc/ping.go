@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/zeebo/goci/app/rpc"
+	"github.com/zeebo/goci/app/rpc/client"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//pingResult is the result of pinging a worker.
+type pingResult struct {
+	URL     string
+	Status  string
+	Latency string
+}
+
+//readURLs reads urls one per line, skipping blank lines and lines starting
+//with #.
+func readURLs(r io.Reader) (urls []string, err error) {
+	scan := bufio.NewScanner(r)
+	for scan.Scan() {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	err = scan.Err()
+	return
+}
+
+//ping calls Pinger.Ping on every url at once and returns the results in the
+//same order.
+func ping(urls []string) (results []pingResult) {
+	results = make([]pingResult, len(urls))
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			//a ping is only made once so dead workers are reported quickly
+			cl := newClient(url)
+			cl.SetRetry(client.Retry{Attempts: 1})
+
+			start := time.Now()
+			err := cl.Call("Pinger.Ping", nil, new(rpc.None))
+			took := time.Since(start)
+
+			results[i] = pingResult{URL: url, Status: "ok", Latency: took.String()}
+			if err != nil {
+				results[i].Status = err.Error()
+			}
+		}(i, url)
+	}
+	wg.Wait()
+	return
+}
+
+//pingCmd pings every worker given on the command line or in the file and
+//exits with a failure if any of them don't respond.
+func pingCmd(args []string) {
+	fs := flag.NewFlagSet("ping", flag.ExitOnError)
+	file := fs.String("f", "", `read the urls one per line from the file, or stdin if "-"`)
+	fs.Parse(args)
+
+	urls := fs.Args()
+	if *file != "" {
+		in := os.Stdin
+		if *file != "-" {
+			f, err := os.Open(*file)
+			check(err, "open")
+			defer f.Close()
+			in = f
+		}
+		more, err := readURLs(in)
+		check(err, "read")
+		urls = append(urls, more...)
+	}
+	if len(urls) == 0 {
+		usage()
+	}
+
+	results := ping(urls)
+	for _, r := range results {
+		if r.Status != "ok" {
+			exitCode = 1
+		}
+	}
+
+	if *format == "table" {
+		show(results)
+		return
+	}
+	for _, r := range results {
+		fmt.Fprintf(output, "%s\t%s\t%s\n", r.URL, r.Status, r.Latency)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/zeebo/goci/app/rpc"
+)
+
+//queues maps the kind of worker to the method that pushes on to its queue and
+//a function returning a new task for it.
+var queues = map[string]struct {
+	method  string
+	newTask func() interface{}
+}{
+	"builder": {"BuilderQueue.Push", func() interface{} { return new(rpc.BuilderTask) }},
+	"runner":  {"RunnerQueue.Push", func() interface{} { return new(rpc.RunnerTask) }},
+}
+
+//decodeTask decodes the json into a new task for the kind of worker, failing
+//on fields the task doesn't have so typos don't push empty tasks.
+func decodeTask(kind, arg string) (method string, task interface{}, err error) {
+	q, ok := queues[kind]
+	if !ok {
+		err = fmt.Errorf("unknown queue %q: expected builder or runner", kind)
+		return
+	}
+
+	method, task = q.method, q.newTask()
+	dec := json.NewDecoder(bytes.NewReader([]byte(arg)))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(task); err != nil {
+		err = fmt.Errorf("decoding %s task: %v", kind, err)
+	}
+	return
+}
+
+//queueCmd handles the queue commands. The only one is push, which pushes a
+//task on to the queue of a builder or runner.
+func queueCmd(args []string) {
+	if len(args) == 0 || args[0] != "push" {
+		usage()
+	}
+
+	fs := flag.NewFlagSet("queue push", flag.ExitOnError)
+	file := fs.String("f", "", `read the task from the file, or stdin if "-"`)
+	fs.Parse(args[1:])
+	if fs.NArg() != 2 && fs.NArg() != 3 {
+		usage()
+	}
+
+	url, kind := fs.Arg(0), fs.Arg(1)
+	arg, err := readArgs(*file, fs.Args()[2:])
+	check(err, "args")
+	method, task, err := decodeTask(kind, arg)
+	check(err, "task")
+
+	check(newClient(url).Call(method, task, new(rpc.None)), "push")
+	show(task)
+}
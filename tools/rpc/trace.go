@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+//traceOut is where traces are written.
+var traceOut io.Writer = os.Stderr
+
+//tracer is a RoundTripper that writes the body of every request and response
+//to traceOut along with the status and how long it took.
+type tracer struct {
+	next http.RoundTripper
+}
+
+func (t tracer) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	var buf bytes.Buffer
+	if req.Body != nil {
+		io.Copy(&buf, req.Body)
+		req.Body = ioutil.NopCloser(bytes.NewReader(buf.Bytes()))
+	}
+	fmt.Fprintf(traceOut, "--> %s %s\n%s\n", req.Method, req.URL, bytes.TrimSpace(buf.Bytes()))
+
+	start := time.Now()
+	resp, err = t.next.RoundTrip(req)
+	took := time.Since(start)
+	if err != nil {
+		fmt.Fprintf(traceOut, "<-- error (%v): %v\n", took, err)
+		return
+	}
+
+	buf.Reset()
+	io.Copy(&buf, resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(buf.Bytes()))
+	fmt.Fprintf(traceOut, "<-- %s (%v)\n%s\n", resp.Status, took, bytes.TrimSpace(buf.Bytes()))
+	return
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/zeebo/goci/environ"
+	"github.com/zeebo/goci/redact"
 	"io"
 	"os"
 	fp "path/filepath"
@@ -16,6 +17,15 @@ type VCS interface {
 	Clone(repo, dir string) (err error)
 	Current(dir string) (rev string, err error)
 	Date(dir, rev string) (t time.Time, err error)
+	Log(dir, from, to string) (commits []Commit, err error)
+}
+
+//Commit is a commit in the log of a repository.
+type Commit struct {
+	Revision string
+	Author   string
+	Date     time.Time
+	Subject  string //the first line of the message
 }
 
 var vcsMap = map[VCSType]VCS{
@@ -57,9 +67,29 @@ type vcsInfo struct {
 	FCheckout string
 	FCurrent  string
 	FDate     string
+	FLog      string
 
 	Format string
 	Filter func(string) (bool, string)
+
+	//ParseLog parses the output of FLog into commits, using the format to
+	//parse the dates.
+	ParseLog func(out, format string) ([]Commit, error)
+
+	//Env is the environment the commands run with. nil uses the environment of
+	//the process.
+	Env []string
+}
+
+//WithEnv returns a VCS like v that runs its commands with the environment.
+func WithEnv(v VCS, env []string) VCS {
+	vi, ok := v.(*vcsInfo)
+	if !ok {
+		return v
+	}
+	c := *vi
+	c.Env = env
+	return &c
 }
 
 var vcsGit = &vcsInfo{
@@ -69,8 +99,10 @@ var vcsGit = &vcsInfo{
 	FCheckout: "checkout {rev}",
 	FCurrent:  "rev-parse HEAD",
 	FDate:     "log -1 --format=%cD {rev}",
+	FLog:      "log --format=%H%x09%an%x09%cD%x09%s {from}..{to}",
 
-	Format: "Mon, 2 Jan 2006 15:04:05 -0700",
+	Format:   "Mon, 2 Jan 2006 15:04:05 -0700",
+	ParseLog: parseTabLog,
 }
 
 var vcsHg = &vcsInfo{
@@ -80,8 +112,10 @@ var vcsHg = &vcsInfo{
 	FCheckout: "update -r {rev}",
 	FCurrent:  "parents --template {node}",
 	FDate:     "parents --template {date|rfc822date} -r {rev}",
+	FLog:      "log -r reverse({from}::{to}) --template {node}\\t{author|person}\\t{date|rfc822date}\\t{desc|firstline}\\n",
 
-	Format: time.RFC822Z,
+	Format:   time.RFC822Z,
+	ParseLog: parseTabLog,
 }
 
 var vcsBzr = &vcsInfo{
@@ -91,8 +125,10 @@ var vcsBzr = &vcsInfo{
 	FCheckout: "update -r {rev}",
 	FCurrent:  "revision-info --tree",
 	FDate:     "log -r {rev}",
+	FLog:      "log --long -r {from}..{to}",
 
-	Format: "Mon 2006-01-02 15:04:05 -0700",
+	Format:   "Mon 2006-01-02 15:04:05 -0700",
+	ParseLog: parseLongLog,
 	Filter: func(in string) (ok bool, out string) {
 		if ok = strings.HasPrefix(in, "timestamp: "); ok {
 			out = in[11:]
@@ -109,8 +145,10 @@ type VCSError struct {
 	Output string
 }
 
+//Error masks anything that looks like a secret, like the password in the url
+//of a repository, because the output is stored and shown with the results.
 func (v *VCSError) Error() string {
-	return fmt.Sprintf("%s: %v\nvcs: %s\nargs: %s\noutput: %s", v.Msg, v.Err, v.Vcs.Name, v.Args, v.Output)
+	return redact.Patterns(fmt.Sprintf("%s: %v\nvcs: %s\nargs: %s\noutput: %s", v.Msg, v.Err, v.Vcs.Name, v.Args, v.Output))
 }
 
 func vcsErrorf(err error, v *vcsInfo, args []string, out string, msg string) error {
@@ -147,11 +185,16 @@ func (v *vcsInfo) expandCmd(dir string, w io.Writer, cmd string, keyval ...strin
 		path = v.Name
 	}
 
+	env := v.Env
+	if env == nil {
+		env = os.Environ()
+	}
+
 	args = append([]string{v.Name}, expandSplit(cmd, vals)...)
 	p = World.Make(environ.Command{
 		W:    w,
 		Dir:  dir,
-		Env:  os.Environ(),
+		Env:  env,
 		Path: path,
 		Args: args,
 	})
@@ -235,3 +278,80 @@ func (v *vcsInfo) Date(dir, rev string) (t time.Time, err error) {
 
 	return
 }
+
+//Log returns the commits after from up to and including to, newest first.
+func (v *vcsInfo) Log(dir, from, to string) (commits []Commit, err error) {
+	var buf bytes.Buffer
+	cmd, args := v.expandCmd(dir, &buf, v.FLog, "from", from, "to", to)
+	if e, ok := cmd.Run(); e != nil || !ok {
+		err = vcsErrorf(e, v, args, buf.String(), "failed to get log")
+		return
+	}
+
+	all, e := v.ParseLog(buf.String(), v.Format)
+	if e != nil {
+		err = vcsErrorf(e, v, args, buf.String(), "failed to parse log")
+		return
+	}
+
+	//some ranges include from so leave it out
+	for _, c := range all {
+		if c.Revision != from {
+			commits = append(commits, c)
+		}
+	}
+	return
+}
+
+//parseTabLog parses a log with a line for each commit with the revision,
+//author, date and subject separated by tabs.
+func parseTabLog(out, format string) (commits []Commit, err error) {
+	for _, line := range strings.Split(out, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		parts := strings.SplitN(line, "\t", 4)
+		if len(parts) != 4 {
+			err = fmt.Errorf("invalid log line: %q", line)
+			return
+		}
+		c := Commit{Revision: parts[0], Author: parts[1], Subject: parts[3]}
+		if c.Date, err = time.Parse(format, parts[2]); err != nil {
+			return
+		}
+		commits = append(commits, c)
+	}
+	return
+}
+
+//parseLongLog parses a bzr log in the long format, where each commit is a
+//block of "key: value" lines followed by an indented message.
+func parseLongLog(out, format string) (commits []Commit, err error) {
+	var c *Commit
+	var message bool
+	for _, line := range strings.Split(out, "\n") {
+		switch {
+		case strings.HasPrefix(line, "-----"):
+			c, message = nil, false
+		case strings.HasPrefix(strings.TrimSpace(line), "revno: "):
+			commits = append(commits, Commit{})
+			c, message = &commits[len(commits)-1], false
+			c.Revision = strings.Fields(strings.TrimSpace(line))[1]
+		case c == nil:
+		case message:
+			//the subject is the first line of the message
+			if text := strings.TrimSpace(line); text != "" && c.Subject == "" {
+				c.Subject = text
+			}
+		case strings.HasPrefix(strings.TrimSpace(line), "message:"):
+			message = true
+		case strings.HasPrefix(strings.TrimSpace(line), "committer: "):
+			c.Author = strings.TrimSpace(line)[11:]
+		case strings.HasPrefix(strings.TrimSpace(line), "timestamp: "):
+			if c.Date, err = time.Parse(format, strings.TrimSpace(line)[11:]); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
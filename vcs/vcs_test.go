@@ -0,0 +1,58 @@
+package vcs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTabLog(t *testing.T) {
+	out := "b2\tJeff\tWed, 2 Jan 2013 10:00:00 -0500\tfix\tthe build\n" +
+		"a1\tJeff\tTue, 1 Jan 2013 10:00:00 -0500\tinitial\n"
+	commits, err := parseTabLog(out, vcsGit.Format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 2 {
+		t.Fatalf("Expected 2 commits. Got %+v", commits)
+	}
+	if c := commits[0]; c.Revision != "b2" || c.Author != "Jeff" || c.Subject != "fix\tthe build" || c.Date.Day() != 2 {
+		t.Fatalf("Got %+v", c)
+	}
+
+	if _, err := parseTabLog("nope\n", vcsGit.Format); err == nil {
+		t.Fatal("Expected an error parsing an invalid line")
+	}
+}
+
+func TestParseLongLog(t *testing.T) {
+	out := `------------------------------------------------------------
+revno: 3 [merge]
+committer: Jeff <jeff@example.com>
+branch nick: trunk
+timestamp: Wed 2013-01-02 10:00:00 -0500
+message:
+  merge the feature
+
+  with details
+    ------------------------------------------------------------
+    revno: 2.1.1
+    committer: Bob <bob@example.com>
+    timestamp: Tue 2013-01-01 10:00:00 -0500
+    message:
+      the feature
+`
+	commits, err := parseLongLog(out, vcsBzr.Format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 2 {
+		t.Fatalf("Expected 2 commits. Got %+v", commits)
+	}
+	want := time.Date(2013, 1, 2, 15, 0, 0, 0, time.UTC)
+	if c := commits[0]; c.Revision != "3" || c.Author != "Jeff <jeff@example.com>" || c.Subject != "merge the feature" || !c.Date.Equal(want) {
+		t.Fatalf("Got %+v", c)
+	}
+	if c := commits[1]; c.Revision != "2.1.1" || c.Subject != "the feature" {
+		t.Fatalf("Got %+v", c)
+	}
+}